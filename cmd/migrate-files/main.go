@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+
+	"github.com/bornholm/oplet/internal/config"
+	"github.com/bornholm/oplet/internal/setup"
+	"github.com/bornholm/oplet/internal/slogx"
+	"github.com/pkg/errors"
+)
+
+var (
+	from         string = config.FileBackendFilesystem
+	to           string = config.FileBackendS3
+	deleteSource bool   = false
+)
+
+func init() {
+	flag.StringVar(&from, "from", from, "file storage backend the files are copied from, 'filesystem' or 's3'")
+	flag.StringVar(&to, "to", to, "file storage backend the files are copied to, 'filesystem' or 's3'")
+	flag.BoolVar(&deleteSource, "delete", deleteSource, "delete the files from the source backend once all of them are copied")
+}
+
+func main() {
+	flag.Parse()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	conf, err := config.Parse()
+	if err != nil {
+		slog.ErrorContext(ctx, "could not parse config", slog.Any("error", errors.WithStack(err)))
+		os.Exit(1)
+	}
+
+	logger := slog.New(slogx.ContextHandler{
+		Handler: slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+			Level: slog.Level(conf.Logger.Level),
+		}),
+	})
+
+	slog.SetDefault(logger)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+
+	go func() {
+		<-sig
+		cancel()
+	}()
+
+	slog.InfoContext(ctx, "migrating files", slog.String("from", from), slog.String("to", to))
+
+	migrated, err := setup.MigrateFiles(ctx, conf, from, to, deleteSource)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not migrate files", slogx.Error(errors.WithStack(err)), slog.Int("migrated", migrated))
+		os.Exit(1)
+	}
+
+	slog.InfoContext(ctx, "files migrated", slog.Int("migrated", migrated))
+	slog.InfoContext(ctx, "set OPLET_STORAGE_FILE_BACKEND to use the new backend", slog.String("backend", to))
+}
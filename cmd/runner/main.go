@@ -6,22 +6,59 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"strconv"
+	"time"
 
 	"github.com/bornholm/oplet/internal/runner"
 	"github.com/bornholm/oplet/internal/slogx"
+	"github.com/bornholm/oplet/internal/task"
 	"github.com/pkg/errors"
 )
 
 var (
-	rawLogLevel string = slog.LevelInfo.String()
-	authToken   string = ""
-	serverURL   string = ""
+	rawLogLevel  string        = slog.LevelInfo.String()
+	authToken    string        = ""
+	serverURL    string        = ""
+	slots        int           = 0
+	drainTimeout time.Duration = 0
+	rawTags      string        = ""
+	maxCPUs      float64       = 0
+	rawMaxMemory string        = ""
+	maxFuel      uint64        = 0
+	rawNetworks  string        = ""
+	egressNet    string        = ""
+	egressProxy  string        = ""
+	rawSecurity  string        = ""
+	executorName string        = ""
+	commandsFile string        = ""
+	kubeConfig   string        = ""
+	kubeNs       string        = ""
+	wasmCacheDir string        = ""
+	tempDir      string        = ""
+	session      bool          = false
 )
 
 func init() {
 	flag.StringVar(&rawLogLevel, "log-level", rawLogLevel, "logging level")
 	flag.StringVar(&serverURL, "server-url", serverURL, "server url")
 	flag.StringVar(&authToken, "auth-token", authToken, "auth token")
+	flag.IntVar(&slots, "slots", slots, "maximum number of concurrent executions (default 1)")
+	flag.StringVar(&rawTags, "tags", rawTags, "comma separated tags used to route executions to the runner, ex: arch=arm64,docker")
+	flag.DurationVar(&drainTimeout, "drain-timeout", drainTimeout, "maximum duration to wait for running executions on shutdown (default 5m)")
+	flag.Float64Var(&maxCPUs, "max-cpus", maxCPUs, "maximum number of cpus shared by the executions (default all host cpus)")
+	flag.StringVar(&rawMaxMemory, "max-memory", rawMaxMemory, "maximum memory shared by the executions, ex: 8Gi (default all host memory)")
+	flag.Uint64Var(&maxFuel, "max-fuel", maxFuel, "fuel granted to each wasm execution, consumed on each function call (default unlimited)")
+	flag.StringVar(&rawNetworks, "networks", rawNetworks, "comma separated network modes or names the executions may be attached to (default none,bridge,egress)")
+	flag.StringVar(&egressNet, "egress-network", egressNet, "network attached to the executions requesting the egress mode")
+	flag.StringVar(&egressProxy, "egress-proxy", egressProxy, "url of the filtering proxy used by the executions requesting the egress mode, ex: http://egress-proxy:3128")
+	flag.StringVar(&executorName, "executor", executorName, "executor running the tasks, 'docker', 'process', 'kubernetes' or 'wasm' (default docker)")
+	flag.StringVar(&commandsFile, "process-commands", commandsFile, "json file mapping image references to the commands run by the process executor")
+	flag.StringVar(&kubeConfig, "kubeconfig", kubeConfig, "kubeconfig file used by the kubernetes executor (default in-cluster configuration)")
+	flag.StringVar(&kubeNs, "kube-namespace", kubeNs, "namespace of the jobs created by the kubernetes executor (default default)")
+	flag.StringVar(&wasmCacheDir, "wasm-cache-dir", wasmCacheDir, "directory keeping the modules compiled by the wasm executor (default in memory)")
+	flag.StringVar(&tempDir, "temp-dir", tempDir, "directory where the input and output files are written while they are transferred (default system temporary directory)")
+	flag.BoolVar(&session, "session", session, "open a websocket session with the server, carrying the runner calls and the server commands")
+	flag.StringVar(&rawSecurity, "security-profile", rawSecurity, "comma separated security profile applied to the executions, 'hardened' selecting "+task.HardenedSecurityProfile+" (default none)")
 }
 
 func main() {
@@ -39,9 +76,129 @@ func main() {
 		serverURL = "http://localhost:3002"
 	}
 
+	if rawTags == "" {
+		rawTags = os.Getenv("OPLET_RUNNER_TAGS")
+	}
+
+	if rawNetworks == "" {
+		rawNetworks = os.Getenv("OPLET_RUNNER_NETWORKS")
+	}
+
+	if egressNet == "" {
+		egressNet = os.Getenv("OPLET_RUNNER_EGRESS_NETWORK")
+	}
+
+	if egressProxy == "" {
+		egressProxy = os.Getenv("OPLET_RUNNER_EGRESS_PROXY")
+	}
+
+	if executorName == "" {
+		executorName = os.Getenv("OPLET_RUNNER_EXECUTOR")
+	}
+
+	if commandsFile == "" {
+		commandsFile = os.Getenv("OPLET_RUNNER_PROCESS_COMMANDS")
+	}
+
+	if kubeConfig == "" {
+		kubeConfig = os.Getenv("OPLET_RUNNER_KUBECONFIG")
+	}
+
+	if kubeNs == "" {
+		kubeNs = os.Getenv("OPLET_RUNNER_KUBE_NAMESPACE")
+	}
+
+	if wasmCacheDir == "" {
+		wasmCacheDir = os.Getenv("OPLET_RUNNER_WASM_CACHE_DIR")
+	}
+
+	if rawSecurity == "" {
+		rawSecurity = os.Getenv("OPLET_RUNNER_SECURITY_PROFILE")
+	}
+
+	if tempDir == "" {
+		tempDir = os.Getenv("OPLET_RUNNER_TEMP_DIR")
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	if rawSlots := os.Getenv("OPLET_RUNNER_SLOTS"); slots == 0 && rawSlots != "" {
+		parsed, err := strconv.Atoi(rawSlots)
+		if err != nil {
+			slog.ErrorContext(ctx, "could not parse runner slots", slogx.Error(errors.WithStack(err)))
+			os.Exit(1)
+		}
+
+		slots = parsed
+	}
+
+	if rawDrainTimeout := os.Getenv("OPLET_RUNNER_DRAIN_TIMEOUT"); drainTimeout == 0 && rawDrainTimeout != "" {
+		parsed, err := time.ParseDuration(rawDrainTimeout)
+		if err != nil {
+			slog.ErrorContext(ctx, "could not parse runner drain timeout", slogx.Error(errors.WithStack(err)))
+			os.Exit(1)
+		}
+
+		drainTimeout = parsed
+	}
+
+	if rawMaxCPUs := os.Getenv("OPLET_RUNNER_MAX_CPUS"); maxCPUs == 0 && rawMaxCPUs != "" {
+		parsed, err := task.ParseCPUs(rawMaxCPUs)
+		if err != nil {
+			slog.ErrorContext(ctx, "could not parse runner max cpus", slogx.Error(errors.WithStack(err)))
+			os.Exit(1)
+		}
+
+		maxCPUs = parsed
+	}
+
+	if rawMaxFuel := os.Getenv("OPLET_RUNNER_MAX_FUEL"); maxFuel == 0 && rawMaxFuel != "" {
+		parsed, err := strconv.ParseUint(rawMaxFuel, 10, 64)
+		if err != nil {
+			slog.ErrorContext(ctx, "could not parse runner max fuel", slogx.Error(errors.WithStack(err)))
+			os.Exit(1)
+		}
+
+		maxFuel = parsed
+	}
+
+	if rawSession := os.Getenv("OPLET_RUNNER_SESSION"); !session && rawSession != "" {
+		parsed, err := strconv.ParseBool(rawSession)
+		if err != nil {
+			slog.ErrorContext(ctx, "could not parse runner session", slogx.Error(errors.WithStack(err)))
+			os.Exit(1)
+		}
+
+		session = parsed
+	}
+
+	if rawMaxMemory == "" {
+		rawMaxMemory = os.Getenv("OPLET_RUNNER_MAX_MEMORY")
+	}
+
+	var maxMemory int64
+	if rawMaxMemory != "" {
+		parsed, err := task.ParseMemory(rawMaxMemory)
+		if err != nil {
+			slog.ErrorContext(ctx, "could not parse runner max memory", slogx.Error(errors.WithStack(err)))
+			os.Exit(1)
+		}
+
+		maxMemory = parsed
+	}
+
+	var securityProfile *task.SecurityProfile
+	if rawSecurity != "" {
+		parsed, err := task.ParseSecurityProfile(rawSecurity)
+		if err != nil {
+			slog.ErrorContext(ctx, "could not parse runner security profile", slogx.Error(errors.WithStack(err)))
+			os.Exit(1)
+		}
+
+		securityProfile = &parsed
+	}
+
 	var logLevel slog.Level
 	if err := logLevel.UnmarshalText([]byte(rawLogLevel)); err != nil {
 		slog.ErrorContext(ctx, "could not parse log level", slogx.Error(errors.WithStack(err)))
@@ -63,10 +220,74 @@ func main() {
 	go func() {
 		slog.InfoContext(ctx, "use ctrl+c to interrupt")
 		<-sig
+		slog.InfoContext(ctx, "draining running executions, use ctrl+c again to force exit")
 		cancel()
+		<-sig
+		os.Exit(1)
 	}()
 
-	runner, err := runner.New(serverURL, authToken)
+	runnerOptions := []runner.OptionFunc{}
+
+	if slots != 0 {
+		runnerOptions = append(runnerOptions, runner.WithSlots(slots))
+	}
+
+	if drainTimeout != 0 {
+		runnerOptions = append(runnerOptions, runner.WithDrainTimeout(drainTimeout))
+	}
+
+	if maxCPUs != 0 {
+		runnerOptions = append(runnerOptions, runner.WithMaxCPUs(maxCPUs))
+	}
+
+	if maxMemory != 0 {
+		runnerOptions = append(runnerOptions, runner.WithMaxMemory(maxMemory))
+	}
+
+	if maxFuel != 0 {
+		runnerOptions = append(runnerOptions, runner.WithMaxFuel(maxFuel))
+	}
+
+	if session {
+		runnerOptions = append(runnerOptions, runner.WithSession(session))
+	}
+
+	if tempDir != "" {
+		runnerOptions = append(runnerOptions, runner.WithTempDir(tempDir))
+	}
+
+	if networks := task.ParseNetworks(rawNetworks); len(networks) > 0 {
+		runnerOptions = append(runnerOptions, runner.WithAllowedNetworks(networks...))
+	}
+
+	if egressNet != "" || egressProxy != "" {
+		runnerOptions = append(runnerOptions, runner.WithEgress(egressNet, egressProxy))
+	}
+
+	if executorName != "" {
+		executor, err := runner.NewExecutor(executorName, runner.ExecutorConfig{
+			ProcessCommands: commandsFile,
+			KubeConfig:      kubeConfig,
+			KubeNamespace:   kubeNs,
+			WasmCacheDir:    wasmCacheDir,
+		}, logger)
+		if err != nil {
+			slog.ErrorContext(ctx, "could not create executor", slogx.Error(errors.WithStack(err)))
+			os.Exit(1)
+		}
+
+		runnerOptions = append(runnerOptions, runner.WithExecutor(executor))
+	}
+
+	if securityProfile != nil {
+		runnerOptions = append(runnerOptions, runner.WithSecurityProfile(*securityProfile))
+	}
+
+	if tags := task.ParseTags(rawTags); len(tags) > 0 {
+		runnerOptions = append(runnerOptions, runner.WithTags(tags...))
+	}
+
+	runner, err := runner.New(serverURL, authToken, runnerOptions...)
 	if err != nil {
 		slog.ErrorContext(ctx, "could not create runner", slogx.Error(errors.WithStack(err)))
 		os.Exit(1)
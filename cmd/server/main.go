@@ -47,6 +47,16 @@ func main() {
 		os.Exit(1)
 	}
 
+	if err := setup.StartExecutionReaper(ctx, conf); err != nil {
+		slog.ErrorContext(ctx, "could not start execution reaper", slogx.Error(errors.WithStack(err)))
+		os.Exit(1)
+	}
+
+	if err := setup.StartJanitor(ctx, conf); err != nil {
+		slog.ErrorContext(ctx, "could not start janitor", slogx.Error(errors.WithStack(err)))
+		os.Exit(1)
+	}
+
 	if conf.Runner.Enabled {
 		if err := setup.StartEmbeddedRunner(ctx, conf); err != nil {
 			slog.ErrorContext(ctx, "could start embedded runner", slogx.Error(errors.WithStack(err)))
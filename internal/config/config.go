@@ -6,12 +6,13 @@ import (
 )
 
 type Config struct {
-	Logger  Logger  `envPrefix:"LOGGER_"`
-	HTTP    HTTP    `envPrefix:"HTTP_"`
-	Storage Storage `envPrefix:"STORAGE_"`
-	Seed    Seed    `envPrefix:"SEED_"`
-	Runner  Runner  `envPrefix:"RUNNER_"`
-	I18n    I18n    `envPrefix:"I18N_"`
+	Logger    Logger    `envPrefix:"LOGGER_"`
+	HTTP      HTTP      `envPrefix:"HTTP_"`
+	Storage   Storage   `envPrefix:"STORAGE_"`
+	Seed      Seed      `envPrefix:"SEED_"`
+	Runner    Runner    `envPrefix:"RUNNER_"`
+	Execution Execution `envPrefix:"EXECUTION_"`
+	I18n      I18n      `envPrefix:"I18N_"`
 }
 
 func Parse() (*Config, error) {
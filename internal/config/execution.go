@@ -0,0 +1,25 @@
+package config
+
+import "time"
+
+const (
+	NotifierMemory = "memory"
+	NotifierStore  = "store"
+)
+
+type Execution struct {
+	LeaseDuration  time.Duration `env:"LEASE_DURATION,expand" envDefault:"2m"`
+	ReaperInterval time.Duration `env:"REAPER_INTERVAL,expand" envDefault:"30s"`
+	MaxTimeout     time.Duration `env:"MAX_TIMEOUT,expand" envDefault:"24h"`
+	// Backend notifying the runners waiting for new executions, memory for
+	// a single server instance or store for instances sharing the database,
+	// and interval at which the store backend checks for notifications
+	Notifier         string        `env:"NOTIFIER,expand" envDefault:"memory"`
+	NotifierInterval time.Duration `env:"NOTIFIER_INTERVAL,expand" envDefault:"1s"`
+	// Duration after which the finished executions and their files are
+	// deleted, zero to keep them, and interval between two cleanups. In dry
+	// run mode, the janitor only logs what it would delete.
+	Retention       time.Duration `env:"RETENTION,expand" envDefault:"0"`
+	JanitorInterval time.Duration `env:"JANITOR_INTERVAL,expand" envDefault:"1h"`
+	JanitorDryRun   bool          `env:"JANITOR_DRY_RUN,expand" envDefault:"false"`
+}
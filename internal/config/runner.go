@@ -3,4 +3,30 @@ package config
 type Runner struct {
 	Enabled   bool   `env:"ENABLED,expand" envDefault:"true"`
 	ServerURL string `env:"SERVER,expand" envDefault:"http://127.0.0.1:3002"`
+	Slots     int    `env:"SLOTS,expand" envDefault:"1"`
+	Tags      string `env:"TAGS,expand"`
+	// Maximum resources shared by the executions, all the host
+	// resources if not set
+	MaxCPUs   float64 `env:"MAX_CPUS,expand"`
+	MaxMemory string  `env:"MAX_MEMORY,expand"`
+	// Fuel granted to each WASM execution, unlimited if not set
+	MaxFuel uint64 `env:"MAX_FUEL,expand"`
+	// Network modes or names the executions may be attached to, and
+	// network and proxy used by the executions requesting the egress mode
+	Networks       string `env:"NETWORKS,expand" envDefault:"none,bridge,egress"`
+	EgressNetwork  string `env:"EGRESS_NETWORK,expand"`
+	EgressProxyURL string `env:"EGRESS_PROXY,expand"`
+	// Comma separated security profile applied to the executions, none if
+	// not set, hardened selecting task.HardenedSecurityProfile
+	SecurityProfile string `env:"SECURITY_PROFILE,expand"`
+	// Executor running the tasks, docker, process, kubernetes or wasm, and
+	// their settings, see runner.ExecutorConfig
+	Executor        string `env:"EXECUTOR,expand" envDefault:"docker"`
+	ProcessCommands string `env:"PROCESS_COMMANDS,expand"`
+	KubeConfig      string `env:"KUBECONFIG,expand"`
+	KubeNamespace   string `env:"KUBE_NAMESPACE,expand"`
+	WasmCacheDir    string `env:"WASM_CACHE_DIR,expand"`
+	// Open a websocket session with the server carrying the runner calls
+	// and the server commands, the REST API being used while it is down
+	Session bool `env:"SESSION,expand"`
 }
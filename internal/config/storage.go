@@ -1,5 +1,12 @@
 package config
 
+import "time"
+
+const (
+	FileBackendFilesystem = "filesystem"
+	FileBackendS3         = "s3"
+)
+
 type Storage struct {
 	Database Database `envPrefix:"DATABASE_"`
 	File     File     `envPrefix:"FILE_"`
@@ -10,5 +17,25 @@ type Database struct {
 }
 
 type File struct {
-	Dir string `env:"DIR,expand" envDefault:"data/files"`
+	// Backend storing the input and output files, filesystem or s3
+	Backend string `env:"BACKEND,expand" envDefault:"filesystem"`
+	Dir     string `env:"DIR,expand" envDefault:"data/files"`
+	// Directory where the files are written while their checksum is
+	// computed, the system temporary directory if empty
+	TempDir string `env:"TEMP_DIR,expand"`
+	S3      S3     `envPrefix:"S3_"`
+}
+
+type S3 struct {
+	Endpoint        string `env:"ENDPOINT,expand"`
+	Region          string `env:"REGION,expand" envDefault:"us-east-1"`
+	Bucket          string `env:"BUCKET,expand"`
+	AccessKeyID     string `env:"ACCESS_KEY_ID,expand"`
+	SecretAccessKey string `env:"SECRET_ACCESS_KEY,expand"`
+	PathStyle       bool   `env:"PATH_STYLE,expand" envDefault:"false"`
+	Prefix          string `env:"PREFIX,expand"`
+	// Redirect the downloads to presigned URLs of the bucket instead of
+	// streaming the files through the server, and validity of the URLs
+	PresignedDownloads bool          `env:"PRESIGNED_DOWNLOADS,expand" envDefault:"false"`
+	PresignExpiry      time.Duration `env:"PRESIGN_EXPIRY,expand" envDefault:"15m"`
 }
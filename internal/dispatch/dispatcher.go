@@ -0,0 +1,168 @@
+package dispatch
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bornholm/oplet/internal/slogx"
+	"github.com/pkg/errors"
+)
+
+// ClaimFunc tries to claim an execution and returns true if it succeeded
+type ClaimFunc func(ctx context.Context) (bool, error)
+
+// Dispatcher parks the runners waiting for executions and wakes them up as
+// soon as executions may be claimed. On each notification, the waiting runners
+// are given a turn to claim an execution one after the other, in their
+// arrival order, so that executions are fairly handed off without all the
+// runners racing for them.
+type Dispatcher struct {
+	notifier Notifier
+	logger   *slog.Logger
+
+	mutex   sync.Mutex
+	waiters []*waiter
+}
+
+type waiter struct {
+	// Receives a channel to close once the turn is over
+	turn chan chan struct{}
+	// Closed once the waiter left
+	gone chan struct{}
+}
+
+func NewDispatcher(notifier Notifier, logger *slog.Logger) *Dispatcher {
+	return &Dispatcher{
+		notifier: notifier,
+		logger:   logger.With("component", "dispatcher"),
+		waiters:  make([]*waiter, 0),
+	}
+}
+
+// Run hands off the executions to the waiting runners on each notification,
+// until ctx is done
+func (d *Dispatcher) Run(ctx context.Context) error {
+	notifications, err := d.notifier.Subscribe(ctx)
+	if err != nil {
+		return errors.Wrap(err, "could not subscribe to notifications")
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		case _, ok := <-notifications:
+			if !ok {
+				return errors.WithStack(ctx.Err())
+			}
+
+			d.handOff()
+		}
+	}
+}
+
+// Notify signals that new executions may be claimed
+func (d *Dispatcher) Notify(ctx context.Context) {
+	if err := d.notifier.Notify(ctx); err != nil {
+		d.logger.ErrorContext(ctx, "could not notify new executions", slogx.Error(err))
+	}
+}
+
+// NotifyAt signals that new executions may be claimed at the given time,
+// ex: once delayed executions are due
+func (d *Dispatcher) NotifyAt(ctx context.Context, at time.Time) {
+	ctx = context.WithoutCancel(ctx)
+
+	time.AfterFunc(time.Until(at), func() {
+		d.Notify(ctx)
+	})
+}
+
+// Claim calls claim immediately, then each time the caller is given a turn,
+// until an execution is claimed, claim fails or ctx is done
+func (d *Dispatcher) Claim(ctx context.Context, claim ClaimFunc) error {
+	// Park before the first attempt so that notifications
+	// sent meanwhile are not missed
+	w := d.park()
+	defer d.leave(w)
+
+	claimed, err := claim(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if claimed {
+		return nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+
+		case done := <-w.turn:
+			claimed, err := claim(ctx)
+			close(done)
+
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			if claimed {
+				return nil
+			}
+		}
+	}
+}
+
+// Waiting returns the number of parked callers
+func (d *Dispatcher) Waiting() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return len(d.waiters)
+}
+
+func (d *Dispatcher) park() *waiter {
+	w := &waiter{
+		turn: make(chan chan struct{}),
+		gone: make(chan struct{}),
+	}
+
+	d.mutex.Lock()
+	d.waiters = append(d.waiters, w)
+	d.mutex.Unlock()
+
+	return w
+}
+
+func (d *Dispatcher) leave(w *waiter) {
+	d.mutex.Lock()
+	d.waiters = slices.DeleteFunc(d.waiters, func(other *waiter) bool {
+		return other == w
+	})
+	d.mutex.Unlock()
+
+	close(w.gone)
+}
+
+// handOff gives a turn to each waiter parked at the time of the call, in
+// their arrival order, waiting for each turn to be over before the next one
+func (d *Dispatcher) handOff() {
+	d.mutex.Lock()
+	waiters := slices.Clone(d.waiters)
+	d.mutex.Unlock()
+
+	for _, w := range waiters {
+		done := make(chan struct{})
+
+		select {
+		case w.turn <- done:
+			<-done
+		case <-w.gone:
+		}
+	}
+}
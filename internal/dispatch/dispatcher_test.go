@@ -0,0 +1,78 @@
+package dispatch
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bornholm/oplet/internal/slogx"
+	"github.com/pkg/errors"
+)
+
+func TestDispatcherHandOff(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dispatcher := NewDispatcher(NewMemoryNotifier(), slogx.NewTestLogger(t))
+
+	go func() {
+		if err := dispatcher.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			t.Errorf("%+v", errors.WithStack(err))
+		}
+	}()
+
+	var (
+		mutex     sync.Mutex
+		available int
+		claims    []int
+		wg        sync.WaitGroup
+	)
+
+	const total = 3
+
+	for i := range total {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			err := dispatcher.Claim(ctx, func(ctx context.Context) (bool, error) {
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				if available == 0 {
+					return false, nil
+				}
+
+				available--
+				claims = append(claims, i)
+
+				return true, nil
+			})
+			if err != nil {
+				t.Errorf("%+v", errors.WithStack(err))
+			}
+		}()
+
+		// Ensure waiters are parked in order
+		for dispatcher.Waiting() != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	for range total {
+		mutex.Lock()
+		available++
+		mutex.Unlock()
+
+		dispatcher.Notify(ctx)
+	}
+
+	wg.Wait()
+
+	if e, g := []int{0, 1, 2}, claims; !slices.Equal(e, g) {
+		t.Errorf("claims: expected %v, got %v", e, g)
+	}
+}
@@ -0,0 +1,78 @@
+package dispatch
+
+import (
+	"context"
+	"sync"
+)
+
+const (
+	// SignalNewExecutions is the signal notified when new executions
+	// may be claimed
+	SignalNewExecutions = "dispatch.new-executions"
+	// SignalExecutionLogs is the signal notified when the logs, the status or
+	// the progress of an execution changed, suffixed by its topic
+	SignalExecutionLogs = "dispatch.execution-logs"
+)
+
+// Notifier broadcasts a signal to its subscribers, possibly across several
+// server instances
+type Notifier interface {
+	// Notify signals the subscribers
+	Notify(ctx context.Context) error
+	// Subscribe returns a channel receiving a value after each notification,
+	// notifications being coalesced while the value is not received. The
+	// channel is closed once ctx is done.
+	Subscribe(ctx context.Context) (<-chan struct{}, error)
+}
+
+// MemoryNotifier is a Notifier broadcasting the notifications to the
+// subscribers of the current server instance only
+type MemoryNotifier struct {
+	mutex       sync.Mutex
+	subscribers map[chan struct{}]struct{}
+}
+
+func NewMemoryNotifier() *MemoryNotifier {
+	return &MemoryNotifier{
+		subscribers: make(map[chan struct{}]struct{}),
+	}
+}
+
+// Notify implements Notifier.
+func (n *MemoryNotifier) Notify(ctx context.Context) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	for ch := range n.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// A notification is already pending
+		}
+	}
+
+	return nil
+}
+
+// Subscribe implements Notifier.
+func (n *MemoryNotifier) Subscribe(ctx context.Context) (<-chan struct{}, error) {
+	ch := make(chan struct{}, 1)
+
+	n.mutex.Lock()
+	n.subscribers[ch] = struct{}{}
+	n.mutex.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		n.mutex.Lock()
+		delete(n.subscribers, ch)
+		n.mutex.Unlock()
+
+		close(ch)
+	}()
+
+	return ch, nil
+}
+
+var _ Notifier = &MemoryNotifier{}
@@ -0,0 +1,114 @@
+package dispatch
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/bornholm/oplet/internal/slogx"
+	"github.com/bornholm/oplet/internal/store"
+	"github.com/bornholm/oplet/internal/store/repository/signal"
+	"github.com/pkg/errors"
+)
+
+// StoreNotifier is a Notifier sharing the notifications with the server
+// instances using the same database. Notifications are delivered immediately
+// to the current instance, and to the other ones once they polled the
+// sequence of a signal raised in the database.
+type StoreNotifier struct {
+	repo     *signal.Repository
+	signal   string
+	interval time.Duration
+	local    *MemoryNotifier
+	logger   *slog.Logger
+
+	mutex sync.Mutex
+	seen  uint64
+}
+
+func NewStoreNotifier(store *store.Store, signalID string, interval time.Duration, logger *slog.Logger) *StoreNotifier {
+	return &StoreNotifier{
+		repo:     signal.NewRepository(store),
+		signal:   signalID,
+		interval: interval,
+		local:    NewMemoryNotifier(),
+		logger:   logger.With("component", "store-notifier", "signal", signalID),
+	}
+}
+
+// Notify implements Notifier.
+func (n *StoreNotifier) Notify(ctx context.Context) error {
+	sequence, err := n.repo.Raise(ctx, n.signal)
+	if err != nil {
+		return errors.Wrap(err, "could not raise signal")
+	}
+
+	n.see(sequence)
+
+	return errors.WithStack(n.local.Notify(ctx))
+}
+
+// Subscribe implements Notifier.
+func (n *StoreNotifier) Subscribe(ctx context.Context) (<-chan struct{}, error) {
+	sequence, err := n.repo.GetSequence(ctx, n.signal)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not retrieve signal sequence")
+	}
+
+	n.see(sequence)
+
+	notifications, err := n.local.Subscribe(ctx)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	go n.poll(ctx)
+
+	return notifications, nil
+}
+
+// poll notifies the local subscribers when the signal was raised
+// by another server instance
+func (n *StoreNotifier) poll(ctx context.Context) {
+	ticker := time.NewTicker(n.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		sequence, err := n.repo.GetSequence(ctx, n.signal)
+		if err != nil {
+			n.logger.ErrorContext(ctx, "could not retrieve signal sequence", slogx.Error(err))
+			continue
+		}
+
+		if !n.see(sequence) {
+			continue
+		}
+
+		if err := n.local.Notify(ctx); err != nil {
+			n.logger.ErrorContext(ctx, "could not notify subscribers", slogx.Error(err))
+		}
+	}
+}
+
+// see records the given sequence and returns true if it was not seen yet
+func (n *StoreNotifier) see(sequence uint64) bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if sequence <= n.seen {
+		return false
+	}
+
+	n.seen = sequence
+
+	return true
+}
+
+var _ Notifier = &StoreNotifier{}
@@ -0,0 +1,103 @@
+package dispatch
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// TopicNotifier broadcasts signals to the subscribers of a topic only,
+// possibly across several server instances
+type TopicNotifier interface {
+	// Notify signals the subscribers of the topic
+	Notify(ctx context.Context, topic string) error
+	// Subscribe returns a channel receiving a value after each notification
+	// of the topic, as Notifier.Subscribe
+	Subscribe(ctx context.Context, topic string) (<-chan struct{}, error)
+}
+
+// ExecutionTopic returns the topic of the notifications about an execution
+func ExecutionTopic(executionID uint) string {
+	return strconv.FormatUint(uint64(executionID), 10)
+}
+
+// TopicSignal returns the identifier of the signal of a topic
+func TopicSignal(signalID string, topic string) string {
+	return signalID + "." + topic
+}
+
+// Topics is a TopicNotifier using a Notifier for each topic, created
+// while the topic has subscribers on the current server instance
+type Topics struct {
+	newNotifier func(topic string) Notifier
+
+	mutex  sync.Mutex
+	topics map[string]*topicSubscribers
+}
+
+type topicSubscribers struct {
+	notifier Notifier
+	count    int
+}
+
+func NewTopics(newNotifier func(topic string) Notifier) *Topics {
+	return &Topics{
+		newNotifier: newNotifier,
+		topics:      make(map[string]*topicSubscribers),
+	}
+}
+
+// Notify implements TopicNotifier.
+func (t *Topics) Notify(ctx context.Context, topic string) error {
+	t.mutex.Lock()
+	subscribers, exists := t.topics[topic]
+	t.mutex.Unlock()
+
+	if !exists {
+		// The topic may still have subscribers on other server instances
+		return errors.WithStack(t.newNotifier(topic).Notify(ctx))
+	}
+
+	return errors.WithStack(subscribers.notifier.Notify(ctx))
+}
+
+// Subscribe implements TopicNotifier.
+func (t *Topics) Subscribe(ctx context.Context, topic string) (<-chan struct{}, error) {
+	t.mutex.Lock()
+	subscribers, exists := t.topics[topic]
+	if !exists {
+		subscribers = &topicSubscribers{notifier: t.newNotifier(topic)}
+		t.topics[topic] = subscribers
+	}
+	subscribers.count++
+	t.mutex.Unlock()
+
+	notifications, err := subscribers.notifier.Subscribe(ctx)
+	if err != nil {
+		t.release(topic, subscribers)
+		return nil, errors.WithStack(err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		t.release(topic, subscribers)
+	}()
+
+	return notifications, nil
+}
+
+// release forgets the notifier of the topic once it has no subscribers left
+func (t *Topics) release(topic string, subscribers *topicSubscribers) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	subscribers.count--
+
+	if subscribers.count == 0 && t.topics[topic] == subscribers {
+		delete(t.topics, topic)
+	}
+}
+
+var _ TopicNotifier = &Topics{}
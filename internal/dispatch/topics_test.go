@@ -0,0 +1,76 @@
+package dispatch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestTopics(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	topics := NewTopics(func(topic string) Notifier {
+		return NewMemoryNotifier()
+	})
+
+	first, err := topics.Subscribe(ctx, ExecutionTopic(1))
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	secondCtx, cancelSecond := context.WithCancel(ctx)
+
+	second, err := topics.Subscribe(secondCtx, ExecutionTopic(2))
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	if err := topics.Notify(ctx, ExecutionTopic(1)); err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	select {
+	case <-first:
+	case <-ctx.Done():
+		t.Fatalf("first subscriber: expected a notification")
+	}
+
+	select {
+	case <-second:
+		t.Errorf("second subscriber: expected no notification")
+	default:
+	}
+
+	cancelSecond()
+
+	// The channel is closed once the subscription is done
+	if _, ok := <-second; ok {
+		t.Errorf("second subscriber: expected a closed channel")
+	}
+
+	// Notifying a topic without subscriber is not an error
+	if err := topics.Notify(ctx, ExecutionTopic(3)); err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	// The topic of the second subscriber is released in the background
+	var remaining int
+	for range 100 {
+		topics.mutex.Lock()
+		remaining = len(topics.topics)
+		topics.mutex.Unlock()
+
+		if remaining == 1 {
+			break
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if e, g := 1, remaining; e != g {
+		t.Errorf("remaining topics: expected %d, got %d", e, g)
+	}
+}
@@ -0,0 +1,60 @@
+package file
+
+import (
+	"context"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// ErrChunkLength is returned when the content of an upload chunk is shorter
+// than its declared length
+var ErrChunkLength = errors.New("chunk length mismatch")
+
+// Backend stores the content of the files under keys, slash separated
+// relative paths
+type Backend interface {
+	// Put stores the content of the reader under the given key, replacing the
+	// existing content if any. The size is -1 if it is unknown.
+	Put(ctx context.Context, key string, reader io.Reader, size int64) error
+	// Get opens the content stored under the given key, returning ErrNotFound
+	// if there is none
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete deletes the content stored under the given key, if any
+	Delete(ctx context.Context, key string) error
+	// DeleteAll deletes the content stored under all the keys starting
+	// with the given prefix
+	DeleteAll(ctx context.Context, prefix string) error
+	// List returns the keys starting with the given prefix
+	List(ctx context.Context, prefix string) ([]string, error)
+	// Check returns an error if the backend is not available
+	Check(ctx context.Context) error
+}
+
+// URLSigner is implemented by the backends able to grant a temporary access
+// to the content of a key without going through the server
+type URLSigner interface {
+	SignURL(ctx context.Context, key string, expiry time.Duration, opts URLOptions) (string, error)
+}
+
+// URLOptions are the headers of the responses served by signed URLs
+type URLOptions struct {
+	ContentType        string
+	ContentDisposition string
+}
+
+// IsValidKey returns true if the given key is a clean relative path
+func IsValidKey(key string) bool {
+	if key == "" || path.IsAbs(key) || strings.Contains(key, "\\") {
+		return false
+	}
+
+	cleaned := path.Clean(key)
+
+	return cleaned == key && cleaned != "." && cleaned != ".." && !strings.HasPrefix(cleaned, "../")
+}
@@ -0,0 +1,103 @@
+package file
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// VerifyS3Signature returns an error if the request is not signed, in its
+// headers or its query, with the given credentials
+func VerifyS3Signature(r *http.Request, accessKeyID string, secretAccessKey string, region string) error {
+	signer := &s3Signer{
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+		region:          region,
+	}
+
+	query := r.URL.Query()
+
+	var (
+		amzDate       string
+		credential    string
+		signedHeaders string
+		signature     string
+		payloadHash   string
+	)
+
+	if query.Has("X-Amz-Signature") {
+		amzDate = query.Get("X-Amz-Date")
+		credential = query.Get("X-Amz-Credential")
+		signedHeaders = query.Get("X-Amz-SignedHeaders")
+		signature = query.Get("X-Amz-Signature")
+		payloadHash = unsignedPayload
+
+		expires, err := time.ParseDuration(query.Get("X-Amz-Expires") + "s")
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		date, err := time.Parse(sigV4DateFormat, amzDate)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if time.Now().After(date.Add(expires)) {
+			return errors.New("presigned url expired")
+		}
+	} else {
+		authorization, ok := strings.CutPrefix(r.Header.Get("Authorization"), sigV4Algorithm+" ")
+		if !ok {
+			return errors.New("missing authorization header")
+		}
+
+		for _, field := range strings.Split(authorization, ", ") {
+			name, value, _ := strings.Cut(field, "=")
+			switch name {
+			case "Credential":
+				credential = value
+			case "SignedHeaders":
+				signedHeaders = value
+			case "Signature":
+				signature = value
+			}
+		}
+
+		amzDate = r.Header.Get("X-Amz-Date")
+		payloadHash = r.Header.Get("X-Amz-Content-Sha256")
+	}
+
+	now, err := time.Parse(sigV4DateFormat, amzDate)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if expected := accessKeyID + "/" + signer.scope(now); credential != expected {
+		return errors.Errorf("unexpected credential '%s'", credential)
+	}
+
+	headers := make(map[string]string)
+	for _, name := range strings.Split(signedHeaders, ";") {
+		if name == "host" {
+			headers[name] = r.Host
+			continue
+		}
+
+		headers[name] = strings.Join(r.Header.Values(name), ",")
+	}
+
+	u := *r.URL
+	query.Del("X-Amz-Signature")
+	u.RawQuery = query.Encode()
+
+	canonicalSignedHeaders, canonicalHeaders := canonicalizeHeaders(headers)
+	canonicalRequest := signer.canonicalRequest(r.Method, &u, canonicalHeaders, canonicalSignedHeaders, payloadHash)
+
+	if expected := signer.signature(now, amzDate, signer.scope(now), canonicalRequest); signature != expected {
+		return errors.Errorf("signature mismatch, canonical request:\n%s", canonicalRequest)
+	}
+
+	return nil
+}
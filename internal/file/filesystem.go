@@ -0,0 +1,190 @@
+package file
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// tempFilePrefix prefixes the files being written, renamed once complete
+const tempFilePrefix = ".tmp-"
+
+// FilesystemBackend stores the files in a local directory
+type FilesystemBackend struct {
+	dir string
+}
+
+func NewFilesystemBackend(dir string) *FilesystemBackend {
+	return &FilesystemBackend{
+		dir: dir,
+	}
+}
+
+// Put implements Backend.
+func (b *FilesystemBackend) Put(ctx context.Context, key string, reader io.Reader, size int64) error {
+	filePath, err := b.path(key)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	dirPath := filepath.Dir(filePath)
+
+	if err := os.MkdirAll(dirPath, 0750); err != nil {
+		return errors.Wrapf(err, "failed to create directory %s", dirPath)
+	}
+
+	// The content is written to a temporary file so that incomplete files
+	// are never visible under their key
+	file, err := os.CreateTemp(dirPath, tempFilePrefix+"*")
+	if err != nil {
+		return errors.Wrapf(err, "failed to create file in %s", dirPath)
+	}
+
+	defer os.Remove(file.Name())
+
+	if _, err := io.Copy(file, reader); err != nil {
+		file.Close()
+		return errors.Wrapf(err, "failed to write file %s", filePath)
+	}
+
+	if err := file.Close(); err != nil {
+		return errors.Wrapf(err, "failed to write file %s", filePath)
+	}
+
+	if err := os.Rename(file.Name(), filePath); err != nil {
+		return errors.Wrapf(err, "failed to rename file %s", filePath)
+	}
+
+	return nil
+}
+
+// Get implements Backend.
+func (b *FilesystemBackend) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	filePath, err := b.path(key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, errors.Wrapf(ErrNotFound, "file %s", filePath)
+		}
+
+		return nil, errors.Wrapf(err, "failed to open file %s", filePath)
+	}
+
+	return file, nil
+}
+
+// Delete implements Backend.
+func (b *FilesystemBackend) Delete(ctx context.Context, key string) error {
+	filePath, err := b.path(key)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := os.Remove(filePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return errors.Wrapf(err, "failed to delete file %s", filePath)
+	}
+
+	return nil
+}
+
+// DeleteAll implements Backend.
+func (b *FilesystemBackend) DeleteAll(ctx context.Context, prefix string) error {
+	// Prefixes of directories are deleted at once
+	if dirKey, ok := strings.CutSuffix(prefix, "/"); ok && IsValidKey(dirKey) {
+		dirPath := filepath.Join(b.dir, filepath.FromSlash(dirKey))
+
+		if err := os.RemoveAll(dirPath); err != nil {
+			return errors.Wrapf(err, "failed to delete directory %s", dirPath)
+		}
+
+		return nil
+	}
+
+	keys, err := b.List(ctx, prefix)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for _, key := range keys {
+		if err := b.Delete(ctx, key); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
+
+// List implements Backend.
+func (b *FilesystemBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	// Only the directory holding the keys with the given prefix is walked
+	root := b.dir
+	if dirKey := path.Dir(prefix); dirKey != "." {
+		if !IsValidKey(dirKey) {
+			return nil, errors.Errorf("invalid key prefix '%s'", prefix)
+		}
+
+		root = filepath.Join(b.dir, filepath.FromSlash(dirKey))
+	}
+
+	keys := make([]string, 0)
+
+	err := filepath.WalkDir(root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+
+			return errors.WithStack(err)
+		}
+
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), tempFilePrefix) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(b.dir, filePath)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list files of %s", root)
+	}
+
+	return keys, nil
+}
+
+// Check implements Backend.
+func (b *FilesystemBackend) Check(ctx context.Context) error {
+	if err := os.MkdirAll(b.dir, 0750); err != nil {
+		return errors.Wrapf(err, "failed to create directory %s", b.dir)
+	}
+
+	return nil
+}
+
+func (b *FilesystemBackend) path(key string) (string, error) {
+	if !IsValidKey(key) {
+		return "", errors.Errorf("invalid key '%s'", key)
+	}
+
+	return filepath.Join(b.dir, filepath.FromSlash(key)), nil
+}
+
+var _ Backend = &FilesystemBackend{}
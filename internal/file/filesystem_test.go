@@ -0,0 +1,14 @@
+package file_test
+
+import (
+	"testing"
+
+	"github.com/bornholm/oplet/internal/file"
+	"github.com/bornholm/oplet/internal/file/testsuite"
+)
+
+func TestFilesystemBackend(t *testing.T) {
+	backend := file.NewFilesystemBackend(t.TempDir())
+
+	testsuite.RunBackendTestSuite(t, backend)
+}
@@ -0,0 +1,463 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// defaultS3PartSize is the size of the parts of the multipart uploads, used
+// for the content whose size is unknown
+const defaultS3PartSize = 8 << 20
+
+type S3Options struct {
+	// URL of the S3 compatible endpoint, ex: https://s3.eu-west-1.amazonaws.com
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// Address the bucket in the path of the URLs instead of the host name,
+	// as expected by most self-hosted implementations
+	PathStyle bool
+	// Prefix of the keys in the bucket
+	Prefix     string
+	HTTPClient *http.Client
+}
+
+// S3Backend stores the files in a bucket of an S3 compatible object storage
+type S3Backend struct {
+	endpoint  *url.URL
+	bucket    string
+	pathStyle bool
+	prefix    string
+	partSize  int
+	signer    *s3Signer
+	client    *http.Client
+}
+
+func NewS3Backend(opts S3Options) (*S3Backend, error) {
+	if opts.Endpoint == "" {
+		return nil, errors.New("s3 endpoint is required")
+	}
+
+	if opts.Bucket == "" {
+		return nil, errors.New("s3 bucket is required")
+	}
+
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid s3 endpoint '%s'", opts.Endpoint)
+	}
+
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, errors.Errorf("invalid s3 endpoint '%s', must be an http or https url", opts.Endpoint)
+	}
+
+	region := opts.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	client := opts.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	prefix := strings.Trim(opts.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	return &S3Backend{
+		endpoint:  endpoint,
+		bucket:    opts.Bucket,
+		pathStyle: opts.PathStyle,
+		prefix:    prefix,
+		partSize:  defaultS3PartSize,
+		signer: &s3Signer{
+			accessKeyID:     opts.AccessKeyID,
+			secretAccessKey: opts.SecretAccessKey,
+			region:          region,
+		},
+		client: client,
+	}, nil
+}
+
+// Put implements Backend.
+func (b *S3Backend) Put(ctx context.Context, key string, reader io.Reader, size int64) error {
+	if !IsValidKey(key) {
+		return errors.Errorf("invalid key '%s'", key)
+	}
+
+	if size >= 0 {
+		return b.putObject(ctx, key, reader, size)
+	}
+
+	// The object is sent in a single request if it fits in a part
+	part := make([]byte, b.partSize)
+
+	n, err := io.ReadFull(reader, part)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return b.putObject(ctx, key, bytes.NewReader(part[:n]), int64(n))
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return b.putMultipartObject(ctx, key, io.MultiReader(bytes.NewReader(part), reader))
+}
+
+func (b *S3Backend) putObject(ctx context.Context, key string, reader io.Reader, size int64) error {
+	res, err := b.do(ctx, http.MethodPut, b.objectKey(key), nil, reader, size)
+	if err != nil {
+		return errors.Wrapf(err, "failed to put object %s", key)
+	}
+
+	defer res.Body.Close()
+
+	return nil
+}
+
+type s3InitiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type s3CompleteMultipartUpload struct {
+	XMLName xml.Name         `xml:"CompleteMultipartUpload"`
+	Parts   []s3UploadedPart `xml:"Part"`
+}
+
+type s3UploadedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (b *S3Backend) putMultipartObject(ctx context.Context, key string, reader io.Reader) error {
+	objectKey := b.objectKey(key)
+
+	var initiated s3InitiateMultipartUploadResult
+	if err := b.doXML(ctx, http.MethodPost, objectKey, url.Values{"uploads": {""}}, nil, &initiated); err != nil {
+		return errors.Wrapf(err, "failed to initiate multipart upload of object %s", key)
+	}
+
+	uploadQuery := url.Values{"uploadId": {initiated.UploadID}}
+
+	complete, err := b.uploadParts(ctx, objectKey, initiated.UploadID, reader)
+	if err != nil {
+		// Parts already uploaded are discarded
+		if res, abortErr := b.do(context.WithoutCancel(ctx), http.MethodDelete, objectKey, uploadQuery, nil, 0); abortErr == nil {
+			res.Body.Close()
+		}
+
+		return errors.Wrapf(err, "failed to upload parts of object %s", key)
+	}
+
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := b.doXML(ctx, http.MethodPost, objectKey, uploadQuery, body, nil); err != nil {
+		return errors.Wrapf(err, "failed to complete multipart upload of object %s", key)
+	}
+
+	return nil
+}
+
+func (b *S3Backend) uploadParts(ctx context.Context, objectKey string, uploadID string, reader io.Reader) (*s3CompleteMultipartUpload, error) {
+	complete := &s3CompleteMultipartUpload{}
+	part := make([]byte, b.partSize)
+
+	for number := 1; ; number++ {
+		n, err := io.ReadFull(reader, part)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, errors.WithStack(err)
+		}
+
+		query := url.Values{
+			"partNumber": {strconv.Itoa(number)},
+			"uploadId":   {uploadID},
+		}
+
+		res, err := b.do(ctx, http.MethodPut, objectKey, query, bytes.NewReader(part[:n]), int64(n))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to upload part %d", number)
+		}
+
+		res.Body.Close()
+
+		complete.Parts = append(complete.Parts, s3UploadedPart{
+			PartNumber: number,
+			ETag:       res.Header.Get("ETag"),
+		})
+
+		if n < len(part) {
+			break
+		}
+	}
+
+	return complete, nil
+}
+
+// Get implements Backend.
+func (b *S3Backend) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if !IsValidKey(key) {
+		return nil, errors.Errorf("invalid key '%s'", key)
+	}
+
+	res, err := b.do(ctx, http.MethodGet, b.objectKey(key), nil, nil, 0)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get object %s", key)
+	}
+
+	return res.Body, nil
+}
+
+// Delete implements Backend.
+func (b *S3Backend) Delete(ctx context.Context, key string) error {
+	if !IsValidKey(key) {
+		return errors.Errorf("invalid key '%s'", key)
+	}
+
+	res, err := b.do(ctx, http.MethodDelete, b.objectKey(key), nil, nil, 0)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+
+		return errors.Wrapf(err, "failed to delete object %s", key)
+	}
+
+	defer res.Body.Close()
+
+	return nil
+}
+
+// DeleteAll implements Backend.
+func (b *S3Backend) DeleteAll(ctx context.Context, prefix string) error {
+	keys, err := b.List(ctx, prefix)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for _, key := range keys {
+		if err := b.Delete(ctx, key); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
+
+type s3ListBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List implements Backend.
+func (b *S3Backend) List(ctx context.Context, prefix string) ([]string, error) {
+	keys := make([]string, 0)
+	continuationToken := ""
+
+	for {
+		query := url.Values{
+			"list-type": {"2"},
+			"prefix":    {b.prefix + prefix},
+		}
+
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+
+		var result s3ListBucketResult
+		if err := b.doXML(ctx, http.MethodGet, "", query, nil, &result); err != nil {
+			return nil, errors.Wrapf(err, "failed to list objects with prefix %s", prefix)
+		}
+
+		for _, content := range result.Contents {
+			keys = append(keys, strings.TrimPrefix(content.Key, b.prefix))
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+
+		continuationToken = result.NextContinuationToken
+	}
+}
+
+// Check implements Backend.
+func (b *S3Backend) Check(ctx context.Context) error {
+	res, err := b.do(ctx, http.MethodHead, "", nil, nil, 0)
+	if err != nil {
+		return errors.Wrapf(err, "failed to reach bucket %s", b.bucket)
+	}
+
+	defer res.Body.Close()
+
+	return nil
+}
+
+// SignURL implements URLSigner.
+func (b *S3Backend) SignURL(ctx context.Context, key string, expiry time.Duration, opts URLOptions) (string, error) {
+	if !IsValidKey(key) {
+		return "", errors.Errorf("invalid key '%s'", key)
+	}
+
+	query := url.Values{}
+
+	if opts.ContentType != "" {
+		query.Set("response-content-type", opts.ContentType)
+	}
+
+	if opts.ContentDisposition != "" {
+		query.Set("response-content-disposition", opts.ContentDisposition)
+	}
+
+	u := b.url(b.objectKey(key), query)
+	b.signer.presignURL(http.MethodGet, u, expiry, time.Now())
+
+	return u.String(), nil
+}
+
+func (b *S3Backend) objectKey(key string) string {
+	return b.prefix + key
+}
+
+// url returns the URL of the given object of the bucket, or of the bucket
+// itself if the object key is empty
+func (b *S3Backend) url(objectKey string, query url.Values) *url.URL {
+	u := *b.endpoint
+
+	path := strings.TrimSuffix(u.Path, "/")
+	if b.pathStyle {
+		path += "/" + b.bucket
+	} else {
+		u.Host = b.bucket + "." + u.Host
+	}
+
+	if objectKey != "" {
+		path += "/" + objectKey
+	}
+
+	u.Path = path
+	u.RawPath = uriEncode(path, false)
+	u.RawQuery = canonicalizeQuery(query)
+
+	return &u
+}
+
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// do sends a signed request, returning an error if the response is not
+// successful
+func (b *S3Backend) do(ctx context.Context, method string, objectKey string, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	// Empty bodies would otherwise be sent with a chunked transfer encoding
+	if body != nil && size == 0 {
+		body = http.NoBody
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, b.url(objectKey, query).String(), body)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if body != nil {
+		req.ContentLength = size
+	}
+
+	payloadHash := unsignedPayload
+	if body == nil {
+		payloadHash = emptyPayloadHash
+	}
+
+	b.signer.signRequest(req, payloadHash, time.Now())
+
+	res, err := b.client.Do(req)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+
+	defer res.Body.Close()
+
+	var s3Err s3Error
+	if data, err := io.ReadAll(io.LimitReader(res.Body, 1<<16)); err == nil && len(data) > 0 {
+		xml.Unmarshal(data, &s3Err)
+	}
+
+	if res.StatusCode == http.StatusNotFound && s3Err.Code != "NoSuchBucket" && s3Err.Code != "NoSuchUpload" {
+		return nil, errors.WithStack(ErrNotFound)
+	}
+
+	if s3Err.Code == "" {
+		s3Err.Code = res.Status
+	}
+
+	return nil, errors.Errorf("s3 request failed: %s %s", s3Err.Code, s3Err.Message)
+}
+
+// doXML sends a signed request with the given XML body and decodes the
+// XML response in the given result, if any
+func (b *S3Backend) doXML(ctx context.Context, method string, objectKey string, query url.Values, body []byte, result any) error {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	res, err := b.do(ctx, method, objectKey, query, reader, int64(len(body)))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Failures of the completion of multipart uploads are reported in
+	// successful responses
+	if bytes.Contains(data, []byte("<Error>")) {
+		var s3Err s3Error
+		xml.Unmarshal(data, &s3Err)
+		return errors.Errorf("s3 request failed: %s %s", s3Err.Code, s3Err.Message)
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	if err := xml.Unmarshal(data, result); err != nil {
+		return errors.Wrap(err, "could not decode s3 response")
+	}
+
+	return nil
+}
+
+var (
+	_ Backend   = &S3Backend{}
+	_ URLSigner = &S3Backend{}
+)
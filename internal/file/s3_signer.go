@@ -0,0 +1,194 @@
+package file
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AWS signature version 4, see
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-authenticating-requests.html
+
+const (
+	sigV4Algorithm   = "AWS4-HMAC-SHA256"
+	sigV4DateFormat  = "20060102T150405Z"
+	sigV4Service     = "s3"
+	unsignedPayload  = "UNSIGNED-PAYLOAD"
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// unsignedHeaders are the headers which can be modified by the HTTP client
+// or the proxies once the request is signed
+var unsignedHeaders = []string{"authorization", "user-agent", "accept-encoding", "content-length", "connection"}
+
+type s3Signer struct {
+	accessKeyID     string
+	secretAccessKey string
+	region          string
+}
+
+// signRequest adds the authorization header to the request, the hash of its
+// payload being given
+func (s *s3Signer) signRequest(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format(sigV4DateFormat)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{
+		"host": req.URL.Host,
+	}
+
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if slices.Contains(unsignedHeaders, name) {
+			continue
+		}
+
+		headers[name] = strings.Join(values, ",")
+	}
+
+	signedHeaders, canonicalHeaders := canonicalizeHeaders(headers)
+	canonicalRequest := s.canonicalRequest(req.Method, req.URL, canonicalHeaders, signedHeaders, payloadHash)
+	scope := s.scope(now)
+	signature := s.signature(now, amzDate, scope, canonicalRequest)
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, s.accessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+// presignURL adds the authentication query parameters to the URL, granting
+// an access to the resource for the given duration
+func (s *s3Signer) presignURL(method string, u *url.URL, expiry time.Duration, now time.Time) {
+	amzDate := now.UTC().Format(sigV4DateFormat)
+	scope := s.scope(now)
+
+	query := u.Query()
+	query.Set("X-Amz-Algorithm", sigV4Algorithm)
+	query.Set("X-Amz-Credential", s.accessKeyID+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.FormatInt(int64(expiry/time.Second), 10))
+	query.Set("X-Amz-SignedHeaders", "host")
+	u.RawQuery = canonicalizeQuery(query)
+
+	signedHeaders, canonicalHeaders := canonicalizeHeaders(map[string]string{"host": u.Host})
+	canonicalRequest := s.canonicalRequest(method, u, canonicalHeaders, signedHeaders, unsignedPayload)
+	signature := s.signature(now, amzDate, scope, canonicalRequest)
+
+	u.RawQuery += "&X-Amz-Signature=" + signature
+}
+
+func (s *s3Signer) canonicalRequest(method string, u *url.URL, canonicalHeaders string, signedHeaders string, payloadHash string) string {
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	return strings.Join([]string{
+		method,
+		path,
+		canonicalizeQuery(u.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+}
+
+func (s *s3Signer) scope(now time.Time) string {
+	return strings.Join([]string{now.UTC().Format("20060102"), s.region, sigV4Service, "aws4_request"}, "/")
+}
+
+func (s *s3Signer) signature(now time.Time, amzDate string, scope string, canonicalRequest string) string {
+	hash := sha256.Sum256([]byte(canonicalRequest))
+
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		amzDate,
+		scope,
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretAccessKey), now.UTC().Format("20060102"))
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, sigV4Service)
+	key = hmacSHA256(key, "aws4_request")
+
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func canonicalizeHeaders(headers map[string]string) (string, string) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	var canonical strings.Builder
+
+	for _, name := range names {
+		canonical.WriteString(name)
+		canonical.WriteString(":")
+		canonical.WriteString(strings.Join(strings.Fields(headers[name]), " "))
+		canonical.WriteString("\n")
+	}
+
+	return strings.Join(names, ";"), canonical.String()
+}
+
+func canonicalizeQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+
+	slices.Sort(keys)
+
+	parts := make([]string, 0, len(keys))
+
+	for _, key := range keys {
+		values := slices.Clone(query[key])
+		slices.Sort(values)
+
+		for _, value := range values {
+			parts = append(parts, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
+
+// uriEncode encodes all the characters but the unreserved ones, and the
+// slashes unless told otherwise
+func uriEncode(value string, encodeSlash bool) string {
+	var encoded strings.Builder
+
+	for _, b := range []byte(value) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9',
+			b == '-', b == '_', b == '.', b == '~':
+			encoded.WriteByte(b)
+		case b == '/' && !encodeSlash:
+			encoded.WriteByte(b)
+		default:
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+
+	return encoded.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
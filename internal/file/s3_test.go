@@ -0,0 +1,380 @@
+package file_test
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bornholm/oplet/internal/file"
+	"github.com/bornholm/oplet/internal/file/testsuite"
+)
+
+const (
+	testS3Bucket          = "oplet"
+	testS3Region          = "eu-west-1"
+	testS3AccessKeyID     = "oplet-access-key"
+	testS3SecretAccessKey = "oplet-secret-key"
+)
+
+func TestS3Backend(t *testing.T) {
+	server := httptest.NewServer(newFakeS3(t))
+	defer server.Close()
+
+	backend := newTestS3Backend(t, server.URL, "")
+
+	testsuite.RunBackendTestSuite(t, backend)
+}
+
+func TestS3BackendPrefix(t *testing.T) {
+	server := httptest.NewServer(newFakeS3(t))
+	defer server.Close()
+
+	backend := newTestS3Backend(t, server.URL, "/oplet/files/")
+
+	testsuite.RunBackendTestSuite(t, backend)
+}
+
+func TestS3BackendSignURL(t *testing.T) {
+	server := httptest.NewServer(newFakeS3(t))
+	defer server.Close()
+
+	ctx := context.Background()
+	backend := newTestS3Backend(t, server.URL, "files")
+
+	content := "col1,col2\n1,2\n"
+	key := "executions/1/outputs/rapport final_1_abcd.csv"
+
+	if err := backend.Put(ctx, key, strings.NewReader(content), int64(len(content))); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	signedURL, err := backend.SignURL(ctx, key, time.Minute, file.URLOptions{
+		ContentType:        "text/csv",
+		ContentDisposition: `attachment; filename="rapport final.csv"`,
+	})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	res, err := http.Get(signedURL)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d", res.StatusCode)
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if string(body) != content {
+		t.Errorf("unexpected content '%s'", body)
+	}
+
+	if contentType := res.Header.Get("Content-Type"); contentType != "text/csv" {
+		t.Errorf("unexpected content type '%s'", contentType)
+	}
+
+	if disposition := res.Header.Get("Content-Disposition"); disposition != `attachment; filename="rapport final.csv"` {
+		t.Errorf("unexpected content disposition '%s'", disposition)
+	}
+
+	// Tampering with the signed URL is rejected
+	res, err = http.Get(strings.Replace(signedURL, "text%2Fcsv", "text%2Fhtml", 1))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("unexpected status %d for a tampered url", res.StatusCode)
+	}
+}
+
+func newTestS3Backend(t *testing.T, endpoint string, prefix string) *file.S3Backend {
+	backend, err := file.NewS3Backend(file.S3Options{
+		Endpoint:        endpoint,
+		Region:          testS3Region,
+		Bucket:          testS3Bucket,
+		AccessKeyID:     testS3AccessKeyID,
+		SecretAccessKey: testS3SecretAccessKey,
+		PathStyle:       true,
+		Prefix:          prefix,
+	})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	return backend
+}
+
+// fakeS3 is an in memory stand-in of an S3 compatible object storage,
+// implementing the subset of the API used by the backend with path style
+// URLs
+type fakeS3 struct {
+	t       *testing.T
+	mutex   sync.Mutex
+	objects map[string][]byte
+	uploads map[string]map[int][]byte
+	nextID  int
+}
+
+// fakeS3PageSize is small so that the listings are paginated
+const fakeS3PageSize = 2
+
+func newFakeS3(t *testing.T) *fakeS3 {
+	return &fakeS3{
+		t:       t,
+		objects: make(map[string][]byte),
+		uploads: make(map[string]map[int][]byte),
+	}
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := file.VerifyS3Signature(r, testS3AccessKeyID, testS3SecretAccessKey, testS3Region); err != nil {
+		s.t.Logf("invalid signature of %s %s: %+v", r.Method, r.URL, err)
+		s.writeError(w, http.StatusForbidden, "SignatureDoesNotMatch")
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != testS3Bucket {
+		s.writeError(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	query := r.URL.Query()
+
+	switch {
+	case key == "" && r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+
+	case key == "" && r.Method == http.MethodGet:
+		s.listObjects(w, r)
+
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		s.nextID++
+		uploadID := strconv.Itoa(s.nextID)
+		s.uploads[uploadID] = make(map[int][]byte)
+
+		s.writeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadId string
+		}{Bucket: bucket, Key: key, UploadId: uploadID})
+
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		parts, exists := s.uploads[query.Get("uploadId")]
+		if !exists {
+			s.writeError(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+
+		number, err := strconv.Atoi(query.Get("partNumber"))
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, "InvalidArgument")
+			return
+		}
+
+		data, ok := s.readBody(w, r)
+		if !ok {
+			return
+		}
+
+		parts[number] = data
+		w.Header().Set("ETag", etag(data))
+
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		s.completeUpload(w, r, key)
+
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(s.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPut:
+		data, ok := s.readBody(w, r)
+		if !ok {
+			return
+		}
+
+		s.objects[key] = data
+		w.Header().Set("ETag", etag(data))
+
+	case r.Method == http.MethodGet:
+		data, exists := s.objects[key]
+		if !exists {
+			s.writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+
+		if contentType := query.Get("response-content-type"); contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+
+		if disposition := query.Get("response-content-disposition"); disposition != "" {
+			w.Header().Set("Content-Disposition", disposition)
+		}
+
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Write(data)
+
+	case r.Method == http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		s.writeError(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (s *fakeS3) listObjects(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	after := query.Get("continuation-token")
+
+	keys := make([]string, 0)
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+
+	slices.Sort(keys)
+
+	type content struct {
+		Key  string
+		Size int
+	}
+
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Prefix                string
+		KeyCount              int
+		IsTruncated           bool
+		NextContinuationToken string    `xml:",omitempty"`
+		Contents              []content `xml:"Contents"`
+	}{
+		Prefix: prefix,
+	}
+
+	if len(keys) > fakeS3PageSize {
+		keys = keys[:fakeS3PageSize]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[len(keys)-1]
+	}
+
+	for _, key := range keys {
+		result.Contents = append(result.Contents, content{Key: key, Size: len(s.objects[key])})
+	}
+
+	result.KeyCount = len(result.Contents)
+
+	s.writeXML(w, result)
+}
+
+func (s *fakeS3) completeUpload(w http.ResponseWriter, r *http.Request, key string) {
+	uploadID := r.URL.Query().Get("uploadId")
+
+	parts, exists := s.uploads[uploadID]
+	if !exists {
+		s.writeError(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+
+	var complete struct {
+		Parts []struct {
+			PartNumber int
+			ETag       string
+		} `xml:"Part"`
+	}
+
+	if err := xml.NewDecoder(r.Body).Decode(&complete); err != nil {
+		s.writeError(w, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+
+	var data []byte
+
+	for i, part := range complete.Parts {
+		content, exists := parts[part.PartNumber]
+		if part.PartNumber != i+1 || !exists || part.ETag != etag(content) {
+			// Errors of the completion are reported in successful responses
+			s.writeXML(w, struct {
+				XMLName xml.Name `xml:"Error"`
+				Code    string
+			}{Code: "InvalidPart"})
+			return
+		}
+
+		data = append(data, content...)
+	}
+
+	s.objects[key] = data
+	delete(s.uploads, uploadID)
+
+	s.writeXML(w, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Key     string
+		ETag    string
+	}{Key: key, ETag: etag(data)})
+}
+
+func (s *fakeS3) readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	if r.ContentLength < 0 || len(r.TransferEncoding) > 0 {
+		s.writeError(w, http.StatusLengthRequired, "MissingContentLength")
+		return nil, false
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "IncompleteBody")
+		return nil, false
+	}
+
+	return data, true
+}
+
+func (s *fakeS3) writeXML(w http.ResponseWriter, value any) {
+	data, err := xml.Marshal(value)
+	if err != nil {
+		s.t.Errorf("%+v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+}
+
+func (s *fakeS3) writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s<Error><Code>%s</Code></Error>", xml.Header, code)
+}
+
+func etag(data []byte) string {
+	hash := md5.Sum(data)
+	return `"` + hex.EncodeToString(hash[:]) + `"`
+}
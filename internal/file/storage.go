@@ -1,259 +1,437 @@
 package file
 
 import (
-	"crypto/rand"
+	"bufio"
+	"context"
 	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"log/slog"
+	"mime"
 	"net/http"
 	"os"
-	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 )
 
+const (
+	// ExecutionsPrefix prefixes the keys of the files stored by execution,
+	// before the deduplication of their content
+	ExecutionsPrefix = "executions/"
+	// BlobsPrefix prefixes the keys of the blobs, named after the checksum
+	// of their content
+	BlobsPrefix = "blobs/"
+	// UploadsPrefix prefixes the keys of the chunks of the uploads in
+	// progress, named after their offset
+	UploadsPrefix = "uploads/"
+)
+
+// BlobIndex records the blobs stored in the backend
+type BlobIndex interface {
+	// Touch marks the blob with the given checksum as stored again,
+	// returning false if it is unknown
+	Touch(ctx context.Context, checksum string) (bool, error)
+	// Register records the blob with the given checksum once its content
+	// is stored
+	Register(ctx context.Context, checksum string, size int64) error
+}
+
+// Storage stores the input and output files of the executions in a backend,
+// as blobs shared by the files having the same content
 type Storage struct {
-	basePath string
-	logger   *slog.Logger
+	backend   Backend
+	blobs     BlobIndex
+	urlExpiry time.Duration
+	tempDir   string
+	logger    *slog.Logger
 }
 
 type StoredFile struct {
-	ID           string
 	OriginalName string
-	StoredPath   string
-	Size         int64
-	MimeType     string
-	Checksum     string
+	// Key of the file in the backend
+	Key      string
+	Size     int64
+	MimeType string
+	Checksum string
+	// True if the content was already stored
+	Deduplicated bool
 }
 
-func NewStorage(basePath string, logger *slog.Logger) *Storage {
-	return &Storage{
-		basePath: basePath,
-		logger:   logger.With("component", "file-storage"),
+type StorageOptionFunc func(s *Storage)
+
+// WithTempDir sets the directory where the files are written while their
+// checksum is computed, the system temporary directory by default
+func WithTempDir(dir string) StorageOptionFunc {
+	return func(s *Storage) {
+		s.tempDir = dir
 	}
 }
 
-func (fs *Storage) GetBasePath() string {
-	return fs.basePath
+// WithSignedURLs enables the downloads of the files through URLs signed by
+// the backend, valid for the given duration, if it supports it
+func WithSignedURLs(expiry time.Duration) StorageOptionFunc {
+	return func(s *Storage) {
+		s.urlExpiry = expiry
+	}
 }
 
-func (fs *Storage) StoreInputFile(executionID uint, filename string, reader io.Reader) (*StoredFile, error) {
-	return fs.storeFile(executionID, "inputs", filename, reader)
+func NewStorage(backend Backend, blobs BlobIndex, logger *slog.Logger, funcs ...StorageOptionFunc) *Storage {
+	storage := &Storage{
+		backend: backend,
+		blobs:   blobs,
+		logger:  logger.With("component", "file-storage"),
+	}
+
+	for _, fn := range funcs {
+		fn(storage)
+	}
+
+	return storage
+}
+
+// Backend returns the backend holding the content of the files
+func (fs *Storage) Backend() Backend {
+	return fs.backend
 }
 
-func (fs *Storage) StoreOutputFile(executionID uint, filename string, reader io.Reader) (*StoredFile, error) {
-	return fs.storeFile(executionID, "outputs", filename, reader)
+func (fs *Storage) StoreInputFile(ctx context.Context, executionID uint, filename string, reader io.Reader) (*StoredFile, error) {
+	return fs.storeFile(ctx, executionID, "input", filename, reader)
 }
 
-func (fs *Storage) storeFile(executionID uint, subdir, filename string, reader io.Reader) (*StoredFile, error) {
-	// Create directory structure
-	dirPath := filepath.Join(fs.basePath, "executions", fmt.Sprintf("%d", executionID), subdir)
+func (fs *Storage) StoreOutputFile(ctx context.Context, executionID uint, filename string, reader io.Reader) (*StoredFile, error) {
+	return fs.storeFile(ctx, executionID, "output", filename, reader)
+}
+
+// StoreUploadChunk stores the chunk of the upload starting at the given
+// offset, the previous content of the chunk being overwritten. It returns
+// ErrChunkLength if the content is shorter than the given size, the bytes
+// beyond it being ignored.
+func (fs *Storage) StoreUploadChunk(ctx context.Context, uploadID string, offset int64, reader io.Reader, size int64) error {
+	key := uploadChunkKey(uploadID, offset)
+
+	chunk := &exactReader{reader: reader, remaining: size}
 
-	if err := os.MkdirAll(dirPath, 0750); err != nil {
-		return nil, errors.Wrapf(err, "failed to create directory %s", dirPath)
+	if err := fs.backend.Put(ctx, key, chunk, size); err != nil {
+		if errors.Is(err, ErrChunkLength) {
+			return errors.Wrapf(err, "invalid upload chunk %s", key)
+		}
+
+		return errors.Wrapf(err, "failed to store upload chunk %s", key)
+	}
+
+	// Backends may stop reading at the given size without error
+	if chunk.remaining > 0 {
+		if err := fs.backend.Delete(ctx, key); err != nil && !errors.Is(err, ErrNotFound) {
+			fs.logger.Warn("could not delete invalid upload chunk", "key", key, "error", err)
+		}
+
+		return errors.Wrapf(ErrChunkLength, "invalid upload chunk %s", key)
+	}
+
+	return nil
+}
+
+// StoreUpload assembles the chunks received for the upload, up to the given
+// size, into a file and deletes them
+func (fs *Storage) StoreUpload(ctx context.Context, uploadID string, filename string, size int64) (*StoredFile, error) {
+	keys, err := fs.backend.List(ctx, uploadPrefix(uploadID))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list chunks of upload %s", uploadID)
 	}
 
-	// Generate unique filename to avoid conflicts
-	storedFilename := fs.generateUniqueFilename(filename)
-	storedPath := filepath.Join(dirPath, storedFilename)
+	// Chunk keys are named after their zero padded offset
+	slices.Sort(keys)
+
+	reader := &chunkReader{ctx: ctx, backend: fs.backend, keys: keys}
+	defer reader.Close()
 
-	// Create file
-	file, err := os.Create(storedPath)
+	storedFile, err := fs.storeContent(ctx, filename, io.LimitReader(reader, size), "upload_id", uploadID)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to create file %s", storedPath)
+		return nil, errors.WithStack(err)
+	}
+
+	if storedFile.Size != size {
+		return nil, errors.Errorf("upload %s is incomplete: expected %d bytes, got %d", uploadID, size, storedFile.Size)
+	}
+
+	if err := fs.DeleteUpload(ctx, uploadID); err != nil {
+		fs.logger.Warn("could not delete upload chunks", "upload_id", uploadID, "error", err)
+	}
+
+	return storedFile, nil
+}
+
+// DeleteUpload deletes the chunks received for the upload
+func (fs *Storage) DeleteUpload(ctx context.Context, uploadID string) error {
+	prefix := uploadPrefix(uploadID)
+	if err := fs.backend.DeleteAll(ctx, prefix); err != nil {
+		return errors.Wrapf(err, "failed to delete upload chunks %s", prefix)
+	}
+
+	return nil
+}
+
+func (fs *Storage) storeFile(ctx context.Context, executionID uint, kind, filename string, reader io.Reader) (*StoredFile, error) {
+	return fs.storeContent(ctx, filename, reader, "execution_id", executionID, "kind", kind)
+}
+
+func (fs *Storage) storeContent(ctx context.Context, filename string, reader io.Reader, attrs ...any) (*StoredFile, error) {
+	// The content is written to a temporary file while its checksum, naming
+	// its blob, is computed
+	temp, err := os.CreateTemp(fs.tempDir, "oplet-file-*")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create temporary file")
+	}
+
+	defer func() {
+		temp.Close()
+		os.Remove(temp.Name())
+	}()
+
+	// The MIME type is detected from the first bytes of the content
+	buffered := bufio.NewReaderSize(reader, 512)
+	head, _ := buffered.Peek(512)
+
+	mimeType := "application/octet-stream"
+	if len(head) > 0 {
+		mimeType = http.DetectContentType(head)
 	}
-	defer file.Close()
 
-	// Copy with checksum calculation
 	hasher := sha256.New()
-	multiWriter := io.MultiWriter(file, hasher)
 
-	size, err := io.Copy(multiWriter, reader)
+	size, err := io.Copy(io.MultiWriter(temp, hasher), buffered)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to write temporary file %s", temp.Name())
+	}
+
+	checksum := hex.EncodeToString(hasher.Sum(nil))
+	key := BlobKey(checksum)
+
+	exists, err := fs.blobs.Touch(ctx, checksum)
 	if err != nil {
-		os.Remove(storedPath) // Cleanup on error
-		return nil, errors.Wrapf(err, "failed to write file %s", storedPath)
+		return nil, errors.WithStack(err)
 	}
 
-	// Detect MIME type
-	mimeType := fs.detectMimeType(storedPath)
+	if !exists {
+		if _, err := temp.Seek(0, io.SeekStart); err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if err := fs.backend.Put(ctx, key, temp, size); err != nil {
+			return nil, errors.Wrapf(err, "failed to store file %s", key)
+		}
+
+		if err := fs.blobs.Register(ctx, checksum, size); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
 
-	fs.logger.Debug("stored file",
-		"execution_id", executionID,
+	fs.logger.Debug("stored file", append(attrs,
 		"original_name", filename,
-		"stored_path", storedPath,
+		"key", key,
 		"size", size,
-		"mime_type", mimeType)
+		"mime_type", mimeType,
+		"deduplicated", exists)...)
 
 	return &StoredFile{
-		ID:           generateFileID(),
 		OriginalName: filename,
-		StoredPath:   storedPath,
+		Key:          key,
 		Size:         size,
 		MimeType:     mimeType,
-		Checksum:     fmt.Sprintf("%x", hasher.Sum(nil)),
+		Checksum:     checksum,
+		Deduplicated: exists,
 	}, nil
 }
 
-func (fs *Storage) GetFile(filePath string) (io.ReadCloser, error) {
-	file, err := os.Open(filePath)
+func (fs *Storage) GetFile(ctx context.Context, key string) (io.ReadCloser, error) {
+	reader, err := fs.backend.Get(ctx, key)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to open file %s", filePath)
+		return nil, errors.Wrapf(err, "failed to open file %s", key)
 	}
-	return file, nil
+	return reader, nil
 }
 
-func (fs *Storage) DeleteExecution(executionID uint) error {
-	execPath := filepath.Join(fs.basePath, "executions", fmt.Sprintf("%d", executionID))
-	if err := os.RemoveAll(execPath); err != nil {
-		return errors.Wrapf(err, "failed to delete execution directory %s", execPath)
+// SignedURL returns a URL downloading the file directly from the backend,
+// or an empty string if signed URLs are disabled or not supported
+func (fs *Storage) SignedURL(ctx context.Context, key string, filename string, mimeType string) (string, error) {
+	signer, ok := fs.backend.(URLSigner)
+	if !ok || fs.urlExpiry <= 0 {
+		return "", nil
 	}
 
-	fs.logger.Info("deleted execution directory", "execution_id", executionID, "path", execPath)
-	return nil
-}
-
-func (fs *Storage) generateUniqueFilename(original string) string {
-	ext := filepath.Ext(original)
-	base := strings.TrimSuffix(original, ext)
-	timestamp := time.Now().Unix()
+	url, err := signer.SignURL(ctx, key, fs.urlExpiry, URLOptions{
+		ContentType:        mimeType,
+		ContentDisposition: mime.FormatMediaType("attachment", map[string]string{"filename": filename}),
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to sign url of file %s", key)
+	}
 
-	// Generate random suffix
-	randomBytes := make([]byte, 4)
-	rand.Read(randomBytes)
-	randomSuffix := fmt.Sprintf("%x", randomBytes)
+	return url, nil
+}
 
-	return fmt.Sprintf("%s_%d_%s%s", base, timestamp, randomSuffix, ext)
+// IsExecutionFile returns true if the given key is a blob or belongs to the
+// files of the execution stored before deduplication
+func (fs *Storage) IsExecutionFile(executionID uint, key string) bool {
+	return IsValidKey(key) && (strings.HasPrefix(key, BlobsPrefix) || strings.HasPrefix(key, executionPrefix(executionID)))
 }
 
-func (fs *Storage) detectMimeType(filePath string) string {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return "application/octet-stream"
+// DeleteBlob deletes the content of the blob with the given checksum
+func (fs *Storage) DeleteBlob(ctx context.Context, checksum string) error {
+	key := BlobKey(checksum)
+	if err := fs.backend.Delete(ctx, key); err != nil {
+		return errors.Wrapf(err, "failed to delete blob %s", key)
 	}
-	defer file.Close()
 
-	// Read first 512 bytes for MIME detection
-	buffer := make([]byte, 512)
-	n, err := file.Read(buffer)
-	if err != nil {
-		return "application/octet-stream"
+	fs.logger.Info("deleted blob", "checksum", checksum)
+	return nil
+}
+
+func (fs *Storage) DeleteExecution(ctx context.Context, executionID uint) error {
+	prefix := executionPrefix(executionID)
+	if err := fs.backend.DeleteAll(ctx, prefix); err != nil {
+		return errors.Wrapf(err, "failed to delete execution files %s", prefix)
 	}
 
-	return http.DetectContentType(buffer[:n])
+	fs.logger.Info("deleted execution files", "execution_id", executionID, "prefix", prefix)
+	return nil
 }
 
-func generateFileID() string {
-	randomBytes := make([]byte, 8)
-	rand.Read(randomBytes)
-	return fmt.Sprintf("file_%d_%x", time.Now().Unix(), randomBytes)
-}
+// ListExecutions returns the identifiers of the executions having files
+// stored before deduplication
+func (fs *Storage) ListExecutions(ctx context.Context) ([]uint, error) {
+	keys, err := fs.backend.List(ctx, ExecutionsPrefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list execution files")
+	}
 
-// GetStorageStats returns storage statistics for an execution
-func (fs *Storage) GetStorageStats(executionID uint) (*StorageStats, error) {
-	execPath := filepath.Join(fs.basePath, "executions", fmt.Sprintf("%d", executionID))
+	ids := make([]uint, 0)
+	seen := make(map[uint]struct{})
 
-	var totalSize int64
-	var fileCount int
+	for _, key := range keys {
+		rawID, _, _ := strings.Cut(strings.TrimPrefix(key, ExecutionsPrefix), "/")
 
-	err := filepath.Walk(execPath, func(path string, info os.FileInfo, err error) error {
+		id, err := strconv.ParseUint(rawID, 10, 64)
 		if err != nil {
-			return errors.WithStack(err)
+			continue
 		}
-		if !info.IsDir() {
-			totalSize += info.Size()
-			fileCount++
+
+		if _, exists := seen[uint(id)]; exists {
+			continue
 		}
-		return nil
-	})
 
-	if err != nil {
-		return nil, errors.Wrapf(err, "failed to calculate storage stats for execution %d", executionID)
+		seen[uint(id)] = struct{}{}
+		ids = append(ids, uint(id))
 	}
 
-	return &StorageStats{
-		TotalSize: totalSize,
-		FileCount: fileCount,
-	}, nil
+	return ids, nil
 }
 
-// EnsureDirectoryExists creates the base directory structure if it doesn't exist
-func (fs *Storage) EnsureDirectoryExists() error {
-	if err := os.MkdirAll(fs.basePath, 0755); err != nil {
-		return errors.Wrapf(err, "failed to create base storage directory %s", fs.basePath)
+// Check returns an error if the backend is not available
+func (fs *Storage) Check(ctx context.Context) error {
+	if err := fs.backend.Check(ctx); err != nil {
+		return errors.WithStack(err)
 	}
 
-	// Create subdirectories
-	subdirs := []string{"executions", "temp", "config"}
-	for _, subdir := range subdirs {
-		path := filepath.Join(fs.basePath, subdir)
-		if err := os.MkdirAll(path, 0755); err != nil {
-			return errors.Wrapf(err, "failed to create storage subdirectory %s", path)
-		}
+	return nil
+}
+
+// BlobKey returns the key of the blob with the given checksum
+func BlobKey(checksum string) string {
+	return BlobsPrefix + checksum[:2] + "/" + checksum
+}
+
+func uploadPrefix(uploadID string) string {
+	return UploadsPrefix + uploadID + "/"
+}
+
+func uploadChunkKey(uploadID string, offset int64) string {
+	return fmt.Sprintf("%s%020d", uploadPrefix(uploadID), offset)
+}
+
+// exactReader fails with ErrChunkLength if its reader ends before the
+// expected number of bytes, and reads no more than them
+type exactReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (r *exactReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
 	}
 
-	fs.logger.Info("storage directory structure initialized", "base_path", fs.basePath)
-	return nil
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+
+	if errors.Is(err, io.EOF) && r.remaining > 0 {
+		return n, errors.WithStack(ErrChunkLength)
+	}
+
+	return n, err
 }
 
-// CleanupTempFiles removes temporary files older than the specified duration
-func (fs *Storage) CleanupTempFiles(olderThan time.Duration) error {
-	tempPath := filepath.Join(fs.basePath, "temp")
-	cutoff := time.Now().Add(-olderThan)
+// chunkReader reads the content of the given keys one after the other,
+// opening each one once the previous is read
+type chunkReader struct {
+	ctx     context.Context
+	backend Backend
+	keys    []string
+	current io.ReadCloser
+}
 
-	return filepath.Walk(tempPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return errors.WithStack(err)
-		}
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.keys) == 0 {
+				return 0, io.EOF
+			}
 
-		if !info.IsDir() && info.ModTime().Before(cutoff) {
-			if err := os.Remove(path); err != nil {
-				fs.logger.Warn("failed to remove temp file", "path", path, "error", err)
-			} else {
-				fs.logger.Debug("removed temp file", "path", path)
+			reader, err := r.backend.Get(r.ctx, r.keys[0])
+			if err != nil {
+				return 0, errors.Wrapf(err, "failed to open chunk %s", r.keys[0])
 			}
+
+			r.current = reader
+			r.keys = r.keys[1:]
 		}
 
-		return nil
-	})
-}
+		n, err := r.current.Read(p)
+		if errors.Is(err, io.EOF) {
+			r.current.Close()
+			r.current = nil
 
-// GetExecutionPath returns the filesystem path for an execution
-func (fs *Storage) GetExecutionPath(executionID uint) string {
-	return filepath.Join(fs.basePath, "executions", fmt.Sprintf("%d", executionID))
-}
+			if n == 0 {
+				continue
+			}
 
-// FileExists checks if a file exists at the given path
-func (fs *Storage) FileExists(filePath string) bool {
-	_, err := os.Stat(filePath)
-	return !os.IsNotExist(err)
+			return n, nil
+		}
+
+		return n, err
+	}
 }
 
-// GetFileInfo returns file information
-func (fs *Storage) GetFileInfo(filePath string) (*FileInfo, error) {
-	info, err := os.Stat(filePath)
-	if err != nil {
-		return nil, errors.Wrapf(err, "failed to get file info for %s", filePath)
+func (r *chunkReader) Close() error {
+	if r.current == nil {
+		return nil
 	}
 
-	return &FileInfo{
-		Name:    info.Name(),
-		Size:    info.Size(),
-		ModTime: info.ModTime(),
-		IsDir:   info.IsDir(),
-	}, nil
-}
+	err := r.current.Close()
+	r.current = nil
 
-type StorageStats struct {
-	TotalSize int64
-	FileCount int
+	return err
 }
 
-type FileInfo struct {
-	Name    string
-	Size    int64
-	ModTime time.Time
-	IsDir   bool
+func executionPrefix(executionID uint) string {
+	return ExecutionsPrefix + strconv.FormatUint(uint64(executionID), 10) + "/"
 }
@@ -0,0 +1,188 @@
+package file_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bornholm/oplet/internal/file"
+	"github.com/bornholm/oplet/internal/slogx"
+)
+
+func TestStorageDeduplication(t *testing.T) {
+	ctx := context.Background()
+
+	backend := file.NewFilesystemBackend(t.TempDir())
+	blobs := &memoryBlobIndex{sizes: make(map[string]int64)}
+	storage := file.NewStorage(backend, blobs, slogx.NewTestLogger(t), file.WithTempDir(t.TempDir()))
+
+	input, err := storage.StoreInputFile(ctx, 1, "dataset", strings.NewReader("id,value\n1,foo\n"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if input.Deduplicated {
+		t.Errorf("expected the first file not to be deduplicated")
+	}
+
+	if input.Checksum != "6d02a8df06b795a13794ef3012853e4f92979a745b2f27b9ab9720dddd983ff7" {
+		t.Errorf("unexpected checksum '%s'", input.Checksum)
+	}
+
+	// Same content stored by another execution, as an output
+	output, err := storage.StoreOutputFile(ctx, 2, "copy.csv", strings.NewReader("id,value\n1,foo\n"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if !output.Deduplicated {
+		t.Errorf("expected the second file to be deduplicated")
+	}
+
+	if output.Key != input.Key || output.Checksum != input.Checksum {
+		t.Errorf("expected the files to share a blob, got keys '%s' and '%s'", input.Key, output.Key)
+	}
+
+	other, err := storage.StoreOutputFile(ctx, 2, "other.csv", strings.NewReader("id,value\n2,bar\n"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if other.Key == input.Key {
+		t.Errorf("expected files with different contents not to share a blob")
+	}
+
+	keys, err := backend.List(ctx, file.BlobsPrefix)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if len(keys) != 2 {
+		t.Errorf("expected 2 blobs, got %v", keys)
+	}
+
+	for _, key := range []string{input.Key, other.Key} {
+		if !storage.IsExecutionFile(2, key) {
+			t.Errorf("expected blob '%s' to be a valid execution file", key)
+		}
+	}
+
+	if storage.IsExecutionFile(2, "executions/1/inputs/dataset") {
+		t.Errorf("expected the files of another execution to be invalid")
+	}
+
+	reader, err := storage.GetFile(ctx, output.Key)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	content, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if string(content) != "id,value\n1,foo\n" {
+		t.Errorf("unexpected content '%s'", content)
+	}
+
+	if err := storage.DeleteBlob(ctx, input.Checksum); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if _, err := storage.GetFile(ctx, input.Key); err == nil {
+		t.Errorf("expected the blob to be deleted")
+	}
+}
+
+func TestStorageUpload(t *testing.T) {
+	ctx := context.Background()
+
+	backend := file.NewFilesystemBackend(t.TempDir())
+	blobs := &memoryBlobIndex{sizes: make(map[string]int64)}
+	storage := file.NewStorage(backend, blobs, slogx.NewTestLogger(t), file.WithTempDir(t.TempDir()))
+
+	content := strings.Repeat("0123456789abcdef", 1000)
+	chunkSize := 1500
+
+	// Chunks are received in order but the listing of the backend sorts their
+	// keys, which must follow the offsets
+	for offset := 0; offset < len(content); offset += chunkSize {
+		chunk := content[offset:min(offset+chunkSize, len(content))]
+
+		if err := storage.StoreUploadChunk(ctx, "abcd", int64(offset), strings.NewReader(chunk), int64(len(chunk))); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+
+	// A chunk sent again overwrites the previous one
+	if err := storage.StoreUploadChunk(ctx, "abcd", 0, strings.NewReader(content[:chunkSize]), int64(chunkSize)); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	// A chunk shorter than its declared length is rejected, the previous
+	// content of the chunk being kept
+	if err := storage.StoreUploadChunk(ctx, "abcd", 0, strings.NewReader(content[:10]), int64(chunkSize)); !errors.Is(err, file.ErrChunkLength) {
+		t.Errorf("expected a chunk length error, got '%v'", err)
+	}
+
+	// Missing content is detected
+	if _, err := storage.StoreUpload(ctx, "abcd", "dataset.txt", int64(len(content)+1)); err == nil {
+		t.Errorf("expected an error for an incomplete upload")
+	}
+
+	uploaded, err := storage.StoreUpload(ctx, "abcd", "dataset.txt", int64(len(content)))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if uploaded.Size != int64(len(content)) {
+		t.Errorf("unexpected size %d", uploaded.Size)
+	}
+
+	keys, err := backend.List(ctx, file.UploadsPrefix)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if len(keys) != 0 {
+		t.Errorf("expected the chunks to be deleted, got %v", keys)
+	}
+
+	input, err := storage.StoreInputFile(ctx, 1, "dataset", strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if !input.Deduplicated || input.Checksum != uploaded.Checksum {
+		t.Errorf("expected the uploaded content to match, got checksums '%s' and '%s'", uploaded.Checksum, input.Checksum)
+	}
+}
+
+type memoryBlobIndex struct {
+	mutex sync.Mutex
+	sizes map[string]int64
+}
+
+// Touch implements file.BlobIndex.
+func (i *memoryBlobIndex) Touch(ctx context.Context, checksum string) (bool, error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	_, exists := i.sizes[checksum]
+	return exists, nil
+}
+
+// Register implements file.BlobIndex.
+func (i *memoryBlobIndex) Register(ctx context.Context, checksum string, size int64) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.sizes[checksum] = size
+	return nil
+}
+
+var _ file.BlobIndex = &memoryBlobIndex{}
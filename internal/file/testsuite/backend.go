@@ -0,0 +1,299 @@
+package testsuite
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/bornholm/oplet/internal/file"
+	"github.com/pkg/errors"
+)
+
+type backendTestCase struct {
+	Name string
+	Run  func(t *testing.T, backend file.Backend) error
+}
+
+var backendTestCases = []backendTestCase{
+	{
+		Name: "Put and get",
+		Run:  testPutAndGet,
+	},
+	{
+		Name: "Put large content of unknown size",
+		Run:  testPutLargeUnknownSize,
+	},
+	{
+		Name: "Put empty content",
+		Run:  testPutEmpty,
+	},
+	{
+		Name: "Keys with special characters",
+		Run:  testSpecialKeys,
+	},
+	{
+		Name: "Get missing file",
+		Run:  testGetMissing,
+	},
+	{
+		Name: "List by prefix",
+		Run:  testList,
+	},
+	{
+		Name: "Delete",
+		Run:  testDelete,
+	},
+}
+
+func RunBackendTestSuite(t *testing.T, backend file.Backend) {
+	for _, tc := range backendTestCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if err := tc.Run(t, backend); err != nil {
+				t.Fatalf("%+v", errors.WithStack(err))
+			}
+		})
+	}
+}
+
+func testPutAndGet(t *testing.T, backend file.Backend) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if err := backend.Check(ctx); err != nil {
+		return errors.WithStack(err)
+	}
+
+	content := []byte("hello world")
+
+	if err := backend.Put(ctx, "put/hello.txt", bytes.NewReader(content), int64(len(content))); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := assertContent(ctx, backend, "put/hello.txt", content); err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Existing files are overwritten
+	content = []byte("hello again")
+
+	if err := backend.Put(ctx, "put/hello.txt", bytes.NewReader(content), -1); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := assertContent(ctx, backend, "put/hello.txt", content); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func testPutLargeUnknownSize(t *testing.T, backend file.Backend) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	content := make([]byte, 19<<20)
+	rand.Read(content)
+
+	// The reader is wrapped to hide its size
+	reader := io.MultiReader(bytes.NewReader(content))
+
+	if err := backend.Put(ctx, "large/random.bin", reader, -1); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := assertContent(ctx, backend, "large/random.bin", content); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func testPutEmpty(t *testing.T, backend file.Backend) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if err := backend.Put(ctx, "empty/known.txt", bytes.NewReader(nil), 0); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := backend.Put(ctx, "empty/unknown.txt", io.MultiReader(), -1); err != nil {
+		return errors.WithStack(err)
+	}
+
+	for _, key := range []string{"empty/known.txt", "empty/unknown.txt"} {
+		if err := assertContent(ctx, backend, key, []byte{}); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
+
+func testSpecialKeys(t *testing.T, backend file.Backend) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	keys := []string{
+		"special/with space_1.txt",
+		"special/accentué (copie)_2.txt",
+		"special/symbols+=&!'@$;,_3.txt",
+	}
+
+	for _, key := range keys {
+		content := []byte(key)
+
+		if err := backend.Put(ctx, key, bytes.NewReader(content), int64(len(content))); err != nil {
+			return errors.WithStack(err)
+		}
+
+		if err := assertContent(ctx, backend, key, content); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	if err := assertList(ctx, backend, "special/", keys); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func testGetMissing(t *testing.T, backend file.Backend) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	reader, err := backend.Get(ctx, "missing/file.txt")
+	if err == nil {
+		reader.Close()
+		return errors.New("expected an error for a missing file")
+	}
+
+	if !errors.Is(err, file.ErrNotFound) {
+		return errors.Wrap(err, "expected file.ErrNotFound")
+	}
+
+	return nil
+}
+
+func testList(t *testing.T, backend file.Backend) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	keys := []string{
+		"list/1/inputs/a.txt",
+		"list/1/outputs/b.txt",
+		"list/1/outputs/nested/c.txt",
+		"list/12/outputs/d.txt",
+		"list/2/outputs/e.txt",
+	}
+
+	for _, key := range keys {
+		if err := backend.Put(ctx, key, bytes.NewReader([]byte(key)), -1); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	if err := assertList(ctx, backend, "list/", keys); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := assertList(ctx, backend, "list/1/", keys[:3]); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := assertList(ctx, backend, "list/1", keys[:4]); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := assertList(ctx, backend, "list/3/", []string{}); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func testDelete(t *testing.T, backend file.Backend) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	keys := []string{
+		"delete/1/inputs/a.txt",
+		"delete/1/outputs/b.txt",
+		"delete/2/outputs/c.txt",
+	}
+
+	for _, key := range keys {
+		if err := backend.Put(ctx, key, bytes.NewReader([]byte(key)), -1); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	if err := backend.Delete(ctx, keys[0]); err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Deleting a missing file is not an error
+	if err := backend.Delete(ctx, keys[0]); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := assertList(ctx, backend, "delete/", keys[1:]); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := backend.DeleteAll(ctx, "delete/1/"); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := assertList(ctx, backend, "delete/", keys[2:]); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := backend.DeleteAll(ctx, "delete/3/"); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func assertContent(ctx context.Context, backend file.Backend, key string, expected []byte) error {
+	reader, err := backend.Get(ctx, key)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if !bytes.Equal(content, expected) {
+		return errors.Errorf("unexpected content of '%s': expected %d bytes, got %d", key, len(expected), len(content))
+	}
+
+	return nil
+}
+
+func assertList(ctx context.Context, backend file.Backend, prefix string, expected []string) error {
+	keys, err := backend.List(ctx, prefix)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	slices.Sort(keys)
+
+	expected = slices.Clone(expected)
+	slices.Sort(expected)
+
+	if !slices.Equal(keys, expected) {
+		return errors.Errorf("unexpected keys with prefix '%s': expected %v, got %v", prefix, expected, keys)
+	}
+
+	return nil
+}
@@ -1,28 +1,37 @@
 package runner
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"net/http"
-	"strconv"
 	"strings"
 	"time"
 
+	"github.com/bornholm/oplet/internal/dispatch"
 	"github.com/bornholm/oplet/internal/file"
 	"github.com/bornholm/oplet/internal/slogx"
 	"github.com/bornholm/oplet/internal/store"
 	"github.com/bornholm/oplet/internal/store/repository/execution"
-	"github.com/bornholm/oplet/internal/store/repository/runner"
+	runnerRepository "github.com/bornholm/oplet/internal/store/repository/runner"
 	"github.com/bornholm/oplet/internal/task"
 	"github.com/pkg/errors"
+	"gorm.io/gorm"
 )
 
+// runnerTokenHeader holds the token generated when the runner claimed the
+// execution targeted by the request
+const runnerTokenHeader = "X-Runner-Token"
+
 type Handler struct {
-	mux          *http.ServeMux
-	store        *store.Store
-	taskProvider task.Provider
-	fileStorage  *file.Storage
-	logger       *slog.Logger
+	mux           *http.ServeMux
+	store         *store.Store
+	taskProvider  task.Provider
+	fileStorage   *file.Storage
+	dispatcher    *dispatch.Dispatcher
+	logNotifier   dispatch.TopicNotifier
+	leaseDuration time.Duration
+	logger        *slog.Logger
 }
 
 // ServeHTTP implements http.Handler.
@@ -30,28 +39,46 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.mux.ServeHTTP(w, r)
 }
 
-func NewHandler(store *store.Store, taskProvider task.Provider, fileStorage *file.Storage, logger *slog.Logger) *Handler {
+func NewHandler(store *store.Store, taskProvider task.Provider, fileStorage *file.Storage, dispatcher *dispatch.Dispatcher, logNotifier dispatch.TopicNotifier, leaseDuration time.Duration, logger *slog.Logger) *Handler {
 	h := &Handler{
-		mux:          http.NewServeMux(),
-		store:        store,
-		taskProvider: taskProvider,
-		fileStorage:  fileStorage,
-		logger:       logger.With("component", "runner-handler"),
+		mux:           http.NewServeMux(),
+		store:         store,
+		taskProvider:  taskProvider,
+		fileStorage:   fileStorage,
+		dispatcher:    dispatcher,
+		logNotifier:   logNotifier,
+		leaseDuration: leaseDuration,
+		logger:        logger.With("component", "runner-handler"),
 	}
 
 	h.mux.HandleFunc("POST /heartbeat", h.assertRunner(h.handleHeartbeat))
 	h.mux.HandleFunc("GET /request-task", h.assertRunner(h.handleTaskRequest))
-	h.mux.HandleFunc("GET /tasks/{taskID}/inputs", h.assertRunner(h.handleTaskInputs))
-	h.mux.HandleFunc("POST /tasks/{taskID}/trace", h.assertRunner(h.handleTaskTrace))
-	h.mux.HandleFunc("POST /tasks/{taskID}/status", h.assertRunner(h.handleTaskStatus))
-	h.mux.HandleFunc("POST /tasks/{taskID}/outputs", h.assertRunner(h.handleTaskOutputs))
+	h.mux.HandleFunc("GET /executions/{executionID}/inputs", h.assertRunner(h.handleTaskInputs))
+	h.mux.HandleFunc("POST /executions/{executionID}/trace", h.assertRunner(h.handleTaskTrace))
+	h.mux.HandleFunc("POST /executions/{executionID}/progress", h.assertRunner(h.handleTaskProgress))
+	h.mux.HandleFunc("GET /executions/{executionID}/status", h.assertRunner(h.handleTaskStatusQuery))
+	h.mux.HandleFunc("POST /executions/{executionID}/status", h.assertRunner(h.handleTaskStatus))
+	h.mux.HandleFunc("POST /executions/{executionID}/outputs", h.assertRunner(h.handleTaskOutputs))
+	h.mux.HandleFunc("POST /executions/{executionID}/outputs/uploads", h.assertRunner(h.handleCreateOutputUpload))
+	h.mux.HandleFunc("PATCH /executions/{executionID}/outputs/uploads/{uploadID}", h.assertRunner(h.handleOutputUploadChunk))
+	h.mux.HandleFunc("POST /executions/{executionID}/outputs/uploads/{uploadID}/complete", h.assertRunner(h.handleCompleteOutputUpload))
+	h.mux.HandleFunc("POST /executions/{executionID}/result", h.assertRunner(h.handleTaskResult))
+	h.mux.HandleFunc("GET /session", h.assertRunner(h.handleSession))
 
 	return h
 }
 
+// notifyLogs signals the log streams that the execution changed
+func (h *Handler) notifyLogs(ctx context.Context, executionID uint) {
+	if err := h.logNotifier.Notify(ctx, dispatch.ExecutionTopic(executionID)); err != nil {
+		h.logger.WarnContext(ctx, "could not notify execution logs",
+			"execution_id", executionID, "error", err)
+	}
+}
+
 func (h *Handler) assertRunner(next http.HandlerFunc) http.HandlerFunc {
 
-	repo := runner.NewRepository(h.store)
+	repo := runnerRepository.NewRepository(h.store)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authorization := r.Header.Get("Authorization")
@@ -97,20 +124,79 @@ func (h *Handler) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	response := HeartbeatResponse{
-		ID:          runner.ID,
-		Name:        runner.Name,
-		ContactedAt: time.Now(),
+	// Runners may send their state along with the heartbeat
+	var req *HeartbeatRequest
+	if r.ContentLength != 0 {
+		req = &HeartbeatRequest{}
+		if err := parseJSONRequest(r, req); err != nil {
+			handleValidationError(w, err)
+			return
+		}
+	}
+
+	response, err := h.heartbeat(ctx, runner, req)
+	if err != nil {
+		handleRequestError(h, w, r, err, "could not handle heartbeat")
+		return
 	}
 
 	writeJSONResponse(w, http.StatusOK, response)
+}
+
+// heartbeat records the state reported by the runner, if any, and renews
+// the leases of the executions it reports as running
+func (h *Handler) heartbeat(ctx context.Context, runner *store.Runner, req *HeartbeatRequest) (*HeartbeatResponse, error) {
+	if req != nil {
+		if err := req.Validate(); err != nil {
+			return nil, err
+		}
+
+		runnerRepo := runnerRepository.NewRepository(h.store)
+		state := runnerRepository.ReportedState{
+			Slots:     req.Slots,
+			UsedSlots: req.UsedSlots,
+			Tags:      task.ParseTags(strings.Join(req.Tags, ",")),
+		}
+
+		if req.MaxCapacity != nil {
+			state.MaxCPUs = req.MaxCapacity.CPUs
+			state.MaxMemory = req.MaxCapacity.Memory
+		}
+
+		if err := runnerRepo.UpdateReportedState(ctx, runner.ID, state); err != nil {
+			return nil, errors.Wrap(err, "could not update runner state")
+		}
+
+		runner.Slots = state.Slots
+		runner.UsedSlots = state.UsedSlots
+		runner.ReportedTags = task.FormatTags(state.Tags)
+		runner.MaxCPUs = state.MaxCPUs
+		runner.MaxMemory = state.MaxMemory
+	}
+
+	// Heartbeats renew the leases of the executions the runner reports as
+	// running, the other ones it holds being left to expire
+	if req != nil {
+		executionRepo := execution.NewRepository(h.store)
+		if err := executionRepo.RenewRunnerLeases(ctx, runner.ID, req.Executions, time.Now().Add(h.leaseDuration)); err != nil {
+			return nil, errors.Wrap(err, "could not renew runner leases")
+		}
+	}
 
 	h.logger.DebugContext(ctx, "heartbeat received",
 		"runner_id", runner.ID,
-		"runner_name", runner.Name)
+		"runner_name", runner.Name,
+		"slots", runner.Slots,
+		"used_slots", runner.UsedSlots)
+
+	return &HeartbeatResponse{
+		ID:          runner.ID,
+		Name:        runner.Name,
+		ContactedAt: time.Now(),
+	}, nil
 }
 
-// handleTaskStatus handles POST /runner/tasks/{taskID}/status
+// handleTaskStatus handles POST /runner/executions/{executionID}/status
 func (h *Handler) handleTaskStatus(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
@@ -120,43 +206,47 @@ func (h *Handler) handleTaskStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	taskID, err := getTaskIDFromPath(r)
-	if err != nil {
-		handleValidationError(w, err)
-		return
-	}
-
 	var req TaskStatusRequest
 	if err := parseJSONRequest(r, &req); err != nil {
 		handleValidationError(w, err)
 		return
 	}
 
-	if err := req.Validate(); err != nil {
+	executionID, err := getExecutionIDFromPath(r)
+	if err != nil {
 		handleValidationError(w, err)
 		return
 	}
 
-	// Get execution by task ID
-	taskIDUint, err := strconv.ParseUint(taskID, 10, 32)
+	response, err := h.updateStatus(ctx, runner, executionID, r.Header.Get(runnerTokenHeader), req)
 	if err != nil {
-		handleValidationError(w, ErrInvalidRequest("invalid task ID"))
+		handleRequestError(h, w, r, err, "could not update execution status")
 		return
 	}
 
-	executionRepo := execution.NewRepository(h.store)
-	executions, err := executionRepo.GetByTaskID(ctx, uint(taskIDUint), 1, 0)
+	writeJSONResponse(w, http.StatusOK, response)
+}
+
+// updateStatus applies the status update reported by the runner to the
+// execution, scheduling its retry if it failed. Updates of executions which
+// already reached a final status are rejected with errLeaseLost.
+func (h *Handler) updateStatus(ctx context.Context, runner *store.Runner, executionID uint, runnerToken string, req TaskStatusRequest) (*TaskStatusResponse, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	exec, err := h.claimedExecution(ctx, runner, executionID, runnerToken)
 	if err != nil {
-		handleInternalError(h, w, r, err, "could not retrieve execution")
-		return
+		return nil, errors.WithStack(err)
 	}
 
-	if len(executions) == 0 {
-		handleNotFoundError(w, "execution")
-		return
+	// Final statuses, reported by the runner or set when the execution was
+	// canceled or reclaimed, can not be overridden by late reports
+	if execution.IsFinal(exec) {
+		return nil, errors.WithStack(errLeaseLost)
 	}
 
-	exec := executions[0]
+	executionRepo := execution.NewRepository(h.store)
 
 	// Update execution status
 	exec.Status = req.Status
@@ -169,6 +259,18 @@ func (h *Handler) handleTaskStatus(w http.ResponseWriter, r *http.Request) {
 	if req.Error != "" {
 		exec.ErrorMessage = req.Error
 	}
+	if req.ErrorType != "" {
+		exec.ErrorType = req.ErrorType
+	}
+	if req.CPULimit != nil {
+		exec.EffectiveCPULimit = *req.CPULimit
+	}
+	if req.MemoryLimit != nil {
+		exec.EffectiveMemoryLimit = *req.MemoryLimit
+	}
+	if req.SecurityProfile != nil {
+		exec.SecurityProfile = *req.SecurityProfile
+	}
 	if req.StartedAt != nil {
 		exec.StartedAt = req.StartedAt
 	}
@@ -177,8 +279,7 @@ func (h *Handler) handleTaskStatus(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := executionRepo.Update(ctx, exec); err != nil {
-		handleInternalError(h, w, r, err, "could not update execution status")
-		return
+		return nil, errors.Wrap(err, "could not update execution status")
 	}
 
 	// Add system log entry for status change
@@ -195,19 +296,110 @@ func (h *Handler) handleTaskStatus(w http.ResponseWriter, r *http.Request) {
 			"execution_id", exec.ID, "error", err)
 	}
 
-	response := TaskStatusResponse{
+	h.notifyLogs(ctx, exec.ID)
+
+	if req.Status == store.StatusFailed {
+		next, err := executionRepo.ScheduleRetry(ctx, exec.ID)
+		if err != nil {
+			h.logger.ErrorContext(ctx, "could not schedule execution retry",
+				"execution_id", exec.ID, "error", err)
+		} else if next != nil {
+			h.logger.InfoContext(ctx, "scheduled execution retry",
+				"execution_id", exec.ID,
+				"retry_execution_id", next.ID,
+				"attempt", next.Attempt)
+
+			h.dispatcher.NotifyAt(ctx, *next.ScheduledAt)
+		}
+	}
+
+	h.logger.InfoContext(ctx, "task status updated",
+		"runner_id", runner.ID,
+		"execution_id", exec.ID,
+		"task_id", exec.TaskID,
+		"status", req.Status)
+
+	return &TaskStatusResponse{
 		ExecutionID: exec.ID,
 		Status:      req.Status,
+		Canceled:    exec.CanceledAt != nil,
 		UpdatedAt:   time.Now(),
+	}, nil
+}
+
+// handleTaskStatusQuery handles GET /runner/executions/{executionID}/status
+func (h *Handler) handleTaskStatusQuery(w http.ResponseWriter, r *http.Request) {
+	exec, ok := h.retrieveExecution(w, r)
+	if !ok {
+		return
+	}
+
+	response := TaskStatusResponse{
+		ExecutionID: exec.ID,
+		Status:      exec.Status,
+		Canceled:    exec.CanceledAt != nil,
+		UpdatedAt:   exec.UpdatedAt,
 	}
 
 	writeJSONResponse(w, http.StatusOK, response)
+}
 
-	h.logger.InfoContext(ctx, "task status updated",
-		"runner_id", runner.ID,
-		"execution_id", exec.ID,
-		"task_id", taskID,
-		"status", req.Status)
+// retrieveExecution retrieves the claimed execution targeted by the request path,
+// renews its lease and writes the error response if it can not be found or if
+// the runner does not hold its lease anymore
+func (h *Handler) retrieveExecution(w http.ResponseWriter, r *http.Request) (*store.TaskExecution, bool) {
+	ctx := r.Context()
+
+	runner, err := contextRunner(ctx)
+	if err != nil {
+		handleInternalError(h, w, r, err, "could not retrieve runner from context")
+		return nil, false
+	}
+
+	executionID, err := getExecutionIDFromPath(r)
+	if err != nil {
+		handleValidationError(w, err)
+		return nil, false
+	}
+
+	exec, err := h.claimedExecution(ctx, runner, executionID, r.Header.Get(runnerTokenHeader))
+	if err != nil {
+		handleRequestError(h, w, r, err, "could not retrieve execution")
+		return nil, false
+	}
+
+	return exec, true
+}
+
+// claimedExecution retrieves the given claimed execution and renews its lease,
+// returning errExecutionNotFound if it can not be found and errLeaseLost if
+// the runner does not hold its lease anymore or if the token does not match
+// its current claim
+func (h *Handler) claimedExecution(ctx context.Context, runner *store.Runner, executionID uint, runnerToken string) (*store.TaskExecution, error) {
+	executionRepo := execution.NewRepository(h.store)
+
+	exec, err := executionRepo.GetClaimed(ctx, executionID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.WithStack(errExecutionNotFound)
+		}
+
+		return nil, errors.Wrap(err, "could not retrieve execution")
+	}
+
+	if !execution.HoldsLease(runner, exec, runnerToken) {
+		return nil, errors.WithStack(errLeaseLost)
+	}
+
+	leaseExpiresAt := time.Now().Add(h.leaseDuration)
+
+	if err := executionRepo.RenewLease(ctx, exec.ID, leaseExpiresAt); err != nil {
+		return nil, errors.Wrap(err, "could not renew execution lease")
+	}
+
+	exec.LeaseExpiresAt = &leaseExpiresAt
+
+	return exec, nil
 }
 
 var _ http.Handler = &Handler{}
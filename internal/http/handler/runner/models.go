@@ -1,13 +1,29 @@
 package runner
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
+	"regexp"
 	"time"
 
 	"github.com/bornholm/oplet/internal/store"
+	"github.com/bornholm/oplet/internal/task"
+	"github.com/pkg/errors"
 )
 
 // Heartbeat Models
+type HeartbeatRequest struct {
+	Slots     int      `json:"slots"`
+	UsedSlots int      `json:"used_slots"`
+	Tags      []string `json:"tags,omitempty"`
+	// Executions running on the runner, whose leases are renewed
+	Executions []uint `json:"executions,omitempty"`
+	// Maximum capacity of the runner, used to flag the executions no
+	// runner will ever be able to claim
+	MaxCapacity *TaskCapacity `json:"max_capacity,omitempty"`
+}
+
 type HeartbeatResponse struct {
 	ID          uint      `json:"id"`
 	Name        string    `json:"name"`
@@ -24,23 +40,40 @@ type TaskRequestResponse struct {
 	RunnerToken     string            `json:"runner_token"`
 	InputsDir       string            `json:"inputs_dir"`
 	OutputsDir      string            `json:"outputs_dir"`
-	CreatedAt       time.Time         `json:"created_at"`
+	Timeout         int64             `json:"timeout,omitempty"`
+	Network         string            `json:"network,omitempty"`
+	// Comma separated relaxations of the runner security profile
+	SecurityRelaxations string        `json:"security_relaxations,omitempty"`
+	Resources           TaskResources `json:"resources"`
+	CreatedAt           time.Time     `json:"created_at"`
+}
+
+type TaskResources struct {
+	CPURequest    float64 `json:"cpu_request,omitempty"`
+	CPULimit      float64 `json:"cpu_limit,omitempty"`
+	MemoryRequest int64   `json:"memory_request,omitempty"`
+	MemoryLimit   int64   `json:"memory_limit,omitempty"`
 }
 
 // Task Status Models
 type TaskStatusRequest struct {
-	Status      store.TaskExecutionStatus `json:"status" validate:"required"`
-	ContainerID string                    `json:"container_id,omitempty"`
-	ExitCode    *int                      `json:"exit_code,omitempty"`
-	Error       string                    `json:"error,omitempty"`
-	StartedAt   *time.Time                `json:"started_at,omitempty"`
-	FinishedAt  *time.Time                `json:"finished_at,omitempty"`
-	Timestamp   int64                     `json:"timestamp" validate:"required"`
+	Status          store.TaskExecutionStatus `json:"status" validate:"required"`
+	ContainerID     string                    `json:"container_id,omitempty"`
+	ExitCode        *int                      `json:"exit_code,omitempty"`
+	Error           string                    `json:"error,omitempty"`
+	ErrorType       string                    `json:"error_type,omitempty"`
+	CPULimit        *float64                  `json:"cpu_limit,omitempty"`
+	MemoryLimit     *int64                    `json:"memory_limit,omitempty"`
+	SecurityProfile *string                   `json:"security_profile,omitempty"`
+	StartedAt       *time.Time                `json:"started_at,omitempty"`
+	FinishedAt      *time.Time                `json:"finished_at,omitempty"`
+	Timestamp       int64                     `json:"timestamp" validate:"required"`
 }
 
 type TaskStatusResponse struct {
 	ExecutionID uint                      `json:"execution_id"`
 	Status      store.TaskExecutionStatus `json:"status"`
+	Canceled    bool                      `json:"canceled"`
 	UpdatedAt   time.Time                 `json:"updated_at"`
 }
 
@@ -48,6 +81,7 @@ type TaskStatusResponse struct {
 type LogEntry struct {
 	Timestamp int64  `json:"timestamp" validate:"required"`
 	Source    string `json:"source" validate:"required,oneof=container system"`
+	Stream    string `json:"stream" validate:"omitempty,oneof=stdout stderr"`
 	Message   string `json:"message" validate:"required"`
 	Clock     uint   `json:"clock" validate:"required"`
 }
@@ -61,6 +95,26 @@ type TaskTraceResponse struct {
 	LogsAdded   int  `json:"logs_added"`
 }
 
+// Task Progress Models
+type TaskProgressRequest struct {
+	Percent float64 `json:"percent"`
+	Step    string  `json:"step,omitempty"`
+}
+
+type TaskProgressResponse struct {
+	ExecutionID uint      `json:"execution_id"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Task Result Models
+type TaskResultRequest struct {
+	task.Result
+}
+
+type TaskResultResponse struct {
+	ExecutionID uint `json:"execution_id"`
+}
+
 // Task Input Models
 type TaskInputsResponse struct {
 	ExecutionID uint   `json:"execution_id"`
@@ -75,6 +129,84 @@ type TaskOutputsResponse struct {
 	Message     string `json:"message"`
 }
 
+// Output Upload Models
+type OutputUploadRequest struct {
+	// Path of the file relative to the outputs directory
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+type OutputUploadCompleteRequest struct {
+	// Hex encoded SHA-256 checksum of the whole file
+	Checksum string `json:"checksum"`
+}
+
+type OutputUploadResponse struct {
+	ID          string `json:"id"`
+	ExecutionID uint   `json:"execution_id"`
+	Path        string `json:"path"`
+	Size        int64  `json:"size"`
+	Received    int64  `json:"received"`
+	ChunkSize   int64  `json:"chunk_size"`
+	Completed   bool   `json:"completed"`
+	Checksum    string `json:"checksum,omitempty"`
+}
+
+// Session Models
+type SessionMessageType string
+
+const (
+	// Calls sent by the runner, each one answered by a reply
+	// carrying the identifier of the call
+	SessionMessageHeartbeat   SessionMessageType = "heartbeat"
+	SessionMessageRequestTask SessionMessageType = "request-task"
+	SessionMessageStatus      SessionMessageType = "status"
+	SessionMessageTrace       SessionMessageType = "trace"
+	SessionMessageProgress    SessionMessageType = "progress"
+	SessionMessageReply       SessionMessageType = "reply"
+
+	// Commands sent by the server
+	SessionMessageCancel SessionMessageType = "cancel"
+	SessionMessageDrain  SessionMessageType = "drain"
+	SessionMessageResume SessionMessageType = "resume"
+	SessionMessagePull   SessionMessageType = "pull"
+)
+
+type SessionMessage struct {
+	Type    SessionMessageType `json:"type"`
+	ID      uint64             `json:"id,omitempty"`
+	Payload json.RawMessage    `json:"payload,omitempty"`
+	Error   *ErrorResponse     `json:"error,omitempty"`
+}
+
+type TaskCapacity struct {
+	CPUs   float64 `json:"cpus"`
+	Memory int64   `json:"memory"`
+}
+
+type SessionStatusRequest struct {
+	ExecutionID uint   `json:"execution_id"`
+	RunnerToken string `json:"runner_token"`
+	TaskStatusRequest
+}
+
+type SessionTraceRequest struct {
+	ExecutionID uint   `json:"execution_id"`
+	RunnerToken string `json:"runner_token"`
+	TaskTraceRequest
+}
+
+type SessionProgressRequest struct {
+	ExecutionID uint   `json:"execution_id"`
+	RunnerToken string `json:"runner_token"`
+	TaskProgressRequest
+}
+
+type SessionCommand struct {
+	ExecutionID uint   `json:"execution_id,omitempty"`
+	ImageRef    string `json:"image_ref,omitempty"`
+}
+
 // Error Response Models
 type ErrorResponse struct {
 	Error   string            `json:"error"`
@@ -88,9 +220,17 @@ type SuccessResponse struct {
 	Data    interface{} `json:"data,omitempty"`
 }
 
+var checksumPattern = regexp.MustCompile("^[a-f0-9]{64}$")
+
 // Error helper functions
+var (
+	errInvalidRequest    = errors.New("invalid request")
+	errExecutionNotFound = errors.New("execution not found")
+	errLeaseLost         = errors.New("execution lease lost")
+)
+
 func ErrInvalidRequest(format string, args ...interface{}) error {
-	return fmt.Errorf("invalid request: "+format, args...)
+	return fmt.Errorf("%w: "+format, append([]interface{}{errInvalidRequest}, args...)...)
 }
 
 func ErrNotFound(resource string) error {
@@ -102,6 +242,28 @@ func ErrUnauthorized(message string) error {
 }
 
 // Validation helper functions
+func (c *TaskCapacity) Validate() error {
+	if c.CPUs < 0 {
+		return ErrInvalidRequest("invalid cpus capacity")
+	}
+
+	if c.Memory < 0 {
+		return ErrInvalidRequest("invalid memory capacity")
+	}
+
+	return nil
+}
+
+func (r *HeartbeatRequest) Validate() error {
+	if r.Slots < 0 || r.UsedSlots < 0 {
+		return ErrInvalidRequest("slots can not be negative")
+	}
+	if r.MaxCapacity != nil {
+		return r.MaxCapacity.Validate()
+	}
+	return nil
+}
+
 func (r *TaskStatusRequest) Validate() error {
 	if r.Status == "" {
 		return ErrInvalidRequest("status is required")
@@ -120,6 +282,9 @@ func (r *TaskTraceRequest) Validate() error {
 		if log.Source != "container" && log.Source != "system" {
 			return ErrInvalidRequest("log source must be 'container' or 'system' for entry %d", i)
 		}
+		if log.Stream != "" && log.Stream != "stdout" && log.Stream != "stderr" {
+			return ErrInvalidRequest("log stream must be 'stdout' or 'stderr' for entry %d", i)
+		}
 		if log.Message == "" {
 			return ErrInvalidRequest("log message is required for entry %d", i)
 		}
@@ -129,3 +294,37 @@ func (r *TaskTraceRequest) Validate() error {
 	}
 	return nil
 }
+
+func (r *TaskResultRequest) Validate() error {
+	if err := r.Result.Validate(); err != nil {
+		return ErrInvalidRequest("%s", err.Error())
+	}
+	return nil
+}
+
+func (r *OutputUploadRequest) Validate() error {
+	if !task.IsValidOutputPath(r.Path) {
+		return ErrInvalidRequest("invalid output file path '%s'", r.Path)
+	}
+	if r.Size < 0 {
+		return ErrInvalidRequest("output file size can not be negative")
+	}
+	return nil
+}
+
+func (r *OutputUploadCompleteRequest) Validate() error {
+	if !checksumPattern.MatchString(r.Checksum) {
+		return ErrInvalidRequest("checksum must be a hex encoded sha256 checksum")
+	}
+	return nil
+}
+
+func (r *TaskProgressRequest) Validate() error {
+	if math.IsNaN(r.Percent) || r.Percent < 0 || r.Percent > 100 {
+		return ErrInvalidRequest("progress percent must be between 0 and 100")
+	}
+	if len(r.Step) > 256 {
+		return ErrInvalidRequest("progress step can not exceed 256 bytes")
+	}
+	return nil
+}
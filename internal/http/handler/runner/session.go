@@ -0,0 +1,370 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bornholm/oplet/internal/slogx"
+	"github.com/bornholm/oplet/internal/store"
+	"github.com/bornholm/oplet/internal/store/repository/execution"
+	runnerRepository "github.com/bornholm/oplet/internal/store/repository/runner"
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const (
+	// sessionReadLimit is the maximum size of a message sent by the runner
+	sessionReadLimit = 8 << 20
+	// sessionCommandInterval is the interval at which the cancellations and
+	// the commands queued for the runner are looked up
+	sessionCommandInterval = time.Second
+)
+
+var errRunnerRevoked = errors.New("runner revoked")
+
+// handleSession handles GET /runner/session, upgrading the connection to a
+// websocket session over which the runner calls the API and receives its
+// commands without being authenticated again on each call
+func (h *Handler) handleSession(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	runner, err := contextRunner(ctx)
+	if err != nil {
+		handleInternalError(h, w, r, err, "could not retrieve runner from context")
+		return
+	}
+
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		h.logger.WarnContext(ctx, "could not accept runner session", slogx.Error(errors.WithStack(err)))
+		return
+	}
+
+	defer conn.CloseNow()
+
+	conn.SetReadLimit(sessionReadLimit)
+
+	h.logger.InfoContext(ctx, "runner session opened", "runner_id", runner.ID)
+
+	s := &session{
+		handler: h,
+		conn:    conn,
+		runner:  runner,
+	}
+
+	err = s.run(ctx)
+
+	switch {
+	case errors.Is(err, errRunnerRevoked):
+		conn.Close(websocket.StatusPolicyViolation, "runner revoked")
+		h.logger.WarnContext(ctx, "runner session closed, runner revoked", "runner_id", runner.ID)
+
+	case ctx.Err() != nil, websocket.CloseStatus(err) == websocket.StatusNormalClosure, websocket.CloseStatus(err) == websocket.StatusGoingAway:
+		h.logger.InfoContext(ctx, "runner session closed", "runner_id", runner.ID)
+
+	default:
+		h.logger.WarnContext(ctx, "runner session lost", "runner_id", runner.ID, slogx.Error(err))
+	}
+}
+
+// session is the websocket session of a runner
+type session struct {
+	handler *Handler
+	conn    *websocket.Conn
+
+	mutex  sync.RWMutex
+	runner *store.Runner
+}
+
+// run handles the calls of the runner, each one in its own goroutine as
+// task requests wait for an execution, and pushes its commands until the
+// session is closed
+func (s *session) run(ctx context.Context) error {
+	var calls sync.WaitGroup
+	defer calls.Wait()
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	calls.Add(1)
+	go func() {
+		defer calls.Done()
+		s.pushCommands(ctx)
+	}()
+
+	for {
+		var msg SessionMessage
+		if err := wsjson.Read(ctx, s.conn, &msg); err != nil {
+			if cause := context.Cause(ctx); errors.Is(cause, errRunnerRevoked) {
+				return errors.WithStack(cause)
+			}
+
+			return errors.WithStack(err)
+		}
+
+		calls.Add(1)
+		go func() {
+			defer calls.Done()
+
+			if err := s.handle(ctx, msg); errors.Is(err, errRunnerRevoked) {
+				cancel(err)
+			}
+		}()
+	}
+}
+
+// handle answers a call of the runner
+func (s *session) handle(ctx context.Context, msg SessionMessage) error {
+	h := s.handler
+
+	var (
+		result any
+		err    error
+	)
+
+	switch msg.Type {
+	case SessionMessageHeartbeat:
+		result, err = s.heartbeat(ctx, msg.Payload)
+
+	case SessionMessageRequestTask:
+		var capacity *execution.Capacity
+
+		if len(msg.Payload) > 0 {
+			var req TaskCapacity
+			if err = decodePayload(msg.Payload, &req); err == nil {
+				if err = req.Validate(); err == nil {
+					capacity = &execution.Capacity{CPUs: req.CPUs, Memory: req.Memory}
+				}
+			}
+		}
+
+		if err == nil {
+			result, err = h.claimTask(ctx, s.currentRunner(), capacity)
+		}
+
+	case SessionMessageStatus:
+		var req SessionStatusRequest
+		if err = decodePayload(msg.Payload, &req); err == nil {
+			result, err = h.updateStatus(ctx, s.currentRunner(), req.ExecutionID, req.RunnerToken, req.TaskStatusRequest)
+		}
+
+	case SessionMessageTrace:
+		var req SessionTraceRequest
+		if err = decodePayload(msg.Payload, &req); err == nil {
+			result, err = h.addLogs(ctx, s.currentRunner(), req.ExecutionID, req.RunnerToken, req.TaskTraceRequest)
+		}
+
+	case SessionMessageProgress:
+		var req SessionProgressRequest
+		if err = decodePayload(msg.Payload, &req); err == nil {
+			result, err = h.updateProgress(ctx, s.currentRunner(), req.ExecutionID, req.RunnerToken, req.TaskProgressRequest)
+		}
+
+	default:
+		err = ErrInvalidRequest("unexpected message type '%s'", msg.Type)
+	}
+
+	if errors.Is(err, errRunnerRevoked) {
+		return errors.WithStack(err)
+	}
+
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	reply := SessionMessage{
+		Type: SessionMessageReply,
+		ID:   msg.ID,
+	}
+
+	if err != nil {
+		reply.Error = s.errorResponse(ctx, msg.Type, err)
+	} else {
+		payload, err := json.Marshal(result)
+		if err != nil {
+			reply.Error = s.errorResponse(ctx, msg.Type, errors.WithStack(err))
+		} else {
+			reply.Payload = payload
+		}
+	}
+
+	if err := wsjson.Write(ctx, s.conn, reply); err != nil && ctx.Err() == nil {
+		h.logger.WarnContext(ctx, "could not write session reply", "type", msg.Type, slogx.Error(errors.WithStack(err)))
+	}
+
+	return nil
+}
+
+// heartbeat authenticates the runner again, its token being possibly
+// revoked since the session was opened, before handling its heartbeat
+func (s *session) heartbeat(ctx context.Context, payload json.RawMessage) (*HeartbeatResponse, error) {
+	var req *HeartbeatRequest
+	if len(payload) > 0 {
+		req = &HeartbeatRequest{}
+		if err := decodePayload(payload, req); err != nil {
+			return nil, err
+		}
+	}
+
+	repo := runnerRepository.NewRepository(s.handler.store)
+
+	runner, err := repo.GetRunnerByToken(ctx, s.currentRunner().Token)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.WithStack(errRunnerRevoked)
+		}
+
+		return nil, errors.WithStack(err)
+	}
+
+	if err := repo.UpdateContactAt(ctx, runner.ID, time.Now()); err != nil {
+		return nil, errors.Wrap(err, "could not update runner")
+	}
+
+	response, err := s.handler.heartbeat(ctx, runner, req)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	s.mutex.Lock()
+	s.runner = runner
+	s.mutex.Unlock()
+
+	return response, nil
+}
+
+func (s *session) currentRunner() *store.Runner {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.runner
+}
+
+// pushCommands periodically sends the cancellation of the executions held by
+// the runner and the commands queued for it
+func (s *session) pushCommands(ctx context.Context) {
+	h := s.handler
+
+	executionRepo := execution.NewRepository(h.store)
+	runnerRepo := runnerRepository.NewRepository(h.store)
+
+	runnerID := s.currentRunner().ID
+
+	// Executions whose cancellation was already sent
+	canceled := map[uint]struct{}{}
+
+	ticker := time.NewTicker(sessionCommandInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		executionIDs, err := executionRepo.ListCanceled(ctx, runnerID)
+		if err != nil && ctx.Err() == nil {
+			h.logger.WarnContext(ctx, "could not list canceled executions", "runner_id", runnerID, slogx.Error(err))
+		}
+
+		if err == nil {
+			stillCanceled := make(map[uint]struct{}, len(executionIDs))
+
+			for _, executionID := range executionIDs {
+				stillCanceled[executionID] = struct{}{}
+
+				if _, sent := canceled[executionID]; sent {
+					continue
+				}
+
+				if err := s.send(ctx, SessionMessageCancel, SessionCommand{ExecutionID: executionID}); err != nil {
+					delete(stillCanceled, executionID)
+					continue
+				}
+
+				h.logger.InfoContext(ctx, "execution cancellation sent to runner",
+					"runner_id", runnerID,
+					"execution_id", executionID)
+			}
+
+			canceled = stillCanceled
+		}
+
+		commands, err := runnerRepo.PopCommands(ctx, runnerID)
+		if err != nil {
+			if ctx.Err() == nil {
+				h.logger.WarnContext(ctx, "could not retrieve runner commands", "runner_id", runnerID, slogx.Error(err))
+			}
+
+			continue
+		}
+
+		for _, command := range commands {
+			if err := s.send(ctx, SessionMessageType(command.Type), SessionCommand{ImageRef: command.ImageRef}); err != nil {
+				continue
+			}
+
+			h.logger.InfoContext(ctx, "command sent to runner",
+				"runner_id", runnerID,
+				"command", command.Type,
+				"image_ref", command.ImageRef)
+		}
+	}
+}
+
+// send writes a command to the runner
+func (s *session) send(ctx context.Context, msgType SessionMessageType, command SessionCommand) error {
+	payload, err := json.Marshal(command)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	msg := SessionMessage{
+		Type:    msgType,
+		Payload: payload,
+	}
+
+	if err := wsjson.Write(ctx, s.conn, msg); err != nil {
+		if ctx.Err() == nil {
+			s.handler.logger.WarnContext(ctx, "could not send command to runner", "command", msgType, slogx.Error(errors.WithStack(err)))
+		}
+
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// errorResponse maps the error returned while handling a call to the
+// error of its reply, as the REST API would
+func (s *session) errorResponse(ctx context.Context, msgType SessionMessageType, err error) *ErrorResponse {
+	switch {
+	case errors.Is(err, errInvalidRequest):
+		return &ErrorResponse{Error: err.Error(), Code: "validation_error"}
+	case errors.Is(err, errExecutionNotFound):
+		return &ErrorResponse{Error: "execution not found", Code: "not_found"}
+	case errors.Is(err, errLeaseLost):
+		return &ErrorResponse{Error: "execution lease lost", Code: "lease_lost"}
+	default:
+		s.handler.logger.ErrorContext(ctx, "could not handle session call", "type", msgType, slogx.Error(err))
+		return &ErrorResponse{Error: "Internal server error", Code: "internal_error"}
+	}
+}
+
+func decodePayload(payload json.RawMessage, dest any) error {
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(dest); err != nil {
+		return ErrInvalidRequest("invalid JSON: %v", err)
+	}
+
+	return nil
+}
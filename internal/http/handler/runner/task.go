@@ -7,8 +7,8 @@ import (
 	"io"
 	"mime/multipart"
 	"net/http"
+	"path"
 	"path/filepath"
-	"strconv"
 	"time"
 
 	"github.com/bornholm/oplet/internal/store"
@@ -27,117 +27,155 @@ func (h *Handler) handleTaskRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	capacity, err := parseCapacity(r)
+	if err != nil {
+		handleValidationError(w, err)
+		return
+	}
+
+	response, err := h.claimTask(ctx, runner, capacity)
+	if err != nil {
+		if ctx.Err() != nil {
+			// The runner is gone
+			return
+		}
+
+		handleInternalError(h, w, r, err, "could not retrieve next task")
+		return
+	}
+
+	if response == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, response)
+}
+
+// claimTask waits for an execution the runner is able to handle and claims it,
+// returning nil if none was claimed in time so that the runner renews its request
+func (h *Handler) claimTask(ctx context.Context, runner *store.Runner, capacity *execution.Capacity) (*TaskRequestResponse, error) {
 	taskExecutionRepo := execution.NewRepository(h.store)
 
-	timer := time.NewTimer(30 * time.Second)
-	defer timer.Stop()
+	waitCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
 
-	pollInterval := 3 * time.Second
+	var nextExecution *store.TaskExecution
 
-	for {
-		select {
-		case <-timer.C:
-			w.WriteHeader(http.StatusNoContent)
-			return
+	err := h.dispatcher.Claim(waitCtx, func(ctx context.Context) (bool, error) {
+		claimed, err := taskExecutionRepo.NextTask(ctx, runner, capacity, h.leaseDuration)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
 
-		default:
-			nextExecution, err := taskExecutionRepo.NextTask(ctx)
-			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
-				handleInternalError(h, w, r, err, "could not retrieve next task")
-				return
-			}
+		if err != nil {
+			return false, errors.WithStack(err)
+		}
 
-			if nextExecution == nil {
-				time.Sleep(pollInterval)
-				continue
-			}
+		nextExecution = claimed
+
+		return true, nil
+	})
+	if err != nil {
+		if ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+			return nil, nil
+		}
 
-			// Parse input parameters and build environment
-			environment := make(map[string]string)
+		return nil, errors.WithStack(err)
+	}
 
-			// Get task definition to understand input types
-			taskDef, err := h.taskProvider.FetchTaskDefinition(ctx, nextExecution.Task.ImageRef)
-			if err != nil {
-				h.logger.WarnContext(ctx, "could not fetch task definition",
+	// Parse input parameters and build environment
+	environment := make(map[string]string)
+
+	// Get task definition to understand input types
+	taskDef, err := h.taskProvider.FetchTaskDefinition(ctx, nextExecution.Task.ImageRef)
+	if err != nil {
+		h.logger.WarnContext(ctx, "could not fetch task definition",
+			"execution_id", nextExecution.ID, "error", err)
+	} else {
+		// Process input parameters from form submission
+		if nextExecution.InputParameters != "" {
+			var params map[string]interface{}
+			if err := json.Unmarshal([]byte(nextExecution.InputParameters), &params); err != nil {
+				h.logger.WarnContext(ctx, "could not parse input parameters",
 					"execution_id", nextExecution.ID, "error", err)
 			} else {
-				// Process input parameters from form submission
-				if nextExecution.InputParameters != "" {
-					var params map[string]interface{}
-					if err := json.Unmarshal([]byte(nextExecution.InputParameters), &params); err != nil {
-						h.logger.WarnContext(ctx, "could not parse input parameters",
-							"execution_id", nextExecution.ID, "error", err)
-					} else {
-						// Add form values to environment (non-file inputs)
-						for _, input := range taskDef.Inputs {
-							if input.Type != task.TypeFile {
-								if value, exists := params[input.Name]; exists {
-									if input.Type == task.TypeBoolean {
-										// Handle boolean conversion
-										if boolVal, ok := value.(bool); ok {
-											if boolVal {
-												environment[input.Name] = "true"
-											} else {
-												environment[input.Name] = "false"
-											}
-										}
+				// Add form values to environment (non-file inputs)
+				for _, input := range taskDef.Inputs {
+					if input.Type != task.TypeFile {
+						if value, exists := params[input.Name]; exists {
+							if input.Type == task.TypeBoolean {
+								// Handle boolean conversion
+								if boolVal, ok := value.(bool); ok {
+									if boolVal {
+										environment[input.Name] = "true"
 									} else {
-										environment[input.Name] = fmt.Sprintf("%v", value)
+										environment[input.Name] = "false"
 									}
 								}
+							} else {
+								environment[input.Name] = fmt.Sprintf("%v", value)
 							}
 						}
 					}
 				}
+			}
+		}
 
-				// Process configuration parameters
-				for _, config := range nextExecution.Task.Configurations {
-					var configInput *task.Input
-					for _, ci := range taskDef.Configuration {
-						if ci.Name == config.Name {
-							configInput = ci
-							break
-						}
-					}
-					if configInput == nil {
-						continue
-					}
-
-					value := config.Value
-
-					if configInput.Type == task.TypeBoolean {
-						if value == "on" {
-							value = "true"
-						} else {
-							value = "false"
-						}
-					}
-
-					environment[config.Name] = value
+		// Process configuration parameters
+		for _, config := range nextExecution.Task.Configurations {
+			var configInput *task.Input
+			for _, ci := range taskDef.Configuration {
+				if ci.Name == config.Name {
+					configInput = ci
+					break
 				}
 			}
-
-			response := TaskRequestResponse{
-				ExecutionID:     nextExecution.ID,
-				TaskID:          nextExecution.TaskID,
-				ImageRef:        nextExecution.Task.ImageRef,
-				Environment:     environment,
-				InputParameters: nextExecution.InputParameters,
-				RunnerToken:     nextExecution.RunnerToken,
-				InputsDir:       "/oplet/inputs",
-				OutputsDir:      "/oplet/outputs",
-				CreatedAt:       nextExecution.CreatedAt,
+			if configInput == nil {
+				continue
 			}
 
-			writeJSONResponse(w, http.StatusOK, response)
+			value := config.Value
 
-			h.logger.InfoContext(ctx, "task assigned to runner",
-				"runner_id", runner.ID,
-				"execution_id", nextExecution.ID,
-				"task_id", nextExecution.TaskID)
-			return
+			if configInput.Type == task.TypeBoolean {
+				if value == "on" {
+					value = "true"
+				} else {
+					value = "false"
+				}
+			}
+
+			environment[config.Name] = value
 		}
 	}
+
+	response := &TaskRequestResponse{
+		ExecutionID:         nextExecution.ID,
+		TaskID:              nextExecution.TaskID,
+		ImageRef:            nextExecution.Task.ImageRef,
+		Environment:         environment,
+		InputParameters:     nextExecution.InputParameters,
+		RunnerToken:         nextExecution.RunnerToken,
+		InputsDir:           "/oplet/inputs",
+		OutputsDir:          "/oplet/outputs",
+		Timeout:             int64(nextExecution.Timeout.Seconds()),
+		Network:             nextExecution.Network,
+		SecurityRelaxations: nextExecution.SecurityRelaxations,
+		CreatedAt:           nextExecution.CreatedAt,
+		Resources: TaskResources{
+			CPURequest:    nextExecution.CPURequest,
+			CPULimit:      nextExecution.CPULimit,
+			MemoryRequest: nextExecution.MemoryRequest,
+			MemoryLimit:   nextExecution.MemoryLimit,
+		},
+	}
+
+	h.logger.InfoContext(ctx, "task assigned to runner",
+		"runner_id", runner.ID,
+		"execution_id", nextExecution.ID,
+		"task_id", nextExecution.TaskID)
+
+	return response, nil
 }
 
 func (h *Handler) handleTaskTrace(w http.ResponseWriter, r *http.Request) {
@@ -149,44 +187,39 @@ func (h *Handler) handleTaskTrace(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	taskID, err := getTaskIDFromPath(r)
-	if err != nil {
-		handleValidationError(w, err)
-		return
-	}
-
 	var req TaskTraceRequest
 	if err := parseJSONRequest(r, &req); err != nil {
 		handleValidationError(w, err)
 		return
 	}
 
-	if err := req.Validate(); err != nil {
+	executionID, err := getExecutionIDFromPath(r)
+	if err != nil {
 		handleValidationError(w, err)
 		return
 	}
 
-	// Get execution by task ID and runner token
-	executionRepo := execution.NewRepository(h.store)
-	taskIDUint, err := strconv.ParseUint(taskID, 10, 32)
+	response, err := h.addLogs(ctx, runner, executionID, r.Header.Get(runnerTokenHeader), req)
 	if err != nil {
-		handleValidationError(w, ErrInvalidRequest("invalid task ID"))
+		handleRequestError(h, w, r, err, "could not add execution logs")
 		return
 	}
 
-	// Find execution by task ID (simplified for now)
-	executions, err := executionRepo.GetByTaskID(ctx, uint(taskIDUint), 1, 0)
-	if err != nil {
-		handleInternalError(h, w, r, err, "could not retrieve execution")
-		return
+	writeJSONResponse(w, http.StatusOK, response)
+}
+
+// addLogs appends the log entries submitted by the runner to the execution
+func (h *Handler) addLogs(ctx context.Context, runner *store.Runner, executionID uint, runnerToken string, req TaskTraceRequest) (*TaskTraceResponse, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
 	}
 
-	if len(executions) == 0 {
-		handleNotFoundError(w, "execution")
-		return
+	exec, err := h.claimedExecution(ctx, runner, executionID, runnerToken)
+	if err != nil {
+		return nil, errors.WithStack(err)
 	}
 
-	exec := executions[0]
+	executionRepo := execution.NewRepository(h.store)
 
 	// Add logs to execution
 	dbLogs := make([]*store.TaskExecutionLog, 0)
@@ -196,6 +229,7 @@ func (h *Handler) handleTaskTrace(w http.ResponseWriter, r *http.Request) {
 			ExecutionID: exec.ID,
 			Timestamp:   logEntry.Timestamp,
 			Source:      logEntry.Source,
+			Stream:      logEntry.Stream,
 			Message:     logEntry.Message,
 			Clock:       logEntry.Clock,
 		})
@@ -206,20 +240,21 @@ func (h *Handler) handleTaskTrace(w http.ResponseWriter, r *http.Request) {
 			"execution_id", exec.ID, "error", err)
 	}
 
-	response := TaskTraceResponse{
-		ExecutionID: exec.ID,
-		LogsAdded:   len(dbLogs),
-	}
-
-	writeJSONResponse(w, http.StatusOK, response)
+	h.notifyLogs(ctx, exec.ID)
 
 	h.logger.DebugContext(ctx, "logs added to execution",
 		"runner_id", runner.ID,
 		"execution_id", exec.ID,
 		"logs_added", len(dbLogs))
+
+	return &TaskTraceResponse{
+		ExecutionID: exec.ID,
+		LogsAdded:   len(dbLogs),
+	}, nil
 }
 
-func (h *Handler) handleTaskInputs(w http.ResponseWriter, r *http.Request) {
+// handleTaskProgress handles POST /runner/executions/{executionID}/progress
+func (h *Handler) handleTaskProgress(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	runner, err := contextRunner(ctx)
@@ -228,32 +263,72 @@ func (h *Handler) handleTaskInputs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	taskID, err := getTaskIDFromPath(r)
+	var req TaskProgressRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		handleValidationError(w, err)
+		return
+	}
+
+	executionID, err := getExecutionIDFromPath(r)
 	if err != nil {
 		handleValidationError(w, err)
 		return
 	}
 
-	taskIDUint, err := strconv.ParseUint(taskID, 10, 32)
+	response, err := h.updateProgress(ctx, runner, executionID, r.Header.Get(runnerTokenHeader), req)
 	if err != nil {
-		handleValidationError(w, ErrInvalidRequest("invalid task ID"))
+		handleRequestError(h, w, r, err, "could not update execution progress")
 		return
 	}
 
-	// Get execution
+	writeJSONResponse(w, http.StatusOK, response)
+}
+
+// updateProgress records the progress reported by the task of the execution
+func (h *Handler) updateProgress(ctx context.Context, runner *store.Runner, executionID uint, runnerToken string, req TaskProgressRequest) (*TaskProgressResponse, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	exec, err := h.claimedExecution(ctx, runner, executionID, runnerToken)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
 	executionRepo := execution.NewRepository(h.store)
-	executions, err := executionRepo.GetByTaskID(ctx, uint(taskIDUint), 1, 0)
+
+	if err := executionRepo.UpdateProgress(ctx, exec.ID, req.Percent, req.Step); err != nil {
+		return nil, errors.Wrap(err, "could not update execution progress")
+	}
+
+	h.notifyLogs(ctx, exec.ID)
+
+	h.logger.DebugContext(ctx, "execution progress updated",
+		"runner_id", runner.ID,
+		"execution_id", exec.ID,
+		"percent", req.Percent)
+
+	return &TaskProgressResponse{
+		ExecutionID: exec.ID,
+		UpdatedAt:   time.Now(),
+	}, nil
+}
+
+func (h *Handler) handleTaskInputs(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	runner, err := contextRunner(ctx)
 	if err != nil {
-		handleInternalError(h, w, r, err, "could not retrieve execution")
+		handleInternalError(h, w, r, err, "could not retrieve runner from context")
 		return
 	}
 
-	if len(executions) == 0 {
-		handleNotFoundError(w, "execution")
+	exec, ok := h.retrieveExecution(w, r)
+	if !ok {
 		return
 	}
 
-	exec := executions[0]
+	executionRepo := execution.NewRepository(h.store)
 
 	// Get input files for this execution
 	inputFiles, err := executionRepo.GetFiles(ctx, exec.ID, false) // false = input files
@@ -277,6 +352,7 @@ func (h *Handler) handleTaskInputs(w http.ResponseWriter, r *http.Request) {
 			"filename":  file.Filename,
 			"file_size": file.FileSize,
 			"mime_type": file.MimeType,
+			"checksum":  file.Checksum,
 		}
 	}
 
@@ -311,7 +387,7 @@ func (h *Handler) downloadInputFile(w http.ResponseWriter, r *http.Request, exec
 	}
 
 	// Open the file from storage
-	fileReader, err := h.fileStorage.GetFile(targetFile.FilePath)
+	fileReader, err := h.fileStorage.GetFile(ctx, targetFile.FilePath)
 	if err != nil {
 		handleInternalError(h, w, r, err, "could not open input file")
 		return
@@ -323,6 +399,11 @@ func (h *Handler) downloadInputFile(w http.ResponseWriter, r *http.Request, exec
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", targetFile.Filename))
 	w.Header().Set("Content-Length", fmt.Sprintf("%d", targetFile.FileSize))
 
+	// Files stored before the checksums were recorded are sent without it
+	if targetFile.Checksum != "" {
+		w.Header().Set(checksumHeader, targetFile.Checksum)
+	}
+
 	// Stream the file content
 	if _, err := io.Copy(w, fileReader); err != nil {
 		h.logger.ErrorContext(ctx, "failed to stream input file",
@@ -338,6 +419,8 @@ func (h *Handler) downloadInputFile(w http.ResponseWriter, r *http.Request, exec
 		"size", targetFile.FileSize)
 }
 
+// handleTaskOutputs stores the output files sent at once in a multipart form,
+// as did the runners before the output uploads were introduced
 func (h *Handler) handleTaskOutputs(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
@@ -347,38 +430,17 @@ func (h *Handler) handleTaskOutputs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	taskID, err := getTaskIDFromPath(r)
-	if err != nil {
-		handleValidationError(w, err)
-		return
-	}
-
 	// Parse multipart form
 	if err := r.ParseMultipartForm(32 << 20); err != nil { // 32MB max
 		handleValidationError(w, ErrInvalidRequest("could not parse multipart form: %v", err))
 		return
 	}
 
-	taskIDUint, err := strconv.ParseUint(taskID, 10, 32)
-	if err != nil {
-		handleValidationError(w, ErrInvalidRequest("invalid task ID"))
-		return
-	}
-
-	// Get execution
-	executionRepo := execution.NewRepository(h.store)
-	executions, err := executionRepo.GetByTaskID(ctx, uint(taskIDUint), 1, 0)
-	if err != nil {
-		handleInternalError(h, w, r, err, "could not retrieve execution")
+	exec, ok := h.retrieveExecution(w, r)
+	if !ok {
 		return
 	}
 
-	if len(executions) == 0 {
-		handleNotFoundError(w, "execution")
-		return
-	}
-
-	exec := executions[0]
 	filesStored := 0
 
 	// Process uploaded output files
@@ -407,6 +469,53 @@ func (h *Handler) handleTaskOutputs(w http.ResponseWriter, r *http.Request) {
 		"files_stored", filesStored)
 }
 
+func (h *Handler) handleTaskResult(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	runner, err := contextRunner(ctx)
+	if err != nil {
+		handleInternalError(h, w, r, err, "could not retrieve runner from context")
+		return
+	}
+
+	var req TaskResultRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		handleValidationError(w, err)
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		handleValidationError(w, err)
+		return
+	}
+
+	exec, ok := h.retrieveExecution(w, r)
+	if !ok {
+		return
+	}
+
+	result, err := json.Marshal(req.Result)
+	if err != nil {
+		handleInternalError(h, w, r, err, "could not marshal execution result")
+		return
+	}
+
+	executionRepo := execution.NewRepository(h.store)
+
+	if err := executionRepo.UpdateResult(ctx, exec.ID, string(result)); err != nil {
+		handleInternalError(h, w, r, err, "could not update execution result")
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, TaskResultResponse{
+		ExecutionID: exec.ID,
+	})
+
+	h.logger.InfoContext(ctx, "execution result stored",
+		"runner_id", runner.ID,
+		"execution_id", exec.ID)
+}
+
 func (h *Handler) storeOutputFile(ctx context.Context, executionID uint, fieldName string, fileHeader *multipart.FileHeader) error {
 	file, err := fileHeader.Open()
 	if err != nil {
@@ -414,8 +523,14 @@ func (h *Handler) storeOutputFile(ctx context.Context, executionID uint, fieldNa
 	}
 	defer file.Close()
 
-	filename := filepath.Base(fileHeader.Filename)
-	storedFile, err := h.fileStorage.StoreOutputFile(executionID, filename, file)
+	// File names of multipart forms being reduced to their base name, the
+	// path of the file relative to the outputs directory is sent as field name
+	filename := path.Clean(fieldName)
+	if !task.IsValidOutputPath(filename) {
+		filename = filepath.Base(fileHeader.Filename)
+	}
+
+	storedFile, err := h.fileStorage.StoreOutputFile(ctx, executionID, filename, file)
 	if err != nil {
 		return errors.Wrapf(err, "could not store output file %s", filename)
 	}
@@ -425,9 +540,10 @@ func (h *Handler) storeOutputFile(ctx context.Context, executionID uint, fieldNa
 	dbFile := &store.TaskExecutionFile{
 		ExecutionID: executionID,
 		Filename:    storedFile.OriginalName,
-		FilePath:    storedFile.StoredPath,
+		FilePath:    storedFile.Key,
 		FileSize:    storedFile.Size,
 		MimeType:    storedFile.MimeType,
+		Checksum:    storedFile.Checksum,
 		IsOutput:    true,
 	}
 
@@ -0,0 +1,292 @@
+package runner
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bornholm/oplet/internal/file"
+	"github.com/bornholm/oplet/internal/store"
+	"github.com/bornholm/oplet/internal/store/repository/upload"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const (
+	// outputUploadChunkSize is the size of the chunks sent by the runners
+	outputUploadChunkSize = 8 << 20
+	// maxOutputUploadChunkSize is the maximum size of a chunk accepted at once
+	maxOutputUploadChunkSize = 64 << 20
+	// uploadOffsetHeader holds the offset of the chunk sent, and the number
+	// of bytes received in the responses
+	uploadOffsetHeader = "Upload-Offset"
+	// checksumHeader holds the hex encoded SHA-256 checksum of the files
+	// downloaded by the runners
+	checksumHeader = "X-Checksum-Sha256"
+)
+
+// handleCreateOutputUpload handles POST /runner/executions/{executionID}/outputs/uploads
+func (h *Handler) handleCreateOutputUpload(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req OutputUploadRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		handleValidationError(w, err)
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		handleValidationError(w, err)
+		return
+	}
+
+	exec, ok := h.retrieveExecution(w, r)
+	if !ok {
+		return
+	}
+
+	u := &store.Upload{
+		UserID:      exec.UserID,
+		TaskID:      exec.TaskID,
+		ExecutionID: &exec.ID,
+		Filename:    req.Path,
+		Size:        req.Size,
+	}
+
+	if err := upload.NewRepository(h.store).Create(ctx, u); err != nil {
+		handleInternalError(h, w, r, err, "could not create output upload")
+		return
+	}
+
+	h.logger.DebugContext(ctx, "output upload created",
+		"execution_id", exec.ID,
+		"upload_id", u.ID,
+		"path", req.Path,
+		"size", req.Size)
+
+	writeJSONResponse(w, http.StatusCreated, newOutputUploadResponse(u))
+}
+
+// handleOutputUploadChunk handles PATCH /runner/executions/{executionID}/outputs/uploads/{uploadID},
+// storing the chunk starting at the offset given in the Upload-Offset header,
+// which must be the number of bytes received so far
+func (h *Handler) handleOutputUploadChunk(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	exec, ok := h.retrieveExecution(w, r)
+	if !ok {
+		return
+	}
+
+	u, ok := h.findOutputUpload(w, r, exec.ID)
+	if !ok {
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get(uploadOffsetHeader), 10, 64)
+	if err != nil {
+		handleValidationError(w, ErrInvalidRequest("invalid upload offset"))
+		return
+	}
+
+	if u.IsCompleted() || offset != u.Received {
+		writeOffsetConflict(w, u.Received)
+		return
+	}
+
+	size := r.ContentLength
+	if size <= 0 {
+		writeErrorResponseWithCode(w, http.StatusLengthRequired, "chunk size is required", "validation_error")
+		return
+	}
+
+	if size > maxOutputUploadChunkSize || offset+size > u.Size {
+		writeErrorResponseWithCode(w, http.StatusRequestEntityTooLarge, "chunk too large", "validation_error")
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, size)
+
+	if err := h.fileStorage.StoreUploadChunk(ctx, u.ID, offset, body, size); err != nil {
+		if errors.Is(err, file.ErrChunkLength) {
+			handleValidationError(w, ErrInvalidRequest("chunk shorter than its declared length"))
+			return
+		}
+
+		// The runner sends the chunk again
+		handleInternalError(h, w, r, err, "could not store output upload chunk")
+		return
+	}
+
+	uploadRepo := upload.NewRepository(h.store)
+
+	advanced, err := uploadRepo.Advance(ctx, u.ID, offset, offset+size)
+	if err != nil {
+		handleInternalError(h, w, r, err, "could not update output upload")
+		return
+	}
+
+	if !advanced {
+		// The same chunk was sent again in the meantime
+		h.writeCurrentOffsetConflict(w, r, u)
+		return
+	}
+
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(offset+size, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleCompleteOutputUpload handles POST /runner/executions/{executionID}/outputs/uploads/{uploadID}/complete,
+// assembling the received chunks and recording the output file once the
+// checksum computed by the runner is verified
+func (h *Handler) handleCompleteOutputUpload(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req OutputUploadCompleteRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		handleValidationError(w, err)
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		handleValidationError(w, err)
+		return
+	}
+
+	exec, ok := h.retrieveExecution(w, r)
+	if !ok {
+		return
+	}
+
+	u, ok := h.findOutputUpload(w, r, exec.ID)
+	if !ok {
+		return
+	}
+
+	if u.IsCompleted() {
+		// The runner did not receive the response of a previous completion
+		if u.Checksum != req.Checksum {
+			writeErrorResponseWithCode(w, http.StatusUnprocessableEntity, "checksum mismatch", "checksum_mismatch")
+			return
+		}
+
+		writeJSONResponse(w, http.StatusOK, newOutputUploadResponse(u))
+		return
+	}
+
+	if u.Received != u.Size {
+		writeOffsetConflict(w, u.Received)
+		return
+	}
+
+	storedFile, err := h.fileStorage.StoreUpload(ctx, u.ID, u.Filename, u.Size)
+	if err != nil {
+		handleInternalError(h, w, r, err, "could not store output upload")
+		return
+	}
+
+	uploadRepo := upload.NewRepository(h.store)
+
+	// The stored content is left to the collection of the unreferenced blobs
+	// if it is rejected
+	if storedFile.Checksum != req.Checksum {
+		h.logger.WarnContext(ctx, "output upload checksum mismatch, restarting it",
+			"execution_id", exec.ID,
+			"upload_id", u.ID,
+			"expected", req.Checksum,
+			"actual", storedFile.Checksum)
+
+		// Chunks left over would be assembled with the ones sent again
+		if err := h.fileStorage.DeleteUpload(ctx, u.ID); err != nil {
+			handleInternalError(h, w, r, err, "could not delete output upload chunks")
+			return
+		}
+
+		if err := uploadRepo.Reset(ctx, u.ID); err != nil {
+			handleInternalError(h, w, r, err, "could not reset output upload")
+			return
+		}
+
+		w.Header().Set(uploadOffsetHeader, "0")
+		writeErrorResponseWithCode(w, http.StatusUnprocessableEntity, "checksum mismatch", "checksum_mismatch")
+		return
+	}
+
+	completed, err := uploadRepo.CompleteOutput(ctx, u, storedFile.Checksum, storedFile.Key, storedFile.MimeType)
+	if err != nil {
+		handleInternalError(h, w, r, err, "could not record output file")
+		return
+	}
+
+	if !completed {
+		h.writeCurrentOffsetConflict(w, r, u)
+		return
+	}
+
+	h.logger.InfoContext(ctx, "output file stored",
+		"execution_id", exec.ID,
+		"upload_id", u.ID,
+		"path", u.Filename,
+		"size", storedFile.Size,
+		"deduplicated", storedFile.Deduplicated)
+
+	u.Checksum = storedFile.Checksum
+	u.FilePath = storedFile.Key
+	u.MimeType = storedFile.MimeType
+	completedAt := time.Now()
+	u.CompletedAt = &completedAt
+
+	writeJSONResponse(w, http.StatusOK, newOutputUploadResponse(u))
+}
+
+// findOutputUpload retrieves the upload of the path, writing an error
+// response if it does not exist or belongs to another execution
+func (h *Handler) findOutputUpload(w http.ResponseWriter, r *http.Request, executionID uint) (*store.Upload, bool) {
+	u, err := upload.NewRepository(h.store).GetByIDForExecution(r.Context(), r.PathValue("uploadID"), executionID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			handleNotFoundError(w, "upload")
+			return nil, false
+		}
+
+		handleInternalError(h, w, r, err, "could not retrieve output upload")
+		return nil, false
+	}
+
+	return u, true
+}
+
+func (h *Handler) writeCurrentOffsetConflict(w http.ResponseWriter, r *http.Request, u *store.Upload) {
+	current, err := upload.NewRepository(h.store).GetByIDForExecution(r.Context(), u.ID, *u.ExecutionID)
+	if err != nil {
+		handleInternalError(h, w, r, err, "could not retrieve output upload")
+		return
+	}
+
+	writeOffsetConflict(w, current.Received)
+}
+
+// writeOffsetConflict tells the runner the offset from which the upload must
+// be resumed
+func writeOffsetConflict(w http.ResponseWriter, received int64) {
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(received, 10))
+	writeErrorResponseWithCode(w, http.StatusConflict, "unexpected upload offset", "offset_mismatch")
+}
+
+func newOutputUploadResponse(u *store.Upload) OutputUploadResponse {
+	var executionID uint
+	if u.ExecutionID != nil {
+		executionID = *u.ExecutionID
+	}
+
+	return OutputUploadResponse{
+		ID:          u.ID,
+		ExecutionID: executionID,
+		Path:        u.Filename,
+		Size:        u.Size,
+		Received:    u.Received,
+		ChunkSize:   outputUploadChunkSize,
+		Completed:   u.IsCompleted(),
+		Checksum:    u.Checksum,
+	}
+}
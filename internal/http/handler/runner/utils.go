@@ -3,8 +3,10 @@ package runner
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
 
 	"github.com/bornholm/oplet/internal/slogx"
+	"github.com/bornholm/oplet/internal/store/repository/execution"
 	"github.com/pkg/errors"
 )
 
@@ -39,6 +41,21 @@ func handleInternalError(h *Handler, w http.ResponseWriter, r *http.Request, err
 	writeErrorResponse(w, http.StatusInternalServerError, "Internal server error")
 }
 
+// handleRequestError writes the error response matching the error returned
+// while handling a runner request
+func handleRequestError(h *Handler, w http.ResponseWriter, r *http.Request, err error, message string) {
+	switch {
+	case errors.Is(err, errInvalidRequest):
+		handleValidationError(w, err)
+	case errors.Is(err, errExecutionNotFound):
+		handleNotFoundError(w, "execution")
+	case errors.Is(err, errLeaseLost):
+		handleLeaseLostError(w)
+	default:
+		handleInternalError(h, w, r, err, message)
+	}
+}
+
 func handleValidationError(w http.ResponseWriter, err error) {
 	writeErrorResponseWithCode(w, http.StatusBadRequest, err.Error(), "validation_error")
 }
@@ -47,6 +64,10 @@ func handleNotFoundError(w http.ResponseWriter, resource string) {
 	writeErrorResponseWithCode(w, http.StatusNotFound, resource+" not found", "not_found")
 }
 
+func handleLeaseLostError(w http.ResponseWriter) {
+	writeErrorResponseWithCode(w, http.StatusConflict, "execution lease lost", "lease_lost")
+}
+
 // Request parsing utilities
 func parseJSONRequest(r *http.Request, dest interface{}) error {
 	if r.Header.Get("Content-Type") != "application/json" {
@@ -64,10 +85,44 @@ func parseJSONRequest(r *http.Request, dest interface{}) error {
 }
 
 // Path parameter utilities
-func getTaskIDFromPath(r *http.Request) (string, error) {
-	taskID := r.PathValue("taskID")
-	if taskID == "" {
-		return "", ErrInvalidRequest("taskID is required")
+func getExecutionIDFromPath(r *http.Request) (uint, error) {
+	rawExecutionID := r.PathValue("executionID")
+	if rawExecutionID == "" {
+		return 0, ErrInvalidRequest("executionID is required")
+	}
+
+	executionID, err := strconv.ParseUint(rawExecutionID, 10, 32)
+	if err != nil {
+		return 0, ErrInvalidRequest("invalid execution ID")
+	}
+
+	return uint(executionID), nil
+}
+
+// Query parameter utilities
+
+// parseCapacity parses the remaining capacity reported by the runner,
+// nil if the runner does not report it
+func parseCapacity(r *http.Request) (*execution.Capacity, error) {
+	query := r.URL.Query()
+
+	rawCPUs, rawMemory := query.Get("cpus"), query.Get("memory")
+	if rawCPUs == "" && rawMemory == "" {
+		return nil, nil
 	}
-	return taskID, nil
+
+	cpus, err := strconv.ParseFloat(rawCPUs, 64)
+	if err != nil || cpus < 0 {
+		return nil, ErrInvalidRequest("invalid cpus capacity")
+	}
+
+	memory, err := strconv.ParseInt(rawMemory, 10, 64)
+	if err != nil || memory < 0 {
+		return nil, ErrInvalidRequest("invalid memory capacity")
+	}
+
+	return &execution.Capacity{
+		CPUs:   cpus,
+		Memory: memory,
+	}, nil
 }
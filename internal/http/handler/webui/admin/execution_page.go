@@ -0,0 +1,84 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/a-h/templ"
+	"github.com/bornholm/oplet/internal/http/handler/webui/admin/component"
+	"github.com/bornholm/oplet/internal/http/handler/webui/common"
+	commonComp "github.com/bornholm/oplet/internal/http/handler/webui/common/component"
+	"github.com/bornholm/oplet/internal/store"
+	executionRepo "github.com/bornholm/oplet/internal/store/repository/execution"
+	runnerRepo "github.com/bornholm/oplet/internal/store/repository/runner"
+	"github.com/pkg/errors"
+)
+
+func (h *Handler) getExecutionQueuePage(w http.ResponseWriter, r *http.Request) {
+	vmodel, err := h.fillExecutionQueuePageViewModel(r)
+	if err != nil {
+		common.HandleError(w, r, errors.WithStack(err))
+		return
+	}
+
+	executionQueuePage := component.ExecutionQueuePage(*vmodel)
+	templ.Handler(executionQueuePage).ServeHTTP(w, r)
+}
+
+func (h *Handler) fillExecutionQueuePageViewModel(r *http.Request) (*component.ExecutionQueuePageVModel, error) {
+	vmodel := &component.ExecutionQueuePageVModel{}
+	ctx := r.Context()
+
+	err := common.FillViewModel(
+		ctx,
+		vmodel, r,
+		h.fillExecutionQueueNavbarVModel,
+		h.fillExecutionQueueDataVModel,
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return vmodel, nil
+}
+
+func (h *Handler) fillExecutionQueueNavbarVModel(ctx context.Context, vmodel *component.ExecutionQueuePageVModel, r *http.Request) error {
+	if err := commonComp.FillNavbarVModel(ctx, &vmodel.Navbar, r); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (h *Handler) fillExecutionQueueDataVModel(ctx context.Context, vmodel *component.ExecutionQueuePageVModel, r *http.Request) error {
+	executions, err := executionRepo.NewRepository(h.store).ListPending(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	runners, _, err := runnerRepo.NewRepository(h.store).ListWithPagination(ctx, 0, 0)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Runners not contacted within the lease window are gone and can not
+	// claim the pending executions anymore
+	runners = slices.DeleteFunc(runners, func(runner *store.Runner) bool {
+		return runner.ContactedAt == nil || time.Since(*runner.ContactedAt) > h.leaseDuration
+	})
+
+	vmodel.Executions = executions
+	vmodel.Unroutable = make(map[uint]bool)
+
+	for _, e := range executions {
+		routable := slices.ContainsFunc(runners, func(runner *store.Runner) bool {
+			return executionRepo.CanEverClaim(runner, e)
+		})
+		if !routable {
+			vmodel.Unroutable[e.ID] = true
+		}
+	}
+
+	return nil
+}
@@ -3,9 +3,11 @@ package admin
 import (
 	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/bornholm/oplet/internal/file"
 	"github.com/bornholm/oplet/internal/http/authz"
+	"github.com/bornholm/oplet/internal/janitor"
 	"github.com/bornholm/oplet/internal/store"
 	"github.com/bornholm/oplet/internal/task"
 
@@ -13,11 +15,13 @@ import (
 )
 
 type Handler struct {
-	mux          *http.ServeMux
-	store        *store.Store
-	taskProvider task.Provider
-	fileStorage  *file.Storage
-	logger       *slog.Logger
+	mux           *http.ServeMux
+	store         *store.Store
+	taskProvider  task.Provider
+	fileStorage   *file.Storage
+	janitor       *janitor.Janitor
+	leaseDuration time.Duration
+	logger        *slog.Logger
 }
 
 // ServeHTTP implements http.Handler.
@@ -25,13 +29,15 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.mux.ServeHTTP(w, r)
 }
 
-func NewHandler(store *store.Store, taskProvider task.Provider, fileStorage *file.Storage, logger *slog.Logger) *Handler {
+func NewHandler(store *store.Store, taskProvider task.Provider, fileStorage *file.Storage, janitor *janitor.Janitor, leaseDuration time.Duration, logger *slog.Logger) *Handler {
 	h := &Handler{
-		mux:          http.NewServeMux(),
-		store:        store,
-		taskProvider: taskProvider,
-		fileStorage:  fileStorage,
-		logger:       logger.With("component", "admin-handler"),
+		mux:           http.NewServeMux(),
+		store:         store,
+		taskProvider:  taskProvider,
+		fileStorage:   fileStorage,
+		janitor:       janitor,
+		leaseDuration: leaseDuration,
+		logger:        logger.With("component", "admin-handler"),
 	}
 
 	// Admin-only middleware - only admins can access admin pages
@@ -45,6 +51,7 @@ func NewHandler(store *store.Store, taskProvider task.Provider, fileStorage *fil
 	h.mux.Handle("POST /tasks/new", assertAdmin(http.HandlerFunc(h.handleTaskFormSubmission)))
 	h.mux.Handle("GET /tasks/{taskID}/edit", assertAdmin(http.HandlerFunc(h.getTaskFormPage)))
 	h.mux.Handle("POST /tasks/{taskID}/edit", assertAdmin(http.HandlerFunc(h.handleTaskFormSubmission)))
+	h.mux.Handle("POST /tasks/{taskID}/settings", assertAdmin(http.HandlerFunc(h.handleTaskSettingsSubmission)))
 	h.mux.Handle("DELETE /tasks/{taskID}", assertAdmin(http.HandlerFunc(h.handleTaskDeletion)))
 
 	// User management routes
@@ -61,8 +68,18 @@ func NewHandler(store *store.Store, taskProvider task.Provider, fileStorage *fil
 	h.mux.Handle("POST /runners/{runnerID}/edit", assertAdmin(http.HandlerFunc(h.handleRunnerFormSubmission)))
 	h.mux.Handle("DELETE /runners/{runnerID}", assertAdmin(http.HandlerFunc(h.handleRunnerDeletion)))
 	h.mux.Handle("POST /runners/{runnerID}/regenerate-token", assertAdmin(http.HandlerFunc(h.handleRunnerTokenRegeneration)))
+	h.mux.Handle("POST /runners/{runnerID}/commands", assertAdmin(http.HandlerFunc(h.handleRunnerCommand)))
 	h.mux.Handle("GET /runners/validate-name", assertAdmin(http.HandlerFunc(h.handleRunnerNameValidation)))
 
+	// Execution queue routes
+	h.mux.Handle("GET /executions", assertAdmin(http.HandlerFunc(h.getExecutionQueuePage)))
+
+	// Retention routes
+	h.mux.Handle("GET /retention", assertAdmin(http.HandlerFunc(h.getRetentionPage)))
+
+	// Storage routes
+	h.mux.Handle("GET /storage", assertAdmin(http.HandlerFunc(h.getStoragePage)))
+
 	return h
 }
 
@@ -0,0 +1,58 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/a-h/templ"
+	"github.com/bornholm/oplet/internal/http/handler/webui/admin/component"
+	"github.com/bornholm/oplet/internal/http/handler/webui/common"
+	commonComp "github.com/bornholm/oplet/internal/http/handler/webui/common/component"
+	"github.com/pkg/errors"
+)
+
+func (h *Handler) getRetentionPage(w http.ResponseWriter, r *http.Request) {
+	vmodel, err := h.fillRetentionPageViewModel(r)
+	if err != nil {
+		common.HandleError(w, r, errors.WithStack(err))
+		return
+	}
+
+	retentionPage := component.RetentionPage(*vmodel)
+	templ.Handler(retentionPage).ServeHTTP(w, r)
+}
+
+func (h *Handler) fillRetentionPageViewModel(r *http.Request) (*component.RetentionPageVModel, error) {
+	vmodel := &component.RetentionPageVModel{}
+	ctx := r.Context()
+
+	err := common.FillViewModel(
+		ctx,
+		vmodel, r,
+		h.fillRetentionNavbarVModel,
+		h.fillRetentionDataVModel,
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return vmodel, nil
+}
+
+func (h *Handler) fillRetentionNavbarVModel(ctx context.Context, vmodel *component.RetentionPageVModel, r *http.Request) error {
+	if err := commonComp.FillNavbarVModel(ctx, &vmodel.Navbar, r); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (h *Handler) fillRetentionDataVModel(ctx context.Context, vmodel *component.RetentionPageVModel, r *http.Request) error {
+	report, err := h.janitor.Report(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	vmodel.Report = report
+
+	return nil
+}
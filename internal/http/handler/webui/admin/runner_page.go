@@ -13,6 +13,7 @@ import (
 	commonComp "github.com/bornholm/oplet/internal/http/handler/webui/common/component"
 	"github.com/bornholm/oplet/internal/store"
 	runnerRepo "github.com/bornholm/oplet/internal/store/repository/runner"
+	"github.com/bornholm/oplet/internal/task"
 	"github.com/pkg/errors"
 )
 
@@ -83,6 +84,8 @@ func (h *Handler) handleRunnerFormSubmission(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	runnerTags := task.ParseTags(r.FormValue("tags"))
+
 	var redirectURL templ.SafeURL
 
 	if isEdit {
@@ -98,6 +101,11 @@ func (h *Handler) handleRunnerFormSubmission(w http.ResponseWriter, r *http.Requ
 			return
 		}
 
+		if err := runnerRepository.UpdateTags(ctx, uint(runnerID), runnerTags); err != nil {
+			common.HandleError(w, r, errors.WithStack(err))
+			return
+		}
+
 		h.logger.InfoContext(ctx, "Runner name updated",
 			"runner_id", runnerID,
 			"new_name", runnerName)
@@ -115,6 +123,7 @@ func (h *Handler) handleRunnerFormSubmission(w http.ResponseWriter, r *http.Requ
 		storeRunner := &store.Runner{
 			Name:  runnerName,
 			Token: token,
+			Tags:  task.FormatTags(runnerTags),
 		}
 
 		if err := runnerRepository.Create(ctx, storeRunner); err != nil {
@@ -188,6 +197,69 @@ func (h *Handler) handleRunnerTokenRegeneration(w http.ResponseWriter, r *http.R
 	})
 }
 
+func (h *Handler) handleRunnerCommand(w http.ResponseWriter, r *http.Request) {
+	rawRunnerID := r.PathValue("runnerID")
+	if rawRunnerID == "" {
+		http.Error(w, "Runner ID is required", http.StatusBadRequest)
+		return
+	}
+
+	runnerID, err := strconv.ParseUint(rawRunnerID, 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid runner ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Type     store.RunnerCommandType `json:"type"`
+		ImageRef string                  `json:"image_ref"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid command", http.StatusBadRequest)
+		return
+	}
+
+	switch req.Type {
+	case store.RunnerCommandDrain, store.RunnerCommandResume:
+		req.ImageRef = ""
+	case store.RunnerCommandPull:
+		if req.ImageRef == "" {
+			http.Error(w, "Image reference is required", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Invalid command type", http.StatusBadRequest)
+		return
+	}
+
+	runnerRepository := runnerRepo.NewRepository(h.store)
+
+	if _, err := runnerRepository.GetByID(r.Context(), uint(runnerID)); err != nil {
+		common.HandleError(w, r, errors.WithStack(err))
+		return
+	}
+
+	command := &store.RunnerCommand{
+		RunnerID: uint(runnerID),
+		Type:     req.Type,
+		ImageRef: req.ImageRef,
+	}
+
+	if err := runnerRepository.AddCommand(r.Context(), command); err != nil {
+		common.HandleError(w, r, errors.WithStack(err))
+		return
+	}
+
+	h.logger.InfoContext(r.Context(), "Runner command queued",
+		"runner_id", runnerID,
+		"command", req.Type,
+		"image_ref", req.ImageRef)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
 func (h *Handler) handleRunnerNameValidation(w http.ResponseWriter, r *http.Request) {
 	name := r.URL.Query().Get("name")
 	if name == "" {
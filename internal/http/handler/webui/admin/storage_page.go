@@ -0,0 +1,70 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/a-h/templ"
+	"github.com/bornholm/oplet/internal/http/handler/webui/admin/component"
+	"github.com/bornholm/oplet/internal/http/handler/webui/common"
+	commonComp "github.com/bornholm/oplet/internal/http/handler/webui/common/component"
+	"github.com/bornholm/oplet/internal/store/repository/blob"
+	"github.com/pkg/errors"
+)
+
+// maxSharedBlobs is the number of shared blobs listed on the storage page
+const maxSharedBlobs = 20
+
+func (h *Handler) getStoragePage(w http.ResponseWriter, r *http.Request) {
+	vmodel, err := h.fillStoragePageViewModel(r)
+	if err != nil {
+		common.HandleError(w, r, errors.WithStack(err))
+		return
+	}
+
+	storagePage := component.StoragePage(*vmodel)
+	templ.Handler(storagePage).ServeHTTP(w, r)
+}
+
+func (h *Handler) fillStoragePageViewModel(r *http.Request) (*component.StoragePageVModel, error) {
+	vmodel := &component.StoragePageVModel{}
+	ctx := r.Context()
+
+	err := common.FillViewModel(
+		ctx,
+		vmodel, r,
+		h.fillStorageNavbarVModel,
+		h.fillStorageDataVModel,
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return vmodel, nil
+}
+
+func (h *Handler) fillStorageNavbarVModel(ctx context.Context, vmodel *component.StoragePageVModel, r *http.Request) error {
+	if err := commonComp.FillNavbarVModel(ctx, &vmodel.Navbar, r); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (h *Handler) fillStorageDataVModel(ctx context.Context, vmodel *component.StoragePageVModel, r *http.Request) error {
+	blobRepo := blob.NewRepository(h.store)
+
+	stats, err := blobRepo.GetStats(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	sharedBlobs, err := blobRepo.ListMostShared(ctx, maxSharedBlobs)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	vmodel.Stats = stats
+	vmodel.SharedBlobs = sharedBlobs
+
+	return nil
+}
@@ -183,6 +183,153 @@ func (h *Handler) handleTaskFormSubmission(w http.ResponseWriter, r *http.Reques
 	http.Redirect(w, r, string(redirectURL), http.StatusSeeOther)
 }
 
+func (h *Handler) handleTaskSettingsSubmission(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	taskID, err := strconv.ParseUint(r.PathValue("taskID"), 10, 32)
+	if err != nil {
+		common.HandleError(w, r, errors.WithStack(err))
+		return
+	}
+
+	taskRepository := taskRepo.NewRepository(h.store)
+
+	storeTask, err := taskRepository.GetByID(ctx, uint(taskID))
+	if err != nil {
+		common.HandleError(w, r, errors.WithStack(err))
+		return
+	}
+
+	taskDefinition, err := h.taskProvider.FetchTaskDefinition(ctx, storeTask.ImageRef)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "could not retrieve task definition", slogx.Error(errors.WithStack(err)))
+		common.HandleError(w, r, common.NewError(err.Error(), "Could not retrieve the specified image", http.StatusInternalServerError))
+		return
+	}
+
+	settingsForm := taskForm.NewSettingsForm(taskDefinition, storeTask)
+
+	if err := settingsForm.Handle(r); err != nil {
+		common.HandleError(w, r, errors.WithStack(err))
+		return
+	}
+
+	if !settingsForm.IsValid(ctx) {
+		vmodel, err := h.fillTaskFormPageViewModel(r, storeTask, taskDefinition, true)
+		if err != nil {
+			common.HandleError(w, r, errors.WithStack(err))
+			return
+		}
+
+		vmodel.SettingsForm = settingsForm
+
+		page := component.TaskFormPage(*vmodel)
+		templ.Handler(page).ServeHTTP(w, r)
+		return
+	}
+
+	storeTask.RunnerTags = task.FormatTags(task.ParseTags(settingsForm.Values[taskForm.SettingRunnerTags]))
+
+	requeue, err := taskForm.ParseBoolSetting(settingsForm.Values[taskForm.SettingRequeue])
+	if err != nil {
+		common.HandleError(w, r, errors.WithStack(err))
+		return
+	}
+
+	storeTask.Requeue = requeue
+
+	retries, err := taskForm.ParseIntSetting(settingsForm.Values[taskForm.SettingRetries])
+	if err != nil {
+		common.HandleError(w, r, errors.WithStack(err))
+		return
+	}
+
+	storeTask.Retries = retries
+
+	retryBackoff, err := taskForm.ParseDurationSetting(settingsForm.Values[taskForm.SettingRetryBackoff])
+	if err != nil {
+		common.HandleError(w, r, errors.WithStack(err))
+		return
+	}
+
+	storeTask.RetryBackoff = retryBackoff
+
+	retryOnExit, err := taskForm.ParseBoolSetting(settingsForm.Values[taskForm.SettingRetryOnExit])
+	if err != nil {
+		common.HandleError(w, r, errors.WithStack(err))
+		return
+	}
+
+	storeTask.RetryOnExit = retryOnExit
+
+	timeout, err := taskForm.ParseDurationSetting(settingsForm.Values[taskForm.SettingTimeout])
+	if err != nil {
+		common.HandleError(w, r, errors.WithStack(err))
+		return
+	}
+
+	storeTask.Timeout = timeout
+
+	retention, err := taskForm.ParseDurationSetting(settingsForm.Values[taskForm.SettingRetention])
+	if err != nil {
+		common.HandleError(w, r, errors.WithStack(err))
+		return
+	}
+
+	storeTask.Retention = retention
+
+	cpuRequest, err := taskForm.ParseCPUsSetting(settingsForm.Values[taskForm.SettingCPURequest])
+	if err != nil {
+		common.HandleError(w, r, errors.WithStack(err))
+		return
+	}
+
+	storeTask.CPURequest = cpuRequest
+
+	cpuLimit, err := taskForm.ParseCPUsSetting(settingsForm.Values[taskForm.SettingCPULimit])
+	if err != nil {
+		common.HandleError(w, r, errors.WithStack(err))
+		return
+	}
+
+	storeTask.CPULimit = cpuLimit
+
+	memoryRequest, err := taskForm.ParseMemorySetting(settingsForm.Values[taskForm.SettingMemoryRequest])
+	if err != nil {
+		common.HandleError(w, r, errors.WithStack(err))
+		return
+	}
+
+	storeTask.MemoryRequest = memoryRequest
+
+	memoryLimit, err := taskForm.ParseMemorySetting(settingsForm.Values[taskForm.SettingMemoryLimit])
+	if err != nil {
+		common.HandleError(w, r, errors.WithStack(err))
+		return
+	}
+
+	storeTask.MemoryLimit = memoryLimit
+
+	approvedSecurity, err := taskForm.ParseSecuritySetting(settingsForm.Values[taskForm.SettingSecurity])
+	if err != nil {
+		common.HandleError(w, r, errors.WithStack(err))
+		return
+	}
+
+	storeTask.ApprovedSecurity = approvedSecurity
+
+	if err := taskRepository.UpdateSettings(ctx, storeTask); err != nil {
+		common.HandleError(w, r, errors.WithStack(err))
+		return
+	}
+
+	h.logger.InfoContext(ctx, "task settings updated", "task_id", storeTask.ID)
+
+	redirectURL := commonComp.BaseURL(ctx, commonComp.WithPathf("/admin/tasks/%d/edit", storeTask.ID))
+
+	http.Redirect(w, r, string(redirectURL), http.StatusSeeOther)
+}
+
 func (h *Handler) handleTaskDeletion(w http.ResponseWriter, r *http.Request) {
 	rawTaskID := r.PathValue("taskID")
 	if rawTaskID == "" {
@@ -234,8 +381,8 @@ func (h *Handler) fillTaskFormPageViewModel(r *http.Request, storeTask *store.Ta
 	ctx := r.Context()
 
 	if isEdit {
-		taskForm := taskForm.NewConfigurationForm(taskDefinition, storeTask)
-		vmodel.Form = taskForm
+		vmodel.Form = taskForm.NewConfigurationForm(taskDefinition, storeTask)
+		vmodel.SettingsForm = taskForm.NewSettingsForm(taskDefinition, storeTask)
 	} else {
 		vmodel.Form = taskForm.NewImageRefForm()
 	}
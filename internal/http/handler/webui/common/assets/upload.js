@@ -0,0 +1,569 @@
+// Resumable uploads of the file inputs: the files are sent by chunks before
+// the form is submitted, the form then referencing their upload. Transfers
+// are retried on failure, can be paused and resumed, even after a reload of
+// the page, and their content is verified by the server against the SHA-256
+// checksum computed while sending them.
+(function () {
+  if (window.opletUploads) {
+    window.opletUploads.init(document);
+    return;
+  }
+
+  var K = [
+    0x428a2f98, 0x71374491, 0xb5c0fbcf, 0xe9b5dba5, 0x3956c25b, 0x59f111f1, 0x923f82a4, 0xab1c5ed5,
+    0xd807aa98, 0x12835b01, 0x243185be, 0x550c7dc3, 0x72be5d74, 0x80deb1fe, 0x9bdc06a7, 0xc19bf174,
+    0xe49b69c1, 0xefbe4786, 0x0fc19dc6, 0x240ca1cc, 0x2de92c6f, 0x4a7484aa, 0x5cb0a9dc, 0x76f988da,
+    0x983e5152, 0xa831c66d, 0xb00327c8, 0xbf597fc7, 0xc6e00bf3, 0xd5a79147, 0x06ca6351, 0x14292967,
+    0x27b70a85, 0x2e1b2138, 0x4d2c6dfc, 0x53380d13, 0x650a7354, 0x766a0abb, 0x81c2c92e, 0x92722c85,
+    0xa2bfe8a1, 0xa81a664b, 0xc24b8b70, 0xc76c51a3, 0xd192e819, 0xd6990624, 0xf40e3585, 0x106aa070,
+    0x19a4c116, 0x1e376c08, 0x2748774c, 0x34b0bcb5, 0x391c0cb3, 0x4ed8aa4a, 0x5b9cca4f, 0x682e6ff3,
+    0x748f82ee, 0x78a5636f, 0x84c87814, 0x8cc70208, 0x90befffa, 0xa4506ceb, 0xbef9a3f7, 0xc67178f2,
+  ];
+
+  // Sha256 computes the checksum of a content given piece by piece, the
+  // digest API of the browsers requiring the whole content at once
+  function Sha256() {
+    this.state = new Int32Array([
+      0x6a09e667, 0xbb67ae85, 0x3c6ef372, 0xa54ff53a, 0x510e527f, 0x9b05688c, 0x1f83d9ab, 0x5be0cd19,
+    ]);
+    this.words = new Int32Array(64);
+    this.buffer = new Uint8Array(64);
+    this.buffered = 0;
+    this.length = 0;
+  }
+
+  Sha256.prototype.update = function (data) {
+    var offset = 0;
+    this.length += data.length;
+
+    if (this.buffered > 0) {
+      var missing = Math.min(64 - this.buffered, data.length);
+      this.buffer.set(data.subarray(0, missing), this.buffered);
+      this.buffered += missing;
+      offset = missing;
+
+      if (this.buffered < 64) {
+        return;
+      }
+
+      this.block(this.buffer, 0);
+      this.buffered = 0;
+    }
+
+    for (; offset + 64 <= data.length; offset += 64) {
+      this.block(data, offset);
+    }
+
+    if (offset < data.length) {
+      this.buffer.set(data.subarray(offset), 0);
+      this.buffered = data.length - offset;
+    }
+  };
+
+  Sha256.prototype.block = function (data, offset) {
+    var w = this.words;
+    var s = this.state;
+    var i, t1, t2;
+
+    for (i = 0; i < 16; i++) {
+      var j = offset + i * 4;
+      w[i] = (data[j] << 24) | (data[j + 1] << 16) | (data[j + 2] << 8) | data[j + 3];
+    }
+
+    for (i = 16; i < 64; i++) {
+      var w15 = w[i - 15];
+      var w2 = w[i - 2];
+      var s0 = ((w15 >>> 7) | (w15 << 25)) ^ ((w15 >>> 18) | (w15 << 14)) ^ (w15 >>> 3);
+      var s1 = ((w2 >>> 17) | (w2 << 15)) ^ ((w2 >>> 19) | (w2 << 13)) ^ (w2 >>> 10);
+      w[i] = (w[i - 16] + s0 + w[i - 7] + s1) | 0;
+    }
+
+    var a = s[0], b = s[1], c = s[2], d = s[3], e = s[4], f = s[5], g = s[6], h = s[7];
+
+    for (i = 0; i < 64; i++) {
+      t1 = (h +
+        (((e >>> 6) | (e << 26)) ^ ((e >>> 11) | (e << 21)) ^ ((e >>> 25) | (e << 7))) +
+        ((e & f) ^ (~e & g)) + K[i] + w[i]) | 0;
+      t2 = ((((a >>> 2) | (a << 30)) ^ ((a >>> 13) | (a << 19)) ^ ((a >>> 22) | (a << 10))) +
+        ((a & b) ^ (a & c) ^ (b & c))) | 0;
+      h = g;
+      g = f;
+      f = e;
+      e = (d + t1) | 0;
+      d = c;
+      c = b;
+      b = a;
+      a = (t1 + t2) | 0;
+    }
+
+    s[0] += a; s[1] += b; s[2] += c; s[3] += d;
+    s[4] += e; s[5] += f; s[6] += g; s[7] += h;
+  };
+
+  Sha256.prototype.hex = function () {
+    var length = this.length;
+    var padding = new Uint8Array((this.buffered < 56 ? 64 : 128) - this.buffered);
+    padding[0] = 0x80;
+
+    // Length of the content in bits, as a 64 bits big endian integer
+    var high = Math.floor(length / 0x20000000);
+    var low = (length % 0x20000000) * 8;
+    var end = padding.length;
+    padding[end - 8] = high >>> 24;
+    padding[end - 7] = high >>> 16;
+    padding[end - 6] = high >>> 8;
+    padding[end - 5] = high;
+    padding[end - 4] = low >>> 24;
+    padding[end - 3] = low >>> 16;
+    padding[end - 2] = low >>> 8;
+    padding[end - 1] = low;
+
+    this.update(padding);
+
+    var hex = "";
+    for (var i = 0; i < 8; i++) {
+      hex += ("00000000" + (this.state[i] >>> 0).toString(16)).slice(-8);
+    }
+
+    return hex;
+  };
+
+  // request sends an HTTP request, rejecting on network errors and aborts
+  function request(method, url, body, headers, onProgress) {
+    return new Promise(function (resolve, reject) {
+      var xhr = new XMLHttpRequest();
+      xhr.open(method, url);
+
+      for (var name in headers || {}) {
+        xhr.setRequestHeader(name, headers[name]);
+      }
+
+      if (onProgress) {
+        xhr.upload.onprogress = function (evt) {
+          onProgress(evt.loaded);
+        };
+      }
+
+      xhr.onload = function () {
+        var data = null;
+        try {
+          data = JSON.parse(xhr.responseText);
+        } catch (err) {}
+
+        resolve({
+          status: xhr.status,
+          offset: xhr.getResponseHeader("Upload-Offset"),
+          data: data || {},
+        });
+      };
+      xhr.onerror = function () {
+        reject(new Error("network error"));
+      };
+      xhr.onabort = function () {
+        var err = new Error("aborted");
+        err.aborted = true;
+        reject(err);
+      };
+
+      if (onProgress) {
+        onProgress.xhr = xhr;
+      }
+
+      xhr.send(body);
+    });
+  }
+
+  function delay(ms) {
+    return new Promise(function (resolve) {
+      setTimeout(resolve, ms);
+    });
+  }
+
+  function formBody(values) {
+    var body = new FormData();
+    for (var name in values) {
+      body.append(name, values[name]);
+    }
+    return body;
+  }
+
+  var uploads = [];
+
+  // FileUpload sends the file selected in a file input of a form whose
+  // container gives the URL of the uploads and the labels to display
+  function FileUpload(input, container) {
+    this.input = input;
+    this.container = container;
+    this.url = container.dataset.uploadUrl;
+    this.name = input.name;
+    this.required = input.required;
+    this.state = "idle";
+    this.paused = false;
+    this.resume = null;
+    this.xhr = null;
+
+    var field = input.closest(".field") || input.parentNode;
+
+    this.hidden = document.createElement("input");
+    this.hidden.type = "hidden";
+    field.appendChild(this.hidden);
+
+    this.element = document.createElement("div");
+    this.element.className = "upload-progress is-hidden mt-2";
+    this.element.innerHTML =
+      '<div class="is-flex is-align-items-center">' +
+      '<progress class="progress is-small is-info mb-0 mr-2" value="0" max="100"></progress>' +
+      '<button type="button" class="button is-small is-light"></button>' +
+      "</div>" +
+      '<p class="help"></p>';
+    field.insertBefore(this.element, input.closest(".file").nextSibling);
+
+    this.progress = this.element.querySelector("progress");
+    this.button = this.element.querySelector("button");
+    this.status = this.element.querySelector("p");
+
+    var self = this;
+
+    this.button.addEventListener("click", function () {
+      self.togglePause();
+    });
+
+    input.addEventListener("change", function () {
+      self.select(input.files.length > 0 ? input.files[0] : null);
+    });
+
+    if (input.dataset.value) {
+      this.restore(input.dataset.value);
+    }
+  }
+
+  FileUpload.prototype.label = function (name) {
+    return this.container.dataset["label" + name] || name;
+  };
+
+  FileUpload.prototype.show = function (status, isError) {
+    this.element.classList.remove("is-hidden");
+    this.status.textContent = status;
+    this.status.classList.toggle("is-danger", !!isError);
+  };
+
+  FileUpload.prototype.setProgress = function (received) {
+    this.progress.value = this.file && this.file.size > 0 ? (received / this.file.size) * 100 : 100;
+  };
+
+  FileUpload.prototype.isPending = function () {
+    return this.state === "uploading";
+  };
+
+  // restore reuses the upload referenced by the form re-rendered with errors
+  FileUpload.prototype.restore = function (id) {
+    var self = this;
+
+    request("GET", this.url + "/" + id).then(function (res) {
+      if (res.status !== 200 || !res.data.completed) {
+        return;
+      }
+
+      self.file = { name: res.data.filename, size: res.data.size };
+      self.input.closest(".file-label").querySelector(".file-name").textContent = res.data.filename;
+      self.complete(res.data);
+    }).catch(function () {});
+  };
+
+  FileUpload.prototype.select = function (file) {
+    var previous = this.id;
+
+    this.reset();
+
+    if (previous) {
+      request("DELETE", this.url + "/" + previous).catch(function () {});
+    }
+
+    this.file = file;
+    this.generation = (this.generation || 0) + 1;
+
+    if (!file) {
+      this.element.classList.add("is-hidden");
+      return;
+    }
+
+    this.state = "uploading";
+    this.run(this.generation);
+  };
+
+  // reset falls back to the submission of the file with the form
+  FileUpload.prototype.reset = function () {
+    if (this.xhr) {
+      this.xhr.abort();
+    }
+
+    this.id = null;
+    this.state = "idle";
+    this.paused = false;
+    this.hidden.removeAttribute("name");
+    this.hidden.value = "";
+    this.input.name = this.name;
+    this.input.required = this.required;
+    this.button.classList.remove("is-hidden");
+    this.button.textContent = this.label("Pause");
+    this.setProgress(0);
+
+    if (this.resume) {
+      this.resume();
+    }
+  };
+
+  FileUpload.prototype.togglePause = function () {
+    if (this.state !== "uploading") {
+      return;
+    }
+
+    this.paused = !this.paused;
+    this.button.textContent = this.label(this.paused ? "Resume" : "Pause");
+
+    if (this.paused) {
+      this.show(this.label("Paused"));
+      if (this.xhr) {
+        this.xhr.abort();
+      }
+    } else if (this.resume) {
+      this.resume();
+    }
+  };
+
+  FileUpload.prototype.waitIfPaused = function () {
+    var self = this;
+
+    if (!this.paused) {
+      return Promise.resolve();
+    }
+
+    return new Promise(function (resolve) {
+      self.resume = function () {
+        self.resume = null;
+        resolve();
+      };
+    });
+  };
+
+  FileUpload.prototype.storageKey = function () {
+    var file = this.file;
+    return ["oplet-upload", this.url, this.name, file.name, file.size, file.lastModified].join(":");
+  };
+
+  FileUpload.prototype.run = function (generation) {
+    var self = this;
+
+    this.upload(generation).catch(function (err) {
+      if (generation !== self.generation) {
+        return;
+      }
+
+      // The file is then sent with the form
+      self.reset();
+      self.show(self.label("Failed") + " " + err.message, true);
+    });
+  };
+
+  FileUpload.prototype.upload = async function (generation) {
+    var file = this.file;
+    var key = this.storageKey();
+    var status = null;
+
+    this.show(this.label("Uploading"));
+
+    var id = window.localStorage.getItem(key);
+    if (id) {
+      var res = await this.retry(generation, function () {
+        return request("GET", this.url + "/" + id);
+      });
+
+      if (res.status === 200) {
+        status = res.data;
+      } else {
+        window.localStorage.removeItem(key);
+      }
+    }
+
+    if (!status) {
+      res = await this.retry(generation, function () {
+        return request("POST", this.url, formBody({ input: this.name, filename: file.name, size: file.size }));
+      });
+
+      if (res.status !== 201) {
+        throw new Error(res.data.error || res.status);
+      }
+
+      status = res.data;
+      window.localStorage.setItem(key, status.id);
+    }
+
+    this.id = status.id;
+
+    if (status.completed) {
+      return this.complete(status);
+    }
+
+    var received = status.received;
+    var hasher = new Sha256();
+    var hashed = 0;
+    var attempts = 0;
+
+    for (;;) {
+      // Chunks are hashed once received, the content already received
+      // before a reload being hashed again
+
+      while (hashed < received) {
+        this.show(this.label("Verifying"));
+        var end = Math.min(hashed + status.chunkSize, received);
+        hasher.update(new Uint8Array(await file.slice(hashed, end).arrayBuffer()));
+        hashed = end;
+        this.setProgress(hashed);
+        this.checkGeneration(generation);
+      }
+
+      if (received >= file.size) {
+        var checksum = hasher.hex();
+
+        res = await this.retry(generation, function () {
+          return request("POST", this.url + "/" + this.id + "/complete", formBody({ checksum: checksum }));
+        });
+
+        if (res.status === 200) {
+          window.localStorage.removeItem(key);
+          return this.complete(res.data);
+        }
+
+        // The content received is corrupted, the upload restarts
+        if (res.status === 422 && res.offset === "0" && attempts++ < 1) {
+          hasher = new Sha256();
+          hashed = received = 0;
+          continue;
+        }
+
+        window.localStorage.removeItem(key);
+        throw new Error(res.data.error || res.status);
+      }
+
+      await this.waitIfPaused();
+      this.checkGeneration(generation);
+      this.show(this.label("Uploading"));
+
+      var chunk = new Uint8Array(await file.slice(received, Math.min(received + status.chunkSize, file.size)).arrayBuffer());
+      var offset = received;
+
+      res = await this.retry(generation, function () {
+        var self = this;
+        var onProgress = function (loaded) {
+          self.setProgress(offset + loaded);
+        };
+
+        var promise = request("PATCH", this.url + "/" + this.id, chunk, { "Upload-Offset": String(offset) }, onProgress);
+        this.xhr = onProgress.xhr;
+
+        return promise;
+      });
+
+      if (res.status === 204) {
+        hasher.update(chunk);
+        hashed = received = offset + chunk.length;
+      } else if (res.status === 409 && res.offset !== null) {
+        // Another chunk was received in the meantime
+        received = parseInt(res.offset, 10);
+      } else {
+        window.localStorage.removeItem(key);
+        throw new Error(res.data.error || res.status);
+      }
+
+      this.setProgress(received);
+    }
+  };
+
+  // retry sends the request until the server answers, waiting longer after
+  // each failure and while the upload is paused
+  FileUpload.prototype.retry = async function (generation, send) {
+    var wait = 1000;
+
+    for (;;) {
+      await this.waitIfPaused();
+      this.checkGeneration(generation);
+
+      try {
+        var res = await send.call(this);
+        if (res.status < 500) {
+          return res;
+        }
+      } catch (err) {
+        if (err.aborted) {
+          continue;
+        }
+      } finally {
+        this.xhr = null;
+      }
+
+      this.checkGeneration(generation);
+      this.show(this.label("Retrying"), true);
+
+      await delay(wait);
+      wait = Math.min(wait * 2, 30000);
+    }
+  };
+
+  FileUpload.prototype.checkGeneration = function (generation) {
+    if (generation !== this.generation) {
+      throw new Error("superseded");
+    }
+  };
+
+  // complete makes the form reference the upload instead of sending the file
+  FileUpload.prototype.complete = function (status) {
+    this.id = status.id;
+    this.state = "completed";
+    this.input.removeAttribute("name");
+    this.input.required = false;
+    this.hidden.name = this.name;
+    this.hidden.value = status.id;
+    this.button.classList.add("is-hidden");
+    this.progress.value = 100;
+    this.show(this.label("Completed"));
+  };
+
+  function init(root) {
+    var inputs = root.querySelectorAll("[data-upload-url] input[type=file]");
+
+    for (var i = 0; i < inputs.length; i++) {
+      var input = inputs[i];
+      if (input.dataset.uploadReady) {
+        continue;
+      }
+
+      input.dataset.uploadReady = "true";
+      uploads.push(new FileUpload(input, input.closest("[data-upload-url]")));
+    }
+  }
+
+  // Forms are not submitted until their uploads are complete
+  document.addEventListener("submit", function (evt) {
+    var pending = false;
+
+    uploads = uploads.filter(function (upload) {
+      return document.contains(upload.input);
+    });
+
+    uploads.forEach(function (upload) {
+      if (evt.target.contains(upload.input) && upload.isPending()) {
+        pending = true;
+        upload.show(upload.label("Pending"), true);
+      }
+    });
+
+    if (pending) {
+      evt.preventDefault();
+      evt.stopImmediatePropagation();
+    }
+  }, true);
+
+  window.opletUploads = { init: init };
+
+  init(document);
+})();
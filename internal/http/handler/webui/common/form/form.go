@@ -51,6 +51,12 @@ func (f *Form) Handle(r *http.Request) error {
 		if field.IsFile() {
 			fileHeaders, exists := r.MultipartForm.File[field.Name]
 			if !exists {
+				// The file may have been uploaded beforehand, the field then
+				// holding its reference
+				if value := r.FormValue(field.Name); value != "" {
+					f.Values[field.Name] = value
+				}
+
 				continue
 			}
 
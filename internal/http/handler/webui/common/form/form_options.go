@@ -25,3 +25,10 @@ func NewFormOptions(funcs ...FormOptionFunc) *FormOptions {
 
 	return opts
 }
+
+// WithFieldRenderer registers a renderer for the fields with the given name or type
+func WithFieldRenderer(nameOrType string, renderer FieldRenderer) FormOptionFunc {
+	return func(opts *FormOptions) {
+		opts.FieldRenderers[nameOrType] = renderer
+	}
+}
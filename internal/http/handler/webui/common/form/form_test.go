@@ -2,6 +2,7 @@ package form
 
 import (
 	"bytes"
+	"context"
 	"mime/multipart"
 	"net/http/httptest"
 	"strings"
@@ -98,3 +99,45 @@ func TestNewFormWithoutFileField(t *testing.T) {
 		t.Errorf("Expected email 'jane@example.com', got '%s'", form.Values["email"])
 	}
 }
+
+func TestNewFormWithUploadedFile(t *testing.T) {
+	// The file was uploaded beforehand, the field holding its reference
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	writer.WriteField("document", "f3a9c1")
+
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/test", &buf)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	fields := []Field{
+		{Name: "document", Type: "file", Required: true, Validation: []ValidationRule{RequiredRule{}}},
+		{Name: "attachment", Type: "file", Required: true, Validation: []ValidationRule{RequiredRule{}}},
+	}
+
+	form := New(fields)
+
+	if err := form.Handle(req); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if form.Values["document"] != "f3a9c1" {
+		t.Errorf("Expected document reference 'f3a9c1', got '%s'", form.Values["document"])
+	}
+	if _, exists := form.Files["document"]; exists {
+		t.Errorf("Expected no document file")
+	}
+
+	if form.IsValid(context.Background()) {
+		t.Fatalf("Expected the form to be invalid")
+	}
+
+	if _, exists := form.Errors["document"]; exists {
+		t.Errorf("Expected the uploaded document to satisfy the required rule")
+	}
+	if _, exists := form.Errors["attachment"]; !exists {
+		t.Errorf("Expected the missing attachment to be reported")
+	}
+}
@@ -21,7 +21,8 @@ var _ ValidationRule = &RequiredRule{}
 func (r RequiredRule) Validate(ctx context.Context, f *Form, field Field) error {
 	found := true
 	if field.IsFile() {
-		if _, exists := f.Files[field.Name]; !exists {
+		_, exists := f.Files[field.Name]
+		if !exists && strings.TrimSpace(f.Values[field.Name]) == "" {
 			found = false
 		}
 	} else {
@@ -44,6 +44,10 @@ func newForm(inputs []*task.Input, defaultValues map[string]string) *form.Form {
 			field.Attributes["autocomplete"] = "one-time-code"
 		}
 
+		if accept := task.Accept(input); field.Type == "file" && accept != "" {
+			field.Attributes["accept"] = accept
+		}
+
 		// Build validation rules
 		field.Validation = buildValidationRules(input)
 
@@ -134,6 +138,12 @@ func (r *constraintBasedValidationRule) Validate(ctx context.Context, form *form
 		}
 
 		for _, fh := range fileHeaders {
+			if infoConstraint, ok := r.constraint.(task.FileInfoConstraint); ok {
+				if err := infoConstraint.AssertFileInfo(ctx, r.input, fh.Filename, fh.Size); err != nil && !errors.Is(err, task.ErrSkipConstraint) {
+					return errors.WithStack(err)
+				}
+			}
+
 			file, err := fh.Open()
 			if err != nil {
 				return errors.WithStack(err)
@@ -0,0 +1,431 @@
+package task
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/bornholm/oplet/internal/http/handler/webui/common/form"
+	"github.com/bornholm/oplet/internal/store"
+	"github.com/bornholm/oplet/internal/task"
+	"github.com/pkg/errors"
+)
+
+const (
+	SettingRunnerTags    = "runner_tags"
+	SettingRequeue       = "requeue"
+	SettingRetries       = "retries"
+	SettingRetryBackoff  = "retry_backoff"
+	SettingRetryOnExit   = "retry_on_exit"
+	SettingTimeout       = "timeout"
+	SettingRetention     = "retention"
+	SettingCPURequest    = "cpu_request"
+	SettingCPULimit      = "cpu_limit"
+	SettingMemoryRequest = "memory_request"
+	SettingMemoryLimit   = "memory_limit"
+	SettingSecurity      = "approved_security"
+)
+
+// NewSettingsForm creates a new form to override the execution settings
+// declared by the task image labels
+func NewSettingsForm(taskDef *task.Definition, storeTask *store.Task) *form.Form {
+	runnerTags := form.Field{
+		Name:        SettingRunnerTags,
+		Label:       "Runner tags",
+		Type:        "text",
+		Placeholder: "Tags a runner must declare to execute the task, ex: arch=arm64,docker. Image labels: " + labelValue(task.FormatTags(taskDef.RunnerTags)),
+		Attributes:  make(map[string]any),
+		Validation:  make([]form.ValidationRule, 0),
+	}
+
+	requeue := form.Field{
+		Name:        SettingRequeue,
+		Label:       "Requeue the execution when its runner disappears",
+		Type:        "select",
+		Placeholder: "Image labels: " + boolLabelValue(taskDef.Requeue),
+		Attributes:  make(map[string]any),
+		Validation:  []form.ValidationRule{BoolSettingRule{}},
+	}
+
+	retries := form.Field{
+		Name:        SettingRetries,
+		Label:       "Retries",
+		Type:        "number",
+		Placeholder: "Number of times a failed execution is retried. Image labels: " + strconv.Itoa(taskDef.Retries),
+		Attributes:  map[string]any{"min": "0", "max": strconv.Itoa(task.MaxRetries)},
+		Validation:  []form.ValidationRule{IntSettingRule{Max: task.MaxRetries}},
+	}
+
+	retryBackoff := form.Field{
+		Name:        SettingRetryBackoff,
+		Label:       "Retry backoff",
+		Type:        "text",
+		Placeholder: "Delay before the first retry, doubled on each following attempt up to " + task.MaxRetryDelay.String() + ", ex: 30s. Image labels: " + taskDef.RetryBackoff.String(),
+		Attributes:  make(map[string]any),
+		Validation:  []form.ValidationRule{DurationSettingRule{}},
+	}
+
+	retryOnExit := form.Field{
+		Name:        SettingRetryOnExit,
+		Label:       "Retry executions exiting with a non-zero code",
+		Type:        "select",
+		Placeholder: "Image labels: " + boolLabelValue(taskDef.RetryOnExit),
+		Attributes:  make(map[string]any),
+		Validation:  []form.ValidationRule{BoolSettingRule{}},
+	}
+
+	timeout := form.Field{
+		Name:        SettingTimeout,
+		Label:       "Timeout",
+		Type:        "text",
+		Placeholder: "Maximum duration of an execution, capped by the server maximum, ex: 15m. Image labels: " + durationLabelValue(taskDef.Timeout),
+		Attributes:  make(map[string]any),
+		Validation:  []form.ValidationRule{DurationSettingRule{}},
+	}
+
+	retention := form.Field{
+		Name:        SettingRetention,
+		Label:       "Retention",
+		Type:        "text",
+		Placeholder: "Duration after which the finished executions and their files are deleted, 0 to keep them, ex: 720h. Empty for the server retention",
+		Attributes:  make(map[string]any),
+		Validation:  []form.ValidationRule{DurationSettingRule{}},
+	}
+
+	cpuRequest := form.Field{
+		Name:        SettingCPURequest,
+		Label:       "CPU request",
+		Type:        "text",
+		Placeholder: "Number of CPUs a runner must have available to claim an execution, ex: 0.5. Image labels: " + cpusLabelValue(taskDef.Resources.CPURequest),
+		Attributes:  make(map[string]any),
+		Validation:  []form.ValidationRule{CPUsSettingRule{}},
+	}
+
+	cpuLimit := form.Field{
+		Name:        SettingCPULimit,
+		Label:       "CPU limit",
+		Type:        "text",
+		Placeholder: "Maximum number of CPUs of an execution, capped by the runner maximum, ex: 2. Image labels: " + cpusLabelValue(taskDef.Resources.CPULimit),
+		Attributes:  make(map[string]any),
+		Validation:  []form.ValidationRule{CPUsSettingRule{}},
+	}
+
+	memoryRequest := form.Field{
+		Name:        SettingMemoryRequest,
+		Label:       "Memory request",
+		Type:        "text",
+		Placeholder: "Memory a runner must have available to claim an execution, ex: 512Mi. Image labels: " + memoryLabelValue(taskDef.Resources.MemoryRequest),
+		Attributes:  make(map[string]any),
+		Validation:  []form.ValidationRule{MemorySettingRule{}},
+	}
+
+	memoryLimit := form.Field{
+		Name:        SettingMemoryLimit,
+		Label:       "Memory limit",
+		Type:        "text",
+		Placeholder: "Maximum memory of an execution, capped by the runner maximum, ex: 2Gi. Image labels: " + memoryLabelValue(taskDef.Resources.MemoryLimit),
+		Attributes:  make(map[string]any),
+		Validation:  []form.ValidationRule{MemorySettingRule{}},
+	}
+
+	security := form.Field{
+		Name:        SettingSecurity,
+		Label:       "Approved security relaxations",
+		Type:        "text",
+		Placeholder: "Relaxations of the runner security profile allowed for the task, ex: writable-rootfs,image-user,cap=NET_BIND_SERVICE. Image labels: " + labelValue(taskDef.Security.String()),
+		Attributes:  make(map[string]any),
+		Validation:  []form.ValidationRule{SecuritySettingRule{}},
+	}
+
+	form := form.New(
+		[]form.Field{runnerTags, requeue, retries, retryBackoff, retryOnExit, timeout, retention, cpuRequest, cpuLimit, memoryRequest, memoryLimit, security},
+		form.WithFieldRenderer(SettingRequeue, form.NewSelectRenderer(boolOptions)),
+		form.WithFieldRenderer(SettingRetryOnExit, form.NewSelectRenderer(boolOptions)),
+	)
+
+	form.Values = map[string]string{
+		SettingRunnerTags:    storeTask.RunnerTags,
+		SettingRequeue:       FormatBoolSetting(storeTask.Requeue),
+		SettingRetries:       FormatIntSetting(storeTask.Retries),
+		SettingRetryBackoff:  FormatDurationSetting(storeTask.RetryBackoff),
+		SettingRetryOnExit:   FormatBoolSetting(storeTask.RetryOnExit),
+		SettingTimeout:       FormatDurationSetting(storeTask.Timeout),
+		SettingRetention:     FormatDurationSetting(storeTask.Retention),
+		SettingCPURequest:    FormatCPUsSetting(storeTask.CPURequest),
+		SettingCPULimit:      FormatCPUsSetting(storeTask.CPULimit),
+		SettingMemoryRequest: FormatMemorySetting(storeTask.MemoryRequest),
+		SettingMemoryLimit:   FormatMemorySetting(storeTask.MemoryLimit),
+		SettingSecurity:      storeTask.ApprovedSecurity,
+	}
+
+	return form
+}
+
+var boolOptions = []form.SelectOption{
+	{Value: "true", Label: "Yes"},
+	{Value: "false", Label: "No"},
+}
+
+// ParseBoolSetting parses a boolean setting, an empty value meaning
+// that the image labels should be used
+func ParseBoolSetting(value string) (*bool, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &parsed, nil
+}
+
+// FormatBoolSetting formats a boolean setting for the settings form
+func FormatBoolSetting(value *bool) string {
+	if value == nil {
+		return ""
+	}
+
+	return strconv.FormatBool(*value)
+}
+
+// BoolSettingRule validates that a field holds a boolean setting
+type BoolSettingRule struct{}
+
+var _ form.ValidationRule = &BoolSettingRule{}
+
+func (r BoolSettingRule) Validate(ctx context.Context, f *form.Form, field form.Field) error {
+	if _, err := ParseBoolSetting(f.Values[field.Name]); err != nil {
+		return errors.New("invalid value, must be 'true' or 'false'")
+	}
+
+	return nil
+}
+
+// ParseIntSetting parses a positive integer setting, an empty value meaning
+// that the image labels should be used
+func ParseIntSetting(value string) (*int, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if parsed < 0 {
+		return nil, errors.Errorf("invalid value '%d', must be positive", parsed)
+	}
+
+	return &parsed, nil
+}
+
+// FormatIntSetting formats an integer setting for the settings form
+func FormatIntSetting(value *int) string {
+	if value == nil {
+		return ""
+	}
+
+	return strconv.Itoa(*value)
+}
+
+// IntSettingRule validates that a field holds a positive integer setting,
+// up to Max if not zero
+type IntSettingRule struct {
+	Max int
+}
+
+var _ form.ValidationRule = &IntSettingRule{}
+
+func (r IntSettingRule) Validate(ctx context.Context, f *form.Form, field form.Field) error {
+	value, err := ParseIntSetting(f.Values[field.Name])
+	if err != nil {
+		return errors.New("invalid value, must be a positive integer")
+	}
+
+	if value != nil && r.Max > 0 && *value > r.Max {
+		return errors.Errorf("invalid value, must be lower than or equal to %d", r.Max)
+	}
+
+	return nil
+}
+
+// ParseDurationSetting parses a positive duration setting, an empty value meaning
+// that the image labels should be used
+func ParseDurationSetting(value string) (*time.Duration, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if parsed < 0 {
+		return nil, errors.Errorf("invalid value '%s', must be positive", parsed)
+	}
+
+	return &parsed, nil
+}
+
+// FormatDurationSetting formats a duration setting for the settings form
+func FormatDurationSetting(value *time.Duration) string {
+	if value == nil {
+		return ""
+	}
+
+	return value.String()
+}
+
+// DurationSettingRule validates that a field holds a positive duration setting
+type DurationSettingRule struct{}
+
+var _ form.ValidationRule = &DurationSettingRule{}
+
+func (r DurationSettingRule) Validate(ctx context.Context, f *form.Form, field form.Field) error {
+	if _, err := ParseDurationSetting(f.Values[field.Name]); err != nil {
+		return errors.New("invalid value, must be a positive duration, ex: 30s, 5m")
+	}
+
+	return nil
+}
+
+// ParseCPUsSetting parses a number of CPUs setting, an empty value meaning
+// that the image labels should be used
+func ParseCPUsSetting(value string) (*float64, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	parsed, err := task.ParseCPUs(value)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &parsed, nil
+}
+
+// FormatCPUsSetting formats a number of CPUs setting for the settings form
+func FormatCPUsSetting(value *float64) string {
+	if value == nil {
+		return ""
+	}
+
+	return task.FormatCPUs(*value)
+}
+
+// CPUsSettingRule validates that a field holds a number of CPUs setting
+type CPUsSettingRule struct{}
+
+var _ form.ValidationRule = &CPUsSettingRule{}
+
+func (r CPUsSettingRule) Validate(ctx context.Context, f *form.Form, field form.Field) error {
+	if _, err := ParseCPUsSetting(f.Values[field.Name]); err != nil {
+		return errors.New("invalid value, must be a positive number, ex: 0.5, 2")
+	}
+
+	return nil
+}
+
+// ParseMemorySetting parses a memory quantity setting, an empty value meaning
+// that the image labels should be used
+func ParseMemorySetting(value string) (*int64, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	parsed, err := task.ParseMemory(value)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &parsed, nil
+}
+
+// FormatMemorySetting formats a memory quantity setting for the settings form
+func FormatMemorySetting(value *int64) string {
+	if value == nil {
+		return ""
+	}
+
+	return task.FormatMemory(*value)
+}
+
+// MemorySettingRule validates that a field holds a memory quantity setting
+type MemorySettingRule struct{}
+
+var _ form.ValidationRule = &MemorySettingRule{}
+
+func (r MemorySettingRule) Validate(ctx context.Context, f *form.Form, field form.Field) error {
+	if _, err := ParseMemorySetting(f.Values[field.Name]); err != nil {
+		return errors.New("invalid value, must be a positive quantity, ex: 512Mi, 2G")
+	}
+
+	return nil
+}
+
+// ParseSecuritySetting parses and normalizes a list of approved security relaxations
+func ParseSecuritySetting(value string) (string, error) {
+	relaxations, err := task.ParseSecurityRelaxations(value)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return relaxations.String(), nil
+}
+
+// SecuritySettingRule validates that a field holds a list of security relaxations
+type SecuritySettingRule struct{}
+
+var _ form.ValidationRule = &SecuritySettingRule{}
+
+func (r SecuritySettingRule) Validate(ctx context.Context, f *form.Form, field form.Field) error {
+	if _, err := ParseSecuritySetting(f.Values[field.Name]); err != nil {
+		return errors.New("invalid value, must be a comma separated list of 'writable-rootfs', 'image-user' or 'cap=<capability>'")
+	}
+
+	return nil
+}
+
+func labelValue(value string) string {
+	if value == "" {
+		return "none"
+	}
+
+	return value
+}
+
+func durationLabelValue(value time.Duration) string {
+	if value == 0 {
+		return "none"
+	}
+
+	return value.String()
+}
+
+func cpusLabelValue(value float64) string {
+	if value == 0 {
+		return "none"
+	}
+
+	return task.FormatCPUs(value)
+}
+
+func memoryLabelValue(value int64) string {
+	if value == 0 {
+		return "none"
+	}
+
+	return task.FormatMemory(value)
+}
+
+func boolLabelValue(value bool) string {
+	if value {
+		return "yes"
+	}
+
+	return "no"
+}
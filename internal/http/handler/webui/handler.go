@@ -4,10 +4,13 @@ import (
 	"log/slog"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/bornholm/oplet/internal/dispatch"
 	"github.com/bornholm/oplet/internal/file"
 	adminModule "github.com/bornholm/oplet/internal/http/handler/webui/admin"
 	taskModule "github.com/bornholm/oplet/internal/http/handler/webui/task"
+	"github.com/bornholm/oplet/internal/janitor"
 	"github.com/bornholm/oplet/internal/store"
 	"github.com/bornholm/oplet/internal/task"
 )
@@ -21,15 +24,15 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.mux.ServeHTTP(w, r)
 }
 
-func NewHandler(store *store.Store, taskProvider task.Provider, taskExecutor task.Executor, fileStorage *file.Storage, logger *slog.Logger) *Handler {
+func NewHandler(store *store.Store, taskProvider task.Provider, taskExecutor task.Executor, fileStorage *file.Storage, dispatcher *dispatch.Dispatcher, logNotifier dispatch.TopicNotifier, janitor *janitor.Janitor, maxTimeout time.Duration, leaseDuration time.Duration, logger *slog.Logger) *Handler {
 	mux := http.NewServeMux()
 
 	h := &Handler{
 		mux: mux,
 	}
 
-	mount(mux, "/", taskModule.NewHandler(store, taskProvider, taskExecutor, fileStorage, logger))
-	mount(mux, "/admin/", adminModule.NewHandler(store, taskProvider, fileStorage, logger))
+	mount(mux, "/", taskModule.NewHandler(store, taskProvider, taskExecutor, fileStorage, dispatcher, logNotifier, maxTimeout, logger))
+	mount(mux, "/admin/", adminModule.NewHandler(store, taskProvider, fileStorage, janitor, leaseDuration, logger))
 
 	return h
 }
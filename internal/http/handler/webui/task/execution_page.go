@@ -4,17 +4,21 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"path"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/a-h/templ"
+	"github.com/bornholm/oplet/internal/dispatch"
+	"github.com/bornholm/oplet/internal/file"
 	"github.com/bornholm/oplet/internal/http/authz"
 	httpCtx "github.com/bornholm/oplet/internal/http/context"
 	"github.com/bornholm/oplet/internal/http/handler/webui/common"
 	commonComp "github.com/bornholm/oplet/internal/http/handler/webui/common/component"
 	"github.com/bornholm/oplet/internal/http/handler/webui/task/component"
+	"github.com/bornholm/oplet/internal/slogx"
 	"github.com/bornholm/oplet/internal/store"
 	"github.com/bornholm/oplet/internal/store/repository/execution"
 	"github.com/pkg/errors"
@@ -66,7 +70,7 @@ func (h *Handler) getExecutionLogs(w http.ResponseWriter, r *http.Request) {
 	}
 
 	shouldRefresh := false
-	if execution.Status == store.StatusSucceeded || execution.Status == store.StatusFailed || execution.Status == store.StatusKilled {
+	if execution.Status == store.StatusSucceeded || execution.Status == store.StatusFailed || execution.Status == store.StatusKilled || execution.Status == store.StatusTimedOut {
 		shouldRefresh = true
 	}
 
@@ -74,6 +78,105 @@ func (h *Handler) getExecutionLogs(w http.ResponseWriter, r *http.Request) {
 	templ.Handler(logsComponent).ServeHTTP(w, r)
 }
 
+func (h *Handler) handleExecutionCancel(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	executionID := getExecutionIDFromPath(r)
+	if executionID == 0 {
+		common.HandleError(w, r, errors.New("invalid execution ID"))
+		return
+	}
+
+	// Check permissions first
+	if !h.canAccessExecution(ctx, executionID) {
+		h.getForbiddenPage(w, r)
+		return
+	}
+
+	executionRepo := execution.NewRepository(h.store)
+
+	if err := executionRepo.Cancel(ctx, executionID); err != nil {
+		common.HandleError(w, r, errors.WithStack(err))
+		return
+	}
+
+	exec, err := executionRepo.GetByID(ctx, executionID)
+	if err != nil {
+		common.HandleError(w, r, errors.WithStack(err))
+		return
+	}
+
+	now := time.Now().UnixMicro()
+	logEntry := &store.TaskExecutionLog{
+		Timestamp: now,
+		Source:    "system",
+		Message:   "Cancellation requested",
+		Clock:     uint(now),
+	}
+
+	if err := executionRepo.AddLog(ctx, exec.ID, logEntry); err != nil {
+		h.logger.WarnContext(ctx, "could not add cancellation log",
+			"execution_id", exec.ID, "error", err)
+	}
+
+	if err := h.logNotifier.Notify(ctx, dispatch.ExecutionTopic(exec.ID)); err != nil {
+		h.logger.WarnContext(ctx, "could not notify execution logs",
+			"execution_id", exec.ID, "error", err)
+	}
+
+	h.logger.InfoContext(ctx, "execution cancellation requested",
+		"execution_id", exec.ID,
+		"status", exec.Status)
+
+	executionURL := commonComp.BaseURL(ctx, commonComp.WithPathf("/tasks/%d/executions/%d", exec.TaskID, exec.ID))
+
+	http.Redirect(w, r, string(executionURL), http.StatusSeeOther)
+}
+
+// handleExecutionPin pins or unpins an execution, pinned executions being
+// kept by the janitor whatever the retention
+func (h *Handler) handleExecutionPin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	executionID := getExecutionIDFromPath(r)
+	if executionID == 0 {
+		common.HandleError(w, r, errors.New("invalid execution ID"))
+		return
+	}
+
+	// Check permissions first
+	if !h.canAccessExecution(ctx, executionID) {
+		h.getForbiddenPage(w, r)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	pinned, err := strconv.ParseBool(r.FormValue("pinned"))
+	if err != nil {
+		http.Error(w, "Invalid pinned value", http.StatusBadRequest)
+		return
+	}
+
+	executionRepo := execution.NewRepository(h.store)
+
+	if err := executionRepo.SetPinned(ctx, executionID, pinned); err != nil {
+		common.HandleError(w, r, errors.WithStack(err))
+		return
+	}
+
+	h.logger.InfoContext(ctx, "execution pin updated",
+		"execution_id", executionID,
+		"pinned", pinned)
+
+	executionURL := commonComp.BaseURL(ctx, commonComp.WithPathf("/tasks/%d/executions/%d", getTaskIDFromPath(r), executionID))
+
+	http.Redirect(w, r, string(executionURL), http.StatusSeeOther)
+}
+
 func (h *Handler) downloadExecutionFile(w http.ResponseWriter, r *http.Request) {
 	executionID := getExecutionIDFromPath(r)
 	filename := r.PathValue("filename")
@@ -90,7 +193,7 @@ func (h *Handler) downloadExecutionFile(w http.ResponseWriter, r *http.Request)
 	}
 
 	executionRepo := execution.NewRepository(h.store)
-	file, err := executionRepo.GetFileByPath(r.Context(), executionID, filename)
+	executionFile, err := executionRepo.GetFileByPath(r.Context(), executionID, filename)
 	if err != nil {
 		if errors.Is(err, errors.New("record not found")) {
 			http.NotFound(w, r)
@@ -101,18 +204,60 @@ func (h *Handler) downloadExecutionFile(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Security check: ensure file path is within execution directory
-	if !h.isValidFilePath(executionID, file.FilePath) {
+	if !h.isValidFilePath(executionID, executionFile.FilePath) {
 		http.Error(w, "Invalid file path", http.StatusForbidden)
 		return
 	}
 
+	inline := r.URL.Query().Get("inline") == "true"
+
+	// Attachments are downloaded directly from the backend when it signs URLs
+	if !inline {
+		signedURL, err := h.fileStorage.SignedURL(r.Context(), executionFile.FilePath, path.Base(executionFile.Filename), executionFile.MimeType)
+		if err != nil {
+			common.HandleError(w, r, errors.WithStack(err))
+			return
+		}
+
+		if signedURL != "" {
+			http.Redirect(w, r, signedURL, http.StatusFound)
+			return
+		}
+	}
+
+	reader, err := h.fileStorage.GetFile(r.Context(), executionFile.FilePath)
+	if err != nil {
+		if errors.Is(err, file.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		common.HandleError(w, r, errors.WithStack(err))
+		return
+	}
+
+	defer reader.Close()
+
 	// Set appropriate headers
-	w.Header().Set("Content-Type", file.MimeType)
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", file.FileSize))
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file.Filename))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if inline {
+		setInlineFileHeaders(w, executionFile)
+	} else {
+		w.Header().Set("Content-Type", executionFile.MimeType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(executionFile.Filename)))
+	}
+
+	// Serve executionFile, with range requests if the backend allows seeking
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", executionFile.UpdatedAt, seeker)
+		return
+	}
+
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", executionFile.FileSize))
 
-	// Serve file
-	http.ServeFile(w, r, file.FilePath)
+	if _, err := io.Copy(w, reader); err != nil {
+		h.logger.WarnContext(r.Context(), "could not send execution file", slogx.Error(err), "execution_id", executionID)
+	}
 }
 
 func (h *Handler) getTaskExecutionHistory(w http.ResponseWriter, r *http.Request) {
@@ -157,7 +302,7 @@ func (h *Handler) getHealthCheck(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check file system
-	if err := h.checkFileSystemHealth(); err != nil {
+	if err := h.checkFileSystemHealth(ctx); err != nil {
 		w.WriteHeader(http.StatusServiceUnavailable)
 		json.NewEncoder(w).Encode(map[string]string{
 			"status": "unhealthy",
@@ -218,10 +363,17 @@ func (h *Handler) fillExecutionPageViewModel(r *http.Request, executionID uint)
 		return nil, errors.WithStack(err)
 	}
 
+	// Get the attempts chain of the execution
+	attempts, err := executionRepo.GetAttempts(ctx, exec)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
 	vmodel.Task = task
 	vmodel.Execution = exec
 	vmodel.Logs = logs
 	vmodel.OutputFiles = outputFiles
+	vmodel.Attempts = attempts
 	vmodel.IsRunning = isRunning(exec.Status)
 
 	// Fill common view model parts
@@ -398,6 +550,8 @@ func getTimestampFromQuery(r *http.Request, param string) time.Time {
 func isRunning(status store.TaskExecutionStatus) bool {
 	return status != store.StatusSucceeded &&
 		status != store.StatusFailed &&
+		status != store.StatusKilled &&
+		status != store.StatusTimedOut &&
 		status != store.StatusFinished
 }
 
@@ -427,25 +581,16 @@ func (h *Handler) isValidFilePath(executionID uint, filePath string) bool {
 		return false
 	}
 
-	expectedPrefix := h.fileStorage.GetExecutionPath(executionID)
-	return strings.HasPrefix(filePath, expectedPrefix)
+	return h.fileStorage.IsExecutionFile(executionID, filePath)
 }
 
-func (h *Handler) checkFileSystemHealth() error {
+func (h *Handler) checkFileSystemHealth(ctx context.Context) error {
 	if h.fileStorage == nil {
 		return errors.New("file storage not configured")
 	}
 
-	// Try to create a test file
-	testPath := h.fileStorage.GetBasePath() + "/.health_check"
-	if err := h.fileStorage.EnsureDirectoryExists(); err != nil {
-		errors.WithStack(err)
-	}
-
-	// Simple write test
-	file, err := h.fileStorage.GetFile(testPath)
-	if err == nil {
-		file.Close()
+	if err := h.fileStorage.Check(ctx); err != nil {
+		return errors.WithStack(err)
 	}
 
 	return nil
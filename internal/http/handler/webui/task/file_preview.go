@@ -0,0 +1,227 @@
+package task
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/a-h/templ"
+	"github.com/bornholm/oplet/internal/http/handler/webui/common"
+	"github.com/bornholm/oplet/internal/http/handler/webui/task/component"
+	"github.com/bornholm/oplet/internal/store"
+	"github.com/bornholm/oplet/internal/store/repository/execution"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const (
+	// maxTextPreviewSize is the maximum size of the content of the text files
+	// shown in their preview
+	maxTextPreviewSize = 512 << 10
+	// csvPreviewPageSize is the number of rows of the CSV files previewed
+	// on each page
+	csvPreviewPageSize = 50
+	// maxCSVPreviewRows is the number of rows of the CSV files after which
+	// they are not previewed anymore, the whole file being read up to the
+	// requested page
+	maxCSVPreviewRows = 200 * csvPreviewPageSize
+)
+
+const (
+	// inlineFilePolicy sandboxes the files served inline, so that their
+	// content can not run scripts on behalf of the application
+	inlineFilePolicy = "sandbox; default-src 'none'; img-src 'self'; media-src 'self'; style-src 'unsafe-inline'; frame-ancestors 'self'"
+	// inlinePDFPolicy applies to PDF files, the viewers of the browsers
+	// refusing to render sandboxed documents
+	inlinePDFPolicy = "default-src 'none'; frame-ancestors 'self'"
+)
+
+// getExecutionFilePreview renders the preview of an output file, swapped
+// into the execution page
+func (h *Handler) getExecutionFilePreview(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	executionID := getExecutionIDFromPath(r)
+	filename := r.PathValue("filename")
+
+	if executionID == 0 || filename == "" {
+		http.Error(w, "Invalid parameters", http.StatusBadRequest)
+		return
+	}
+
+	// Check permissions
+	if !h.canAccessExecution(ctx, executionID) {
+		h.getForbiddenPage(w, r)
+		return
+	}
+
+	executionRepo := execution.NewRepository(h.store)
+	file, err := executionRepo.GetFileByPath(ctx, executionID, filename)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		common.HandleError(w, r, errors.WithStack(err))
+		return
+	}
+
+	// Security check: ensure file path is within execution directory
+	if !h.isValidFilePath(executionID, file.FilePath) {
+		http.Error(w, "Invalid file path", http.StatusForbidden)
+		return
+	}
+
+	vmodel := component.FilePreviewVModel{
+		TaskID:      getTaskIDFromPath(r),
+		ExecutionID: executionID,
+		File:        file,
+		Kind:        component.GetFilePreviewKind(file),
+	}
+
+	switch vmodel.Kind {
+	case component.FilePreviewNone:
+		http.Error(w, "File can not be previewed", http.StatusUnsupportedMediaType)
+		return
+
+	case component.FilePreviewText, component.FilePreviewJSON, component.FilePreviewYAML:
+		if err := h.fillTextPreview(ctx, &vmodel); err != nil {
+			common.HandleError(w, r, errors.WithStack(err))
+			return
+		}
+
+	case component.FilePreviewCSV:
+		page, err := strconv.Atoi(r.URL.Query().Get("page"))
+		if err != nil {
+			page = 1
+		}
+
+		if err := h.fillCSVPreview(ctx, &vmodel, page); err != nil {
+			common.HandleError(w, r, errors.WithStack(err))
+			return
+		}
+	}
+
+	templ.Handler(component.FilePreview(vmodel)).ServeHTTP(w, r)
+}
+
+// fillTextPreview reads the beginning of the text file
+func (h *Handler) fillTextPreview(ctx context.Context, vmodel *component.FilePreviewVModel) error {
+	reader, err := h.fileStorage.GetFile(ctx, vmodel.File.FilePath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer reader.Close()
+
+	return errors.WithStack(readTextPreview(reader, vmodel))
+}
+
+// readTextPreview reads the beginning of the text, up to maxTextPreviewSize
+func readTextPreview(r io.Reader, vmodel *component.FilePreviewVModel) error {
+	content, err := io.ReadAll(io.LimitReader(r, maxTextPreviewSize+1))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if len(content) > maxTextPreviewSize {
+		content = content[:maxTextPreviewSize]
+		vmodel.Truncated = true
+	}
+
+	// Invalid sequences, including the last rune if it was cut in the middle,
+	// are replaced
+	vmodel.Text = strings.ToValidUTF8(string(content), "\uFFFD")
+
+	return nil
+}
+
+// fillCSVPreview reads the header and the rows of the given page of the
+// CSV file
+func (h *Handler) fillCSVPreview(ctx context.Context, vmodel *component.FilePreviewVModel, page int) error {
+	reader, err := h.fileStorage.GetFile(ctx, vmodel.File.FilePath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer reader.Close()
+
+	return errors.WithStack(readCSVPreview(reader, vmodel, page))
+}
+
+// readCSVPreview reads the header and the rows of the given page of the CSV
+// content, stopping at the first row of the next page. Pages past
+// maxCSVPreviewRows are not previewed, the last one being shown instead.
+func readCSVPreview(r io.Reader, vmodel *component.FilePreviewVModel, page int) error {
+	page = min(max(page, 1), maxCSVPreviewRows/csvPreviewPageSize)
+
+	records := csv.NewReader(r)
+	records.FieldsPerRecord = -1
+	records.LazyQuotes = true
+	records.ReuseRecord = true
+
+	header, err := records.Read()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return errors.WithStack(err)
+	}
+
+	vmodel.CSVHeader = append([]string(nil), header...)
+	vmodel.CSVPage = page
+
+	first := (page - 1) * csvPreviewPageSize
+
+	for count := 0; ; count++ {
+		record, err := records.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if count == first+csvPreviewPageSize {
+			// The file has more rows than the given page
+			if count < maxCSVPreviewRows {
+				vmodel.CSVHasNext = true
+			} else {
+				vmodel.Truncated = true
+			}
+
+			return nil
+		}
+
+		if count >= first {
+			vmodel.CSVRows = append(vmodel.CSVRows, append([]string(nil), record...))
+		}
+	}
+}
+
+// setInlineFileHeaders prepares the response serving the file to be
+// displayed by the browser. Only the media types rendered by the previews
+// are kept, other files being served as plain text or as attachments.
+func setInlineFileHeaders(w http.ResponseWriter, file *store.TaskExecutionFile) {
+	contentType := file.MimeType
+	policy := inlineFilePolicy
+
+	switch component.GetFilePreviewKind(file) {
+	case component.FilePreviewImage, component.FilePreviewAudio, component.FilePreviewVideo:
+		// Served with their own media type
+	case component.FilePreviewPDF:
+		policy = inlinePDFPolicy
+	case component.FilePreviewText, component.FilePreviewJSON, component.FilePreviewYAML, component.FilePreviewCSV:
+		contentType = "text/plain; charset=utf-8"
+	default:
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(file.Filename)))
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", path.Base(file.Filename)))
+	w.Header().Set("Content-Security-Policy", policy)
+}
@@ -0,0 +1,208 @@
+package task
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/bornholm/oplet/internal/http/handler/webui/task/component"
+)
+
+func TestReadCSVPreview(t *testing.T) {
+	type testCase struct {
+		name              string
+		rows              int
+		page              int
+		expectedPage      int
+		expectedFirst     string
+		expectedRows      int
+		expectedHasNext   bool
+		expectedTruncated bool
+	}
+
+	testCases := []testCase{
+		{
+			name:         "empty file",
+			rows:         0,
+			page:         1,
+			expectedPage: 1,
+			expectedRows: 0,
+		},
+		{
+			name:          "single page",
+			rows:          csvPreviewPageSize,
+			page:          1,
+			expectedPage:  1,
+			expectedFirst: "0",
+			expectedRows:  csvPreviewPageSize,
+		},
+		{
+			name:            "first of several pages",
+			rows:            csvPreviewPageSize + 1,
+			page:            1,
+			expectedPage:    1,
+			expectedFirst:   "0",
+			expectedRows:    csvPreviewPageSize,
+			expectedHasNext: true,
+		},
+		{
+			name:          "last page",
+			rows:          2*csvPreviewPageSize + 10,
+			page:          3,
+			expectedPage:  3,
+			expectedFirst: fmt.Sprint(2 * csvPreviewPageSize),
+			expectedRows:  10,
+		},
+		{
+			name:         "page past the end",
+			rows:         10,
+			page:         4,
+			expectedPage: 4,
+			expectedRows: 0,
+		},
+		{
+			name:          "invalid page",
+			rows:          10,
+			page:          -1,
+			expectedPage:  1,
+			expectedFirst: "0",
+			expectedRows:  10,
+		},
+		{
+			name:              "rows past the preview limit",
+			rows:              maxCSVPreviewRows + 1,
+			page:              maxCSVPreviewRows,
+			expectedPage:      maxCSVPreviewRows / csvPreviewPageSize,
+			expectedFirst:     fmt.Sprint(maxCSVPreviewRows - csvPreviewPageSize),
+			expectedRows:      csvPreviewPageSize,
+			expectedTruncated: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var content strings.Builder
+
+			content.WriteString("id,value\n")
+
+			for i := range tc.rows {
+				fmt.Fprintf(&content, "%d,value %d\n", i, i)
+			}
+
+			var vmodel component.FilePreviewVModel
+
+			if err := readCSVPreview(strings.NewReader(content.String()), &vmodel, tc.page); err != nil {
+				t.Fatalf("%+v", errors.WithStack(err))
+			}
+
+			if e, g := "id,value", strings.Join(vmodel.CSVHeader, ","); e != g {
+				t.Errorf("vmodel.CSVHeader: expected '%s', got '%s'", e, g)
+			}
+
+			if e, g := tc.expectedPage, vmodel.CSVPage; e != g {
+				t.Errorf("vmodel.CSVPage: expected %d, got %d", e, g)
+			}
+
+			if e, g := tc.expectedRows, len(vmodel.CSVRows); e != g {
+				t.Fatalf("len(vmodel.CSVRows): expected %d, got %d", e, g)
+			}
+
+			if tc.expectedRows > 0 {
+				if e, g := tc.expectedFirst, vmodel.CSVRows[0][0]; e != g {
+					t.Errorf("vmodel.CSVRows[0][0]: expected '%s', got '%s'", e, g)
+				}
+			}
+
+			if e, g := tc.expectedHasNext, vmodel.CSVHasNext; e != g {
+				t.Errorf("vmodel.CSVHasNext: expected %v, got %v", e, g)
+			}
+
+			if e, g := tc.expectedTruncated, vmodel.Truncated; e != g {
+				t.Errorf("vmodel.Truncated: expected %v, got %v", e, g)
+			}
+		})
+	}
+}
+
+func TestReadCSVPreviewStopsAfterPage(t *testing.T) {
+	var content strings.Builder
+
+	content.WriteString("id,value\n")
+
+	for i := range maxCSVPreviewRows {
+		fmt.Fprintf(&content, "%d,value %d\n", i, i)
+	}
+
+	reader := &countingReader{reader: strings.NewReader(content.String())}
+
+	var vmodel component.FilePreviewVModel
+
+	if err := readCSVPreview(reader, &vmodel, 1); err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	// Only the buffer of the CSV reader is read past the first page
+	if max := int64(64 << 10); reader.count > max {
+		t.Errorf("bytes read: expected at most %d, got %d", max, reader.count)
+	}
+}
+
+func TestReadTextPreview(t *testing.T) {
+	type testCase struct {
+		name              string
+		content           string
+		expectedText      string
+		expectedTruncated bool
+	}
+
+	testCases := []testCase{
+		{
+			name:         "small file",
+			content:      "hello world",
+			expectedText: "hello world",
+		},
+		{
+			name:              "file larger than the preview",
+			content:           strings.Repeat("a", maxTextPreviewSize) + "b",
+			expectedText:      strings.Repeat("a", maxTextPreviewSize),
+			expectedTruncated: true,
+		},
+		{
+			name:              "rune cut by the preview",
+			content:           strings.Repeat("a", maxTextPreviewSize-1) + "é",
+			expectedText:      strings.Repeat("a", maxTextPreviewSize-1) + "�",
+			expectedTruncated: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var vmodel component.FilePreviewVModel
+
+			if err := readTextPreview(strings.NewReader(tc.content), &vmodel); err != nil {
+				t.Fatalf("%+v", errors.WithStack(err))
+			}
+
+			if e, g := tc.expectedText, vmodel.Text; e != g {
+				t.Errorf("vmodel.Text: expected %d bytes, got %d bytes", len(e), len(g))
+			}
+
+			if e, g := tc.expectedTruncated, vmodel.Truncated; e != g {
+				t.Errorf("vmodel.Truncated: expected %v, got %v", e, g)
+			}
+		})
+	}
+}
+
+type countingReader struct {
+	reader *strings.Reader
+	count  int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
@@ -3,7 +3,9 @@ package task
 import (
 	"log/slog"
 	"net/http"
+	"time"
 
+	"github.com/bornholm/oplet/internal/dispatch"
 	"github.com/bornholm/oplet/internal/file"
 	"github.com/bornholm/oplet/internal/http/authz"
 	"github.com/bornholm/oplet/internal/store"
@@ -16,6 +18,9 @@ type Handler struct {
 	taskProvider task.Provider
 	taskExecutor task.Executor
 	fileStorage  *file.Storage
+	dispatcher   *dispatch.Dispatcher
+	logNotifier  dispatch.TopicNotifier
+	maxTimeout   time.Duration
 	logger       *slog.Logger
 }
 
@@ -24,13 +29,16 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.mux.ServeHTTP(w, r)
 }
 
-func NewHandler(store *store.Store, taskProvider task.Provider, taskExecutor task.Executor, fileStorage *file.Storage, logger *slog.Logger) *Handler {
+func NewHandler(store *store.Store, taskProvider task.Provider, taskExecutor task.Executor, fileStorage *file.Storage, dispatcher *dispatch.Dispatcher, logNotifier dispatch.TopicNotifier, maxTimeout time.Duration, logger *slog.Logger) *Handler {
 	h := &Handler{
 		mux:          http.NewServeMux(),
 		store:        store,
 		taskProvider: taskProvider,
 		taskExecutor: taskExecutor,
 		fileStorage:  fileStorage,
+		dispatcher:   dispatcher,
+		logNotifier:  logNotifier,
+		maxTimeout:   maxTimeout,
 		logger:       logger.With("component", "task-handler"),
 	}
 
@@ -42,10 +50,23 @@ func NewHandler(store *store.Store, taskProvider task.Provider, taskExecutor tas
 	h.mux.Handle("GET /tasks/{taskID}/new", assertUser(http.HandlerFunc(h.getNewTaskPage)))
 	h.mux.Handle("POST /tasks/{taskID}/new", assertUser(http.HandlerFunc(h.getNewTaskPage)))
 
+	// Resumable uploads of the file inputs, sent by chunks before the
+	// execution is created
+	h.mux.Handle("POST /tasks/{taskID}/uploads", assertUser(http.HandlerFunc(h.createUpload)))
+	h.mux.Handle("GET /tasks/{taskID}/uploads/{uploadID}", assertUser(http.HandlerFunc(h.getUpload)))
+	h.mux.Handle("PATCH /tasks/{taskID}/uploads/{uploadID}", assertUser(http.HandlerFunc(h.appendUploadChunk)))
+	h.mux.Handle("POST /tasks/{taskID}/uploads/{uploadID}/complete", assertUser(http.HandlerFunc(h.completeUpload)))
+	h.mux.Handle("DELETE /tasks/{taskID}/uploads/{uploadID}", assertUser(http.HandlerFunc(h.cancelUpload)))
+
 	// Add new routes for execution tracking
 	h.mux.Handle("GET /tasks/{taskID}/executions/{executionID}", assertUser(http.HandlerFunc(h.getExecutionPage)))
 	h.mux.Handle("GET /tasks/{taskID}/executions/{executionID}/logs", assertUser(http.HandlerFunc(h.getExecutionLogs)))
-	h.mux.Handle("GET /tasks/{taskID}/executions/{executionID}/files/{filename}", assertUser(http.HandlerFunc(h.downloadExecutionFile)))
+	h.mux.Handle("GET /tasks/{taskID}/executions/{executionID}/logs/stream", assertUser(http.HandlerFunc(h.streamExecutionLogs)))
+	h.mux.Handle("POST /tasks/{taskID}/executions/{executionID}/cancel", assertUser(http.HandlerFunc(h.handleExecutionCancel)))
+	h.mux.Handle("POST /tasks/{taskID}/executions/{executionID}/pin", assertUser(http.HandlerFunc(h.handleExecutionPin)))
+	h.mux.Handle("GET /tasks/{taskID}/executions/{executionID}/files/{filename...}", assertUser(http.HandlerFunc(h.downloadExecutionFile)))
+	h.mux.Handle("GET /tasks/{taskID}/executions/{executionID}/previews/{filename...}", assertUser(http.HandlerFunc(h.getExecutionFilePreview)))
+	h.mux.Handle("GET /tasks/{taskID}/executions/{executionID}/outputs.zip", assertUser(http.HandlerFunc(h.downloadExecutionOutputs)))
 	h.mux.Handle("GET /tasks/{taskID}/executions", assertUser(http.HandlerFunc(h.getTaskExecutionHistory)))
 	h.mux.Handle("GET /tasks/executions", assertUser(http.HandlerFunc(h.getGlobalExecutionHistory)))
 	h.mux.Handle("GET /health", http.HandlerFunc(h.getHealthCheck))
@@ -0,0 +1,239 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/a-h/templ"
+	"github.com/bornholm/oplet/internal/dispatch"
+	"github.com/bornholm/oplet/internal/http/handler/webui/common"
+	"github.com/bornholm/oplet/internal/http/handler/webui/task/component"
+	"github.com/bornholm/oplet/internal/store"
+	"github.com/bornholm/oplet/internal/store/repository/execution"
+	"github.com/pkg/errors"
+)
+
+const (
+	// logStreamBatchSize is the maximum number of log entries read at once
+	logStreamBatchSize = 500
+	// logStreamKeepAlive is the interval at which idle streams are kept alive,
+	// the execution being checked again at the same time
+	logStreamKeepAlive = 15 * time.Second
+)
+
+// Events sent on the execution log streams
+const (
+	logStreamEventLog      = "log"
+	logStreamEventStatus   = "status"
+	logStreamEventProgress = "progress"
+	logStreamEventEnd      = "end"
+)
+
+// streamExecutionLogs streams the log entries, the status changes and the
+// progress of an execution as server-sent events. Log events are identified
+// by the ID of their entry, so that the stream resumes after the
+// Last-Event-ID sent by the client, or the last_event_id query parameter.
+// The stream ends once the execution reached a final status.
+func (h *Handler) streamExecutionLogs(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	executionID := getExecutionIDFromPath(r)
+	if executionID == 0 {
+		common.HandleError(w, r, errors.New("invalid execution ID"))
+		return
+	}
+
+	// Check permissions first
+	if !h.canAccessExecution(ctx, executionID) {
+		h.getForbiddenPage(w, r)
+		return
+	}
+
+	lastEventID, err := getLastEventID(r)
+	if err != nil {
+		http.Error(w, "Invalid last event ID", http.StatusBadRequest)
+		return
+	}
+
+	notifications, err := h.logNotifier.Subscribe(ctx, dispatch.ExecutionTopic(executionID))
+	if err != nil {
+		common.HandleError(w, r, errors.WithStack(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	stream := &logStream{
+		w:           w,
+		controller:  http.NewResponseController(w),
+		lastEventID: lastEventID,
+	}
+
+	keepAlive := time.NewTicker(logStreamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		ended, err := h.sendExecutionEvents(ctx, stream, executionID)
+		if err != nil {
+			if !errors.Is(err, context.Canceled) {
+				h.logger.WarnContext(ctx, "could not stream execution logs",
+					"execution_id", executionID, "error", err)
+			}
+			return
+		}
+
+		if ended {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-notifications:
+			if !ok {
+				return
+			}
+		case <-keepAlive.C:
+			if err := stream.comment("keep-alive"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// sendExecutionEvents sends the log entries added since the last event and
+// the status and progress of the execution if they changed. It returns true
+// once the execution reached a final status.
+func (h *Handler) sendExecutionEvents(ctx context.Context, stream *logStream, executionID uint) (bool, error) {
+	executionRepo := execution.NewRepository(h.store)
+
+	// The status is read first so that the log entries added before the
+	// execution ended are sent with the end of the stream
+	exec, err := executionRepo.GetProgress(ctx, executionID)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	status := exec.Status
+
+	for {
+		logs, err := executionRepo.GetLogsAfter(ctx, executionID, stream.lastEventID, logStreamBatchSize)
+		if err != nil {
+			return false, errors.WithStack(err)
+		}
+
+		for _, log := range logs {
+			if err := stream.send(ctx, logStreamEventLog, strconv.FormatUint(uint64(log.ID), 10), component.LogEntry(log)); err != nil {
+				return false, errors.WithStack(err)
+			}
+
+			stream.lastEventID = log.ID
+		}
+
+		if len(logs) < logStreamBatchSize {
+			break
+		}
+	}
+
+	if status != stream.status {
+		if err := stream.send(ctx, logStreamEventStatus, "", component.StatusBadge(status, "is-large")); err != nil {
+			return false, errors.WithStack(err)
+		}
+
+		stream.status = status
+	}
+
+	if exec.ProgressUpdatedAt != nil && !exec.ProgressUpdatedAt.Equal(stream.progressUpdatedAt) {
+		if err := stream.send(ctx, logStreamEventProgress, "", component.ExecutionProgress(exec, isRunning(status))); err != nil {
+			return false, errors.WithStack(err)
+		}
+
+		stream.progressUpdatedAt = *exec.ProgressUpdatedAt
+	}
+
+	ended := !isRunning(status)
+	if ended {
+		if err := stream.send(ctx, logStreamEventEnd, "", templ.Raw(string(status))); err != nil {
+			return false, errors.WithStack(err)
+		}
+	}
+
+	return ended, errors.WithStack(stream.controller.Flush())
+}
+
+type logStream struct {
+	w           io.Writer
+	controller  *http.ResponseController
+	lastEventID uint
+	status      store.TaskExecutionStatus
+	// Time of the last progress sent
+	progressUpdatedAt time.Time
+}
+
+// send writes an event holding the rendered component
+func (s *logStream) send(ctx context.Context, event string, id string, component templ.Component) error {
+	var buf bytes.Buffer
+
+	if err := component.Render(ctx, &buf); err != nil {
+		return errors.WithStack(err)
+	}
+
+	var msg strings.Builder
+
+	fmt.Fprintf(&msg, "event: %s\n", event)
+
+	if id != "" {
+		fmt.Fprintf(&msg, "id: %s\n", id)
+	}
+
+	// Line breaks delimit the fields of the events, the data is split
+	// on several lines joined back by the client
+	data := strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(buf.String())
+	for line := range strings.SplitSeq(data, "\n") {
+		fmt.Fprintf(&msg, "data: %s\n", line)
+	}
+
+	msg.WriteString("\n")
+
+	if _, err := io.WriteString(s.w, msg.String()); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// comment writes a comment ignored by the client
+func (s *logStream) comment(text string) error {
+	if _, err := fmt.Fprintf(s.w, ": %s\n\n", text); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(s.controller.Flush())
+}
+
+func getLastEventID(r *http.Request) (uint, error) {
+	rawLastEventID := r.Header.Get("Last-Event-ID")
+	if rawLastEventID == "" {
+		rawLastEventID = r.URL.Query().Get("last_event_id")
+	}
+
+	if rawLastEventID == "" {
+		return 0, nil
+	}
+
+	lastEventID, err := strconv.ParseUint(rawLastEventID, 10, 32)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return uint(lastEventID), nil
+}
@@ -0,0 +1,225 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+
+	"github.com/bornholm/oplet/internal/store"
+)
+
+func TestGetLastEventID(t *testing.T) {
+	type testCase struct {
+		name          string
+		header        string
+		query         string
+		expected      uint
+		expectedError bool
+	}
+
+	testCases := []testCase{
+		{name: "none", expected: 0},
+		{name: "header", header: "42", expected: 42},
+		{name: "query parameter", query: "12", expected: 12},
+		{name: "header prevails", header: "42", query: "12", expected: 42},
+		{name: "invalid header", header: "abc", expectedError: true},
+		{name: "negative query parameter", query: "-1", expectedError: true},
+		{name: "overflow", header: "4294967296", expectedError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/logs/stream?last_event_id="+tc.query, nil)
+			if tc.header != "" {
+				r.Header.Set("Last-Event-ID", tc.header)
+			}
+
+			lastEventID, err := getLastEventID(r)
+
+			if tc.expectedError {
+				if err == nil {
+					t.Errorf("expected an error, got nil")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("%+v", errors.WithStack(err))
+			}
+
+			if e, g := tc.expected, lastEventID; e != g {
+				t.Errorf("lastEventID: expected %d, got %d", e, g)
+			}
+		})
+	}
+}
+
+func TestSendExecutionEvents(t *testing.T) {
+	type testCase struct {
+		name           string
+		status         store.TaskExecutionStatus
+		logs           int
+		resumeAfter    int
+		expectedIDs    []int
+		expectedEvents []string
+		expectedEnded  bool
+	}
+
+	testCases := []testCase{
+		{
+			name:           "from the start",
+			status:         store.StatusRunning,
+			logs:           3,
+			expectedIDs:    []int{1, 2, 3},
+			expectedEvents: []string{logStreamEventLog, logStreamEventLog, logStreamEventLog, logStreamEventStatus},
+		},
+		{
+			name:           "resumed after an entry",
+			status:         store.StatusRunning,
+			logs:           3,
+			resumeAfter:    2,
+			expectedIDs:    []int{3},
+			expectedEvents: []string{logStreamEventLog, logStreamEventStatus},
+		},
+		{
+			name:           "resumed after the last entry",
+			status:         store.StatusRunning,
+			logs:           3,
+			resumeAfter:    3,
+			expectedIDs:    []int{},
+			expectedEvents: []string{logStreamEventStatus},
+		},
+		{
+			name:           "more entries than a batch",
+			status:         store.StatusRunning,
+			logs:           logStreamBatchSize + 2,
+			resumeAfter:    logStreamBatchSize - 1,
+			expectedIDs:    []int{logStreamBatchSize, logStreamBatchSize + 1, logStreamBatchSize + 2},
+			expectedEvents: []string{logStreamEventLog, logStreamEventLog, logStreamEventLog, logStreamEventStatus},
+		},
+		{
+			name:           "finished execution",
+			status:         store.StatusSucceeded,
+			logs:           1,
+			expectedIDs:    []int{1},
+			expectedEvents: []string{logStreamEventLog, logStreamEventStatus, logStreamEventEnd},
+			expectedEnded:  true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			db, err := gorm.Open(sqlite.Open(t.TempDir()+"/db.sqlite"), &gorm.Config{})
+			if err != nil {
+				t.Fatalf("%+v", errors.WithStack(err))
+			}
+
+			st := store.New(db)
+
+			// Run the migrations before the records are created
+			if err := st.Ping(ctx); err != nil {
+				t.Fatalf("%+v", errors.WithStack(err))
+			}
+
+			task := &store.Task{ImageRef: "task:1"}
+			if err := db.Create(task).Error; err != nil {
+				t.Fatalf("%+v", errors.WithStack(err))
+			}
+
+			execution := &store.TaskExecution{TaskID: task.ID, Status: tc.status, RunnerToken: "token"}
+			if err := db.Create(execution).Error; err != nil {
+				t.Fatalf("%+v", errors.WithStack(err))
+			}
+
+			logs := make([]store.TaskExecutionLog, 0, tc.logs)
+			for i := range tc.logs {
+				logs = append(logs, store.TaskExecutionLog{
+					ExecutionID: execution.ID,
+					Timestamp:   int64(i),
+					Source:      "container",
+					Clock:       uint(i),
+					Message:     fmt.Sprintf("line %d", i),
+				})
+			}
+
+			if len(logs) > 0 {
+				if err := db.CreateInBatches(logs, 100).Error; err != nil {
+					t.Fatalf("%+v", errors.WithStack(err))
+				}
+			}
+
+			h := &Handler{store: st}
+
+			recorder := httptest.NewRecorder()
+
+			stream := &logStream{
+				w:           recorder,
+				controller:  http.NewResponseController(recorder),
+				lastEventID: uint(tc.resumeAfter),
+			}
+
+			ended, err := h.sendExecutionEvents(ctx, stream, execution.ID)
+			if err != nil {
+				t.Fatalf("%+v", errors.WithStack(err))
+			}
+
+			if e, g := tc.expectedEnded, ended; e != g {
+				t.Errorf("ended: expected %v, got %v", e, g)
+			}
+
+			ids := make([]int, 0)
+			events := make([]string, 0)
+
+			for line := range strings.SplitSeq(recorder.Body.String(), "\n") {
+				if id, found := strings.CutPrefix(line, "id: "); found {
+					var parsed int
+					if _, err := fmt.Sscan(id, &parsed); err != nil {
+						t.Fatalf("%+v", errors.WithStack(err))
+					}
+					ids = append(ids, parsed)
+				}
+
+				if event, found := strings.CutPrefix(line, "event: "); found {
+					events = append(events, event)
+				}
+			}
+
+			if e, g := tc.expectedIDs, ids; !slices.Equal(e, g) {
+				t.Errorf("event ids: expected %v, got %v", e, g)
+			}
+
+			if e, g := tc.expectedEvents, events; !slices.Equal(e, g) {
+				t.Errorf("events: expected %v, got %v", e, g)
+			}
+
+			if len(tc.expectedIDs) > 0 {
+				if e, g := uint(tc.expectedIDs[len(tc.expectedIDs)-1]), stream.lastEventID; e != g {
+					t.Errorf("stream.lastEventID: expected %d, got %d", e, g)
+				}
+			}
+
+			// Nothing is sent again once the stream is up to date
+			if !ended {
+				recorder.Body.Reset()
+
+				if _, err := h.sendExecutionEvents(ctx, stream, execution.ID); err != nil {
+					t.Fatalf("%+v", errors.WithStack(err))
+				}
+
+				if recorder.Body.Len() > 0 {
+					t.Errorf("expected no event, got '%s'", recorder.Body.String())
+				}
+			}
+		})
+	}
+}
@@ -4,22 +4,27 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"maps"
 	"mime/multipart"
 	"net/http"
+	"slices"
 	"strconv"
 
 	"github.com/a-h/templ"
 	httpCtx "github.com/bornholm/oplet/internal/http/context"
 	"github.com/bornholm/oplet/internal/http/handler/webui/common"
 	commonComp "github.com/bornholm/oplet/internal/http/handler/webui/common/component"
+	"github.com/bornholm/oplet/internal/http/handler/webui/common/form"
 	taskForm "github.com/bornholm/oplet/internal/http/handler/webui/common/task"
 	"github.com/bornholm/oplet/internal/http/handler/webui/task/component"
 	"github.com/bornholm/oplet/internal/http/url"
 	"github.com/bornholm/oplet/internal/store"
 	"github.com/bornholm/oplet/internal/store/repository/execution"
 	taskRepository "github.com/bornholm/oplet/internal/store/repository/task"
+	"github.com/bornholm/oplet/internal/store/repository/upload"
 	"github.com/bornholm/oplet/internal/task"
 	"github.com/pkg/errors"
+	"gorm.io/gorm"
 )
 
 func (h *Handler) getNewTaskPage(w http.ResponseWriter, r *http.Request) {
@@ -128,8 +133,17 @@ func (h *Handler) handleNewTaskSubmission(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// Validate form
-	if !taskForm.IsValid(ctx) {
+	// Validate form, the files uploaded beforehand having been checked once
+	// they were complete
+	taskForm.IsValid(ctx)
+
+	uploads, err := h.resolveUploads(ctx, user.ID, storeTask.ID, taskDef, taskForm)
+	if err != nil {
+		common.HandleError(w, r, errors.WithStack(err))
+		return
+	}
+
+	if len(taskForm.Errors) > 0 {
 		// Re-render form with errors
 		vmodel, err := h.fillNewTaskPageViewModel(r, storeTask.ID, taskDef)
 		if err != nil {
@@ -151,9 +165,11 @@ func (h *Handler) handleNewTaskSubmission(w http.ResponseWriter, r *http.Request
 		TaskID:          storeTask.ID,
 		UserID:          user.ID,
 		Status:          store.StatusPending,
-		InputParameters: h.marshalInputParameters(taskDef, taskForm.Values, taskForm.Files),
+		InputParameters: h.marshalInputParameters(taskDef, taskForm.Values, taskForm.Files, uploads),
 	}
 
+	applyExecutionSettings(taskExecution, storeTask, taskDef, h.maxTimeout)
+
 	if err := executionRepo.Create(ctx, taskExecution); err != nil {
 		common.HandleError(w, r, errors.WithStack(err))
 		return
@@ -165,20 +181,68 @@ func (h *Handler) handleNewTaskSubmission(w http.ResponseWriter, r *http.Request
 		"user_id", user.ID)
 
 	// Store input files for runner to download later
-	if err := h.storeInputFiles(ctx, taskForm.Files, taskDef, taskExecution.ID); err != nil {
+	if err := h.storeInputFiles(ctx, taskForm.Files, uploads, taskDef, taskExecution.ID); err != nil {
 		// Mark execution as failed
 		executionRepo.SetCompleted(ctx, taskExecution.ID, -1, err.Error())
 		common.HandleError(w, r, errors.WithStack(err))
 		return
 	}
 
+	// Uploaded files become the input files of the execution without being
+	// copied, their content being already stored
+	if err := upload.NewRepository(h.store).Consume(ctx, taskExecution.ID, slices.Collect(maps.Values(uploads))); err != nil {
+		executionRepo.SetCompleted(ctx, taskExecution.ID, -1, err.Error())
+		common.HandleError(w, r, errors.WithStack(err))
+		return
+	}
+
+	// Wake up the runners waiting for executions
+	h.dispatcher.Notify(ctx)
+
 	progressURL := commonComp.BaseURL(ctx, url.WithPath("/tasks", commonComp.FormatID(taskExecution.TaskID), "executions", commonComp.FormatID(taskExecution.ID)))
 
 	// Redirect to progress page
 	http.Redirect(w, r, string(progressURL), http.StatusSeeOther)
 }
 
-func (h *Handler) marshalInputParameters(taskDef *task.Definition, values map[string]string, files map[string][]*multipart.FileHeader) string {
+// resolveUploads returns the completed uploads referenced by the file inputs
+// of the form, by input name, reporting the invalid references as errors of
+// their field
+func (h *Handler) resolveUploads(ctx context.Context, userID uint, taskID uint, taskDef *task.Definition, inputForm *form.Form) (map[string]*store.Upload, error) {
+	uploadRepo := upload.NewRepository(h.store)
+	uploads := make(map[string]*store.Upload)
+
+	for _, input := range taskDef.Inputs {
+		if input.Type != task.TypeFile {
+			continue
+		}
+
+		uploadID, exists := inputForm.Values[input.Name]
+		if !exists {
+			continue
+		}
+
+		if _, exists := inputForm.Files[input.Name]; exists {
+			continue
+		}
+
+		u, err := uploadRepo.GetByIDForUser(ctx, uploadID, userID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.WithStack(err)
+		}
+
+		if u == nil || u.TaskID != taskID || u.InputName != input.Name || !u.IsCompleted() {
+			inputForm.Errors[input.Name] = "the uploaded file is not available anymore, please upload it again"
+			continue
+		}
+
+		uploads[input.Name] = u
+	}
+
+	return uploads, nil
+}
+
+func (h *Handler) marshalInputParameters(taskDef *task.Definition, values map[string]string, files map[string][]*multipart.FileHeader, uploads map[string]*store.Upload) string {
 	params := make(map[string]interface{})
 
 	// Create a map of input types for quick lookup
@@ -190,6 +254,11 @@ func (h *Handler) marshalInputParameters(taskDef *task.Definition, values map[st
 	// Add form values with proper type conversion
 	for key, value := range values {
 		inputType, exists := inputTypes[key]
+		if exists && inputType == task.TypeFile {
+			// Values of the file inputs reference their upload
+			continue
+		}
+
 		if exists && inputType == task.TypeBoolean {
 			// Handle boolean conversion like in createExecutionRequest
 			if value == "on" {
@@ -211,6 +280,9 @@ func (h *Handler) marshalInputParameters(taskDef *task.Definition, values map[st
 		}
 		fileInfo[key] = filenames
 	}
+	for key, u := range uploads {
+		fileInfo[key] = []string{u.Filename}
+	}
 	if len(fileInfo) > 0 {
 		params["_files"] = fileInfo
 	}
@@ -226,7 +298,7 @@ func (h *Handler) marshalInputParameters(taskDef *task.Definition, values map[st
 }
 
 // storeInputFiles stores uploaded input files for later download by runners
-func (h *Handler) storeInputFiles(ctx context.Context, files map[string][]*multipart.FileHeader, taskDef *task.Definition, executionID uint) error {
+func (h *Handler) storeInputFiles(ctx context.Context, files map[string][]*multipart.FileHeader, uploads map[string]*store.Upload, taskDef *task.Definition, executionID uint) error {
 	executionRepo := execution.NewRepository(h.store)
 
 	// Process file inputs
@@ -247,7 +319,7 @@ func (h *Handler) storeInputFiles(ctx context.Context, files map[string][]*multi
 				parameterName := input.Name
 
 				// Store input file using parameter name
-				storedFile, err := h.fileStorage.StoreInputFile(executionID, parameterName, uploadedFile)
+				storedFile, err := h.fileStorage.StoreInputFile(ctx, executionID, parameterName, uploadedFile)
 				if err != nil {
 					return errors.Wrapf(err, "failed to store input file %s", input.Name)
 				}
@@ -256,9 +328,10 @@ func (h *Handler) storeInputFiles(ctx context.Context, files map[string][]*multi
 				dbFile := &store.TaskExecutionFile{
 					ExecutionID: executionID,
 					Filename:    parameterName, // Use parameter name, not original filename
-					FilePath:    storedFile.StoredPath,
+					FilePath:    storedFile.Key,
 					FileSize:    storedFile.Size,
 					MimeType:    storedFile.MimeType,
+					Checksum:    storedFile.Checksum,
 					IsOutput:    false,
 				}
 
@@ -273,7 +346,7 @@ func (h *Handler) storeInputFiles(ctx context.Context, files map[string][]*multi
 					"original_filename", fileHeader.Filename,
 					"size", storedFile.Size)
 
-			} else if input.Required {
+			} else if _, uploaded := uploads[input.Name]; !uploaded && input.Required {
 				return fmt.Errorf("required file %s not provided", input.Name)
 			}
 		}
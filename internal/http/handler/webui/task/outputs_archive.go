@@ -0,0 +1,97 @@
+package task
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/bornholm/oplet/internal/http/handler/webui/common"
+	"github.com/bornholm/oplet/internal/store"
+	"github.com/bornholm/oplet/internal/store/repository/execution"
+	"github.com/pkg/errors"
+)
+
+// downloadExecutionOutputs streams the output files of an execution as a ZIP
+// archive, preserving their directory structure
+func (h *Handler) downloadExecutionOutputs(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	executionID := getExecutionIDFromPath(r)
+	if executionID == 0 {
+		http.Error(w, "Invalid parameters", http.StatusBadRequest)
+		return
+	}
+
+	// Check permissions
+	if !h.canAccessExecution(ctx, executionID) {
+		h.getForbiddenPage(w, r)
+		return
+	}
+
+	executionRepo := execution.NewRepository(h.store)
+
+	files, err := executionRepo.GetFiles(ctx, executionID, true)
+	if err != nil {
+		common.HandleError(w, r, errors.WithStack(err))
+		return
+	}
+
+	if len(files) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("execution-%d-outputs.zip", executionID)))
+
+	archive := zip.NewWriter(w)
+
+	for _, file := range files {
+		if err := h.addArchiveFile(ctx, archive, executionID, file); err != nil {
+			// The response is already started, the archive is left truncated
+			h.logger.ErrorContext(ctx, "could not archive output file",
+				"execution_id", executionID,
+				"filename", file.Filename,
+				"error", err)
+			return
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		h.logger.ErrorContext(ctx, "could not close outputs archive",
+			"execution_id", executionID,
+			"error", err)
+	}
+}
+
+// addArchiveFile copies the content of an output file to the archive
+func (h *Handler) addArchiveFile(ctx context.Context, archive *zip.Writer, executionID uint, file *store.TaskExecutionFile) error {
+	// Security check: ensure file path is within execution directory
+	if !h.isValidFilePath(executionID, file.FilePath) {
+		return errors.Errorf("invalid path '%s'", file.FilePath)
+	}
+
+	reader, err := h.fileStorage.GetFile(ctx, file.FilePath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer reader.Close()
+
+	writer, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     file.Filename,
+		Method:   zip.Deflate,
+		Modified: file.CreatedAt,
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if _, err := io.Copy(writer, reader); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
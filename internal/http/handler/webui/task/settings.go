@@ -0,0 +1,84 @@
+package task
+
+import (
+	"time"
+
+	"github.com/bornholm/oplet/internal/store"
+	"github.com/bornholm/oplet/internal/task"
+)
+
+// applyExecutionSettings resolves the settings of a new execution from the
+// task image labels and the overrides defined by an administrator. The timeout
+// is capped by the server maximum, if any.
+func applyExecutionSettings(execution *store.TaskExecution, storeTask *store.Task, taskDef *task.Definition, maxTimeout time.Duration) {
+	runnerTags := taskDef.RunnerTags
+	if storeTask.RunnerTags != "" {
+		runnerTags = task.ParseTags(storeTask.RunnerTags)
+	}
+
+	execution.RunnerTags = task.FormatTags(runnerTags)
+
+	execution.Requeue = taskDef.Requeue
+	if storeTask.Requeue != nil {
+		execution.Requeue = *storeTask.Requeue
+	}
+
+	execution.Attempt = 1
+
+	execution.Retries = taskDef.Retries
+	if storeTask.Retries != nil {
+		execution.Retries = *storeTask.Retries
+	}
+
+	execution.RetryBackoff = taskDef.RetryBackoff
+	if storeTask.RetryBackoff != nil {
+		execution.RetryBackoff = *storeTask.RetryBackoff
+	}
+
+	execution.RetryOnExit = taskDef.RetryOnExit
+	if storeTask.RetryOnExit != nil {
+		execution.RetryOnExit = *storeTask.RetryOnExit
+	}
+
+	execution.Timeout = taskDef.Timeout
+	if storeTask.Timeout != nil {
+		execution.Timeout = *storeTask.Timeout
+	}
+
+	if maxTimeout > 0 && (execution.Timeout == 0 || execution.Timeout > maxTimeout) {
+		execution.Timeout = maxTimeout
+	}
+
+	execution.Network = taskDef.Network
+
+	// Relaxations requested by the labels are applied only once approved,
+	// so that a new image version can not grant itself more privileges
+	approved, err := task.ParseSecurityRelaxations(storeTask.ApprovedSecurity)
+	if err == nil {
+		execution.SecurityRelaxations = taskDef.Security.Intersect(approved).String()
+	}
+
+	execution.CPULimit = taskDef.Resources.CPULimit
+	if storeTask.CPULimit != nil {
+		execution.CPULimit = *storeTask.CPULimit
+	}
+
+	execution.CPURequest = taskDef.Resources.CPURequest
+	if storeTask.CPURequest != nil {
+		execution.CPURequest = *storeTask.CPURequest
+	} else if storeTask.CPULimit != nil {
+		execution.CPURequest = execution.CPULimit
+	}
+
+	execution.MemoryLimit = taskDef.Resources.MemoryLimit
+	if storeTask.MemoryLimit != nil {
+		execution.MemoryLimit = *storeTask.MemoryLimit
+	}
+
+	execution.MemoryRequest = taskDef.Resources.MemoryRequest
+	if storeTask.MemoryRequest != nil {
+		execution.MemoryRequest = *storeTask.MemoryRequest
+	} else if storeTask.MemoryLimit != nil {
+		execution.MemoryRequest = execution.MemoryLimit
+	}
+}
@@ -0,0 +1,47 @@
+package task
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bornholm/oplet/internal/store"
+	"github.com/bornholm/oplet/internal/task"
+)
+
+func TestApplyExecutionSettingsTimeout(t *testing.T) {
+	type testCase struct {
+		name       string
+		timeout    time.Duration
+		override   *time.Duration
+		maxTimeout time.Duration
+		expected   time.Duration
+	}
+
+	overridden := 2 * time.Hour
+	disabled := time.Duration(0)
+
+	testCases := []testCase{
+		{name: "no task timeout", maxTimeout: time.Hour, expected: time.Hour},
+		{name: "under the cap", timeout: 15 * time.Minute, maxTimeout: time.Hour, expected: 15 * time.Minute},
+		{name: "at the cap", timeout: time.Hour, maxTimeout: time.Hour, expected: time.Hour},
+		{name: "over the cap", timeout: 3 * time.Hour, maxTimeout: time.Hour, expected: time.Hour},
+		{name: "override over the cap", timeout: 15 * time.Minute, override: &overridden, maxTimeout: time.Hour, expected: time.Hour},
+		{name: "override disabling the timeout", timeout: 15 * time.Minute, override: &disabled, maxTimeout: time.Hour, expected: time.Hour},
+		{name: "no cap", timeout: 3 * time.Hour, expected: 3 * time.Hour},
+		{name: "no cap nor task timeout", expected: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			execution := &store.TaskExecution{}
+			storeTask := &store.Task{Timeout: tc.override}
+			taskDef := &task.Definition{Timeout: tc.timeout}
+
+			applyExecutionSettings(execution, storeTask, taskDef, tc.maxTimeout)
+
+			if e, g := tc.expected, execution.Timeout; e != g {
+				t.Errorf("execution.Timeout: expected %v, got %v", e, g)
+			}
+		})
+	}
+}
@@ -0,0 +1,438 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bornholm/oplet/internal/file"
+	httpCtx "github.com/bornholm/oplet/internal/http/context"
+	"github.com/bornholm/oplet/internal/store"
+	taskRepository "github.com/bornholm/oplet/internal/store/repository/task"
+	"github.com/bornholm/oplet/internal/store/repository/upload"
+	"github.com/bornholm/oplet/internal/task"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const (
+	// uploadChunkSize is the size of the chunks sent by the browsers
+	uploadChunkSize = 8 << 20
+	// maxUploadChunkSize is the maximum size of a chunk accepted at once
+	maxUploadChunkSize = 64 << 20
+	// uploadOffsetHeader holds the offset of the chunk sent, and the number
+	// of bytes received in the responses
+	uploadOffsetHeader = "Upload-Offset"
+)
+
+var checksumPattern = regexp.MustCompile("^[a-f0-9]{64}$")
+
+type uploadStatus struct {
+	ID        string `json:"id"`
+	Input     string `json:"input"`
+	Filename  string `json:"filename"`
+	Size      int64  `json:"size"`
+	Received  int64  `json:"received"`
+	ChunkSize int64  `json:"chunkSize"`
+	Completed bool   `json:"completed"`
+	Checksum  string `json:"checksum,omitempty"`
+}
+
+// createUpload starts the resumable upload of a file input of the task
+func (h *Handler) createUpload(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := httpCtx.User(ctx)
+	if user == nil {
+		writeUploadError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+
+	storeTask, taskDef, err := h.getTaskDefinition(ctx, getTaskIDFromPath(r))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeUploadError(w, http.StatusNotFound, "task not found")
+			return
+		}
+		h.handleUploadError(w, r, errors.WithStack(err))
+		return
+	}
+
+	inputName := r.FormValue("input")
+	input := findFileInput(taskDef, inputName)
+	if input == nil {
+		writeUploadError(w, http.StatusBadRequest, "unknown file input")
+		return
+	}
+
+	size, err := strconv.ParseInt(r.FormValue("size"), 10, 64)
+	if err != nil || size < 0 {
+		writeUploadError(w, http.StatusBadRequest, "invalid size")
+		return
+	}
+
+	filename := r.FormValue("filename")
+	if filename == "" || len(filename) > 255 || strings.ContainsAny(filename, "/\\") {
+		writeUploadError(w, http.StatusBadRequest, "invalid filename")
+		return
+	}
+
+	// The content is checked again once received
+	if err := task.AssertFileInfo(ctx, input, filename, size); err != nil {
+		writeUploadError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	u := &store.Upload{
+		UserID:    user.ID,
+		TaskID:    storeTask.ID,
+		InputName: inputName,
+		Filename:  filename,
+		Size:      size,
+	}
+
+	if err := upload.NewRepository(h.store).Create(ctx, u); err != nil {
+		h.handleUploadError(w, r, errors.WithStack(err))
+		return
+	}
+
+	h.logger.InfoContext(ctx, "created upload",
+		"upload_id", u.ID,
+		"task_id", storeTask.ID,
+		"user_id", user.ID,
+		"input", inputName,
+		"size", size)
+
+	writeUploadStatus(w, http.StatusCreated, u)
+}
+
+// getUpload returns the progress of the upload, so that it can be resumed
+func (h *Handler) getUpload(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.findUpload(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(u.Received, 10))
+	writeUploadStatus(w, http.StatusOK, u)
+}
+
+// appendUploadChunk stores the chunk of the upload starting at the offset
+// given in the Upload-Offset header, which must be the number of bytes
+// received so far
+func (h *Handler) appendUploadChunk(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	u, ok := h.findUpload(w, r)
+	if !ok {
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get(uploadOffsetHeader), 10, 64)
+	if err != nil {
+		writeUploadError(w, http.StatusBadRequest, "invalid upload offset")
+		return
+	}
+
+	if u.IsCompleted() || offset != u.Received {
+		writeUploadConflict(w, u.Received)
+		return
+	}
+
+	size := r.ContentLength
+	if size <= 0 {
+		writeUploadError(w, http.StatusLengthRequired, "chunk size is required")
+		return
+	}
+
+	if size > maxUploadChunkSize || offset+size > u.Size {
+		writeUploadError(w, http.StatusRequestEntityTooLarge, "chunk too large")
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, size)
+
+	if err := h.fileStorage.StoreUploadChunk(ctx, u.ID, offset, body, size); err != nil {
+		if errors.Is(err, file.ErrChunkLength) {
+			writeUploadError(w, http.StatusBadRequest, "chunk shorter than its declared length")
+			return
+		}
+
+		// Interrupted transfers are resumed from the last chunk received
+		h.logger.WarnContext(ctx, "could not store upload chunk", "upload_id", u.ID, "offset", offset, "error", err)
+		writeUploadError(w, http.StatusInternalServerError, "could not store chunk")
+		return
+	}
+
+	advanced, err := upload.NewRepository(h.store).Advance(ctx, u.ID, offset, offset+size)
+	if err != nil {
+		h.handleUploadError(w, r, errors.WithStack(err))
+		return
+	}
+
+	if !advanced {
+		// Another request sent the same chunk in the meantime
+		h.writeCurrentUploadConflict(w, r, u)
+		return
+	}
+
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(offset+size, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// completeUpload assembles the received chunks into the input file once its
+// checksum, computed by the browser, and the constraints of its input are
+// verified
+func (h *Handler) completeUpload(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	u, ok := h.findUpload(w, r)
+	if !ok {
+		return
+	}
+
+	checksum := r.FormValue("checksum")
+	if !checksumPattern.MatchString(checksum) {
+		writeUploadError(w, http.StatusBadRequest, "invalid checksum")
+		return
+	}
+
+	if u.IsCompleted() {
+		if u.Checksum != checksum {
+			writeUploadError(w, http.StatusUnprocessableEntity, "checksum mismatch")
+			return
+		}
+
+		writeUploadStatus(w, http.StatusOK, u)
+		return
+	}
+
+	if u.Received != u.Size {
+		writeUploadConflict(w, u.Received)
+		return
+	}
+
+	_, taskDef, err := h.getTaskDefinition(ctx, u.TaskID)
+	if err != nil {
+		h.handleUploadError(w, r, errors.WithStack(err))
+		return
+	}
+
+	input := findFileInput(taskDef, u.InputName)
+	if input == nil {
+		writeUploadError(w, http.StatusBadRequest, "unknown file input")
+		return
+	}
+
+	storedFile, err := h.fileStorage.StoreUpload(ctx, u.ID, u.Filename, u.Size)
+	if err != nil {
+		h.handleUploadError(w, r, errors.WithStack(err))
+		return
+	}
+
+	uploadRepo := upload.NewRepository(h.store)
+
+	// The stored content is left to the collection of the unreferenced blobs
+	// if it is rejected
+	if storedFile.Checksum != checksum {
+		h.logger.WarnContext(ctx, "upload checksum mismatch, restarting it",
+			"upload_id", u.ID,
+			"expected", checksum,
+			"actual", storedFile.Checksum)
+
+		// Chunks left over would be assembled with the ones sent again
+		if err := h.fileStorage.DeleteUpload(ctx, u.ID); err != nil {
+			h.handleUploadError(w, r, errors.WithStack(err))
+			return
+		}
+
+		if err := uploadRepo.Reset(ctx, u.ID); err != nil {
+			h.handleUploadError(w, r, errors.WithStack(err))
+			return
+		}
+
+		w.Header().Set(uploadOffsetHeader, "0")
+		writeUploadError(w, http.StatusUnprocessableEntity, "checksum mismatch")
+		return
+	}
+
+	if err := h.assertFileConstraints(ctx, input, storedFile.Key); err != nil {
+		if err := uploadRepo.Delete(ctx, u.ID); err != nil {
+			h.handleUploadError(w, r, errors.WithStack(err))
+			return
+		}
+
+		if err := h.fileStorage.DeleteUpload(ctx, u.ID); err != nil {
+			h.logger.WarnContext(ctx, "could not delete upload chunks", "upload_id", u.ID, "error", err)
+		}
+
+		writeUploadError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	completed, err := uploadRepo.Complete(ctx, u.ID, storedFile.Checksum, storedFile.Key, storedFile.MimeType)
+	if err != nil {
+		h.handleUploadError(w, r, errors.WithStack(err))
+		return
+	}
+
+	if !completed {
+		h.writeCurrentUploadConflict(w, r, u)
+		return
+	}
+
+	h.logger.InfoContext(ctx, "completed upload",
+		"upload_id", u.ID,
+		"checksum", storedFile.Checksum,
+		"size", storedFile.Size,
+		"deduplicated", storedFile.Deduplicated)
+
+	u.Checksum = storedFile.Checksum
+	u.FilePath = storedFile.Key
+	u.MimeType = storedFile.MimeType
+	completedAt := time.Now()
+	u.CompletedAt = &completedAt
+
+	writeUploadStatus(w, http.StatusOK, u)
+}
+
+// cancelUpload deletes the upload and its received chunks
+func (h *Handler) cancelUpload(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	u, ok := h.findUpload(w, r)
+	if !ok {
+		return
+	}
+
+	if err := upload.NewRepository(h.store).Delete(ctx, u.ID); err != nil {
+		h.handleUploadError(w, r, errors.WithStack(err))
+		return
+	}
+
+	if err := h.fileStorage.DeleteUpload(ctx, u.ID); err != nil {
+		h.logger.WarnContext(ctx, "could not delete upload chunks", "upload_id", u.ID, "error", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// findUpload retrieves the upload of the path, writing an error response
+// if it does not exist or belongs to another user or task
+func (h *Handler) findUpload(w http.ResponseWriter, r *http.Request) (*store.Upload, bool) {
+	ctx := r.Context()
+	user := httpCtx.User(ctx)
+	if user == nil {
+		writeUploadError(w, http.StatusForbidden, "forbidden")
+		return nil, false
+	}
+
+	u, err := upload.NewRepository(h.store).GetByIDForUser(ctx, r.PathValue("uploadID"), user.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeUploadError(w, http.StatusNotFound, "upload not found")
+			return nil, false
+		}
+		h.handleUploadError(w, r, errors.WithStack(err))
+		return nil, false
+	}
+
+	if u.TaskID != getTaskIDFromPath(r) {
+		writeUploadError(w, http.StatusNotFound, "upload not found")
+		return nil, false
+	}
+
+	return u, true
+}
+
+func (h *Handler) writeCurrentUploadConflict(w http.ResponseWriter, r *http.Request, u *store.Upload) {
+	current, err := upload.NewRepository(h.store).GetByIDForUser(r.Context(), u.ID, u.UserID)
+	if err != nil {
+		h.handleUploadError(w, r, errors.WithStack(err))
+		return
+	}
+
+	writeUploadConflict(w, current.Received)
+}
+
+func (h *Handler) getTaskDefinition(ctx context.Context, taskID uint) (*store.Task, *task.Definition, error) {
+	storeTask, err := taskRepository.NewRepository(h.store).GetByID(ctx, taskID)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	taskDef, err := h.taskProvider.FetchTaskDefinition(ctx, storeTask.ImageRef)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to fetch task %d definition", taskID)
+	}
+
+	return storeTask, taskDef, nil
+}
+
+// assertFileConstraints checks the stored file against the constraints of
+// its input, as the form does for the files sent with it
+func (h *Handler) assertFileConstraints(ctx context.Context, input *task.Input, key string) error {
+	for _, constraint := range input.Constraints {
+		err := func() error {
+			reader, err := h.fileStorage.GetFile(ctx, key)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			defer reader.Close()
+
+			return constraint.AssertFile(ctx, input, reader)
+		}()
+		if err != nil && !errors.Is(err, task.ErrSkipConstraint) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h *Handler) handleUploadError(w http.ResponseWriter, r *http.Request, err error) {
+	h.logger.ErrorContext(r.Context(), "unexpected upload error", "error", err)
+	writeUploadError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+}
+
+func findFileInput(taskDef *task.Definition, name string) *task.Input {
+	for _, input := range taskDef.Inputs {
+		if input.Name == name && input.Type == task.TypeFile {
+			return input
+		}
+	}
+
+	return nil
+}
+
+func writeUploadStatus(w http.ResponseWriter, statusCode int, u *store.Upload) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(uploadStatus{
+		ID:        u.ID,
+		Input:     u.InputName,
+		Filename:  u.Filename,
+		Size:      u.Size,
+		Received:  u.Received,
+		ChunkSize: uploadChunkSize,
+		Completed: u.IsCompleted(),
+		Checksum:  u.Checksum,
+	})
+}
+
+func writeUploadConflict(w http.ResponseWriter, received int64) {
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(received, 10))
+	writeUploadError(w, http.StatusConflict, "unexpected upload offset")
+}
+
+func writeUploadError(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": message,
+	})
+}
@@ -0,0 +1,443 @@
+package janitor
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/bornholm/oplet/internal/dispatch"
+	"github.com/bornholm/oplet/internal/file"
+	"github.com/bornholm/oplet/internal/slogx"
+	"github.com/bornholm/oplet/internal/store"
+	"github.com/bornholm/oplet/internal/store/repository/blob"
+	"github.com/bornholm/oplet/internal/store/repository/execution"
+	"github.com/bornholm/oplet/internal/store/repository/signal"
+	taskRepo "github.com/bornholm/oplet/internal/store/repository/task"
+	"github.com/bornholm/oplet/internal/store/repository/upload"
+	"github.com/pkg/errors"
+)
+
+const (
+	// purgeBatchSize is the number of expired executions purged at once
+	purgeBatchSize = 100
+	// maxReportExecutions is the maximum number of expired executions
+	// reported for each task
+	maxReportExecutions = 1000
+	// orphanBatchSize is the number of execution directories checked at once
+	orphanBatchSize = 500
+	// blobBatchSize is the number of unreferenced blobs deleted at once
+	blobBatchSize = 100
+	// blobGracePeriod is the duration during which the blobs stored without
+	// reference are kept, the files referencing them being recorded once
+	// they are stored
+	blobGracePeriod = time.Hour
+	// uploadBatchSize is the number of expired uploads deleted at once
+	uploadBatchSize = 100
+	// uploadExpiry is the duration after which the uploads not updated
+	// anymore, abandoned or never used by an execution, are deleted
+	uploadExpiry = 24 * time.Hour
+	// signalExpiry is the duration after which the signals of the executions
+	// not raised anymore are deleted
+	signalExpiry = 24 * time.Hour
+)
+
+// Janitor periodically deletes the executions, with their logs and files,
+// which finished for longer than the retention of their task, the expired
+// uploads and the blobs not referenced by any file anymore
+type Janitor struct {
+	executionRepo *execution.Repository
+	taskRepo      *taskRepo.Repository
+	blobRepo      *blob.Repository
+	uploadRepo    *upload.Repository
+	signalRepo    *signal.Repository
+	fileStorage   *file.Storage
+	interval      time.Duration
+	retention     time.Duration
+	dryRun        bool
+	logger        *slog.Logger
+}
+
+// Report lists what the janitor would delete
+type Report struct {
+	// Retention applied to the tasks which do not override it
+	Retention time.Duration
+	DryRun    bool
+	Tasks     []*TaskReport
+	// Execution directories of the file storage without execution
+	OrphanedDirectories []uint
+	// Uploads abandoned or never used by an execution
+	ExpiredUploads int
+	// Blobs of the file storage without execution file
+	UnreferencedBlobs     int
+	UnreferencedBlobsSize int64
+	TotalExecutions       int
+	TotalSize             int64
+}
+
+type TaskReport struct {
+	Task *store.Task
+	// Retention applied to the task, zero if its executions are kept
+	Retention  time.Duration
+	Executions []*store.TaskExecution
+	// Size of the files of each execution
+	Sizes map[uint]int64
+	Size  int64
+	// True if the task has more expired executions than reported
+	Truncated bool
+}
+
+func (j *Janitor) Run(ctx context.Context) error {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		j.clean(ctx)
+
+		select {
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// Report returns the expired executions and orphaned directories which
+// would be deleted by the next cleanup
+func (j *Janitor) Report(ctx context.Context) (*Report, error) {
+	tasks, err := j.taskRepo.List(ctx, 0, 0)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	report := &Report{
+		Retention: j.retention,
+		DryRun:    j.dryRun,
+	}
+
+	now := time.Now()
+
+	for _, t := range tasks {
+		retention := j.taskRetention(t)
+		if retention == 0 {
+			continue
+		}
+
+		executions, err := j.executionRepo.ListExpired(ctx, t.ID, now.Add(-retention), maxReportExecutions+1)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if len(executions) == 0 {
+			continue
+		}
+
+		taskReport := &TaskReport{
+			Task:      t,
+			Retention: retention,
+		}
+
+		if len(executions) > maxReportExecutions {
+			executions = executions[:maxReportExecutions]
+			taskReport.Truncated = true
+		}
+
+		ids := make([]uint, 0, len(executions))
+		for _, e := range executions {
+			ids = append(ids, e.ID)
+		}
+
+		sizes, err := j.executionRepo.GetFilesSize(ctx, ids)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		for _, size := range sizes {
+			taskReport.Size += size
+		}
+
+		taskReport.Executions = executions
+		taskReport.Sizes = sizes
+
+		report.Tasks = append(report.Tasks, taskReport)
+		report.TotalExecutions += len(executions)
+		report.TotalSize += taskReport.Size
+	}
+
+	orphans, err := j.listOrphanedDirectories(ctx)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	report.OrphanedDirectories = orphans
+
+	uploads, err := j.uploadRepo.ListExpired(ctx, now.Add(-uploadExpiry), 0)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	report.ExpiredUploads = len(uploads)
+
+	blobs, err := j.blobRepo.ListUnreferenced(ctx, now.Add(-blobGracePeriod), 0)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for _, b := range blobs {
+		report.UnreferencedBlobs++
+		report.UnreferencedBlobsSize += b.Size
+	}
+
+	return report, nil
+}
+
+func (j *Janitor) clean(ctx context.Context) {
+	if j.dryRun {
+		j.logReport(ctx)
+		return
+	}
+
+	tasks, err := j.taskRepo.List(ctx, 0, 0)
+	if err != nil {
+		j.logger.ErrorContext(ctx, "could not list tasks", slogx.Error(err))
+		return
+	}
+
+	now := time.Now()
+
+	for _, t := range tasks {
+		retention := j.taskRetention(t)
+		if retention == 0 {
+			continue
+		}
+
+		j.purgeExpired(ctx, t, now.Add(-retention))
+	}
+
+	orphans, err := j.listOrphanedDirectories(ctx)
+	if err != nil {
+		j.logger.ErrorContext(ctx, "could not list orphaned execution directories", slogx.Error(err))
+	}
+
+	for _, id := range orphans {
+		if err := j.fileStorage.DeleteExecution(ctx, id); err != nil {
+			j.logger.ErrorContext(ctx, "could not delete orphaned execution directory", slogx.Error(err), "execution_id", id)
+		}
+	}
+
+	if err := j.executionRepo.CleanupOrphanedLogs(ctx); err != nil {
+		j.logger.ErrorContext(ctx, "could not delete orphaned execution logs", slogx.Error(err))
+	}
+
+	// Blobs of the expired uploads are collected right away, having been
+	// stored before their expiry
+	j.purgeUploads(ctx, now.Add(-uploadExpiry))
+
+	j.collectBlobs(ctx, now.Add(-blobGracePeriod))
+
+	j.purgeSignals(ctx, now.Add(-signalExpiry))
+}
+
+// purgeSignals deletes the signals of the log streams of the executions
+// which were not raised since the given time
+func (j *Janitor) purgeSignals(ctx context.Context, before time.Time) {
+	deleted, err := j.signalRepo.DeleteStale(ctx, dispatch.TopicSignal(dispatch.SignalExecutionLogs, ""), before)
+	if err != nil {
+		j.logger.ErrorContext(ctx, "could not delete stale signals", slogx.Error(err))
+		return
+	}
+
+	if deleted > 0 {
+		j.logger.InfoContext(ctx, "deleted stale signals", "count", deleted)
+	}
+}
+
+// purgeUploads deletes the uploads which were not updated since the given
+// time, with their received chunks
+func (j *Janitor) purgeUploads(ctx context.Context, before time.Time) {
+	for {
+		uploads, err := j.uploadRepo.ListExpired(ctx, before, uploadBatchSize)
+		if err != nil {
+			j.logger.ErrorContext(ctx, "could not list expired uploads", slogx.Error(err))
+			return
+		}
+
+		for _, u := range uploads {
+			if err := j.fileStorage.DeleteUpload(ctx, u.ID); err != nil {
+				// Stop there, the same upload would be listed again
+				j.logger.ErrorContext(ctx, "could not delete expired upload chunks", slogx.Error(err), "upload_id", u.ID)
+				return
+			}
+
+			if err := j.uploadRepo.Delete(ctx, u.ID); err != nil {
+				j.logger.ErrorContext(ctx, "could not delete expired upload", slogx.Error(err), "upload_id", u.ID)
+				return
+			}
+
+			j.logger.InfoContext(ctx, "deleted expired upload",
+				"upload_id", u.ID,
+				"user_id", u.UserID,
+				"received", u.Received,
+				"completed", u.IsCompleted())
+		}
+
+		if len(uploads) < uploadBatchSize {
+			return
+		}
+	}
+}
+
+// collectBlobs deletes the blobs stored before the given time which are not
+// referenced by any execution file anymore
+func (j *Janitor) collectBlobs(ctx context.Context, before time.Time) {
+	for {
+		blobs, err := j.blobRepo.ListUnreferenced(ctx, before, blobBatchSize)
+		if err != nil {
+			j.logger.ErrorContext(ctx, "could not list unreferenced blobs", slogx.Error(err))
+			return
+		}
+
+		for _, b := range blobs {
+			deleted, err := j.blobRepo.Delete(ctx, b.Checksum, before, func(ctx context.Context) error {
+				return j.fileStorage.DeleteBlob(ctx, b.Checksum)
+			})
+			if err != nil {
+				// Stop there, the same blob would be listed again
+				j.logger.ErrorContext(ctx, "could not delete unreferenced blob", slogx.Error(err), "checksum", b.Checksum)
+				return
+			}
+
+			if deleted {
+				j.logger.InfoContext(ctx, "deleted unreferenced blob", "checksum", b.Checksum, "size", b.Size)
+			}
+		}
+
+		if len(blobs) < blobBatchSize {
+			return
+		}
+	}
+}
+
+// purgeExpired deletes the executions of the task which finished before the
+// given time, batch by batch
+func (j *Janitor) purgeExpired(ctx context.Context, t *store.Task, before time.Time) {
+	for {
+		executions, err := j.executionRepo.ListExpired(ctx, t.ID, before, purgeBatchSize)
+		if err != nil {
+			j.logger.ErrorContext(ctx, "could not list expired executions", slogx.Error(err), "task_id", t.ID)
+			return
+		}
+
+		for _, e := range executions {
+			if err := j.executionRepo.Purge(ctx, e.ID); err != nil {
+				// Stop there, the same execution would be listed again
+				j.logger.ErrorContext(ctx, "could not purge expired execution", slogx.Error(err), "execution_id", e.ID)
+				return
+			}
+
+			// Files stored before deduplication are deleted once the execution
+			// is, a failure leaving an orphaned directory deleted by the next
+			// cleanup. Blobs are collected once they are not referenced anymore.
+			if err := j.fileStorage.DeleteExecution(ctx, e.ID); err != nil {
+				j.logger.WarnContext(ctx, "could not delete execution files", slogx.Error(err), "execution_id", e.ID)
+			}
+
+			j.logger.InfoContext(ctx, "purged expired execution",
+				"execution_id", e.ID,
+				"task_id", t.ID,
+				"status", e.Status)
+		}
+
+		if len(executions) < purgeBatchSize {
+			return
+		}
+	}
+}
+
+func (j *Janitor) logReport(ctx context.Context) {
+	report, err := j.Report(ctx)
+	if err != nil {
+		j.logger.ErrorContext(ctx, "could not report expired executions", slogx.Error(err))
+		return
+	}
+
+	for _, taskReport := range report.Tasks {
+		for _, e := range taskReport.Executions {
+			j.logger.InfoContext(ctx, "dry run, expired execution would be purged",
+				"execution_id", e.ID,
+				"task_id", taskReport.Task.ID,
+				"status", e.Status,
+				"size", taskReport.Sizes[e.ID])
+		}
+	}
+
+	for _, id := range report.OrphanedDirectories {
+		j.logger.InfoContext(ctx, "dry run, orphaned execution directory would be deleted", "execution_id", id)
+	}
+
+	if report.ExpiredUploads > 0 {
+		j.logger.InfoContext(ctx, "dry run, expired uploads would be deleted", "count", report.ExpiredUploads)
+	}
+
+	if report.UnreferencedBlobs > 0 {
+		j.logger.InfoContext(ctx, "dry run, unreferenced blobs would be deleted",
+			"count", report.UnreferencedBlobs,
+			"size", report.UnreferencedBlobsSize)
+	}
+}
+
+// listOrphanedDirectories returns the execution directories of the file
+// storage whose execution does not exist anymore
+func (j *Janitor) listOrphanedDirectories(ctx context.Context) ([]uint, error) {
+	ids, err := j.fileStorage.ListExecutions(ctx)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var orphans []uint
+
+	for batch := range slices.Chunk(ids, orphanBatchSize) {
+		existing, err := j.executionRepo.ListExisting(ctx, batch)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		for _, id := range batch {
+			if !slices.Contains(existing, id) {
+				orphans = append(orphans, id)
+			}
+		}
+	}
+
+	return orphans, nil
+}
+
+// taskRetention returns the retention of the executions of the task,
+// zero if they are kept
+func (j *Janitor) taskRetention(t *store.Task) time.Duration {
+	if t.Retention != nil {
+		return *t.Retention
+	}
+
+	return j.retention
+}
+
+func New(store *store.Store, funcs ...OptionFunc) (*Janitor, error) {
+	opts, err := NewOptions(funcs...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &Janitor{
+		executionRepo: execution.NewRepository(store),
+		taskRepo:      taskRepo.NewRepository(store),
+		blobRepo:      blob.NewRepository(store),
+		uploadRepo:    upload.NewRepository(store),
+		signalRepo:    signal.NewRepository(store),
+		fileStorage:   opts.FileStorage,
+		interval:      opts.Interval,
+		retention:     opts.Retention,
+		dryRun:        opts.DryRun,
+		logger:        opts.Logger.With("component", "janitor"),
+	}, nil
+}
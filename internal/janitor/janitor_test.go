@@ -0,0 +1,121 @@
+package janitor
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+
+	"github.com/bornholm/oplet/internal/file"
+	"github.com/bornholm/oplet/internal/slogx"
+	"github.com/bornholm/oplet/internal/store"
+	"github.com/bornholm/oplet/internal/store/repository/blob"
+)
+
+func TestJanitorPurge(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := gorm.Open(sqlite.Open(t.TempDir()+"/db.sqlite"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	st := store.New(db)
+
+	// Run the migrations before the records are created
+	if err := st.Ping(ctx); err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	fileStorage := file.NewStorage(file.NewFilesystemBackend(t.TempDir()), blob.NewRepository(st), slogx.NewTestLogger(t), file.WithTempDir(t.TempDir()))
+
+	janitor, err := New(st,
+		WithFileStorage(fileStorage),
+		WithRetention(24*time.Hour),
+		WithLogger(slogx.NewTestLogger(t)),
+	)
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	kept := time.Duration(0)
+
+	tasks := []*store.Task{
+		{ImageRef: "task:default"},
+		{ImageRef: "task:kept", Retention: &kept},
+	}
+	if err := db.Create(tasks).Error; err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	type execution struct {
+		name     string
+		task     int
+		finished time.Duration
+		pinned   bool
+		deleted  bool
+		purged   bool
+	}
+
+	executions := []execution{
+		{name: "expired", finished: -48 * time.Hour, purged: true},
+		{name: "soft deleted", finished: -48 * time.Hour, deleted: true, purged: true},
+		{name: "pinned", finished: -48 * time.Hour, pinned: true},
+		{name: "recent", finished: -time.Hour},
+		{name: "kept by its task", task: 1, finished: -48 * time.Hour},
+	}
+
+	ids := make([]uint, 0, len(executions))
+
+	for i, e := range executions {
+		finishedAt := time.Now().Add(e.finished)
+
+		record := &store.TaskExecution{
+			TaskID:      tasks[e.task].ID,
+			Status:      store.StatusSucceeded,
+			RunnerToken: fmt.Sprintf("token-%d", i),
+			Pinned:      e.pinned,
+			FinishedAt:  &finishedAt,
+			Logs:        []store.TaskExecutionLog{{Message: e.name}},
+		}
+
+		if err := db.Create(record).Error; err != nil {
+			t.Fatalf("%+v", errors.WithStack(err))
+		}
+
+		if e.deleted {
+			if err := db.Delete(record).Error; err != nil {
+				t.Fatalf("%+v", errors.WithStack(err))
+			}
+		}
+
+		ids = append(ids, record.ID)
+	}
+
+	janitor.clean(ctx)
+
+	existing, err := janitor.executionRepo.ListExisting(ctx, ids)
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	for i, e := range executions {
+		if e, g := !e.purged, slices.Contains(existing, ids[i]); e != g {
+			t.Errorf("execution '%s' exists: expected %v, got %v", executions[i].name, e, g)
+		}
+
+		var logs int64
+		if err := db.Unscoped().Model(&store.TaskExecutionLog{}).Where("execution_id = ?", ids[i]).Count(&logs).Error; err != nil {
+			t.Fatalf("%+v", errors.WithStack(err))
+		}
+
+		if e, g := !e.purged, logs > 0; e != g {
+			t.Errorf("execution '%s' logs exist: expected %v, got %v", executions[i].name, e, g)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package janitor
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/bornholm/oplet/internal/file"
+	"github.com/pkg/errors"
+)
+
+type Options struct {
+	Logger *slog.Logger
+	// Interval between two cleanups
+	Interval time.Duration
+	// Duration after which the finished executions are deleted, zero to keep
+	// them, unless overridden by their task
+	Retention time.Duration
+	// Only log the executions which would be deleted
+	DryRun bool
+	// Storage of the execution files
+	FileStorage *file.Storage
+}
+
+type OptionFunc func(opts *Options) error
+
+func NewOptions(funcs ...OptionFunc) (*Options, error) {
+	opts := &Options{
+		Logger:   slog.Default(),
+		Interval: time.Hour,
+	}
+
+	for _, fn := range funcs {
+		if err := fn(opts); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	if opts.Interval <= 0 {
+		return nil, errors.Errorf("invalid janitor interval '%s', must be positive", opts.Interval)
+	}
+
+	if opts.Retention < 0 {
+		return nil, errors.Errorf("invalid retention '%s', must be positive", opts.Retention)
+	}
+
+	if opts.FileStorage == nil {
+		return nil, errors.New("janitor file storage is required")
+	}
+
+	return opts, nil
+}
+
+func WithLogger(logger *slog.Logger) OptionFunc {
+	return func(opts *Options) error {
+		opts.Logger = logger
+		return nil
+	}
+}
+
+func WithInterval(interval time.Duration) OptionFunc {
+	return func(opts *Options) error {
+		opts.Interval = interval
+		return nil
+	}
+}
+
+func WithRetention(retention time.Duration) OptionFunc {
+	return func(opts *Options) error {
+		opts.Retention = retention
+		return nil
+	}
+}
+
+func WithDryRun(dryRun bool) OptionFunc {
+	return func(opts *Options) error {
+		opts.DryRun = dryRun
+		return nil
+	}
+}
+
+func WithFileStorage(storage *file.Storage) OptionFunc {
+	return func(opts *Options) error {
+		opts.FileStorage = storage
+		return nil
+	}
+}
@@ -0,0 +1,73 @@
+package reaper
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/bornholm/oplet/internal/dispatch"
+	"github.com/pkg/errors"
+)
+
+type Options struct {
+	Logger *slog.Logger
+	// Interval between two reclaims of the expired executions
+	Interval time.Duration
+	// Duration after which an execution whose runner did not renew its lease is reclaimed
+	LeaseDuration time.Duration
+	// Dispatcher notified of the requeued executions, if any
+	Dispatcher *dispatch.Dispatcher
+}
+
+type OptionFunc func(opts *Options) error
+
+func NewOptions(funcs ...OptionFunc) (*Options, error) {
+	opts := &Options{
+		Logger:        slog.Default(),
+		Interval:      time.Second * 30,
+		LeaseDuration: time.Minute * 2,
+	}
+
+	for _, fn := range funcs {
+		if err := fn(opts); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	if opts.Interval <= 0 {
+		return nil, errors.Errorf("invalid reaper interval '%s', must be positive", opts.Interval)
+	}
+
+	if opts.LeaseDuration <= 0 {
+		return nil, errors.Errorf("invalid lease duration '%s', must be positive", opts.LeaseDuration)
+	}
+
+	return opts, nil
+}
+
+func WithLogger(logger *slog.Logger) OptionFunc {
+	return func(opts *Options) error {
+		opts.Logger = logger
+		return nil
+	}
+}
+
+func WithInterval(interval time.Duration) OptionFunc {
+	return func(opts *Options) error {
+		opts.Interval = interval
+		return nil
+	}
+}
+
+func WithLeaseDuration(duration time.Duration) OptionFunc {
+	return func(opts *Options) error {
+		opts.LeaseDuration = duration
+		return nil
+	}
+}
+
+func WithDispatcher(dispatcher *dispatch.Dispatcher) OptionFunc {
+	return func(opts *Options) error {
+		opts.Dispatcher = dispatcher
+		return nil
+	}
+}
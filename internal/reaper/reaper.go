@@ -0,0 +1,99 @@
+package reaper
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/bornholm/oplet/internal/dispatch"
+	"github.com/bornholm/oplet/internal/slogx"
+	"github.com/bornholm/oplet/internal/store"
+	"github.com/bornholm/oplet/internal/store/repository/execution"
+	"github.com/pkg/errors"
+)
+
+// Reaper periodically reclaims the executions held by runners which
+// stopped renewing their lease
+type Reaper struct {
+	executionRepo *execution.Repository
+	interval      time.Duration
+	leaseDuration time.Duration
+	dispatcher    *dispatch.Dispatcher
+	logger        *slog.Logger
+}
+
+func (r *Reaper) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.reap(ctx)
+
+		select {
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Reaper) reap(ctx context.Context) {
+	reclaimed, err := r.executionRepo.ReclaimExpired(ctx, r.leaseDuration)
+	if err != nil {
+		r.logger.ErrorContext(ctx, "could not reclaim expired executions", slogx.Error(err))
+		return
+	}
+
+	requeued := false
+
+	for _, e := range reclaimed {
+		r.logger.WarnContext(ctx, "reclaimed execution with expired lease",
+			"execution_id", e.ID,
+			"task_id", e.TaskID,
+			"status", e.Status)
+
+		if e.Status == store.StatusPending {
+			requeued = true
+		}
+
+		if e.Status != store.StatusFailed {
+			continue
+		}
+
+		next, err := r.executionRepo.ScheduleRetry(ctx, e.ID)
+		if err != nil {
+			r.logger.ErrorContext(ctx, "could not schedule execution retry", slogx.Error(err), "execution_id", e.ID)
+			continue
+		}
+
+		if next != nil {
+			r.logger.InfoContext(ctx, "scheduled execution retry",
+				"execution_id", e.ID,
+				"retry_execution_id", next.ID,
+				"attempt", next.Attempt)
+
+			if r.dispatcher != nil {
+				r.dispatcher.NotifyAt(ctx, *next.ScheduledAt)
+			}
+		}
+	}
+
+	if requeued && r.dispatcher != nil {
+		r.dispatcher.Notify(ctx)
+	}
+}
+
+func New(store *store.Store, funcs ...OptionFunc) (*Reaper, error) {
+	opts, err := NewOptions(funcs...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &Reaper{
+		executionRepo: execution.NewRepository(store),
+		interval:      opts.Interval,
+		leaseDuration: opts.LeaseDuration,
+		dispatcher:    opts.Dispatcher,
+		logger:        opts.Logger.With("component", "reaper"),
+	}, nil
+}
@@ -0,0 +1,119 @@
+package reaper
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+
+	"github.com/bornholm/oplet/internal/slogx"
+	"github.com/bornholm/oplet/internal/store"
+)
+
+func TestReaperReap(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := gorm.Open(sqlite.Open(t.TempDir()+"/db.sqlite"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	st := store.New(db)
+
+	// Run the migrations before the records are created
+	if err := st.Ping(ctx); err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	reaper, err := New(st,
+		WithLeaseDuration(time.Minute),
+		WithLogger(slogx.NewTestLogger(t)),
+	)
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	task := &store.Task{ImageRef: "task:1"}
+	if err := db.Create(task).Error; err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	runner := &store.Runner{Name: "runner", Token: "runner-token"}
+	if err := db.Create(runner).Error; err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	type execution struct {
+		name           string
+		lease          time.Duration
+		requeue        bool
+		retries        int
+		expectedStatus store.TaskExecutionStatus
+		expectedRetry  bool
+	}
+
+	executions := []execution{
+		{name: "failed", lease: -time.Minute, expectedStatus: store.StatusFailed},
+		{name: "failed and retried", lease: -time.Minute, retries: 1, expectedStatus: store.StatusFailed, expectedRetry: true},
+		{name: "requeued", lease: -time.Minute, requeue: true, expectedStatus: store.StatusPending},
+		{name: "renewed", lease: time.Minute, retries: 1, expectedStatus: store.StatusRunning},
+	}
+
+	ids := make([]uint, 0, len(executions))
+
+	for i, e := range executions {
+		now := time.Now()
+		leaseExpiresAt := now.Add(e.lease)
+
+		record := &store.TaskExecution{
+			TaskID:         task.ID,
+			Status:         store.StatusRunning,
+			RunnerToken:    fmt.Sprintf("token-%d", i),
+			RunnerID:       &runner.ID,
+			LeaseExpiresAt: &leaseExpiresAt,
+			StartedAt:      &now,
+			Requeue:        e.requeue,
+			Retries:        e.retries,
+			RetryBackoff:   time.Minute,
+		}
+
+		if err := db.Create(record).Error; err != nil {
+			t.Fatalf("%+v", errors.WithStack(err))
+		}
+
+		ids = append(ids, record.ID)
+	}
+
+	// Reaping again does not reclaim nor retry the executions twice
+	reaper.reap(ctx)
+	reaper.reap(ctx)
+
+	for i, e := range executions {
+		var stored store.TaskExecution
+		if err := db.First(&stored, ids[i]).Error; err != nil {
+			t.Fatalf("%+v", errors.WithStack(err))
+		}
+
+		if e, g := e.expectedStatus, stored.Status; e != g {
+			t.Errorf("execution '%s' status: expected '%s', got '%s'", executions[i].name, e, g)
+		}
+
+		var retries int64
+		if err := db.Model(&store.TaskExecution{}).Where("first_attempt_id = ?", ids[i]).Count(&retries).Error; err != nil {
+			t.Fatalf("%+v", errors.WithStack(err))
+		}
+
+		expectedRetries := int64(0)
+		if e.expectedRetry {
+			expectedRetries = 1
+		}
+
+		if e, g := expectedRetries, retries; e != g {
+			t.Errorf("execution '%s' retries: expected %d, got %d", executions[i].name, e, g)
+		}
+	}
+}
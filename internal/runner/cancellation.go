@@ -0,0 +1,42 @@
+package runner
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// cancellation tracks the cancellation state of an execution handled by the runner
+type cancellation struct {
+	cancel    context.CancelFunc
+	requested atomic.Bool
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newCancellation(cancel context.CancelFunc) *cancellation {
+	return &cancellation{
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+}
+
+// Request cancels the execution context
+func (c *cancellation) Request() {
+	if c.requested.CompareAndSwap(false, true) {
+		c.cancel()
+	}
+}
+
+// Requested returns true if the cancellation of the execution was requested
+func (c *cancellation) Requested() bool {
+	return c.requested.Load()
+}
+
+// Done releases the execution context once the execution reached a final state
+func (c *cancellation) Done() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.cancel()
+	})
+}
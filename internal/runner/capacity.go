@@ -0,0 +1,79 @@
+package runner
+
+import (
+	"sync"
+
+	"github.com/bornholm/oplet/internal/task"
+)
+
+// capacity tracks the resources reserved by the executions handled by the runner
+type capacity struct {
+	maxCPUs   float64
+	maxMemory int64
+
+	mutex          sync.Mutex
+	reservedCPUs   float64
+	reservedMemory int64
+}
+
+func newCapacity(maxCPUs float64, maxMemory int64) *capacity {
+	return &capacity{
+		maxCPUs:   maxCPUs,
+		maxMemory: maxMemory,
+	}
+}
+
+// Max returns the resources shared by the executions
+func (c *capacity) Max() Capacity {
+	return Capacity{
+		CPUs:   c.maxCPUs,
+		Memory: c.maxMemory,
+	}
+}
+
+// Available returns the resources not reserved by running executions
+func (c *capacity) Available() Capacity {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return Capacity{
+		CPUs:   max(c.maxCPUs-c.reservedCPUs, 0),
+		Memory: max(c.maxMemory-c.reservedMemory, 0),
+	}
+}
+
+// Reserve reserves the resources requested by an execution and returns
+// the limits to apply to its container, capped at the runner maximums.
+// The returned function releases the reservation.
+func (c *capacity) Reserve(resources TaskResources) (task.Constraints, func()) {
+	cpus := min(resources.CPURequest, c.maxCPUs)
+	memory := min(resources.MemoryRequest, c.maxMemory)
+
+	c.mutex.Lock()
+	c.reservedCPUs += cpus
+	c.reservedMemory += memory
+	c.mutex.Unlock()
+
+	constraints := task.Constraints{
+		CPUs:      c.maxCPUs,
+		MaxMemory: c.maxMemory,
+	}
+
+	if resources.CPULimit > 0 {
+		constraints.CPUs = min(resources.CPULimit, c.maxCPUs)
+	}
+
+	if resources.MemoryLimit > 0 {
+		constraints.MaxMemory = min(resources.MemoryLimit, c.maxMemory)
+	}
+
+	release := func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		c.reservedCPUs -= cpus
+		c.reservedMemory -= memory
+	}
+
+	return constraints, release
+}
@@ -0,0 +1,60 @@
+package runner
+
+import (
+	"testing"
+)
+
+func TestCapacityReserve(t *testing.T) {
+	capacity := newCapacity(4, 8<<30)
+
+	if e, g := (Capacity{CPUs: 4, Memory: 8 << 30}), capacity.Available(); e != g {
+		t.Errorf("Available: expected %+v, got %+v", e, g)
+	}
+
+	constraints, release := capacity.Reserve(TaskResources{CPURequest: 1, MemoryRequest: 2 << 30, CPULimit: 2})
+
+	if e, g := float64(2), constraints.CPUs; e != g {
+		t.Errorf("constraints.CPUs: expected %v, got %v", e, g)
+	}
+
+	if e, g := int64(8<<30), constraints.MaxMemory; e != g {
+		t.Errorf("constraints.MaxMemory: expected %v, got %v", e, g)
+	}
+
+	if e, g := (Capacity{CPUs: 3, Memory: 6 << 30}), capacity.Available(); e != g {
+		t.Errorf("Available after a reservation: expected %+v, got %+v", e, g)
+	}
+
+	// Requests and limits over the runner maximums are capped, the
+	// remaining capacity never being negative
+	overConstraints, releaseOver := capacity.Reserve(TaskResources{CPURequest: 16, MemoryRequest: 32 << 30, CPULimit: 16, MemoryLimit: 32 << 30})
+
+	if e, g := float64(4), overConstraints.CPUs; e != g {
+		t.Errorf("overConstraints.CPUs: expected %v, got %v", e, g)
+	}
+
+	if e, g := int64(8<<30), overConstraints.MaxMemory; e != g {
+		t.Errorf("overConstraints.MaxMemory: expected %v, got %v", e, g)
+	}
+
+	if e, g := (Capacity{}), capacity.Available(); e != g {
+		t.Errorf("Available over capacity: expected %+v, got %+v", e, g)
+	}
+
+	// Reservations are released once the executions completed
+	releaseOver()
+
+	if e, g := (Capacity{CPUs: 3, Memory: 6 << 30}), capacity.Available(); e != g {
+		t.Errorf("Available after a release: expected %+v, got %+v", e, g)
+	}
+
+	release()
+
+	if e, g := (Capacity{CPUs: 4, Memory: 8 << 30}), capacity.Available(); e != g {
+		t.Errorf("Available after all releases: expected %+v, got %+v", e, g)
+	}
+
+	if e, g := (Capacity{CPUs: 4, Memory: 8 << 30}), capacity.Max(); e != g {
+		t.Errorf("Max: expected %+v, got %+v", e, g)
+	}
+}
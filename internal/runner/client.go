@@ -3,19 +3,42 @@ package runner
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"io"
-	"mime/multipart"
 	"net/http"
 	"net/url"
-	"path/filepath"
 	"strconv"
 	"time"
 
 	"github.com/bornholm/oplet/internal/store"
+	"github.com/bornholm/oplet/internal/task"
 	"github.com/pkg/errors"
 )
 
+// ErrLeaseLost is returned when the server reclaimed the execution from the runner
+var ErrLeaseLost = errors.New("execution lease lost")
+
+// ErrChecksumMismatch is returned when the content of a transferred file does
+// not match its checksum
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+const (
+	// uploadOffsetHeader holds the offset of the output chunks sent, and the
+	// number of bytes received by the server in its responses
+	uploadOffsetHeader = "Upload-Offset"
+	// checksumHeader holds the hex encoded SHA-256 checksum of the input
+	// files downloaded
+	checksumHeader = "X-Checksum-Sha256"
+	// runnerTokenHeader holds the token received when the execution targeted
+	// by the request was claimed
+	runnerTokenHeader = "X-Runner-Token"
+	// defaultOutputChunkSize is the size of the output chunks sent when the
+	// server does not set it
+	defaultOutputChunkSize = 8 << 20
+)
+
 // TaskRequestResponse represents the response from the task request endpoint
 type TaskRequestResponse struct {
 	ExecutionID     uint              `json:"execution_id"`
@@ -26,24 +49,56 @@ type TaskRequestResponse struct {
 	RunnerToken     string            `json:"runner_token"`
 	InputsDir       string            `json:"inputs_dir"`
 	OutputsDir      string            `json:"outputs_dir"`
-	CreatedAt       time.Time         `json:"created_at"`
+	Timeout         int64             `json:"timeout,omitempty"`
+	Network         string            `json:"network,omitempty"`
+	// Comma separated relaxations of the runner security profile
+	SecurityRelaxations string        `json:"security_relaxations,omitempty"`
+	Resources           TaskResources `json:"resources"`
+	CreatedAt           time.Time     `json:"created_at"`
+}
+
+// TaskResources represents the resources requested by an execution and its limits
+type TaskResources struct {
+	CPURequest    float64 `json:"cpu_request,omitempty"`
+	CPULimit      float64 `json:"cpu_limit,omitempty"`
+	MemoryRequest int64   `json:"memory_request,omitempty"`
+	MemoryLimit   int64   `json:"memory_limit,omitempty"`
+}
+
+// Capacity represents the remaining capacity of the runner sent along with a task request
+type Capacity struct {
+	CPUs   float64 `json:"cpus"`
+	Memory int64   `json:"memory"`
 }
 
 // TaskStatusRequest represents a task status update request
 type TaskStatusRequest struct {
+	Status          store.TaskExecutionStatus `json:"status"`
+	ContainerID     string                    `json:"container_id,omitempty"`
+	ExitCode        *int                      `json:"exit_code,omitempty"`
+	Error           string                    `json:"error,omitempty"`
+	ErrorType       string                    `json:"error_type,omitempty"`
+	CPULimit        *float64                  `json:"cpu_limit,omitempty"`
+	MemoryLimit     *int64                    `json:"memory_limit,omitempty"`
+	SecurityProfile *string                   `json:"security_profile,omitempty"`
+	StartedAt       *time.Time                `json:"started_at,omitempty"`
+	FinishedAt      *time.Time                `json:"finished_at,omitempty"`
+	Timestamp       int64                     `json:"timestamp"`
+}
+
+// TaskStatusResponse represents the response from the task status endpoints
+type TaskStatusResponse struct {
+	ExecutionID uint                      `json:"execution_id"`
 	Status      store.TaskExecutionStatus `json:"status"`
-	ContainerID string                    `json:"container_id,omitempty"`
-	ExitCode    *int                      `json:"exit_code,omitempty"`
-	Error       string                    `json:"error,omitempty"`
-	StartedAt   *time.Time                `json:"started_at,omitempty"`
-	FinishedAt  *time.Time                `json:"finished_at,omitempty"`
-	Timestamp   int64                     `json:"timestamp"`
+	Canceled    bool                      `json:"canceled"`
+	UpdatedAt   time.Time                 `json:"updated_at"`
 }
 
 // LogEntry represents a log entry
 type LogEntry struct {
 	Timestamp int64  `json:"timestamp"`
 	Source    string `json:"source"`
+	Stream    string `json:"stream,omitempty"`
 	Message   string `json:"message"`
 	Clock     uint   `json:"clock"`
 }
@@ -53,6 +108,52 @@ type TaskTraceRequest struct {
 	Logs []LogEntry `json:"logs"`
 }
 
+// TaskProgressRequest represents the progress reported by a task
+type TaskProgressRequest struct {
+	Percent float64 `json:"percent"`
+	Step    string  `json:"step,omitempty"`
+}
+
+// TaskResultRequest represents the result written by a task
+type TaskResultRequest struct {
+	task.Result
+}
+
+// OutputUploadRequest represents the creation of the upload of an output file
+type OutputUploadRequest struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// OutputUploadCompleteRequest represents the completion of the upload of an
+// output file, along with the checksum of its whole content
+type OutputUploadCompleteRequest struct {
+	Checksum string `json:"checksum"`
+}
+
+// OutputUpload represents the upload of an output file
+type OutputUpload struct {
+	ID          string `json:"id"`
+	ExecutionID uint   `json:"execution_id"`
+	Path        string `json:"path"`
+	Size        int64  `json:"size"`
+	Received    int64  `json:"received"`
+	ChunkSize   int64  `json:"chunk_size"`
+	Completed   bool   `json:"completed"`
+	Checksum    string `json:"checksum,omitempty"`
+}
+
+// HeartbeatRequest represents the runner state sent along with a heartbeat
+type HeartbeatRequest struct {
+	Slots     int      `json:"slots"`
+	UsedSlots int      `json:"used_slots"`
+	Tags      []string `json:"tags,omitempty"`
+	// Executions running on the runner, whose leases are renewed
+	Executions []uint `json:"executions,omitempty"`
+	// Maximum capacity of the runner, running executions included
+	MaxCapacity *Capacity `json:"max_capacity,omitempty"`
+}
+
 // HeartbeatResponse represents the response from heartbeat endpoint
 type HeartbeatResponse struct {
 	ID          uint      `json:"id"`
@@ -60,11 +161,73 @@ type HeartbeatResponse struct {
 	ContactedAt time.Time `json:"contacted_at"`
 }
 
+// SessionMessageType is the type of a message exchanged over the session
+type SessionMessageType string
+
+const (
+	SessionMessageHeartbeat   SessionMessageType = "heartbeat"
+	SessionMessageRequestTask SessionMessageType = "request-task"
+	SessionMessageStatus      SessionMessageType = "status"
+	SessionMessageTrace       SessionMessageType = "trace"
+	SessionMessageProgress    SessionMessageType = "progress"
+	SessionMessageReply       SessionMessageType = "reply"
+
+	SessionMessageCancel SessionMessageType = "cancel"
+	SessionMessageDrain  SessionMessageType = "drain"
+	SessionMessageResume SessionMessageType = "resume"
+	SessionMessagePull   SessionMessageType = "pull"
+)
+
+// SessionMessage represents a message exchanged over the session, replies
+// carrying the identifier of the call they answer
+type SessionMessage struct {
+	Type    SessionMessageType `json:"type"`
+	ID      uint64             `json:"id,omitempty"`
+	Payload json.RawMessage    `json:"payload,omitempty"`
+	Error   *SessionError      `json:"error,omitempty"`
+}
+
+// SessionError represents the error of a call made over the session
+type SessionError struct {
+	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
+}
+
+// SessionStatusRequest represents a task status update sent over the session
+type SessionStatusRequest struct {
+	ExecutionID uint   `json:"execution_id"`
+	RunnerToken string `json:"runner_token"`
+	TaskStatusRequest
+}
+
+// SessionTraceRequest represents a log submission sent over the session
+type SessionTraceRequest struct {
+	ExecutionID uint   `json:"execution_id"`
+	RunnerToken string `json:"runner_token"`
+	TaskTraceRequest
+}
+
+// SessionProgressRequest represents a task progress sent over the session
+type SessionProgressRequest struct {
+	ExecutionID uint   `json:"execution_id"`
+	RunnerToken string `json:"runner_token"`
+	TaskProgressRequest
+}
+
+// SessionCommand represents a command sent by the server over the session
+type SessionCommand struct {
+	Type        SessionMessageType `json:"-"`
+	ExecutionID uint               `json:"execution_id,omitempty"`
+	ImageRef    string             `json:"image_ref,omitempty"`
+}
+
 // Client provides methods to interact with the runner API
 type Client struct {
 	serverURL *url.URL
 	authToken string
 	http      *http.Client
+	// Session carrying the calls while it is connected, if opened
+	session *Session
 }
 
 // NewClient creates a new runner API client
@@ -85,11 +248,40 @@ func NewClient(serverURL, authToken string, httpClient *http.Client) (*Client, e
 	}, nil
 }
 
+// callSession carries the call over the session if it is connected, returning
+// false if the call must fall back to the REST API
+func (c *Client) callSession(ctx context.Context, msgType SessionMessageType, payload any, result any) (bool, error) {
+	if c.session == nil {
+		return false, nil
+	}
+
+	err := c.session.call(ctx, msgType, payload, result)
+	if errors.Is(err, errSessionUnavailable) {
+		return false, nil
+	}
+
+	return true, err
+}
+
 // SendHeartbeat sends a heartbeat to the server
-func (c *Client) SendHeartbeat(ctx context.Context) (*HeartbeatResponse, error) {
+func (c *Client) SendHeartbeat(ctx context.Context, heartbeatReq HeartbeatRequest) (*HeartbeatResponse, error) {
+	var sessionResp HeartbeatResponse
+	if handled, err := c.callSession(ctx, SessionMessageHeartbeat, heartbeatReq, &sessionResp); handled {
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		return &sessionResp, nil
+	}
+
 	heartbeatURL := c.serverURL.JoinPath("/runner/heartbeat")
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, heartbeatURL.String(), nil)
+	reqBody, err := json.Marshal(heartbeatReq)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal heartbeat request")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, heartbeatURL.String(), bytes.NewReader(reqBody))
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -115,10 +307,24 @@ func (c *Client) SendHeartbeat(ctx context.Context) (*HeartbeatResponse, error)
 	return &heartbeatResp, nil
 }
 
-// RequestTask requests the next available task from the server
-func (c *Client) RequestTask(ctx context.Context) (*TaskRequestResponse, error) {
+// RequestTask requests the next available task fitting the given capacity from the server
+func (c *Client) RequestTask(ctx context.Context, capacity Capacity) (*TaskRequestResponse, error) {
+	var sessionResp *TaskRequestResponse
+	if handled, err := c.callSession(ctx, SessionMessageRequestTask, capacity, &sessionResp); handled {
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		return sessionResp, nil
+	}
+
 	requestTaskURL := c.serverURL.JoinPath("/runner/request-task")
 
+	query := requestTaskURL.Query()
+	query.Set("cpus", strconv.FormatFloat(capacity.CPUs, 'f', -1, 64))
+	query.Set("memory", strconv.FormatInt(capacity.Memory, 10))
+	requestTaskURL.RawQuery = query.Encode()
+
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestTaskURL.String(), nil)
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -149,38 +355,107 @@ func (c *Client) RequestTask(ctx context.Context) (*TaskRequestResponse, error)
 }
 
 // UpdateTaskStatus updates the status of a task execution
-func (c *Client) UpdateTaskStatus(ctx context.Context, taskID uint, statusReq TaskStatusRequest) error {
-	statusURL := c.serverURL.JoinPath("/runner/tasks/" + strconv.FormatUint(uint64(taskID), 10) + "/status")
+func (c *Client) UpdateTaskStatus(ctx context.Context, executionID uint, runnerToken string, statusReq TaskStatusRequest) (*TaskStatusResponse, error) {
+	sessionReq := SessionStatusRequest{
+		ExecutionID:       executionID,
+		RunnerToken:       runnerToken,
+		TaskStatusRequest: statusReq,
+	}
+
+	var sessionResp TaskStatusResponse
+	if handled, err := c.callSession(ctx, SessionMessageStatus, sessionReq, &sessionResp); handled {
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		return &sessionResp, nil
+	}
+
+	statusURL := c.serverURL.JoinPath("/runner/executions/" + strconv.FormatUint(uint64(executionID), 10) + "/status")
 
 	reqBody, err := json.Marshal(statusReq)
 	if err != nil {
-		return errors.Wrap(err, "failed to marshal status request")
+		return nil, errors.Wrap(err, "failed to marshal status request")
 	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, statusURL.String(), bytes.NewReader(reqBody))
 	if err != nil {
-		return errors.WithStack(err)
+		return nil, errors.WithStack(err)
 	}
 
 	req.Header.Set("Authorization", "Bearer "+c.authToken)
+	req.Header.Set(runnerTokenHeader, runnerToken)
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := c.http.Do(req)
 	if err != nil {
-		return errors.WithStack(err)
+		return nil, errors.WithStack(err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusConflict {
+		return nil, errors.WithStack(ErrLeaseLost)
+	}
+
 	if resp.StatusCode != http.StatusOK {
-		return errors.Errorf("status update failed with status %d", resp.StatusCode)
+		return nil, errors.Errorf("status update failed with status %d", resp.StatusCode)
 	}
 
-	return nil
+	var statusResp TaskStatusResponse
+	if err := json.NewDecoder(resp.Body).Decode(&statusResp); err != nil {
+		return nil, errors.Wrap(err, "failed to decode status response")
+	}
+
+	return &statusResp, nil
+}
+
+// GetTaskStatus retrieves the current status of a task execution
+func (c *Client) GetTaskStatus(ctx context.Context, executionID uint, runnerToken string) (*TaskStatusResponse, error) {
+	statusURL := c.serverURL.JoinPath("/runner/executions/" + strconv.FormatUint(uint64(executionID), 10) + "/status")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, statusURL.String(), nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.authToken)
+	req.Header.Set(runnerTokenHeader, runnerToken)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusConflict {
+		return nil, errors.WithStack(ErrLeaseLost)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("status retrieval failed with status %d", resp.StatusCode)
+	}
+
+	var statusResp TaskStatusResponse
+	if err := json.NewDecoder(resp.Body).Decode(&statusResp); err != nil {
+		return nil, errors.Wrap(err, "failed to decode status response")
+	}
+
+	return &statusResp, nil
 }
 
 // SubmitLogs submits execution logs to the server
-func (c *Client) SubmitLogs(ctx context.Context, taskID uint, logs []LogEntry) error {
-	traceURL := c.serverURL.JoinPath("/runner/tasks/" + strconv.FormatUint(uint64(taskID), 10) + "/trace")
+func (c *Client) SubmitLogs(ctx context.Context, executionID uint, runnerToken string, logs []LogEntry) error {
+	sessionReq := SessionTraceRequest{
+		ExecutionID:      executionID,
+		RunnerToken:      runnerToken,
+		TaskTraceRequest: TaskTraceRequest{Logs: logs},
+	}
+
+	if handled, err := c.callSession(ctx, SessionMessageTrace, sessionReq, nil); handled {
+		return errors.WithStack(err)
+	}
+
+	traceURL := c.serverURL.JoinPath("/runner/executions/" + strconv.FormatUint(uint64(executionID), 10) + "/trace")
 
 	traceReq := TaskTraceRequest{Logs: logs}
 	reqBody, err := json.Marshal(traceReq)
@@ -194,6 +469,7 @@ func (c *Client) SubmitLogs(ctx context.Context, taskID uint, logs []LogEntry) e
 	}
 
 	req.Header.Set("Authorization", "Bearer "+c.authToken)
+	req.Header.Set(runnerTokenHeader, runnerToken)
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := c.http.Do(req)
@@ -209,9 +485,54 @@ func (c *Client) SubmitLogs(ctx context.Context, taskID uint, logs []LogEntry) e
 	return nil
 }
 
-// ListInputFiles lists available input files for a task
-func (c *Client) ListInputFiles(ctx context.Context, taskID uint) ([]map[string]interface{}, error) {
-	inputsURL := c.serverURL.JoinPath("/runner/tasks/" + strconv.FormatUint(uint64(taskID), 10) + "/inputs")
+// UpdateTaskProgress reports the progress of the task of an execution
+func (c *Client) UpdateTaskProgress(ctx context.Context, executionID uint, runnerToken string, progressReq TaskProgressRequest) error {
+	sessionReq := SessionProgressRequest{
+		ExecutionID:         executionID,
+		RunnerToken:         runnerToken,
+		TaskProgressRequest: progressReq,
+	}
+
+	if handled, err := c.callSession(ctx, SessionMessageProgress, sessionReq, nil); handled {
+		return errors.WithStack(err)
+	}
+
+	progressURL := c.serverURL.JoinPath("/runner/executions/" + strconv.FormatUint(uint64(executionID), 10) + "/progress")
+
+	reqBody, err := json.Marshal(progressReq)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal progress request")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, progressURL.String(), bytes.NewReader(reqBody))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.authToken)
+	req.Header.Set(runnerTokenHeader, runnerToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusConflict {
+		return errors.WithStack(ErrLeaseLost)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("progress update failed with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// ListInputFiles lists available input files for a task execution
+func (c *Client) ListInputFiles(ctx context.Context, executionID uint, runnerToken string) ([]map[string]interface{}, error) {
+	inputsURL := c.serverURL.JoinPath("/runner/executions/" + strconv.FormatUint(uint64(executionID), 10) + "/inputs")
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, inputsURL.String(), nil)
 	if err != nil {
@@ -219,6 +540,7 @@ func (c *Client) ListInputFiles(ctx context.Context, taskID uint) ([]map[string]
 	}
 
 	req.Header.Set("Authorization", "Bearer "+c.authToken)
+	req.Header.Set(runnerTokenHeader, runnerToken)
 
 	resp, err := c.http.Do(req)
 	if err != nil {
@@ -226,8 +548,12 @@ func (c *Client) ListInputFiles(ctx context.Context, taskID uint) ([]map[string]
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusConflict {
+		return nil, errors.WithStack(ErrLeaseLost)
+	}
+
 	if resp.StatusCode != http.StatusOK {
-		return nil, errors.Errorf("list input files failed with status %d", resp.StatusCode)
+		return nil, newStatusError("list input files", resp.StatusCode)
 	}
 
 	var response struct {
@@ -242,9 +568,11 @@ func (c *Client) ListInputFiles(ctx context.Context, taskID uint) ([]map[string]
 	return response.Files, nil
 }
 
-// DownloadInputFile downloads a specific input file for a task
-func (c *Client) DownloadInputFile(ctx context.Context, taskID uint, filename string) (io.ReadCloser, error) {
-	inputsURL := c.serverURL.JoinPath("/runner/tasks/" + strconv.FormatUint(uint64(taskID), 10) + "/inputs")
+// DownloadInputFile downloads a specific input file for a task execution
+// into the given writer, verifying its size and its checksum when the server
+// sends them, and returns the number of bytes written
+func (c *Client) DownloadInputFile(ctx context.Context, executionID uint, runnerToken string, filename string, w io.Writer) (int64, error) {
+	inputsURL := c.serverURL.JoinPath("/runner/executions/" + strconv.FormatUint(uint64(executionID), 10) + "/inputs")
 
 	// Add filename as query parameter
 	query := inputsURL.Query()
@@ -253,53 +581,63 @@ func (c *Client) DownloadInputFile(ctx context.Context, taskID uint, filename st
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, inputsURL.String(), nil)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return 0, errors.WithStack(err)
 	}
 
 	req.Header.Set("Authorization", "Bearer "+c.authToken)
+	req.Header.Set(runnerTokenHeader, runnerToken)
 
 	resp, err := c.http.Do(req)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return 0, errors.WithStack(err)
 	}
+	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		resp.Body.Close()
-		return nil, errors.Errorf("download input file failed with status %d", resp.StatusCode)
+	if resp.StatusCode == http.StatusConflict {
+		return 0, errors.WithStack(ErrLeaseLost)
 	}
 
-	return resp.Body, nil
-}
+	if resp.StatusCode != http.StatusOK {
+		return 0, newStatusError("download input file", resp.StatusCode)
+	}
 
-// UploadOutputFiles uploads output files for a task
-func (c *Client) UploadOutputFiles(ctx context.Context, taskID uint, files map[string]io.Reader) error {
-	outputsURL := c.serverURL.JoinPath("/runner/tasks/" + strconv.FormatUint(uint64(taskID), 10) + "/outputs")
+	hasher := sha256.New()
 
-	var buf bytes.Buffer
-	writer := multipart.NewWriter(&buf)
+	size, err := io.Copy(io.MultiWriter(w, hasher), resp.Body)
+	if err != nil {
+		return size, errors.Wrapf(err, "failed to download input file %s", filename)
+	}
 
-	for fieldName, fileReader := range files {
-		part, err := writer.CreateFormFile(fieldName, filepath.Base(fieldName))
-		if err != nil {
-			return errors.Wrapf(err, "failed to create form file for %s", fieldName)
-		}
+	if resp.ContentLength >= 0 && size != resp.ContentLength {
+		return size, errors.Errorf("input file %s is truncated: expected %d bytes, got %d", filename, resp.ContentLength, size)
+	}
 
-		if _, err := io.Copy(part, fileReader); err != nil {
-			return errors.Wrapf(err, "failed to copy file content for %s", fieldName)
+	if expected := resp.Header.Get(checksumHeader); expected != "" {
+		if actual := hex.EncodeToString(hasher.Sum(nil)); actual != expected {
+			return size, errors.Wrapf(ErrChecksumMismatch, "input file %s: expected %s, got %s", filename, expected, actual)
 		}
 	}
 
-	if err := writer.Close(); err != nil {
-		return errors.Wrap(err, "failed to close multipart writer")
+	return size, nil
+}
+
+// SubmitTaskResult sends the result written by the task of an execution
+func (c *Client) SubmitTaskResult(ctx context.Context, executionID uint, runnerToken string, resultReq TaskResultRequest) error {
+	resultURL := c.serverURL.JoinPath("/runner/executions/" + strconv.FormatUint(uint64(executionID), 10) + "/result")
+
+	reqBody, err := json.Marshal(resultReq)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal result request")
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, outputsURL.String(), &buf)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, resultURL.String(), bytes.NewReader(reqBody))
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
 	req.Header.Set("Authorization", "Bearer "+c.authToken)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set(runnerTokenHeader, runnerToken)
+	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := c.http.Do(req)
 	if err != nil {
@@ -307,9 +645,211 @@ func (c *Client) UploadOutputFiles(ctx context.Context, taskID uint, files map[s
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusConflict {
+		return errors.WithStack(ErrLeaseLost)
+	}
+
 	if resp.StatusCode != http.StatusOK {
-		return errors.Errorf("output file upload failed with status %d", resp.StatusCode)
+		return errors.Errorf("result submission failed with status %d", resp.StatusCode)
 	}
 
 	return nil
 }
+
+// UploadOutputFile uploads an output file of a task execution, by path
+// relative to the outputs directory, in chunks read from the given content.
+// Each request is retried, the upload being resumed from the offset received
+// by the server, and the file is recorded by the server once the given
+// checksum is verified.
+func (c *Client) UploadOutputFile(ctx context.Context, executionID uint, runnerToken string, path string, content io.ReaderAt, size int64, checksum string) error {
+	var upload *OutputUpload
+	err := retryTransfer(ctx, func() error {
+		created, err := c.createOutputUpload(ctx, executionID, runnerToken, OutputUploadRequest{Path: path, Size: size})
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		upload = created
+
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to create upload of output file %s", path)
+	}
+
+	chunkSize := upload.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultOutputChunkSize
+	}
+
+	offset := upload.Received
+
+	// Completions are attempted again from the offset sent by the server
+	// when it misses chunks or rejects the checksum of the content
+	for range transferAttempts {
+		for offset < size {
+			length := min(chunkSize, size-offset)
+
+			err := retryTransfer(ctx, func() error {
+				received, err := c.uploadOutputChunk(ctx, executionID, runnerToken, upload.ID, offset, io.NewSectionReader(content, offset, length), length)
+				if err != nil {
+					return errors.WithStack(err)
+				}
+
+				offset = received
+
+				return nil
+			})
+			if err != nil {
+				return errors.Wrapf(err, "failed to upload chunk of output file %s at offset %d", path, offset)
+			}
+		}
+
+		var completed bool
+		err := retryTransfer(ctx, func() error {
+			var err error
+			completed, offset, err = c.completeOutputUpload(ctx, executionID, runnerToken, upload.ID, checksum)
+			return errors.WithStack(err)
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed to complete upload of output file %s", path)
+		}
+
+		if completed {
+			return nil
+		}
+	}
+
+	return errors.Errorf("failed to complete upload of output file %s after %d attempts", path, transferAttempts)
+}
+
+func (c *Client) createOutputUpload(ctx context.Context, executionID uint, runnerToken string, uploadReq OutputUploadRequest) (*OutputUpload, error) {
+	uploadsURL := c.serverURL.JoinPath("/runner/executions/" + strconv.FormatUint(uint64(executionID), 10) + "/outputs/uploads")
+
+	reqBody, err := json.Marshal(uploadReq)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal output upload request")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uploadsURL.String(), bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.authToken)
+	req.Header.Set(runnerTokenHeader, runnerToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusConflict {
+		return nil, errors.WithStack(ErrLeaseLost)
+	}
+
+	if resp.StatusCode != http.StatusCreated {
+		return nil, newStatusError("output upload creation", resp.StatusCode)
+	}
+
+	var upload OutputUpload
+	if err := json.NewDecoder(resp.Body).Decode(&upload); err != nil {
+		return nil, errors.Wrap(err, "failed to decode output upload response")
+	}
+
+	return &upload, nil
+}
+
+// uploadOutputChunk sends the chunk of the upload starting at the given
+// offset and returns the offset of the next chunk, the one sent by the
+// server if it expected another chunk
+func (c *Client) uploadOutputChunk(ctx context.Context, executionID uint, runnerToken string, uploadID string, offset int64, chunk io.Reader, length int64) (int64, error) {
+	uploadURL := c.serverURL.JoinPath("/runner/executions/"+strconv.FormatUint(uint64(executionID), 10)+"/outputs/uploads", uploadID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, uploadURL.String(), chunk)
+	if err != nil {
+		return offset, errors.WithStack(err)
+	}
+
+	req.ContentLength = length
+	req.Header.Set("Authorization", "Bearer "+c.authToken)
+	req.Header.Set(runnerTokenHeader, runnerToken)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(uploadOffsetHeader, strconv.FormatInt(offset, 10))
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return offset, errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		return offset + length, nil
+
+	case http.StatusConflict:
+		received, ok := parseUploadOffset(resp)
+		if !ok {
+			return offset, errors.WithStack(ErrLeaseLost)
+		}
+
+		return received, nil
+
+	default:
+		return offset, newStatusError("output chunk upload", resp.StatusCode)
+	}
+}
+
+// completeOutputUpload asks the server to record the uploaded file, returning
+// false and the offset from which the upload must be resumed if the server
+// misses chunks or rejected the checksum of the received content
+func (c *Client) completeOutputUpload(ctx context.Context, executionID uint, runnerToken string, uploadID string, checksum string) (bool, int64, error) {
+	completeURL := c.serverURL.JoinPath("/runner/executions/"+strconv.FormatUint(uint64(executionID), 10)+"/outputs/uploads", uploadID, "complete")
+
+	reqBody, err := json.Marshal(OutputUploadCompleteRequest{Checksum: checksum})
+	if err != nil {
+		return false, 0, errors.Wrap(err, "failed to marshal output upload completion request")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, completeURL.String(), bytes.NewReader(reqBody))
+	if err != nil {
+		return false, 0, errors.WithStack(err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.authToken)
+	req.Header.Set(runnerTokenHeader, runnerToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return false, 0, errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return true, 0, nil
+	}
+
+	if resp.StatusCode == http.StatusConflict || resp.StatusCode == http.StatusUnprocessableEntity {
+		if received, ok := parseUploadOffset(resp); ok {
+			return false, received, nil
+		}
+	}
+
+	if resp.StatusCode == http.StatusConflict {
+		return false, 0, errors.WithStack(ErrLeaseLost)
+	}
+
+	return false, 0, newStatusError("output upload completion", resp.StatusCode)
+}
+
+func parseUploadOffset(resp *http.Response) (int64, bool) {
+	received, err := strconv.ParseInt(resp.Header.Get(uploadOffsetHeader), 10, 64)
+	if err != nil || received < 0 {
+		return 0, false
+	}
+
+	return received, true
+}
@@ -0,0 +1,87 @@
+package runner
+
+import (
+	"log/slog"
+
+	"github.com/bornholm/oplet/internal/task"
+	"github.com/bornholm/oplet/internal/task/docker"
+	"github.com/bornholm/oplet/internal/task/kubernetes"
+	"github.com/bornholm/oplet/internal/task/process"
+	"github.com/bornholm/oplet/internal/task/wasm"
+	"github.com/pkg/errors"
+)
+
+const (
+	ExecutorDocker     = "docker"
+	ExecutorProcess    = "process"
+	ExecutorKubernetes = "kubernetes"
+	ExecutorWasm       = "wasm"
+)
+
+// ExecutorConfig holds the settings of the executors
+type ExecutorConfig struct {
+	// JSON file mapping image references to the commands run
+	// by the process executor
+	ProcessCommands string
+	// Kubeconfig file used by the kubernetes executor, the in-cluster
+	// configuration if empty, and namespace of its jobs
+	KubeConfig    string
+	KubeNamespace string
+	// Directory keeping the modules compiled by the wasm executor
+	// across restarts, in memory only if empty
+	WasmCacheDir string
+}
+
+// NewExecutor creates the executor with the given name
+func NewExecutor(name string, conf ExecutorConfig, logger *slog.Logger) (task.Executor, error) {
+	switch name {
+	case ExecutorDocker:
+		executor, err := docker.NewExecutor(logger)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not create docker executor")
+		}
+
+		return executor, nil
+
+	case ExecutorProcess:
+		if conf.ProcessCommands == "" {
+			return nil, errors.New("process executor requires a commands file")
+		}
+
+		commands, err := process.LoadCommands(conf.ProcessCommands)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not load process executor commands")
+		}
+
+		return process.NewExecutor(logger, commands), nil
+
+	case ExecutorKubernetes:
+		client, config, err := kubernetes.NewClient(conf.KubeConfig)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		options := []kubernetes.OptionFunc{}
+		if conf.KubeNamespace != "" {
+			options = append(options, kubernetes.WithNamespace(conf.KubeNamespace))
+		}
+
+		executor, err := kubernetes.NewExecutor(client, config, logger, options...)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not create kubernetes executor")
+		}
+
+		return executor, nil
+
+	case ExecutorWasm:
+		executor, err := wasm.NewExecutor(logger, wasm.WithCacheDir(conf.WasmCacheDir))
+		if err != nil {
+			return nil, errors.Wrap(err, "could not create wasm executor")
+		}
+
+		return executor, nil
+
+	default:
+		return nil, errors.Errorf("unknown executor '%s', must be '%s', '%s', '%s' or '%s'", name, ExecutorDocker, ExecutorProcess, ExecutorKubernetes, ExecutorWasm)
+	}
+}
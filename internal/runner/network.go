@@ -0,0 +1,51 @@
+package runner
+
+import (
+	"slices"
+
+	"github.com/bornholm/oplet/internal/task"
+	"github.com/pkg/errors"
+)
+
+var ErrNetworkNotAllowed = errors.New("network not allowed")
+
+// networkPolicy decides which networks the executions handled by the runner
+// may be attached to
+type networkPolicy struct {
+	allowed        []string
+	egressNetwork  string
+	egressProxyURL string
+}
+
+func newNetworkPolicy(allowed []string, egressNetwork, egressProxyURL string) *networkPolicy {
+	return &networkPolicy{
+		allowed:        allowed,
+		egressNetwork:  egressNetwork,
+		egressProxyURL: egressProxyURL,
+	}
+}
+
+// Resolve returns the network to attach the container of an execution to.
+// An empty requested network resolves to the bridge network.
+func (p *networkPolicy) Resolve(requested string) (task.Network, error) {
+	if requested == "" {
+		requested = task.NetworkBridge
+	}
+
+	if !slices.Contains(p.allowed, requested) {
+		return task.Network{}, errors.Wrapf(ErrNetworkNotAllowed, "network '%s' is not allowed on this runner", requested)
+	}
+
+	if requested != task.NetworkEgress {
+		return task.Network{Name: requested}, nil
+	}
+
+	if p.egressNetwork == "" || p.egressProxyURL == "" {
+		return task.Network{}, errors.Wrap(ErrNetworkNotAllowed, "egress network is not configured on this runner")
+	}
+
+	return task.Network{
+		Name:     p.egressNetwork,
+		ProxyURL: p.egressProxyURL,
+	}, nil
+}
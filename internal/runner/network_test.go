@@ -0,0 +1,65 @@
+package runner
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/bornholm/oplet/internal/task"
+)
+
+func TestNetworkPolicyResolve(t *testing.T) {
+	type testCase struct {
+		name        string
+		policy      *networkPolicy
+		requested   string
+		expected    task.Network
+		expectedErr error
+	}
+
+	defaultPolicy := newNetworkPolicy([]string{task.NetworkNone, task.NetworkBridge}, "", "")
+	egressPolicy := newNetworkPolicy([]string{task.NetworkNone, task.NetworkEgress}, "oplet-egress", "http://proxy:3128")
+
+	testCases := []testCase{
+		{name: "default network", policy: defaultPolicy, requested: "", expected: task.Network{Name: task.NetworkBridge}},
+		{name: "allowed bridge network", policy: defaultPolicy, requested: task.NetworkBridge, expected: task.Network{Name: task.NetworkBridge}},
+		{name: "allowed none network", policy: defaultPolicy, requested: task.NetworkNone, expected: task.Network{Name: task.NetworkNone}},
+		{name: "egress not allowed", policy: defaultPolicy, requested: task.NetworkEgress, expectedErr: ErrNetworkNotAllowed},
+		{name: "unknown network", policy: defaultPolicy, requested: "host", expectedErr: ErrNetworkNotAllowed},
+		{name: "default network not allowed", policy: egressPolicy, requested: "", expectedErr: ErrNetworkNotAllowed},
+		{name: "egress through the proxy", policy: egressPolicy, requested: task.NetworkEgress, expected: task.Network{Name: "oplet-egress", ProxyURL: "http://proxy:3128"}},
+		{
+			name:        "egress without network",
+			policy:      newNetworkPolicy([]string{task.NetworkEgress}, "", "http://proxy:3128"),
+			requested:   task.NetworkEgress,
+			expectedErr: ErrNetworkNotAllowed,
+		},
+		{
+			name:        "egress without proxy",
+			policy:      newNetworkPolicy([]string{task.NetworkEgress}, "oplet-egress", ""),
+			requested:   task.NetworkEgress,
+			expectedErr: ErrNetworkNotAllowed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			network, err := tc.policy.Resolve(tc.requested)
+
+			if tc.expectedErr != nil {
+				if !errors.Is(err, tc.expectedErr) {
+					t.Errorf("expected error '%v', got '%v'", tc.expectedErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("%+v", errors.WithStack(err))
+			}
+
+			if e, g := tc.expected, network; e != g {
+				t.Errorf("Resolve(%q): expected %+v, got %+v", tc.requested, e, g)
+			}
+		})
+	}
+}
@@ -3,18 +3,53 @@ package runner
 import (
 	"log/slog"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/bornholm/oplet/internal/task"
 	"github.com/bornholm/oplet/internal/task/docker"
 	"github.com/pkg/errors"
+	"github.com/shirou/gopsutil/v4/cpu"
+	"github.com/shirou/gopsutil/v4/mem"
 )
 
 type Options struct {
-	HTTPClient        *http.Client
-	Executor          task.Executor
-	Logger            *slog.Logger
-	ExecutionInterval time.Duration
+	HTTPClient                *http.Client
+	Executor                  task.Executor
+	Logger                    *slog.Logger
+	ExecutionInterval         time.Duration
+	CancellationCheckInterval time.Duration
+	// Maximum number of concurrent executions
+	Slots int
+	// Maximum duration to wait for running executions on shutdown
+	DrainTimeout time.Duration
+	// Tags declared to the server, used to route executions
+	Tags []string
+	// Maximum resources shared by the executions, used to cap their
+	// limits and to claim only the executions fitting the remaining capacity
+	MaxCPUs   float64
+	MaxMemory int64
+	// Fuel granted to each execution, consumed by the WASM executions
+	// on each function call, unlimited if zero
+	MaxFuel uint64
+	// Network modes or names the executions may be attached to
+	AllowedNetworks []string
+	// Network attached to the executions requesting the egress mode, and
+	// URL of the filtering proxy through which their outbound traffic goes
+	EgressNetwork  string
+	EgressProxyURL string
+	// Security profile applied to the executions, relaxed for each
+	// execution by the relaxations approved for its task. None by default,
+	// see task.HardenedSecurityProfile
+	SecurityProfile task.SecurityProfile
+	// Directory where the input and output files of the executions are
+	// written while they are transferred, the system temporary directory
+	// if empty
+	TempDir string
+	// Open a websocket session with the server, carrying the calls of the
+	// runner and the commands of the server, the REST API being used while
+	// it is down
+	Session bool
 }
 
 type OptionFunc func(opts *Options) error
@@ -25,11 +60,27 @@ func NewOptions(funcs ...OptionFunc) (*Options, error) {
 		return nil, errors.Wrap(err, "could not create default docker executor")
 	}
 
+	totalCPUs, err := cpu.Counts(true)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve host cpus stats")
+	}
+
+	memory, err := mem.VirtualMemory()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve host memory stats")
+	}
+
 	opts := &Options{
-		HTTPClient:        http.DefaultClient,
-		Executor:          dockerExecutor,
-		Logger:            slog.Default(),
-		ExecutionInterval: time.Second * 5,
+		HTTPClient:                http.DefaultClient,
+		Executor:                  dockerExecutor,
+		Logger:                    slog.Default(),
+		ExecutionInterval:         time.Second * 5,
+		CancellationCheckInterval: time.Second * 5,
+		Slots:                     1,
+		DrainTimeout:              time.Minute * 5,
+		MaxCPUs:                   float64(totalCPUs),
+		MaxMemory:                 int64(memory.Total),
+		AllowedNetworks:           []string{task.NetworkNone, task.NetworkBridge, task.NetworkEgress},
 	}
 
 	for _, fn := range funcs {
@@ -38,5 +89,114 @@ func NewOptions(funcs ...OptionFunc) (*Options, error) {
 		}
 	}
 
+	if opts.Slots < 1 {
+		return nil, errors.Errorf("invalid number of slots '%d', must be at least 1", opts.Slots)
+	}
+
+	if opts.MaxCPUs <= 0 {
+		return nil, errors.Errorf("invalid maximum number of cpus '%v', must be positive", opts.MaxCPUs)
+	}
+
+	if opts.MaxMemory <= 0 {
+		return nil, errors.Errorf("invalid maximum memory '%d', must be positive", opts.MaxMemory)
+	}
+
+	for _, network := range opts.AllowedNetworks {
+		if !task.ValidNetwork(network) {
+			return nil, errors.Errorf("invalid allowed network '%s'", network)
+		}
+	}
+
+	if opts.SecurityProfile.SeccompProfile != "" {
+		if _, err := os.Stat(opts.SecurityProfile.SeccompProfile); err != nil {
+			return nil, errors.Wrapf(err, "could not find seccomp profile '%s'", opts.SecurityProfile.SeccompProfile)
+		}
+	}
+
 	return opts, nil
 }
+
+func WithSlots(slots int) OptionFunc {
+	return func(opts *Options) error {
+		opts.Slots = slots
+		return nil
+	}
+}
+
+func WithTags(tags ...string) OptionFunc {
+	return func(opts *Options) error {
+		opts.Tags = tags
+		return nil
+	}
+}
+
+func WithDrainTimeout(timeout time.Duration) OptionFunc {
+	return func(opts *Options) error {
+		opts.DrainTimeout = timeout
+		return nil
+	}
+}
+
+func WithMaxFuel(fuel uint64) OptionFunc {
+	return func(opts *Options) error {
+		opts.MaxFuel = fuel
+		return nil
+	}
+}
+
+func WithMaxCPUs(cpus float64) OptionFunc {
+	return func(opts *Options) error {
+		opts.MaxCPUs = cpus
+		return nil
+	}
+}
+
+func WithMaxMemory(memory int64) OptionFunc {
+	return func(opts *Options) error {
+		opts.MaxMemory = memory
+		return nil
+	}
+}
+
+func WithAllowedNetworks(networks ...string) OptionFunc {
+	return func(opts *Options) error {
+		opts.AllowedNetworks = networks
+		return nil
+	}
+}
+
+func WithEgress(network string, proxyURL string) OptionFunc {
+	return func(opts *Options) error {
+		opts.EgressNetwork = network
+		opts.EgressProxyURL = proxyURL
+		return nil
+	}
+}
+
+func WithSecurityProfile(profile task.SecurityProfile) OptionFunc {
+	return func(opts *Options) error {
+		opts.SecurityProfile = profile
+		return nil
+	}
+}
+
+func WithTempDir(dir string) OptionFunc {
+	return func(opts *Options) error {
+		opts.TempDir = dir
+		return nil
+	}
+}
+
+func WithSession(enabled bool) OptionFunc {
+	return func(opts *Options) error {
+		opts.Session = enabled
+		return nil
+	}
+}
+
+func WithExecutor(executor task.Executor) OptionFunc {
+	return func(opts *Options) error {
+		opts.Executor = executor
+		return nil
+	}
+}
@@ -0,0 +1,44 @@
+package runner
+
+import "testing"
+
+func TestOutputPath(t *testing.T) {
+	type testCase struct {
+		name          string
+		expectedPath  string
+		expectedFound bool
+	}
+
+	testCases := []testCase{
+		{name: "outputs/report.pdf", expectedPath: "report.pdf", expectedFound: true},
+		{name: "outputs/reports/2024/summary.pdf", expectedPath: "reports/2024/summary.pdf", expectedFound: true},
+		{name: "outputs/.oplet/result.json", expectedPath: ".oplet/result.json", expectedFound: true},
+		{name: "outputs", expectedFound: false},
+		{name: "outputs/", expectedFound: false},
+		{name: "report.pdf", expectedFound: false},
+		{name: "inputs/secret.txt", expectedFound: false},
+		{name: "outputs/../inputs/secret.txt", expectedFound: false},
+		{name: "outputs/../../etc/passwd", expectedFound: false},
+		{name: "outputs/reports/../../../etc/passwd", expectedFound: false},
+		{name: "../outputs/report.pdf", expectedFound: false},
+		{name: "/outputs/report.pdf", expectedFound: false},
+		{name: "./outputs/report.pdf", expectedFound: false},
+		{name: "outputs//etc/passwd", expectedFound: false},
+		{name: "outputs/./report.pdf", expectedFound: false},
+		{name: "outputs/reports\\..\\..\\report.pdf", expectedFound: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path, found := outputPath(tc.name)
+
+			if e, g := tc.expectedFound, found; e != g {
+				t.Fatalf("outputPath(%q): expected found %v, got %v", tc.name, e, g)
+			}
+
+			if e, g := tc.expectedPath, path; e != g {
+				t.Errorf("outputPath(%q): expected '%s', got '%s'", tc.name, e, g)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package runner
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bornholm/oplet/internal/slogx"
+	"github.com/bornholm/oplet/internal/task"
+)
+
+// progressInterval is the interval at which the progress file is read and
+// the last progress reported by a task is sent to the server
+const progressInterval = 2 * time.Second
+
+// progressReporter keeps the last progress reported by the task of an
+// execution until it is sent to the server
+type progressReporter struct {
+	mutex   sync.Mutex
+	pending *task.Progress
+	sent    *task.Progress
+}
+
+// Report records the progress reported by the task, replacing the one
+// not sent yet
+func (p *progressReporter) Report(progress task.Progress) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.sent != nil && *p.sent == progress {
+		p.pending = nil
+		return
+	}
+
+	p.pending = &progress
+}
+
+// flush sends the pending progress to the server
+func (p *progressReporter) flush(ctx context.Context, r *Runner, taskResp *TaskRequestResponse) {
+	p.mutex.Lock()
+	pending := p.pending
+	p.pending = nil
+	p.mutex.Unlock()
+
+	if pending == nil {
+		return
+	}
+
+	err := r.client.UpdateTaskProgress(ctx, taskResp.ExecutionID, taskResp.RunnerToken, TaskProgressRequest{
+		Percent: pending.Percent,
+		Step:    pending.Step,
+	})
+	if err != nil {
+		r.logger.WarnContext(ctx, "failed to update task progress",
+			"execution_id", taskResp.ExecutionID,
+			slogx.Error(err))
+		return
+	}
+
+	p.mutex.Lock()
+	p.sent = pending
+	p.mutex.Unlock()
+}
+
+// watchProgress sends the progress reported by the task to the server until
+// the execution reached a final state, reading the progress file of the task
+// if the executor supports it
+func (r *Runner) watchProgress(ctx context.Context, taskResp *TaskRequestResponse, containerID string, progress *progressReporter, cancellation *cancellation) {
+	reader, _ := r.executor.(task.ProgressReader)
+
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+
+	// Content of the progress file at the last read, the progress being only
+	// reported when it changed so that it does not override the progress
+	// reported in the logs
+	var lastContent string
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-cancellation.done:
+			progress.flush(ctx, r, taskResp)
+			return
+		case <-ticker.C:
+		}
+
+		if reader != nil {
+			content, err := reader.ReadProgress(ctx, containerID)
+			if err != nil {
+				r.logger.DebugContext(ctx, "could not read task progress file",
+					"execution_id", taskResp.ExecutionID,
+					slogx.Error(err))
+			} else if content != lastContent {
+				lastContent = content
+
+				if parsed, err := task.ParseProgress(content); err == nil {
+					progress.Report(parsed)
+				}
+			}
+		}
+
+		progress.flush(ctx, r, taskResp)
+	}
+}
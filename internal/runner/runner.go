@@ -2,13 +2,19 @@ package runner
 
 import (
 	"archive/tar"
-	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
-	"path/filepath"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bornholm/oplet/internal/slogx"
@@ -17,86 +23,364 @@ import (
 	"github.com/pkg/errors"
 )
 
+const (
+	// logsMinBackoff and logsMaxBackoff bound the delay between two attempts
+	// to open the log stream of a container
+	logsMinBackoff = time.Second
+	logsMaxBackoff = 30 * time.Second
+	// followersTimeout is the maximum duration to wait for the last logs
+	// of an execution once it reached a final state
+	followersTimeout = 30 * time.Second
+	// defaultFinalStateTimeout is the duration after which an execution
+	// without timeout is expected to have reached a final state
+	defaultFinalStateTimeout = 24 * time.Hour
+	// finalStateGracePeriod is added to the timeout of the executions to
+	// cover the pull of their image and the transfer of their files
+	finalStateGracePeriod = time.Hour
+	// killGracePeriod is the duration to wait for an execution to stop
+	// once killed for not reaching a final state
+	killGracePeriod = time.Minute
+)
+
 type Runner struct {
-	serverURL         *url.URL
-	authToken         string
-	http              *http.Client
-	executor          task.Executor
-	logger            *slog.Logger
-	executionInterval time.Duration
-	client            *Client
+	serverURL                 *url.URL
+	authToken                 string
+	http                      *http.Client
+	executor                  task.Executor
+	logger                    *slog.Logger
+	executionInterval         time.Duration
+	cancellationCheckInterval time.Duration
+	drainTimeout              time.Duration
+	slots                     int
+	usedSlots                 atomic.Int32
+	tags                      []string
+	capacity                  *capacity
+	maxFuel                   uint64
+	networkPolicy             *networkPolicy
+	securityProfile           task.SecurityProfile
+	tempDir                   string
+	client                    *Client
+	session                   *Session
+	cancellations             sync.Map
+	draining                  atomic.Bool
+	resumed                   chan struct{}
 }
 
 func (r *Runner) Run(ctx context.Context) error {
 	// Send initial heartbeat
-	if _, err := r.client.SendHeartbeat(ctx); err != nil {
+	if err := r.sendHeartbeat(ctx); err != nil {
 		r.logger.WarnContext(ctx, "failed to send initial heartbeat", slogx.Error(err))
 	}
 
+	// Executions are detached from the runner context so that they can
+	// complete when the runner is stopped
+	executionsCtx, cancelExecutions := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelExecutions()
+
+	if r.session != nil {
+		go r.session.Run(ctx)
+	}
+
+	var executions sync.WaitGroup
+
+	claimDone := make(chan struct{})
+	go func() {
+		defer close(claimDone)
+		r.claimTasks(ctx, executionsCtx, &executions)
+	}()
+
 	// Start heartbeat ticker
 	heartbeatTicker := time.NewTicker(30 * time.Second)
 	defer heartbeatTicker.Stop()
 
-	// Start main execution loop
-	executionTicker := time.NewTicker(r.executionInterval)
-	defer executionTicker.Stop()
-
 	for {
 		select {
 		case <-ctx.Done():
+			<-claimDone
+			r.drain(context.WithoutCancel(ctx), cancelExecutions, &executions)
 			return errors.WithStack(ctx.Err())
 		case <-heartbeatTicker.C:
-			if _, err := r.client.SendHeartbeat(ctx); err != nil {
+			if err := r.sendHeartbeat(ctx); err != nil {
 				r.logger.WarnContext(ctx, "failed to send heartbeat", slogx.Error(err))
 			}
-		case <-executionTicker.C:
-			if err := r.executeNextTask(ctx); err != nil {
+		}
+	}
+}
+
+// claimTasks requests new tasks from the server as long as execution slots are available
+func (r *Runner) claimTasks(ctx context.Context, executionsCtx context.Context, executions *sync.WaitGroup) {
+	slots := make(chan struct{}, r.slots)
+
+	for {
+		// Drained runners wait to be resumed before claiming new executions
+		for r.draining.Load() {
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.resumed:
+			}
+		}
+
+		// Wait for a free slot
+		select {
+		case <-ctx.Done():
+			return
+		case slots <- struct{}{}:
+		}
+
+		taskResp, err := r.client.RequestTask(ctx, r.capacity.Available())
+		if err != nil {
+			<-slots
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			r.logger.ErrorContext(ctx, "failed to request task", slogx.Error(err))
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(r.executionInterval):
+			}
+
+			continue
+		}
+
+		if taskResp == nil {
+			// No tasks available
+			<-slots
+			continue
+		}
+
+		r.logger.InfoContext(ctx, "received task assignment",
+			"execution_id", taskResp.ExecutionID,
+			"task_id", taskResp.TaskID,
+			"image_ref", taskResp.ImageRef)
+
+		constraints, release := r.capacity.Reserve(taskResp.Resources)
+		constraints.MaxFuel = r.maxFuel
+
+		r.usedSlots.Add(1)
+		executions.Add(1)
+
+		go func() {
+			defer func() {
+				release()
+				r.usedSlots.Add(-1)
+				<-slots
+				executions.Done()
+			}()
+
+			if err := r.executeTask(executionsCtx, taskResp, constraints); err != nil {
 				r.logger.ErrorContext(ctx, "task execution error", slogx.Error(err))
-				// Continue running even if a task fails
+			}
+		}()
+	}
+}
+
+// drain waits for the running executions to complete, killing them
+// if the drain timeout is reached
+func (r *Runner) drain(ctx context.Context, cancelExecutions context.CancelFunc, executions *sync.WaitGroup) {
+	drained := make(chan struct{})
+	go func() {
+		executions.Wait()
+		close(drained)
+	}()
+
+	if usedSlots := r.usedSlots.Load(); usedSlots > 0 {
+		r.logger.InfoContext(ctx, "waiting for running executions to complete", "used_slots", usedSlots)
+	}
+
+	timeout := time.NewTimer(r.drainTimeout)
+	defer timeout.Stop()
+
+	heartbeatTicker := time.NewTicker(30 * time.Second)
+	defer heartbeatTicker.Stop()
+
+	for {
+		select {
+		case <-drained:
+			if err := r.sendHeartbeat(ctx); err != nil {
+				r.logger.WarnContext(ctx, "failed to send heartbeat", slogx.Error(err))
+			}
+			return
+		case <-timeout.C:
+			r.logger.WarnContext(ctx, "drain timeout reached, killing running executions", "used_slots", r.usedSlots.Load())
+			cancelExecutions()
+		case <-heartbeatTicker.C:
+			if err := r.sendHeartbeat(ctx); err != nil {
+				r.logger.WarnContext(ctx, "failed to send heartbeat", slogx.Error(err))
 			}
 		}
 	}
 }
 
-func (r *Runner) executeNextTask(ctx context.Context) error {
-	// Request next task
-	taskResp, err := r.client.RequestTask(ctx)
+// handleCommand handles the commands sent by the server over the session
+func (r *Runner) handleCommand(ctx context.Context, command SessionCommand) {
+	switch command.Type {
+	case SessionMessageCancel:
+		value, exists := r.cancellations.Load(command.ExecutionID)
+		if !exists {
+			return
+		}
+
+		r.logger.InfoContext(ctx, "task execution canceled",
+			"execution_id", command.ExecutionID)
+
+		value.(*cancellation).Request()
+
+	case SessionMessageDrain:
+		if r.draining.CompareAndSwap(false, true) {
+			r.logger.InfoContext(ctx, "runner drained, new executions will not be claimed", "used_slots", r.usedSlots.Load())
+		}
+
+	case SessionMessageResume:
+		if r.draining.CompareAndSwap(true, false) {
+			r.logger.InfoContext(ctx, "runner resumed")
+
+			select {
+			case r.resumed <- struct{}{}:
+			default:
+			}
+		}
+
+	case SessionMessagePull:
+		puller, ok := r.executor.(task.ImagePuller)
+		if !ok {
+			r.logger.WarnContext(ctx, "executor can not pull images ahead of executions", "image_ref", command.ImageRef)
+			return
+		}
+
+		if err := puller.PullImage(ctx, command.ImageRef); err != nil {
+			r.logger.ErrorContext(ctx, "failed to pull image", "image_ref", command.ImageRef, slogx.Error(err))
+		}
+
+	default:
+		r.logger.WarnContext(ctx, "ignoring unknown command", "type", command.Type)
+	}
+}
+
+// sendHeartbeat sends the runner state along with the executions it is
+// running, the server renewing only their leases
+func (r *Runner) sendHeartbeat(ctx context.Context) error {
+	executions := make([]uint, 0)
+	r.cancellations.Range(func(key, value any) bool {
+		executions = append(executions, key.(uint))
+		return true
+	})
+
+	maxCapacity := r.capacity.Max()
+
+	_, err := r.client.SendHeartbeat(ctx, HeartbeatRequest{
+		Slots:       r.slots,
+		UsedSlots:   int(r.usedSlots.Load()),
+		Tags:        r.tags,
+		Executions:  executions,
+		MaxCapacity: &maxCapacity,
+	})
 	if err != nil {
-		return errors.Wrap(err, "failed to request task")
+		return errors.WithStack(err)
 	}
 
-	if taskResp == nil {
-		// No tasks available
+	return nil
+}
+
+// executeTask executes the given task and waits for its completion.
+// API calls are detached from ctx so that final states can still be reported
+// once the execution is killed.
+func (r *Runner) executeTask(ctx context.Context, taskResp *TaskRequestResponse, constraints task.Constraints) error {
+	execCtx, cancel := context.WithCancel(ctx)
+	cancellation := newCancellation(cancel)
+
+	r.cancellations.Store(taskResp.ExecutionID, cancellation)
+	defer r.cancellations.Delete(taskResp.ExecutionID)
+
+	ctx = context.WithoutCancel(ctx)
+
+	// The goroutines following the execution, such as the log streaming,
+	// are stopped once it reached a final state
+	followCtx, stopFollowing := context.WithCancel(ctx)
+	defer stopFollowing()
+
+	var followers sync.WaitGroup
+
+	go r.watchCancellation(ctx, taskResp, cancellation)
+
+	securityProfile := r.resolveSecurityProfile(ctx, taskResp)
+	rawSecurityProfile := securityProfile.String()
+
+	// Update status to indicate we're starting, along with the applied limits
+	// and security profile
+	statusResp, err := r.client.UpdateTaskStatus(ctx, taskResp.ExecutionID, taskResp.RunnerToken, TaskStatusRequest{
+		Status:          store.StatusPullingImage,
+		CPULimit:        &constraints.CPUs,
+		MemoryLimit:     &constraints.MaxMemory,
+		SecurityProfile: &rawSecurityProfile,
+		StartedAt:       timePtr(time.Now()),
+	})
+	if errors.Is(err, ErrLeaseLost) {
+		cancellation.Request()
+		cancellation.Done()
+
+		r.logger.WarnContext(ctx, "task execution reclaimed by the server before its start",
+			"execution_id", taskResp.ExecutionID)
+
+		return errors.WithStack(err)
+	} else if err != nil {
+		r.logger.WarnContext(ctx, "failed to update task status", slogx.Error(err))
+	} else if statusResp.Canceled {
+		cancellation.Request()
+		cancellation.Done()
+
+		r.logger.InfoContext(ctx, "task execution canceled before its start",
+			"execution_id", taskResp.ExecutionID)
+
+		if _, statusErr := r.client.UpdateTaskStatus(ctx, taskResp.ExecutionID, taskResp.RunnerToken, TaskStatusRequest{
+			Status:     store.StatusKilled,
+			Error:      "execution canceled",
+			FinishedAt: timePtr(time.Now()),
+		}); statusErr != nil {
+			r.logger.WarnContext(ctx, "failed to update killed task status", slogx.Error(statusErr))
+		}
+
 		return nil
 	}
 
-	r.logger.InfoContext(ctx, "received task assignment",
-		"execution_id", taskResp.ExecutionID,
-		"task_id", taskResp.TaskID,
-		"image_ref", taskResp.ImageRef)
+	network, err := r.networkPolicy.Resolve(taskResp.Network)
+	if err != nil {
+		cancellation.Done()
 
-	// Execute the task
-	return r.executeTask(ctx, taskResp)
-}
+		r.logger.ErrorContext(ctx, "task network rejected",
+			"execution_id", taskResp.ExecutionID,
+			"network", taskResp.Network,
+			"error", err)
 
-func (r *Runner) executeTask(ctx context.Context, taskResp *TaskRequestResponse) error {
-	// Update status to indicate we're starting
-	if err := r.client.UpdateTaskStatus(ctx, taskResp.TaskID, TaskStatusRequest{
-		Status:    store.StatusPullingImage,
-		StartedAt: timePtr(time.Now()),
-	}); err != nil {
-		r.logger.WarnContext(ctx, "failed to update task status", slogx.Error(err))
+		if _, statusErr := r.client.UpdateTaskStatus(ctx, taskResp.ExecutionID, taskResp.RunnerToken, TaskStatusRequest{
+			Status:     store.StatusFailed,
+			Error:      err.Error(),
+			ErrorType:  string(task.ErrorTypeNetworkNotAllowed),
+			FinishedAt: timePtr(time.Now()),
+		}); statusErr != nil {
+			r.logger.WarnContext(ctx, "failed to update failed task status", slogx.Error(statusErr))
+		}
+
+		return errors.Wrap(err, "failed to resolve task network")
 	}
 
 	// Download input files
-	inputs, err := r.downloadInputFiles(ctx, taskResp)
+	inputs, removeInputs, err := r.downloadInputFiles(ctx, taskResp)
+	defer removeInputs()
+
 	if err != nil {
+		cancellation.Done()
+
 		r.logger.ErrorContext(ctx, "failed to download input files",
 			"execution_id", taskResp.ExecutionID,
 			"error", err)
 
 		// Update status to failed
-		if statusErr := r.client.UpdateTaskStatus(ctx, taskResp.TaskID, TaskStatusRequest{
+		if _, statusErr := r.client.UpdateTaskStatus(ctx, taskResp.ExecutionID, taskResp.RunnerToken, TaskStatusRequest{
 			Status:     store.StatusFailed,
 			Error:      err.Error(),
 			FinishedAt: timePtr(time.Now()),
@@ -112,17 +396,23 @@ func (r *Runner) executeTask(ctx context.Context, taskResp *TaskRequestResponse)
 		ImageRef:    taskResp.ImageRef,
 		Environment: taskResp.Environment,
 		Inputs:      inputs,
-		OnChange:    r.createExecutionCallback(ctx, taskResp),
+		Timeout:     time.Duration(taskResp.Timeout) * time.Second,
+		Constraints: constraints,
+		Network:     network,
+		Security:    securityProfile,
+		OnChange:    r.createExecutionCallback(ctx, followCtx, &followers, taskResp, cancellation),
 	}
 
 	// Execute the task
-	if err := r.executor.Execute(ctx, execReq); err != nil {
+	if err := r.executor.Execute(execCtx, execReq); err != nil {
+		cancellation.Done()
+
 		r.logger.ErrorContext(ctx, "task execution failed",
 			"execution_id", taskResp.ExecutionID,
 			"error", err)
 
 		// Update status to failed
-		if statusErr := r.client.UpdateTaskStatus(ctx, taskResp.TaskID, TaskStatusRequest{
+		if _, statusErr := r.client.UpdateTaskStatus(ctx, taskResp.ExecutionID, taskResp.RunnerToken, TaskStatusRequest{
 			Status:     store.StatusFailed,
 			Error:      err.Error(),
 			FinishedAt: timePtr(time.Now()),
@@ -133,16 +423,175 @@ func (r *Runner) executeTask(ctx context.Context, taskResp *TaskRequestResponse)
 		return errors.Wrap(err, "task execution failed")
 	}
 
+	r.waitFinalState(ctx, taskResp, cancellation)
+
+	// Let the last logs be submitted before stopping the log streaming
+	if !waitTimeout(&followers, followersTimeout) {
+		r.logger.WarnContext(ctx, "log streaming did not finish in time",
+			"execution_id", taskResp.ExecutionID)
+	}
+
 	return nil
 }
 
-func (r *Runner) downloadInputFiles(ctx context.Context, taskResp *TaskRequestResponse) (map[string]io.ReadCloser, error) {
+// waitFinalState waits for the execution to reach a final state, killing it
+// if it did not reach one long after its timeout and reporting it failed if
+// it does not stop either
+func (r *Runner) waitFinalState(ctx context.Context, taskResp *TaskRequestResponse, cancellation *cancellation) {
+	timeout := time.Duration(taskResp.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultFinalStateTimeout
+	}
+
+	timer := time.NewTimer(timeout + finalStateGracePeriod)
+	defer timer.Stop()
+
+	select {
+	case <-cancellation.done:
+		return
+	case <-timer.C:
+	}
+
+	r.logger.WarnContext(ctx, "task execution did not reach a final state in time, killing it",
+		"execution_id", taskResp.ExecutionID)
+
+	cancellation.Request()
+
+	timer.Reset(killGracePeriod)
+
+	select {
+	case <-cancellation.done:
+		return
+	case <-timer.C:
+	}
+
+	r.logger.ErrorContext(ctx, "task execution did not stop after being killed",
+		"execution_id", taskResp.ExecutionID)
+
+	if _, err := r.client.UpdateTaskStatus(ctx, taskResp.ExecutionID, taskResp.RunnerToken, TaskStatusRequest{
+		Status:     store.StatusFailed,
+		Error:      "execution did not reach a final state",
+		FinishedAt: timePtr(time.Now()),
+	}); err != nil {
+		r.logger.WarnContext(ctx, "failed to update failed task status", slogx.Error(err))
+	}
+
+	cancellation.Done()
+}
+
+// waitTimeout waits for the group, returning false if it is not done before
+// the given timeout
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// resolveSecurityProfile returns the runner security profile relaxed by the
+// relaxations approved for the execution. Invalid relaxations are ignored.
+func (r *Runner) resolveSecurityProfile(ctx context.Context, taskResp *TaskRequestResponse) task.SecurityProfile {
+	relaxations, err := task.ParseSecurityRelaxations(taskResp.SecurityRelaxations)
+	if err != nil {
+		r.logger.WarnContext(ctx, "ignoring invalid security relaxations",
+			"execution_id", taskResp.ExecutionID,
+			"relaxations", taskResp.SecurityRelaxations,
+			"error", err)
+
+		return r.securityProfile
+	}
+
+	return r.securityProfile.Relax(relaxations)
+}
+
+// watchCancellation periodically asks the server if the execution was canceled
+// and cancels the execution context if so
+func (r *Runner) watchCancellation(ctx context.Context, taskResp *TaskRequestResponse, cancellation *cancellation) {
+	ticker := time.NewTicker(r.cancellationCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-cancellation.done:
+			return
+		case <-ticker.C:
+			// Cancellations are pushed by the server while the session is connected
+			if r.session != nil && r.session.Connected() {
+				continue
+			}
+
+			statusResp, err := r.client.GetTaskStatus(ctx, taskResp.ExecutionID, taskResp.RunnerToken)
+			if errors.Is(err, ErrLeaseLost) {
+				r.logger.WarnContext(ctx, "task execution reclaimed by the server",
+					"execution_id", taskResp.ExecutionID)
+
+				cancellation.Request()
+				return
+			}
+
+			if err != nil {
+				r.logger.WarnContext(ctx, "failed to retrieve task status",
+					"execution_id", taskResp.ExecutionID,
+					"error", err)
+				continue
+			}
+
+			if statusResp.Canceled {
+				r.logger.InfoContext(ctx, "task execution canceled",
+					"execution_id", taskResp.ExecutionID)
+
+				cancellation.Request()
+				return
+			}
+		}
+	}
+}
+
+// downloadInputFiles downloads the input files of the execution into
+// temporary files, so that no response is held open while the execution
+// starts and that their size is known, and returns them opened along with a
+// function closing and removing them
+func (r *Runner) downloadInputFiles(ctx context.Context, taskResp *TaskRequestResponse) (map[string]io.ReadCloser, func(), error) {
+	files := make([]*os.File, 0)
+
+	cleanup := func() {
+		for _, f := range files {
+			f.Close()
+
+			if err := os.Remove(f.Name()); err != nil {
+				r.logger.WarnContext(ctx, "failed to remove input file",
+					"execution_id", taskResp.ExecutionID,
+					"path", f.Name(),
+					"error", err)
+			}
+		}
+	}
+
 	inputs := make(map[string]io.ReadCloser)
 
 	// List available input files
-	fileList, err := r.client.ListInputFiles(ctx, taskResp.TaskID)
+	var fileList []map[string]interface{}
+	err := retryTransfer(ctx, func() error {
+		var err error
+		fileList, err = r.client.ListInputFiles(ctx, taskResp.ExecutionID, taskResp.RunnerToken)
+		return errors.WithStack(err)
+	})
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to list input files")
+		return nil, cleanup, errors.Wrap(err, "failed to list input files")
 	}
 
 	// Download each input file using parameter name as key
@@ -153,43 +602,77 @@ func (r *Runner) downloadInputFiles(ctx context.Context, taskResp *TaskRequestRe
 			continue
 		}
 
-		fileReader, err := r.client.DownloadInputFile(ctx, taskResp.TaskID, parameterName)
+		file, err := os.CreateTemp(r.tempDir, "oplet-input-*")
 		if err != nil {
-			r.logger.WarnContext(ctx, "failed to download input file",
-				"parameter_name", parameterName, "error", err)
-			continue
+			return nil, cleanup, errors.Wrap(err, "failed to create temporary input file")
+		}
+
+		files = append(files, file)
+
+		var size int64
+		err = retryTransfer(ctx, func() error {
+			// Interrupted downloads are started over
+			if err := file.Truncate(0); err != nil {
+				return errors.WithStack(err)
+			}
+
+			if _, err := file.Seek(0, io.SeekStart); err != nil {
+				return errors.WithStack(err)
+			}
+
+			size, err = r.client.DownloadInputFile(ctx, taskResp.ExecutionID, taskResp.RunnerToken, parameterName, file)
+			if err != nil {
+				r.logger.WarnContext(ctx, "failed to download input file",
+					"execution_id", taskResp.ExecutionID,
+					"parameter_name", parameterName,
+					"error", err)
+			}
+
+			return errors.WithStack(err)
+		})
+		if err != nil {
+			return nil, cleanup, errors.Wrapf(err, "failed to download input file %s", parameterName)
+		}
+
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, cleanup, errors.WithStack(err)
 		}
 
 		// Use parameter name as key so file is positioned correctly in /oplet/inputs
-		inputs[parameterName] = fileReader
+		inputs[parameterName] = file
 
 		r.logger.InfoContext(ctx, "downloaded input file",
 			"execution_id", taskResp.ExecutionID,
-			"parameter_name", parameterName)
+			"parameter_name", parameterName,
+			"size", size)
 	}
 
-	return inputs, nil
+	return inputs, cleanup, nil
 }
 
-func (r *Runner) uploadOutputFiles(ctx context.Context, taskResp *TaskRequestResponse, outputs *tar.Reader) {
+// uploadOutputFiles uploads the output files of the archive and submits the
+// result written by the task. Entries other than regular files are ignored.
+// An error is returned if any output file could not be uploaded.
+func (r *Runner) uploadOutputFiles(ctx context.Context, taskResp *TaskRequestResponse, outputs *tar.Reader) error {
 	if outputs == nil {
-		return
+		return nil
 	}
 
-	// Create a map to collect output files
-	outputFiles := make(map[string]io.Reader)
+	uploaded := 0
+	failed := make([]string, 0)
+
+	// Result files written by the task, by name
+	resultFiles := make(map[string][]byte)
 
-	// Read all files from the tar archive
+	// Read all files from the tar archive, each output file being uploaded
+	// before the next one is read
 	for {
 		header, err := outputs.Next()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			r.logger.ErrorContext(ctx, "failed to read output tar",
-				"execution_id", taskResp.ExecutionID,
-				"error", err)
-			return
+			return errors.Wrap(err, "failed to read output tar")
 		}
 
 		// Skip directories
@@ -197,45 +680,202 @@ func (r *Runner) uploadOutputFiles(ctx context.Context, taskResp *TaskRequestRes
 			continue
 		}
 
-		filename := filepath.Base(header.Name)
+		filename, ok := outputPath(header.Name)
+		if !ok {
+			r.logger.WarnContext(ctx, "ignored output file with invalid path",
+				"execution_id", taskResp.ExecutionID,
+				"name", header.Name)
+			continue
+		}
 
-		// Read file content into memory
-		var buf bytes.Buffer
-		if _, err := io.Copy(&buf, outputs); err != nil {
-			r.logger.WarnContext(ctx, "failed to read output file content",
+		// Links could expose files from outside the outputs directory
+		if header.Typeflag != tar.TypeReg {
+			r.logger.WarnContext(ctx, "ignored output file which is not a regular file",
+				"execution_id", taskResp.ExecutionID,
+				"name", header.Name,
+				"type", string(header.Typeflag))
+			r.submitSystemLog(ctx, taskResp, fmt.Sprintf("Ignored output file %s which is not a regular file", filename))
+			continue
+		}
+
+		// Result files are sent apart from the output files
+		if path.Dir(filename) == task.ResultDir {
+			filename = path.Base(filename)
+
+			if filename != task.ResultManifestFile && filename != task.ResultSummaryFile {
+				continue
+			}
+
+			content, err := io.ReadAll(io.LimitReader(outputs, task.MaxResultSize+1))
+			if err != nil {
+				r.logger.WarnContext(ctx, "failed to read result file content",
+					"execution_id", taskResp.ExecutionID,
+					"filename", filename,
+					"error", err)
+				continue
+			}
+
+			if len(content) > task.MaxResultSize {
+				r.submitSystemLog(ctx, taskResp, fmt.Sprintf("Ignored result file %s exceeding %d bytes", filename, task.MaxResultSize))
+				continue
+			}
+
+			resultFiles[filename] = content
+			continue
+		}
+
+		err = r.uploadOutputFile(ctx, taskResp, filename, outputs)
+		if errors.Is(err, ErrLeaseLost) {
+			r.logger.WarnContext(ctx, "execution lease lost, output files upload aborted",
+				"execution_id", taskResp.ExecutionID)
+			return errors.WithStack(err)
+		}
+
+		if err != nil {
+			r.logger.ErrorContext(ctx, "failed to upload output file",
 				"execution_id", taskResp.ExecutionID,
 				"filename", filename,
 				"error", err)
+			r.submitSystemLog(ctx, taskResp, fmt.Sprintf("Could not upload output file %s", filename))
+			failed = append(failed, filename)
 			continue
 		}
 
-		outputFiles[filename] = bytes.NewReader(buf.Bytes())
+		uploaded++
+	}
+
+	if len(resultFiles) > 0 {
+		r.submitResult(ctx, taskResp, resultFiles)
+	}
 
-		r.logger.InfoContext(ctx, "prepared output file for upload",
+	if uploaded > 0 {
+		r.logger.InfoContext(ctx, "successfully uploaded output files",
 			"execution_id", taskResp.ExecutionID,
-			"filename", filename,
-			"size", buf.Len())
+			"file_count", uploaded)
 	}
 
-	// Upload all output files
-	if len(outputFiles) > 0 {
-		if err := r.client.UploadOutputFiles(ctx, taskResp.TaskID, outputFiles); err != nil {
-			r.logger.ErrorContext(ctx, "failed to upload output files",
-				"execution_id", taskResp.ExecutionID,
-				"error", err)
-		} else {
-			r.logger.InfoContext(ctx, "successfully uploaded output files",
-				"execution_id", taskResp.ExecutionID,
-				"file_count", len(outputFiles))
-		}
+	if len(failed) > 0 {
+		return errors.Errorf("could not upload output files: %s", strings.Join(failed, ", "))
 	}
+
+	return nil
 }
 
-func (r *Runner) createExecutionCallback(ctx context.Context, taskResp *TaskRequestResponse) func(task.Execution) {
+// uploadOutputFile writes the content of the output file to a temporary file
+// while computing its checksum, so that its chunks can be sent again, and
+// uploads it
+func (r *Runner) uploadOutputFile(ctx context.Context, taskResp *TaskRequestResponse, filename string, content io.Reader) error {
+	file, err := os.CreateTemp(r.tempDir, "oplet-output-*")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temporary output file")
+	}
+
+	defer func() {
+		file.Close()
+		os.Remove(file.Name())
+	}()
+
+	hasher := sha256.New()
+
+	size, err := io.Copy(io.MultiWriter(file, hasher), content)
+	if err != nil {
+		return errors.Wrap(err, "failed to read output file content")
+	}
+
+	checksum := hex.EncodeToString(hasher.Sum(nil))
+
+	if err := r.client.UploadOutputFile(ctx, taskResp.ExecutionID, taskResp.RunnerToken, filename, file, size, checksum); err != nil {
+		return errors.WithStack(err)
+	}
+
+	r.logger.InfoContext(ctx, "uploaded output file",
+		"execution_id", taskResp.ExecutionID,
+		"filename", filename,
+		"size", size,
+		"checksum", checksum)
+
+	return nil
+}
+
+// outputPath returns the path of the entry of the outputs archive relative
+// to the outputs directory, false if it is not a clean path inside the
+// directory
+func outputPath(name string) (string, bool) {
+	// Entries are prefixed by the name of the outputs directory
+	dir, rel, found := strings.Cut(name, "/")
+	if !found || dir != path.Base(task.OutputsDir) || !task.IsValidOutputPath(rel) {
+		return "", false
+	}
+
+	return rel, true
+}
+
+// submitResult sends the result written by the task to the server, invalid
+// results being reported in the execution logs. The executors only download
+// the outputs of the succeeded executions, failed ones having no result.
+func (r *Runner) submitResult(ctx context.Context, taskResp *TaskRequestResponse, resultFiles map[string][]byte) {
+	result, err := task.ParseResult(resultFiles[task.ResultManifestFile], resultFiles[task.ResultSummaryFile])
+	if err != nil {
+		r.submitSystemLog(ctx, taskResp, fmt.Sprintf("Invalid task result: %s", err.Error()))
+		return
+	}
+
+	if result.IsEmpty() {
+		return
+	}
+
+	if err := r.client.SubmitTaskResult(ctx, taskResp.ExecutionID, taskResp.RunnerToken, TaskResultRequest{Result: *result}); err != nil {
+		r.logger.ErrorContext(ctx, "failed to submit task result",
+			"execution_id", taskResp.ExecutionID,
+			"error", err)
+		return
+	}
+
+	r.logger.InfoContext(ctx, "successfully submitted task result",
+		"execution_id", taskResp.ExecutionID)
+}
+
+// submitSystemLog adds a message of the runner to the execution logs
+func (r *Runner) submitSystemLog(ctx context.Context, taskResp *TaskRequestResponse, message string) {
+	err := r.client.SubmitLogs(ctx, taskResp.ExecutionID, taskResp.RunnerToken, []LogEntry{
+		{
+			Timestamp: time.Now().UnixMicro(),
+			Source:    "system",
+			Message:   message,
+		},
+	})
+	if err != nil {
+		r.logger.WarnContext(ctx, "failed to submit system log",
+			"execution_id", taskResp.ExecutionID,
+			"message", message,
+			"error", err)
+	}
+}
+
+func (r *Runner) createExecutionCallback(ctx context.Context, followCtx context.Context, followers *sync.WaitGroup, taskResp *TaskRequestResponse, cancellation *cancellation) func(task.Execution) {
+	// Failure of the upload of the output files, failing the execution even
+	// if the task succeeded
+	var outputsErr error
+
 	return func(e task.Execution) {
+		if e.State == task.ExecutionStateSucceeded && outputsErr != nil {
+			e.State = task.ExecutionStateFailed
+			e.Error = &task.ExecutionError{
+				Type:        task.ErrorTypeOutputUploadFailed,
+				Message:     outputsErr.Error(),
+				ContainerID: e.ContainerID,
+				Cause:       outputsErr,
+			}
+		}
+
 		// Map execution state to task status
 		status := r.mapExecutionStateToStatus(e.State)
 
+		canceled := cancellation.Requested() && (e.State == task.ExecutionStateFailed || e.State == task.ExecutionStateKilled)
+		if canceled {
+			status = store.StatusKilled
+		}
+
 		statusReq := TaskStatusRequest{
 			Status:      status,
 			ContainerID: e.ContainerID,
@@ -253,38 +893,71 @@ func (r *Runner) createExecutionCallback(ctx context.Context, taskResp *TaskRequ
 		}
 		if e.Error != nil {
 			statusReq.Error = e.Error.Error()
+
+			var executionErr *task.ExecutionError
+			if errors.As(e.Error, &executionErr) {
+				statusReq.ErrorType = string(executionErr.Type)
+			}
+		}
+		if canceled {
+			statusReq.Error = "execution canceled"
 		}
 
 		// Update task status
-		if err := r.client.UpdateTaskStatus(ctx, taskResp.TaskID, statusReq); err != nil {
+		statusResp, err := r.client.UpdateTaskStatus(ctx, taskResp.ExecutionID, taskResp.RunnerToken, statusReq)
+		if errors.Is(err, ErrLeaseLost) {
+			cancellation.Request()
+		} else if err != nil {
 			r.logger.WarnContext(ctx, "failed to update task status",
 				"execution_id", taskResp.ExecutionID,
 				"state", e.State,
 				"error", err)
+		} else if statusResp.Canceled {
+			cancellation.Request()
 		}
 
 		// Handle specific states
 		switch e.State {
 		case task.ExecutionStateContainerStarted:
-			r.startLogStreaming(ctx, taskResp, e.ContainerID)
+			progress := &progressReporter{}
+			followers.Add(1)
+			r.startLogStreaming(ctx, followCtx, followers, taskResp, e.ContainerID, progress)
+			go r.watchProgress(ctx, taskResp, e.ContainerID, progress, cancellation)
 		case task.ExecutionStateFilesDownloaded:
 			// Upload output files when they are downloaded from container
 			if e.Outputs != nil {
-				r.uploadOutputFiles(ctx, taskResp, e.Outputs)
+				outputsErr = r.uploadOutputFiles(ctx, taskResp, e.Outputs)
 			}
 		case task.ExecutionStateSucceeded:
+			cancellation.Done()
 			r.logger.InfoContext(ctx, "task execution succeeded",
 				"execution_id", taskResp.ExecutionID)
 		case task.ExecutionStateFailed:
+			cancellation.Done()
 			r.logger.ErrorContext(ctx, "task execution failed",
 				"execution_id", taskResp.ExecutionID,
 				"error", e.Error)
+		case task.ExecutionStateKilled:
+			cancellation.Done()
+			r.logger.WarnContext(ctx, "task execution killed",
+				"execution_id", taskResp.ExecutionID)
+		case task.ExecutionStateTimedOut:
+			cancellation.Done()
+			r.logger.WarnContext(ctx, "task execution timed out",
+				"execution_id", taskResp.ExecutionID,
+				"timeout", time.Duration(taskResp.Timeout)*time.Second)
 		}
 	}
 }
 
-func (r *Runner) startLogStreaming(ctx context.Context, taskResp *TaskRequestResponse, containerID string) {
+// startLogStreaming submits the logs of the task to the server, the log lines
+// reporting its progress being passed to the progress reporter instead. The
+// logs are followed until their stream ends or followCtx is done, ctx being
+// used to submit them.
+func (r *Runner) startLogStreaming(ctx context.Context, followCtx context.Context, followers *sync.WaitGroup, taskResp *TaskRequestResponse, containerID string, progress *progressReporter) {
 	go func() {
+		defer followers.Done()
+
 		defer func() {
 			if rec := recover(); rec != nil {
 				r.logger.ErrorContext(ctx, "panic in log streaming",
@@ -293,7 +966,11 @@ func (r *Runner) startLogStreaming(ctx context.Context, taskResp *TaskRequestRes
 			}
 		}()
 
-		var localClock uint = 0
+		// Clock of the last entry handled, if any
+		var (
+			localClock uint
+			streamed   bool
+		)
 
 		logs := make([]LogEntry, 0)
 		submitLogs := func() {
@@ -301,7 +978,7 @@ func (r *Runner) startLogStreaming(ctx context.Context, taskResp *TaskRequestRes
 				return
 			}
 
-			if submitErr := r.client.SubmitLogs(ctx, taskResp.TaskID, logs); submitErr != nil {
+			if submitErr := r.client.SubmitLogs(ctx, taskResp.ExecutionID, taskResp.RunnerToken, logs); submitErr != nil {
 				r.logger.WarnContext(ctx, "failed to submit logs",
 					"execution_id", taskResp.ExecutionID,
 					"error", submitErr)
@@ -312,62 +989,90 @@ func (r *Runner) startLogStreaming(ctx context.Context, taskResp *TaskRequestRes
 
 		defer submitLogs()
 
-		ticker := time.NewTicker(time.Second)
-		defer ticker.Stop()
-
 		defer func() {
-			r.logger.InfoContext(ctx, "stopped streaming logs")
+			r.logger.InfoContext(ctx, "stopped streaming logs",
+				"execution_id", taskResp.ExecutionID)
 		}()
 
-		for {
-			logs = make([]LogEntry, 0)
+		logEntries, ok := r.followLogs(followCtx, taskResp, containerID)
+		if !ok {
+			return
+		}
 
-			// Get container logs
-			logEntries, err := r.executor.GetLogs(ctx, containerID)
-			if err != nil {
-				r.logger.ErrorContext(ctx, "failed to get container logs",
-					"execution_id", taskResp.ExecutionID,
-					"container_id", containerID,
-					"error", errors.Cause(err))
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-followCtx.Done():
+				return
 
-				if errors.Is(err, task.ErrContainerNotFound) {
+			case e, ok := <-logEntries:
+				if !ok {
 					return
 				}
 
-				continue
-			}
+				if streamed && e.Clock <= localClock {
+					continue
+				}
 
-		OUTER:
-			for {
-				select {
-				case <-ctx.Done():
-					return
+				localClock = e.Clock
+				streamed = true
 
-				case e, ok := <-logEntries:
-					if !ok {
-						submitLogs()
-						break OUTER
-					}
-
-					if e.Clock >= localClock {
-						logs = append(logs, LogEntry{
-							Timestamp: e.Timestamp.UnixMicro(),
-							Source:    "container",
-							Message:   e.Message,
-							Clock:     e.Clock,
-						})
-						localClock = e.Clock
-					}
-
-				case <-ticker.C:
-					submitLogs()
+				if parsed, ok := task.ParseProgressLine(e.Message); ok {
+					progress.Report(parsed)
+					continue
 				}
+
+				logs = append(logs, LogEntry{
+					Timestamp: e.Timestamp.UnixMicro(),
+					Source:    "container",
+					Stream:    string(e.Stream),
+					Message:   e.Message,
+					Clock:     e.Clock,
+				})
+
+			case <-ticker.C:
+				submitLogs()
 			}
 		}
-
 	}()
 }
 
+// followLogs opens the log stream of the container, backing off between
+// attempts, returning false if the container is gone or ctx is done
+func (r *Runner) followLogs(ctx context.Context, taskResp *TaskRequestResponse, containerID string) (chan task.LogEntry, bool) {
+	backoff := logsMinBackoff
+
+	for {
+		logEntries, err := r.executor.GetLogs(ctx, containerID)
+		if err == nil {
+			return logEntries, true
+		}
+
+		r.logger.ErrorContext(ctx, "failed to get container logs",
+			"execution_id", taskResp.ExecutionID,
+			"container_id", containerID,
+			"retry_in", backoff,
+			"error", errors.Cause(err))
+
+		if errors.Is(err, task.ErrContainerNotFound) {
+			return nil, false
+		}
+
+		timer := time.NewTimer(backoff)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, false
+		case <-timer.C:
+		}
+
+		backoff = min(backoff*2, logsMaxBackoff)
+	}
+}
+
 func (r *Runner) mapExecutionStateToStatus(state task.ExecutionState) store.TaskExecutionStatus {
 	switch state {
 	case task.ExecutionStateProcessingRequest:
@@ -402,6 +1107,8 @@ func (r *Runner) mapExecutionStateToStatus(state task.ExecutionState) store.Task
 		return store.StatusFailed
 	case task.ExecutionStateKilled:
 		return store.StatusKilled
+	case task.ExecutionStateTimedOut:
+		return store.StatusTimedOut
 	default:
 		return store.StatusPending
 	}
@@ -428,13 +1135,29 @@ func New(rawServerURL string, authToken string, funcs ...OptionFunc) (*Runner, e
 		return nil, errors.Wrap(err, "failed to create API client")
 	}
 
-	return &Runner{
-		serverURL:         serverURL,
-		authToken:         authToken,
-		http:              opts.HTTPClient,
-		executor:          opts.Executor,
-		logger:            opts.Logger.With("component", "runner"),
-		executionInterval: opts.ExecutionInterval,
-		client:            client,
-	}, nil
+	runner := &Runner{
+		serverURL:                 serverURL,
+		authToken:                 authToken,
+		http:                      opts.HTTPClient,
+		executor:                  opts.Executor,
+		logger:                    opts.Logger.With("component", "runner"),
+		executionInterval:         opts.ExecutionInterval,
+		cancellationCheckInterval: opts.CancellationCheckInterval,
+		drainTimeout:              opts.DrainTimeout,
+		slots:                     opts.Slots,
+		tags:                      opts.Tags,
+		capacity:                  newCapacity(opts.MaxCPUs, opts.MaxMemory),
+		maxFuel:                   opts.MaxFuel,
+		networkPolicy:             newNetworkPolicy(opts.AllowedNetworks, opts.EgressNetwork, opts.EgressProxyURL),
+		securityProfile:           opts.SecurityProfile,
+		tempDir:                   opts.TempDir,
+		client:                    client,
+		resumed:                   make(chan struct{}, 1),
+	}
+
+	if opts.Session {
+		runner.session = client.OpenSession(runner.handleCommand, opts.Logger)
+	}
+
+	return runner, nil
 }
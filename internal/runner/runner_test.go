@@ -0,0 +1,292 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/bornholm/oplet/internal/slogx"
+	"github.com/bornholm/oplet/internal/store"
+	"github.com/bornholm/oplet/internal/task"
+)
+
+func TestExecuteTaskNotStarted(t *testing.T) {
+	type testCase struct {
+		name             string
+		respond          func(w http.ResponseWriter)
+		expectedErr      error
+		expectedStatuses []store.TaskExecutionStatus
+	}
+
+	testCases := []testCase{
+		{
+			name: "reclaimed by the server",
+			respond: func(w http.ResponseWriter) {
+				http.Error(w, "execution lease lost", http.StatusConflict)
+			},
+			expectedErr:      ErrLeaseLost,
+			expectedStatuses: []store.TaskExecutionStatus{store.StatusPullingImage},
+		},
+		{
+			name: "canceled by a user",
+			respond: func(w http.ResponseWriter) {
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(TaskStatusResponse{ExecutionID: 42, Canceled: true})
+			},
+			expectedStatuses: []store.TaskExecutionStatus{store.StatusPullingImage, store.StatusKilled},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			var (
+				mutex    sync.Mutex
+				statuses []store.TaskExecutionStatus
+			)
+
+			mux := http.NewServeMux()
+
+			mux.HandleFunc("POST /runner/executions/42/status", func(w http.ResponseWriter, r *http.Request) {
+				var req TaskStatusRequest
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					t.Errorf("%+v", errors.WithStack(err))
+				}
+
+				mutex.Lock()
+				statuses = append(statuses, req.Status)
+				mutex.Unlock()
+
+				tc.respond(w)
+			})
+
+			server := httptest.NewServer(mux)
+			defer server.Close()
+
+			client, err := NewClient(server.URL, "token", server.Client())
+			if err != nil {
+				t.Fatalf("%+v", errors.WithStack(err))
+			}
+
+			executor := &unexpectedExecutor{}
+
+			r := &Runner{
+				client:                    client,
+				executor:                  executor,
+				logger:                    slogx.NewTestLogger(t),
+				cancellationCheckInterval: time.Hour,
+			}
+
+			err = r.executeTask(ctx, &TaskRequestResponse{ExecutionID: 42}, task.Constraints{})
+
+			if tc.expectedErr != nil && !errors.Is(err, tc.expectedErr) {
+				t.Errorf("expected error '%v', got '%v'", tc.expectedErr, err)
+			}
+
+			if tc.expectedErr == nil && err != nil {
+				t.Fatalf("%+v", errors.WithStack(err))
+			}
+
+			if executor.executed {
+				t.Errorf("expected the execution not to be started")
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if e, g := tc.expectedStatuses, statuses; !slices.Equal(e, g) {
+				t.Errorf("statuses: expected %v, got %v", e, g)
+			}
+		})
+	}
+}
+
+func TestStartLogStreaming(t *testing.T) {
+	type testCase struct {
+		name             string
+		errors           []error
+		entries          []task.LogEntry
+		keepOpen         bool
+		expectedClocks   []uint
+		expectedProgress *task.Progress
+		expectedCalls    int
+	}
+
+	testCases := []testCase{
+		{
+			name: "entries submitted once and progress kept apart",
+			entries: []task.LogEntry{
+				{Clock: 1, Message: "first"},
+				{Clock: 2, Message: "second"},
+				{Clock: 2, Message: "second"},
+				{Clock: 3, Message: task.ProgressLinePrefix + "50 Halfway"},
+				{Clock: 1, Message: "first"},
+				{Clock: 4, Message: "third"},
+			},
+			expectedClocks:   []uint{1, 2, 4},
+			expectedProgress: &task.Progress{Percent: 50, Step: "Halfway"},
+			expectedCalls:    1,
+		},
+		{
+			name:           "stopped with the execution",
+			keepOpen:       true,
+			expectedClocks: []uint{},
+			expectedCalls:  1,
+		},
+		{
+			name:           "container gone",
+			errors:         []error{errors.WithStack(task.ErrContainerNotFound)},
+			expectedClocks: []uint{},
+			expectedCalls:  1,
+		},
+		{
+			name:           "transient error",
+			errors:         []error{errors.New("daemon unavailable")},
+			entries:        []task.LogEntry{{Clock: 1, Message: "first"}},
+			expectedClocks: []uint{1},
+			expectedCalls:  2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			var (
+				mutex  sync.Mutex
+				clocks = make([]uint, 0)
+			)
+
+			mux := http.NewServeMux()
+
+			mux.HandleFunc("POST /runner/executions/42/trace", func(w http.ResponseWriter, r *http.Request) {
+				var req TaskTraceRequest
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					t.Errorf("%+v", errors.WithStack(err))
+				}
+
+				mutex.Lock()
+				for _, l := range req.Logs {
+					clocks = append(clocks, l.Clock)
+				}
+				mutex.Unlock()
+
+				w.WriteHeader(http.StatusOK)
+			})
+
+			server := httptest.NewServer(mux)
+			defer server.Close()
+
+			client, err := NewClient(server.URL, "token", server.Client())
+			if err != nil {
+				t.Fatalf("%+v", errors.WithStack(err))
+			}
+
+			executor := &logsExecutor{errors: tc.errors, entries: tc.entries, keepOpen: tc.keepOpen}
+
+			r := &Runner{
+				client:   client,
+				executor: executor,
+				logger:   slogx.NewTestLogger(t),
+			}
+
+			followCtx, stopFollowing := context.WithCancel(ctx)
+			defer stopFollowing()
+
+			var followers sync.WaitGroup
+
+			progress := &progressReporter{}
+
+			followers.Add(1)
+			r.startLogStreaming(ctx, followCtx, &followers, &TaskRequestResponse{ExecutionID: 42}, "container", progress)
+
+			if tc.keepOpen {
+				// The execution ends while its logs are still followed
+				stopFollowing()
+			}
+
+			if !waitTimeout(&followers, 5*time.Second) {
+				t.Fatalf("expected the log streaming to stop")
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if e, g := tc.expectedClocks, clocks; !slices.Equal(e, g) {
+				t.Errorf("submitted clocks: expected %v, got %v", e, g)
+			}
+
+			if e, g := tc.expectedCalls, executor.calls; e != g {
+				t.Errorf("GetLogs calls: expected %d, got %d", e, g)
+			}
+
+			progress.mutex.Lock()
+			defer progress.mutex.Unlock()
+
+			switch {
+			case tc.expectedProgress == nil && progress.pending != nil:
+				t.Errorf("progress: expected none, got %+v", *progress.pending)
+			case tc.expectedProgress != nil && progress.pending == nil:
+				t.Errorf("progress: expected %+v, got none", *tc.expectedProgress)
+			case tc.expectedProgress != nil && *tc.expectedProgress != *progress.pending:
+				t.Errorf("progress: expected %+v, got %+v", *tc.expectedProgress, *progress.pending)
+			}
+		})
+	}
+}
+
+type logsExecutor struct {
+	unexpectedExecutor
+	errors   []error
+	entries  []task.LogEntry
+	keepOpen bool
+	calls    int
+}
+
+// GetLogs implements task.Executor.
+func (e *logsExecutor) GetLogs(ctx context.Context, containerID string) (chan task.LogEntry, error) {
+	e.calls++
+
+	if e.calls <= len(e.errors) {
+		return nil, e.errors[e.calls-1]
+	}
+
+	entries := make(chan task.LogEntry, len(e.entries))
+
+	for _, entry := range e.entries {
+		entries <- entry
+	}
+
+	if !e.keepOpen {
+		close(entries)
+	}
+
+	return entries, nil
+}
+
+type unexpectedExecutor struct {
+	executed bool
+}
+
+// Execute implements task.Executor.
+func (e *unexpectedExecutor) Execute(ctx context.Context, req task.ExecutionRequest) error {
+	e.executed = true
+	return errors.New("unexpected execution")
+}
+
+// GetLogs implements task.Executor.
+func (e *unexpectedExecutor) GetLogs(ctx context.Context, containerID string) (chan task.LogEntry, error) {
+	return nil, errors.New("unexpected logs")
+}
+
+var _ task.Executor = &unexpectedExecutor{}
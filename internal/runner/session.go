@@ -0,0 +1,245 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bornholm/oplet/internal/slogx"
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/pkg/errors"
+)
+
+const (
+	// sessionReadLimit is the maximum size of a message sent by the server
+	sessionReadLimit = 8 << 20
+	// Delays between two connection attempts, doubled on each failure
+	sessionMinBackoff = time.Second
+	sessionMaxBackoff = 30 * time.Second
+)
+
+// errSessionUnavailable is returned when a call can not be carried by the
+// session, the client falling back to the REST API
+var errSessionUnavailable = errors.New("session unavailable")
+
+// CommandHandler handles the commands sent by the server over the session
+type CommandHandler func(ctx context.Context, command SessionCommand)
+
+// Session is a websocket connection to the server carrying the calls of the
+// client and the commands sent by the server. It reconnects automatically,
+// the client using the REST API while it is down.
+type Session struct {
+	client  *Client
+	handler CommandHandler
+	logger  *slog.Logger
+
+	mutex   sync.Mutex
+	conn    *websocket.Conn
+	nextID  uint64
+	pending map[uint64]chan SessionMessage
+}
+
+// OpenSession creates the session of the client, the session being
+// connected once Run is called
+func (c *Client) OpenSession(handler CommandHandler, logger *slog.Logger) *Session {
+	session := &Session{
+		client:  c,
+		handler: handler,
+		logger:  logger.With("component", "runner-session"),
+		pending: make(map[uint64]chan SessionMessage),
+	}
+
+	c.session = session
+
+	return session
+}
+
+// Run connects the session and reconnects it when it is lost, until ctx is done
+func (s *Session) Run(ctx context.Context) {
+	backoff := sessionMinBackoff
+
+	for {
+		connected, err := s.serve(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if connected {
+			backoff = sessionMinBackoff
+		}
+
+		s.logger.WarnContext(ctx, "runner session lost, falling back to the rest api",
+			"retry_in", backoff,
+			slogx.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, sessionMaxBackoff)
+	}
+}
+
+// Connected returns true if the session is currently connected
+func (s *Session) Connected() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.conn != nil
+}
+
+// serve connects the session and reads the messages sent by the server until
+// the connection is lost, returning true if the connection was established
+func (s *Session) serve(ctx context.Context) (bool, error) {
+	sessionURL := s.client.serverURL.JoinPath("/runner/session")
+
+	conn, _, err := websocket.Dial(ctx, sessionURL.String(), &websocket.DialOptions{
+		HTTPClient: s.client.http,
+		HTTPHeader: http.Header{
+			"Authorization": []string{"Bearer " + s.client.authToken},
+		},
+	})
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	defer conn.CloseNow()
+
+	conn.SetReadLimit(sessionReadLimit)
+
+	s.mutex.Lock()
+	s.conn = conn
+	s.mutex.Unlock()
+
+	defer s.disconnect()
+
+	s.logger.InfoContext(ctx, "runner session opened")
+
+	for {
+		var msg SessionMessage
+		if err := wsjson.Read(ctx, conn, &msg); err != nil {
+			return true, errors.WithStack(err)
+		}
+
+		if msg.Type == SessionMessageReply {
+			s.resolve(msg)
+			continue
+		}
+
+		command := SessionCommand{Type: msg.Type}
+		if err := json.Unmarshal(msg.Payload, &command); err != nil {
+			s.logger.WarnContext(ctx, "ignoring invalid command", "type", msg.Type, slogx.Error(errors.WithStack(err)))
+			continue
+		}
+
+		go s.handler(ctx, command)
+	}
+}
+
+// disconnect releases the connection, the pending calls falling back
+// to the REST API
+func (s *Session) disconnect() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.conn = nil
+
+	for id, replies := range s.pending {
+		close(replies)
+		delete(s.pending, id)
+	}
+}
+
+// resolve passes the reply to the call waiting for it
+func (s *Session) resolve(reply SessionMessage) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	replies, exists := s.pending[reply.ID]
+	if !exists {
+		return
+	}
+
+	replies <- reply
+	delete(s.pending, reply.ID)
+}
+
+// call sends a call to the server and decodes its reply in result,
+// returning errSessionUnavailable if the session is not connected or if
+// it is lost before the reply is received
+func (s *Session) call(ctx context.Context, msgType SessionMessageType, payload any, result any) error {
+	rawPayload, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal %s payload", msgType)
+	}
+
+	s.mutex.Lock()
+
+	conn := s.conn
+	if conn == nil {
+		s.mutex.Unlock()
+		return errors.WithStack(errSessionUnavailable)
+	}
+
+	s.nextID++
+	id := s.nextID
+
+	replies := make(chan SessionMessage, 1)
+	s.pending[id] = replies
+
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		delete(s.pending, id)
+		s.mutex.Unlock()
+	}()
+
+	msg := SessionMessage{
+		Type:    msgType,
+		ID:      id,
+		Payload: rawPayload,
+	}
+
+	if err := wsjson.Write(ctx, conn, msg); err != nil {
+		return errors.Wrap(errSessionUnavailable, err.Error())
+	}
+
+	var reply SessionMessage
+
+	select {
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+
+	case r, ok := <-replies:
+		if !ok {
+			return errors.WithStack(errSessionUnavailable)
+		}
+
+		reply = r
+	}
+
+	if reply.Error != nil {
+		if reply.Error.Code == "lease_lost" {
+			return errors.WithStack(ErrLeaseLost)
+		}
+
+		return errors.Errorf("%s failed: %s", msgType, reply.Error.Error)
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(reply.Payload, result); err != nil {
+		return errors.Wrapf(err, "failed to decode %s reply", msgType)
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/pkg/errors"
+)
+
+func TestSession(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	restCalls := make(chan string, 10)
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /runner/heartbeat", func(w http.ResponseWriter, r *http.Request) {
+		restCalls <- r.URL.Path
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(HeartbeatResponse{ID: 1})
+	})
+
+	mux.HandleFunc("GET /runner/session", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			t.Errorf("%+v", err)
+			return
+		}
+
+		defer conn.CloseNow()
+
+		// Push a command as soon as the session is opened
+		payload, _ := json.Marshal(SessionCommand{ExecutionID: 42})
+		if err := wsjson.Write(r.Context(), conn, SessionMessage{Type: SessionMessageCancel, Payload: payload}); err != nil {
+			t.Errorf("%+v", err)
+			return
+		}
+
+		for {
+			var msg SessionMessage
+			if err := wsjson.Read(r.Context(), conn, &msg); err != nil {
+				return
+			}
+
+			reply := SessionMessage{Type: SessionMessageReply, ID: msg.ID}
+
+			switch msg.Type {
+			case SessionMessageHeartbeat:
+				reply.Payload, _ = json.Marshal(HeartbeatResponse{ID: 2})
+			case SessionMessageRequestTask:
+				reply.Payload = json.RawMessage("null")
+			case SessionMessageStatus:
+				reply.Error = &SessionError{Error: "execution lease lost", Code: "lease_lost"}
+			}
+
+			if err := wsjson.Write(r.Context(), conn, reply); err != nil {
+				return
+			}
+		}
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client, err := NewClient(server.URL, "token", server.Client())
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	commands := make(chan SessionCommand, 1)
+
+	session := client.OpenSession(func(ctx context.Context, command SessionCommand) {
+		commands <- command
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	// The client falls back to the REST API until the session is connected
+	heartbeat, err := client.SendHeartbeat(ctx, HeartbeatRequest{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if e, g := uint(1), heartbeat.ID; e != g {
+		t.Errorf("heartbeat.ID: expected '%v', got '%v'", e, g)
+	}
+
+	if e, g := 1, len(restCalls); e != g {
+		t.Errorf("len(restCalls): expected '%v', got '%v'", e, g)
+	}
+
+	sessionCtx, cancelSession := context.WithCancel(ctx)
+	defer cancelSession()
+
+	go session.Run(sessionCtx)
+
+	select {
+	case command := <-commands:
+		if e, g := SessionMessageCancel, command.Type; e != g {
+			t.Errorf("command.Type: expected '%v', got '%v'", e, g)
+		}
+
+		if e, g := uint(42), command.ExecutionID; e != g {
+			t.Errorf("command.ExecutionID: expected '%v', got '%v'", e, g)
+		}
+	case <-ctx.Done():
+		t.Fatal("command not received")
+	}
+
+	heartbeat, err = client.SendHeartbeat(ctx, HeartbeatRequest{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if e, g := uint(2), heartbeat.ID; e != g {
+		t.Errorf("heartbeat.ID: expected '%v', got '%v'", e, g)
+	}
+
+	taskResp, err := client.RequestTask(ctx, Capacity{CPUs: 1, Memory: 1024})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if taskResp != nil {
+		t.Errorf("taskResp: expected nil, got '%v'", taskResp)
+	}
+
+	if _, err := client.UpdateTaskStatus(ctx, 42, "execution-token", TaskStatusRequest{}); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("err: expected '%v', got '%v'", ErrLeaseLost, err)
+	}
+
+	if e, g := 1, len(restCalls); e != g {
+		t.Errorf("len(restCalls): expected '%v', got '%v'", e, g)
+	}
+}
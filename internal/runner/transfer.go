@@ -0,0 +1,70 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// transferAttempts is the number of attempts made for each request
+	// transferring a file
+	transferAttempts   = 5
+	transferMinBackoff = time.Second
+	transferMaxBackoff = 30 * time.Second
+)
+
+// statusError is returned when the server answers a file transfer request
+// with an unexpected status
+type statusError struct {
+	operation string
+	status    int
+}
+
+func newStatusError(operation string, status int) error {
+	return errors.WithStack(&statusError{operation: operation, status: status})
+}
+
+// Error implements error.
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s failed with status %d", e.operation, e.status)
+}
+
+// retryTransfer calls fn until it succeeds, backing off between attempts.
+// The errors which would be returned again, such as the loss of the lease or
+// the rejection of the request, are returned at once.
+func retryTransfer(ctx context.Context, fn func() error) error {
+	backoff := transferMinBackoff
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt == transferAttempts || !isTransient(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, transferMaxBackoff)
+	}
+}
+
+// isTransient returns true if the transfer may succeed when attempted again
+func isTransient(err error) bool {
+	if errors.Is(err, ErrLeaseLost) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.status >= http.StatusInternalServerError || statusErr.status == http.StatusTooManyRequests
+	}
+
+	return true
+}
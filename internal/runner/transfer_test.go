@@ -0,0 +1,190 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestClientUploadOutputFile(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	content := []byte("the content of a large output file")
+
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+
+	var (
+		mutex       sync.Mutex
+		received    bytes.Buffer
+		failures    int
+		completions int
+	)
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /runner/executions/42/outputs/uploads", func(w http.ResponseWriter, r *http.Request) {
+		var req OutputUploadRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("%+v", errors.WithStack(err))
+		}
+
+		if req.Path != "reports/report.txt" || req.Size != int64(len(content)) {
+			t.Errorf("unexpected upload request %+v", req)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(OutputUpload{ID: "upload", Path: req.Path, Size: req.Size, ChunkSize: 8})
+	})
+
+	mux.HandleFunc("PATCH /runner/executions/42/outputs/uploads/upload", func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if e, g := "execution-token", r.Header.Get(runnerTokenHeader); e != g {
+			t.Errorf("runner token: expected '%s', got '%s'", e, g)
+		}
+
+		offset, err := strconv.ParseInt(r.Header.Get(uploadOffsetHeader), 10, 64)
+		if err != nil {
+			t.Errorf("%+v", errors.WithStack(err))
+		}
+
+		if offset != int64(received.Len()) {
+			w.Header().Set(uploadOffsetHeader, strconv.Itoa(received.Len()))
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		chunk, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("%+v", errors.WithStack(err))
+		}
+
+		// The first chunk is lost once, the second one received without
+		// its response reaching the runner
+		failures++
+		switch failures {
+		case 1:
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		case 3:
+			received.Write(chunk)
+			w.Header().Set(uploadOffsetHeader, strconv.Itoa(received.Len()))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		received.Write(chunk)
+		w.Header().Set(uploadOffsetHeader, strconv.Itoa(received.Len()))
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("POST /runner/executions/42/outputs/uploads/upload/complete", func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		var req OutputUploadCompleteRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("%+v", errors.WithStack(err))
+		}
+
+		completions++
+
+		actual := sha256.Sum256(received.Bytes())
+		if req.Checksum != hex.EncodeToString(actual[:]) {
+			received.Reset()
+			w.Header().Set(uploadOffsetHeader, "0")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(OutputUpload{ID: "upload", Completed: true, Checksum: req.Checksum})
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client, err := NewClient(server.URL, "token", server.Client())
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	if err := client.UploadOutputFile(ctx, 42, "execution-token", "reports/report.txt", bytes.NewReader(content), int64(len(content)), checksum); err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	if e, g := string(content), received.String(); e != g {
+		t.Errorf("received content: expected '%s', got '%s'", e, g)
+	}
+
+	if e, g := 1, completions; e != g {
+		t.Errorf("completions: expected '%d', got '%d'", e, g)
+	}
+}
+
+func TestClientDownloadInputFile(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	content := []byte("the content of an input file")
+
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /runner/executions/42/inputs", func(w http.ResponseWriter, r *http.Request) {
+		body := content
+		if r.URL.Query().Get("file") == "corrupted" {
+			body = bytes.ToUpper(content)
+		}
+
+		w.Header().Set(checksumHeader, checksum)
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Write(body)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client, err := NewClient(server.URL, "token", server.Client())
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	var buf bytes.Buffer
+
+	size, err := client.DownloadInputFile(ctx, 42, "execution-token", "valid", &buf)
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	if e, g := int64(len(content)), size; e != g {
+		t.Errorf("size: expected '%d', got '%d'", e, g)
+	}
+
+	if e, g := string(content), buf.String(); e != g {
+		t.Errorf("content: expected '%s', got '%s'", e, g)
+	}
+
+	buf.Reset()
+
+	if _, err := client.DownloadInputFile(ctx, 42, "execution-token", "corrupted", &buf); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expected checksum mismatch, got '%v'", err)
+	}
+}
@@ -0,0 +1,70 @@
+package setup
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/bornholm/oplet/internal/config"
+	"github.com/bornholm/oplet/internal/dispatch"
+	"github.com/bornholm/oplet/internal/slogx"
+	"github.com/pkg/errors"
+)
+
+var getDispatcherFromConfig = createFromConfigOnce(func(ctx context.Context, conf *config.Config) (*dispatch.Dispatcher, error) {
+	notifier, err := newNotifierFromConfig(ctx, conf, dispatch.SignalNewExecutions)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	dispatcher := dispatch.NewDispatcher(notifier, slog.Default())
+
+	go func() {
+		if err := dispatcher.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			slog.ErrorContext(ctx, "dispatcher failed", slogx.Error(errors.WithStack(err)))
+		}
+	}()
+
+	return dispatcher, nil
+})
+
+// getLogNotifierFromConfig returns the notifier of the log streams, whose
+// topics are the executions
+var getLogNotifierFromConfig = createFromConfigOnce(func(ctx context.Context, conf *config.Config) (dispatch.TopicNotifier, error) {
+	switch conf.Execution.Notifier {
+	case config.NotifierMemory:
+		return dispatch.NewTopics(func(topic string) dispatch.Notifier {
+			return dispatch.NewMemoryNotifier()
+		}), nil
+
+	case config.NotifierStore:
+		st, err := getStoreFromConfig(ctx, conf)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		return dispatch.NewTopics(func(topic string) dispatch.Notifier {
+			return dispatch.NewStoreNotifier(st, dispatch.TopicSignal(dispatch.SignalExecutionLogs, topic), conf.Execution.NotifierInterval, slog.Default())
+		}), nil
+
+	default:
+		return nil, errors.Errorf("unknown notifier '%s', must be '%s' or '%s'", conf.Execution.Notifier, config.NotifierMemory, config.NotifierStore)
+	}
+})
+
+func newNotifierFromConfig(ctx context.Context, conf *config.Config, signalID string) (dispatch.Notifier, error) {
+	switch conf.Execution.Notifier {
+	case config.NotifierMemory:
+		return dispatch.NewMemoryNotifier(), nil
+
+	case config.NotifierStore:
+		st, err := getStoreFromConfig(ctx, conf)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		return dispatch.NewStoreNotifier(st, signalID, conf.Execution.NotifierInterval, slog.Default()), nil
+
+	default:
+		return nil, errors.Errorf("unknown notifier '%s', must be '%s' or '%s'", conf.Execution.Notifier, config.NotifierMemory, config.NotifierStore)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/bornholm/oplet/internal/runner"
 	"github.com/bornholm/oplet/internal/slogx"
 	runnerRepository "github.com/bornholm/oplet/internal/store/repository/runner"
+	"github.com/bornholm/oplet/internal/task"
 	"github.com/pkg/errors"
 )
 
@@ -26,7 +27,53 @@ func StartEmbeddedRunner(ctx context.Context, conf *config.Config) error {
 		return errors.Wrap(err, "could not retrieve embedded runner")
 	}
 
-	runner, err := runner.New(conf.Runner.ServerURL, embeddedRunner.Token)
+	runnerOptions := []runner.OptionFunc{
+		runner.WithSlots(conf.Runner.Slots),
+		runner.WithTags(task.ParseTags(conf.Runner.Tags)...),
+		runner.WithAllowedNetworks(task.ParseNetworks(conf.Runner.Networks)...),
+		runner.WithEgress(conf.Runner.EgressNetwork, conf.Runner.EgressProxyURL),
+		runner.WithSession(conf.Runner.Session),
+	}
+
+	if conf.Runner.MaxCPUs != 0 {
+		runnerOptions = append(runnerOptions, runner.WithMaxCPUs(conf.Runner.MaxCPUs))
+	}
+
+	if conf.Runner.MaxMemory != "" {
+		maxMemory, err := task.ParseMemory(conf.Runner.MaxMemory)
+		if err != nil {
+			return errors.Wrap(err, "could not parse embedded runner max memory")
+		}
+
+		runnerOptions = append(runnerOptions, runner.WithMaxMemory(maxMemory))
+	}
+
+	if conf.Runner.MaxFuel != 0 {
+		runnerOptions = append(runnerOptions, runner.WithMaxFuel(conf.Runner.MaxFuel))
+	}
+
+	executor, err := runner.NewExecutor(conf.Runner.Executor, runner.ExecutorConfig{
+		ProcessCommands: conf.Runner.ProcessCommands,
+		KubeConfig:      conf.Runner.KubeConfig,
+		KubeNamespace:   conf.Runner.KubeNamespace,
+		WasmCacheDir:    conf.Runner.WasmCacheDir,
+	}, slog.Default())
+	if err != nil {
+		return errors.Wrap(err, "could not create embedded runner executor")
+	}
+
+	runnerOptions = append(runnerOptions, runner.WithExecutor(executor))
+
+	if conf.Runner.SecurityProfile != "" {
+		securityProfile, err := task.ParseSecurityProfile(conf.Runner.SecurityProfile)
+		if err != nil {
+			return errors.Wrap(err, "could not parse embedded runner security profile")
+		}
+
+		runnerOptions = append(runnerOptions, runner.WithSecurityProfile(securityProfile))
+	}
+
+	runner, err := runner.New(conf.Runner.ServerURL, embeddedRunner.Token, runnerOptions...)
 	if err != nil {
 		slog.ErrorContext(ctx, "could not create runner", slogx.Error(errors.WithStack(err)))
 		os.Exit(1)
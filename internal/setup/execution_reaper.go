@@ -0,0 +1,41 @@
+package setup
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/bornholm/oplet/internal/config"
+	"github.com/bornholm/oplet/internal/reaper"
+	"github.com/bornholm/oplet/internal/slogx"
+	"github.com/pkg/errors"
+)
+
+func StartExecutionReaper(ctx context.Context, conf *config.Config) error {
+	st, err := getStoreFromConfig(ctx, conf)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	dispatcher, err := getDispatcherFromConfig(ctx, conf)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	reaper, err := reaper.New(
+		st,
+		reaper.WithInterval(conf.Execution.ReaperInterval),
+		reaper.WithLeaseDuration(conf.Execution.LeaseDuration),
+		reaper.WithDispatcher(dispatcher),
+	)
+	if err != nil {
+		return errors.Wrap(err, "could not create execution reaper")
+	}
+
+	go func() {
+		if err := reaper.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			slog.ErrorContext(ctx, "execution reaper failed", slogx.Error(errors.WithStack(err)))
+		}
+	}()
+
+	return nil
+}
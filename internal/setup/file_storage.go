@@ -4,22 +4,180 @@ import (
 	"context"
 	"log/slog"
 	"os"
+	"path/filepath"
 
 	"github.com/bornholm/oplet/internal/config"
 	"github.com/bornholm/oplet/internal/file"
+	"github.com/bornholm/oplet/internal/store/repository/blob"
+	"github.com/bornholm/oplet/internal/store/repository/execution"
 	"github.com/pkg/errors"
 )
 
 var getFileStorageFromConfig = createFromConfigOnce(func(ctx context.Context, conf *config.Config) (*file.Storage, error) {
-	if err := ensureDirectory(conf.Storage.File.Dir); err != nil {
+	backend, err := NewFileBackendFromConfig(conf, conf.Storage.File.Backend)
+	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
-	storage := file.NewStorage(conf.Storage.File.Dir, slog.Default())
+	if err := backend.Check(ctx); err != nil {
+		return nil, errors.Wrapf(err, "file storage backend '%s' unavailable", conf.Storage.File.Backend)
+	}
+
+	if conf.Storage.File.Backend == config.FileBackendFilesystem {
+		if err := normalizeLegacyFilePaths(ctx, conf); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	st, err := getStoreFromConfig(ctx, conf)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	funcs := []file.StorageOptionFunc{
+		file.WithTempDir(conf.Storage.File.TempDir),
+	}
+
+	if conf.Storage.File.Backend == config.FileBackendS3 && conf.Storage.File.S3.PresignedDownloads {
+		funcs = append(funcs, file.WithSignedURLs(conf.Storage.File.S3.PresignExpiry))
+	}
+
+	storage := file.NewStorage(backend, blob.NewRepository(st), slog.Default(), funcs...)
 
 	return storage, nil
 })
 
+// NewFileBackendFromConfig creates the file storage backend with the given
+// name, configured from the storage section of the configuration
+func NewFileBackendFromConfig(conf *config.Config, name string) (file.Backend, error) {
+	switch name {
+	case config.FileBackendFilesystem:
+		if err := ensureDirectory(conf.Storage.File.Dir); err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		return file.NewFilesystemBackend(conf.Storage.File.Dir), nil
+
+	case config.FileBackendS3:
+		s3Conf := conf.Storage.File.S3
+
+		backend, err := file.NewS3Backend(file.S3Options{
+			Endpoint:        s3Conf.Endpoint,
+			Region:          s3Conf.Region,
+			Bucket:          s3Conf.Bucket,
+			AccessKeyID:     s3Conf.AccessKeyID,
+			SecretAccessKey: s3Conf.SecretAccessKey,
+			PathStyle:       s3Conf.PathStyle,
+			Prefix:          s3Conf.Prefix,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "could not create s3 file backend")
+		}
+
+		return backend, nil
+
+	default:
+		return nil, errors.Errorf("unknown file storage backend '%s', must be '%s' or '%s'", name, config.FileBackendFilesystem, config.FileBackendS3)
+	}
+}
+
+// MigrateFiles copies the files of the executions from a backend to another,
+// deleting them from the source one if requested, and returns the number of
+// copied files
+func MigrateFiles(ctx context.Context, conf *config.Config, from string, to string, deleteSource bool) (int, error) {
+	if from == to {
+		return 0, errors.Errorf("source and target file storage backends must differ")
+	}
+
+	source, err := NewFileBackendFromConfig(conf, from)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	target, err := NewFileBackendFromConfig(conf, to)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	if err := target.Check(ctx); err != nil {
+		return 0, errors.Wrapf(err, "file storage backend '%s' unavailable", to)
+	}
+
+	// Files stored before the backends were introduced are referenced by
+	// their path on the filesystem
+	if from == config.FileBackendFilesystem {
+		if err := normalizeLegacyFilePaths(ctx, conf); err != nil {
+			return 0, errors.WithStack(err)
+		}
+	}
+
+	var keys []string
+
+	for _, prefix := range []string{file.BlobsPrefix, file.ExecutionsPrefix} {
+		prefixKeys, err := source.List(ctx, prefix)
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+
+		keys = append(keys, prefixKeys...)
+	}
+
+	for i, key := range keys {
+		if err := copyFile(ctx, source, target, key); err != nil {
+			return i, errors.WithStack(err)
+		}
+
+		slog.InfoContext(ctx, "migrated file", slog.String("key", key), slog.Int("progress", i+1), slog.Int("total", len(keys)))
+	}
+
+	if deleteSource {
+		for _, key := range keys {
+			if err := source.Delete(ctx, key); err != nil {
+				return len(keys), errors.Wrapf(err, "could not delete files of backend '%s'", from)
+			}
+		}
+	}
+
+	return len(keys), nil
+}
+
+func copyFile(ctx context.Context, source file.Backend, target file.Backend, key string) error {
+	reader, err := source.Get(ctx, key)
+	if err != nil {
+		return errors.Wrapf(err, "could not read file '%s'", key)
+	}
+
+	defer reader.Close()
+
+	if err := target.Put(ctx, key, reader, -1); err != nil {
+		return errors.Wrapf(err, "could not write file '%s'", key)
+	}
+
+	return nil
+}
+
+// normalizeLegacyFilePaths turns the paths of the files stored in the
+// configured directory by previous versions into storage keys
+func normalizeLegacyFilePaths(ctx context.Context, conf *config.Config) error {
+	st, err := getStoreFromConfig(ctx, conf)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	prefix := filepath.Clean(conf.Storage.File.Dir) + string(filepath.Separator)
+
+	updated, err := execution.NewRepository(st).TrimFilePathPrefix(ctx, prefix)
+	if err != nil {
+		return errors.Wrap(err, "could not normalize legacy file paths")
+	}
+
+	if updated > 0 {
+		slog.InfoContext(ctx, "normalized legacy file paths", slog.Int64("updated", updated))
+	}
+
+	return nil
+}
+
 func ensureDirectory(dirPath string) error {
 	if err := os.MkdirAll(dirPath, 0750); err != nil {
 		return errors.Wrapf(err, "could not ensure directory '%s'", dirPath)
@@ -58,10 +58,25 @@ func NewHTTPServerFromConfig(ctx context.Context, conf *config.Config) (*http.Se
 		return nil, errors.Wrap(err, "could not configure task executor")
 	}
 
-	runner := runner.NewHandler(store, taskProvider, fileStorage, slog.Default())
+	dispatcher, err := getDispatcherFromConfig(ctx, conf)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not configure dispatcher")
+	}
+
+	logNotifier, err := getLogNotifierFromConfig(ctx, conf)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not configure log notifier")
+	}
+
+	janitor, err := getJanitorFromConfig(ctx, conf)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not configure janitor")
+	}
+
+	runner := runner.NewHandler(store, taskProvider, fileStorage, dispatcher, logNotifier, conf.Execution.LeaseDuration, slog.Default())
 	options = append(options, http.WithMount("/runner/", runner))
 
-	webui := webui.NewHandler(store, taskProvider, taskExecutor, fileStorage, slog.Default())
+	webui := webui.NewHandler(store, taskProvider, taskExecutor, fileStorage, dispatcher, logNotifier, janitor, conf.Execution.MaxTimeout, conf.Execution.LeaseDuration, slog.Default())
 	options = append(options, http.WithMount("/", i18nMiddleware(authnMiddleware(authzMiddleware(i18nMiddleware(webui))))))
 
 	options = append(options, http.WithMount("/pprof/", authnMiddleware(pprof.NewHandler())))
@@ -0,0 +1,51 @@
+package setup
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/bornholm/oplet/internal/config"
+	"github.com/bornholm/oplet/internal/janitor"
+	"github.com/bornholm/oplet/internal/slogx"
+	"github.com/pkg/errors"
+)
+
+var getJanitorFromConfig = createFromConfigOnce(func(ctx context.Context, conf *config.Config) (*janitor.Janitor, error) {
+	st, err := getStoreFromConfig(ctx, conf)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	fileStorage, err := getFileStorageFromConfig(ctx, conf)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	janitor, err := janitor.New(
+		st,
+		janitor.WithInterval(conf.Execution.JanitorInterval),
+		janitor.WithRetention(conf.Execution.Retention),
+		janitor.WithDryRun(conf.Execution.JanitorDryRun),
+		janitor.WithFileStorage(fileStorage),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create janitor")
+	}
+
+	return janitor, nil
+})
+
+func StartJanitor(ctx context.Context, conf *config.Config) error {
+	janitor, err := getJanitorFromConfig(ctx, conf)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	go func() {
+		if err := janitor.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			slog.ErrorContext(ctx, "janitor failed", slogx.Error(errors.WithStack(err)))
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,16 @@
+package store
+
+import (
+	"time"
+)
+
+// Blob is a content stored once in the file storage, shared by the execution
+// files having its checksum
+type Blob struct {
+	Checksum  string `gorm:"primarykey"`
+	Size      int64
+	CreatedAt time.Time
+	// Last time the content was stored, the blobs stored recently being kept
+	// until the files referencing them are recorded
+	StoredAt time.Time `gorm:"index"`
+}
@@ -0,0 +1,120 @@
+package blob
+
+import (
+	"context"
+	"time"
+
+	"github.com/bornholm/oplet/internal/store"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// unreferenced filters the blobs without execution file nor completed upload
+// waiting for its execution, soft deleted files still referencing their blob
+const unreferenced = "NOT EXISTS (SELECT 1 FROM task_execution_files WHERE task_execution_files.checksum = blobs.checksum)" +
+	" AND NOT EXISTS (SELECT 1 FROM uploads WHERE uploads.checksum = blobs.checksum)"
+
+// Touch marks the blob with the given checksum as stored again and returns
+// false if it does not exist
+func (r *Repository) Touch(ctx context.Context, checksum string) (bool, error) {
+	var touched bool
+	err := r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		result := db.Model(&store.Blob{}).
+			Where("checksum = ?", checksum).
+			Update("stored_at", time.Now())
+		if result.Error != nil {
+			return errors.WithStack(result.Error)
+		}
+
+		touched = result.RowsAffected > 0
+		return nil
+	})
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return touched, nil
+}
+
+// Register records the blob with the given checksum once its content is
+// stored
+func (r *Repository) Register(ctx context.Context, checksum string, size int64) error {
+	err := r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		now := time.Now()
+
+		err := db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "checksum"}},
+			DoUpdates: clause.Assignments(map[string]any{"stored_at": now}),
+		}).Create(&store.Blob{Checksum: checksum, Size: size, StoredAt: now}).Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// ListUnreferenced returns the blobs stored before the given time which are
+// not referenced by any execution file
+func (r *Repository) ListUnreferenced(ctx context.Context, before time.Time, limit int) ([]*store.Blob, error) {
+	var blobs []*store.Blob
+	err := r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		query := db.Where("stored_at < ?", before).
+			Where(unreferenced).
+			Order("stored_at ASC")
+
+		if limit > 0 {
+			query = query.Limit(limit)
+		}
+
+		if err := query.Find(&blobs).Error; err != nil {
+			return errors.WithStack(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return blobs, nil
+}
+
+// Delete deletes the blob with the given checksum if it is still unreferenced
+// and was not stored again since the given time, calling the given function
+// to delete its content before the deletion is committed. It returns false
+// if the blob was kept.
+func (r *Repository) Delete(ctx context.Context, checksum string, before time.Time, deleteContent func(ctx context.Context) error) (bool, error) {
+	var deleted bool
+	err := r.store.WithTx(ctx, func(ctx context.Context, db *gorm.DB) error {
+		// The row stays locked until the content is deleted, so that a file
+		// storing the same content concurrently waits and stores it again
+		result := db.Where("checksum = ? AND stored_at < ?", checksum, before).
+			Where(unreferenced).
+			Delete(&store.Blob{})
+		if result.Error != nil {
+			return errors.WithStack(result.Error)
+		}
+
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		if err := deleteContent(ctx); err != nil {
+			return errors.WithStack(err)
+		}
+
+		deleted = true
+		return nil
+	})
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return deleted, nil
+}
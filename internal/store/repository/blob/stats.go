@@ -0,0 +1,130 @@
+package blob
+
+import (
+	"context"
+
+	"github.com/bornholm/oplet/internal/store"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+type Stats struct {
+	// Execution files and their total size, as if each one had its own copy
+	Files     int64
+	FilesSize int64
+	// Execution files stored before deduplication, each one having its own
+	// copy
+	LegacyFiles     int64
+	LegacyFilesSize int64
+	// Blobs holding the content of the other files
+	Blobs     int64
+	BlobsSize int64
+	// Blobs not referenced anymore, deleted by the next collection
+	UnreferencedBlobs     int64
+	UnreferencedBlobsSize int64
+}
+
+// StoredSize returns the size actually used in the file storage
+func (s *Stats) StoredSize() int64 {
+	return s.BlobsSize + s.LegacyFilesSize
+}
+
+// Savings returns the size saved by sharing the blobs between the files
+func (s *Stats) Savings() int64 {
+	return s.FilesSize - s.LegacyFilesSize - (s.BlobsSize - s.UnreferencedBlobsSize)
+}
+
+// SharedBlob is a blob referenced by several execution files
+type SharedBlob struct {
+	Checksum   string
+	Size       int64
+	References int64 `gorm:"column:reference_count"`
+}
+
+// Savings returns the size saved by sharing the blob
+func (b *SharedBlob) Savings() int64 {
+	return b.Size * (b.References - 1)
+}
+
+// GetStats returns the usage of the file storage
+func (r *Repository) GetStats(ctx context.Context) (*Stats, error) {
+	stats := &Stats{}
+	err := r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		var files struct {
+			Count       int64
+			Size        int64
+			LegacyCount int64
+			LegacySize  int64
+		}
+
+		err := db.Unscoped().Model(&store.TaskExecutionFile{}).
+			Select(`COUNT(*) AS count, COALESCE(SUM(file_size), 0) AS size,
+				COALESCE(SUM(CASE WHEN checksum = '' OR checksum IS NULL THEN 1 ELSE 0 END), 0) AS legacy_count,
+				COALESCE(SUM(CASE WHEN checksum = '' OR checksum IS NULL THEN file_size ELSE 0 END), 0) AS legacy_size`).
+			Scan(&files).
+			Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		stats.Files = files.Count
+		stats.FilesSize = files.Size
+		stats.LegacyFiles = files.LegacyCount
+		stats.LegacyFilesSize = files.LegacySize
+
+		var blobs struct {
+			Count             int64
+			Size              int64
+			UnreferencedCount int64
+			UnreferencedSize  int64
+		}
+
+		err = db.Model(&store.Blob{}).
+			Select(`COUNT(*) AS count, COALESCE(SUM(size), 0) AS size,
+				COALESCE(SUM(CASE WHEN ` + unreferenced + ` THEN 1 ELSE 0 END), 0) AS unreferenced_count,
+				COALESCE(SUM(CASE WHEN ` + unreferenced + ` THEN size ELSE 0 END), 0) AS unreferenced_size`).
+			Scan(&blobs).
+			Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		stats.Blobs = blobs.Count
+		stats.BlobsSize = blobs.Size
+		stats.UnreferencedBlobs = blobs.UnreferencedCount
+		stats.UnreferencedBlobsSize = blobs.UnreferencedSize
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return stats, nil
+}
+
+// ListMostShared returns the blobs saving the most space, referenced by
+// several execution files
+func (r *Repository) ListMostShared(ctx context.Context, limit int) ([]*SharedBlob, error) {
+	var blobs []*SharedBlob
+	err := r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		err := db.Unscoped().Model(&store.TaskExecutionFile{}).
+			Select("blobs.checksum AS checksum, blobs.size AS size, COUNT(*) AS reference_count").
+			Joins("JOIN blobs ON blobs.checksum = task_execution_files.checksum").
+			Group("blobs.checksum, blobs.size").
+			Having("COUNT(*) > 1").
+			Order("blobs.size * (COUNT(*) - 1) DESC").
+			Limit(limit).
+			Scan(&blobs).
+			Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return blobs, nil
+}
@@ -2,6 +2,7 @@ package execution
 
 import (
 	"context"
+	"slices"
 	"time"
 
 	"github.com/bornholm/oplet/internal/crypto"
@@ -32,7 +33,7 @@ func (r *Repository) Create(ctx context.Context, execution *store.TaskExecution)
 func (r *Repository) GetByID(ctx context.Context, id uint) (*store.TaskExecution, error) {
 	var execution store.TaskExecution
 	err := r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
-		if err := db.Preload("Task").Preload("User").Preload("Logs").Preload("OutputFiles").First(&execution, id).Error; err != nil {
+		if err := db.Preload("Task").Preload("User").Preload("Runner").Preload("Logs").Preload("OutputFiles").First(&execution, id).Error; err != nil {
 			return errors.WithStack(err)
 		}
 		return nil
@@ -47,7 +48,7 @@ func (r *Repository) GetByID(ctx context.Context, id uint) (*store.TaskExecution
 func (r *Repository) GetByIDForUser(ctx context.Context, id uint, userID uint) (*store.TaskExecution, error) {
 	var execution store.TaskExecution
 	err := r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
-		if err := db.Preload("Task").Preload("User").Preload("Logs").Preload("OutputFiles").
+		if err := db.Preload("Task").Preload("User").Preload("Runner").Preload("Logs").Preload("OutputFiles").
 			Where("id = ? AND user_id = ?", id, userID).First(&execution).Error; err != nil {
 			return errors.WithStack(err)
 		}
@@ -266,6 +267,26 @@ func (r *Repository) GetLogs(ctx context.Context, executionID uint, limit, offse
 	return logs, nil
 }
 
+// GetLogsAfter returns at most limit log entries of the execution added after
+// the entry with the given ID, in the order they were added
+func (r *Repository) GetLogsAfter(ctx context.Context, executionID uint, afterID uint, limit int) ([]*store.TaskExecutionLog, error) {
+	var logs []*store.TaskExecutionLog
+	err := r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		query := db.Where("execution_id = ? AND id > ?", executionID, afterID).Order("id ASC")
+		if limit > 0 {
+			query = query.Limit(limit)
+		}
+		if err := query.Find(&logs).Error; err != nil {
+			return errors.WithStack(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
 // File Operations
 
 func (r *Repository) AddFile(ctx context.Context, executionID uint, file *store.TaskExecutionFile) error {
@@ -308,6 +329,22 @@ func (r *Repository) GetFileByPath(ctx context.Context, executionID uint, filena
 
 // Status Operations
 
+// GetProgress returns the execution with only its status and progress
+// loaded, without its relations
+func (r *Repository) GetProgress(ctx context.Context, executionID uint) (*store.TaskExecution, error) {
+	var execution store.TaskExecution
+	err := r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		if err := db.Select("id", "status", "started_at", "progress", "progress_step", "progress_updated_at").First(&execution, executionID).Error; err != nil {
+			return errors.WithStack(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &execution, nil
+}
+
 func (r *Repository) UpdateStatus(ctx context.Context, executionID uint, status store.TaskExecutionStatus) error {
 	return r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
 		if err := db.Model(&store.TaskExecution{}).Where("id = ?", executionID).Update("status", status).Error; err != nil {
@@ -317,6 +354,85 @@ func (r *Repository) UpdateStatus(ctx context.Context, executionID uint, status
 	})
 }
 
+// UpdateProgress records the progress reported by the task of the execution
+func (r *Repository) UpdateProgress(ctx context.Context, executionID uint, percent float64, step string) error {
+	return r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		err := db.Model(&store.TaskExecution{}).Where("id = ?", executionID).Updates(map[string]any{
+			"progress":            percent,
+			"progress_step":       step,
+			"progress_updated_at": time.Now(),
+		}).Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return nil
+	})
+}
+
+// UpdateResult records the result written by the task of the execution,
+// as JSON
+func (r *Repository) UpdateResult(ctx context.Context, executionID uint, result string) error {
+	return r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		err := db.Model(&store.TaskExecution{}).Where("id = ?", executionID).Update("result", result).Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return nil
+	})
+}
+
+var finalStatuses = []store.TaskExecutionStatus{
+	store.StatusSucceeded,
+	store.StatusFailed,
+	store.StatusKilled,
+	store.StatusTimedOut,
+}
+
+// IsFinal returns true if the execution reached a final status
+func IsFinal(execution *store.TaskExecution) bool {
+	return slices.Contains(finalStatuses, execution.Status)
+}
+
+var failedStatuses = []store.TaskExecutionStatus{
+	store.StatusFailed,
+	store.StatusTimedOut,
+}
+
+// Cancel requests the cancellation of an execution.
+// Pending executions are killed straight away, claimed ones are flagged
+// so that their runner can stop them.
+func (r *Repository) Cancel(ctx context.Context, executionID uint) error {
+	return r.store.WithTx(ctx, func(ctx context.Context, db *gorm.DB) error {
+		now := time.Now()
+
+		result := db.Model(&store.TaskExecution{}).
+			Where("id = ? AND started_at IS NULL AND status = ?", executionID, store.StatusPending).
+			Updates(map[string]interface{}{
+				"status":        store.StatusKilled,
+				"canceled_at":   now,
+				"finished_at":   now,
+				"error_message": "execution canceled",
+			})
+		if result.Error != nil {
+			return errors.WithStack(result.Error)
+		}
+
+		if result.RowsAffected > 0 {
+			return nil
+		}
+
+		err := db.Model(&store.TaskExecution{}).
+			Where("id = ? AND canceled_at IS NULL AND status NOT IN ?", executionID, finalStatuses).
+			Update("canceled_at", now).
+			Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		return nil
+	})
+}
+
 func (r *Repository) SetCompleted(ctx context.Context, executionID uint, exitCode int, errorMsg string) error {
 	return r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
 		updates := map[string]interface{}{
@@ -363,7 +479,7 @@ func (r *Repository) GetExecutionStats(ctx context.Context, taskID uint) (*Execu
 		}
 
 		// Failed runs
-		if err := db.Model(&store.TaskExecution{}).Where("task_id = ? AND status = ?", taskID, store.StatusFailed).Count(&stats.FailedRuns).Error; err != nil {
+		if err := db.Model(&store.TaskExecution{}).Where("task_id = ? AND status IN ?", taskID, failedStatuses).Count(&stats.FailedRuns).Error; err != nil {
 			return errors.WithStack(err)
 		}
 
@@ -407,7 +523,7 @@ func (r *Repository) GetExecutionStatsForUser(ctx context.Context, taskID uint,
 		}
 
 		// Failed runs
-		if err := db.Model(&store.TaskExecution{}).Where("task_id = ? AND user_id = ? AND status = ?", taskID, userID, store.StatusFailed).Count(&stats.FailedRuns).Error; err != nil {
+		if err := db.Model(&store.TaskExecution{}).Where("task_id = ? AND user_id = ? AND status IN ?", taskID, userID, failedStatuses).Count(&stats.FailedRuns).Error; err != nil {
 			return errors.WithStack(err)
 		}
 
@@ -486,38 +602,6 @@ func (r *Repository) CountByStatus(ctx context.Context, status store.TaskExecuti
 	return count, nil
 }
 
-// Cleanup Operations
-
-func (r *Repository) CleanupOldExecutions(ctx context.Context, olderThan time.Time) error {
-	return r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
-		// Delete old logs first (foreign key constraint)
-		if err := db.Where("execution_id IN (SELECT id FROM task_executions WHERE created_at < ?)", olderThan).Delete(&store.TaskExecutionLog{}).Error; err != nil {
-			return errors.WithStack(err)
-		}
-
-		// Delete old files
-		if err := db.Where("execution_id IN (SELECT id FROM task_executions WHERE created_at < ?)", olderThan).Delete(&store.TaskExecutionFile{}).Error; err != nil {
-			return errors.WithStack(err)
-		}
-
-		// Delete old executions
-		if err := db.Where("created_at < ?", olderThan).Delete(&store.TaskExecution{}).Error; err != nil {
-			return errors.WithStack(err)
-		}
-
-		return nil
-	})
-}
-
-func (r *Repository) CleanupOrphanedLogs(ctx context.Context) error {
-	return r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
-		if err := db.Where("execution_id NOT IN (SELECT id FROM task_executions)").Delete(&store.TaskExecutionLog{}).Error; err != nil {
-			return errors.WithStack(err)
-		}
-		return nil
-	})
-}
-
 // Search and Filter Operations
 
 func (r *Repository) SearchExecutions(ctx context.Context, filters ExecutionFilters, limit, offset int) ([]*store.TaskExecution, error) {
@@ -0,0 +1,184 @@
+package execution
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bornholm/oplet/internal/crypto"
+	"github.com/bornholm/oplet/internal/store"
+	"github.com/bornholm/oplet/internal/task"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// HoldsLease returns true if the runner still holds the lease of the execution,
+// the given token being the one generated when it claimed the execution
+func HoldsLease(runner *store.Runner, execution *store.TaskExecution, runnerToken string) bool {
+	return execution.RunnerID != nil && *execution.RunnerID == runner.ID &&
+		execution.LeaseExpiresAt != nil &&
+		runnerToken != "" && execution.RunnerToken == runnerToken
+}
+
+// RenewLease extends the lease of a claimed execution
+func (r *Repository) RenewLease(ctx context.Context, executionID uint, expiresAt time.Time) error {
+	return r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		err := db.Model(&store.TaskExecution{}).
+			Where("id = ? AND lease_expires_at IS NOT NULL", executionID).
+			UpdateColumn("lease_expires_at", expiresAt).
+			Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return nil
+	})
+}
+
+// RenewRunnerLeases extends the leases of the given unfinished executions held
+// by the runner. The executions it does not report anymore are left to expire.
+func (r *Repository) RenewRunnerLeases(ctx context.Context, runnerID uint, executionIDs []uint, expiresAt time.Time) error {
+	if len(executionIDs) == 0 {
+		return nil
+	}
+
+	return r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		err := db.Model(&store.TaskExecution{}).
+			Where("id IN ? AND runner_id = ? AND lease_expires_at IS NOT NULL AND status NOT IN ?", executionIDs, runnerID, finalStatuses).
+			UpdateColumn("lease_expires_at", expiresAt).
+			Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return nil
+	})
+}
+
+// ReclaimExpired reclaims the unfinished executions whose lease expired.
+// Executions allowing it are put back in the queue, the other ones are failed.
+// Executions claimed without a lease are reclaimed once they are not updated
+// for the lease duration.
+func (r *Repository) ReclaimExpired(ctx context.Context, leaseDuration time.Duration) ([]*store.TaskExecution, error) {
+	var reclaimed []*store.TaskExecution
+	err := r.store.WithTx(ctx, func(ctx context.Context, db *gorm.DB) error {
+		now := time.Now()
+
+		var expired []*store.TaskExecution
+
+		err := db.Preload("Runner").
+			Where("started_at IS NOT NULL AND status NOT IN ?", finalStatuses).
+			Where(
+				db.Where("lease_expires_at < ?", now).
+					Or("lease_expires_at IS NULL AND runner_id IS NULL AND updated_at < ?", now.Add(-leaseDuration)),
+			).
+			Find(&expired).
+			Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		for _, e := range expired {
+			runnerName := "unknown runner"
+			if e.Runner != nil {
+				runnerName = fmt.Sprintf("runner '%s'", e.Runner.Name)
+			}
+
+			var (
+				updates map[string]interface{}
+				message string
+			)
+
+			switch {
+			case e.CanceledAt != nil:
+				updates = map[string]interface{}{
+					"status":           store.StatusKilled,
+					"error_message":    "execution canceled",
+					"finished_at":      now,
+					"lease_expires_at": nil,
+				}
+				message = fmt.Sprintf("Lease expired, %s disappeared: execution killed", runnerName)
+
+			case e.Requeue:
+				token, err := crypto.RandomToken(tokenSize)
+				if err != nil {
+					return errors.WithStack(err)
+				}
+
+				updates = map[string]interface{}{
+					"status":                 store.StatusPending,
+					"started_at":             nil,
+					"finished_at":            nil,
+					"exit_code":              nil,
+					"error_message":          "",
+					"error_type":             "",
+					"container_id":           "",
+					"effective_cpu_limit":    0,
+					"effective_memory_limit": 0,
+					"security_profile":       "",
+					"runner_id":              nil,
+					"runner_token":           token,
+					"lease_expires_at":       nil,
+				}
+				message = fmt.Sprintf("Lease expired, %s disappeared: execution requeued", runnerName)
+
+			default:
+				updates = map[string]interface{}{
+					"status":           store.StatusFailed,
+					"error_message":    fmt.Sprintf("%s disappeared while holding the execution", runnerName),
+					"error_type":       string(task.ErrorTypeRunnerLost),
+					"finished_at":      now,
+					"lease_expires_at": nil,
+				}
+				message = fmt.Sprintf("Lease expired, %s disappeared: execution failed", runnerName)
+			}
+
+			if err := db.Model(&store.TaskExecution{}).Where("id = ?", e.ID).Updates(updates).Error; err != nil {
+				return errors.WithStack(err)
+			}
+
+			log := &store.TaskExecutionLog{
+				ExecutionID: e.ID,
+				Timestamp:   now.UnixMicro(),
+				Source:      "system",
+				Message:     message,
+				Clock:       uint(now.UnixMicro()),
+			}
+
+			if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(log).Error; err != nil {
+				return errors.WithStack(err)
+			}
+
+			e.Status = updates["status"].(store.TaskExecutionStatus)
+
+			reclaimed = append(reclaimed, e)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return reclaimed, nil
+}
+
+// ListCanceled returns the identifiers of the unfinished executions held by
+// the given runner whose cancellation was requested
+func (r *Repository) ListCanceled(ctx context.Context, runnerID uint) ([]uint, error) {
+	var ids []uint
+	err := r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		err := db.Model(&store.TaskExecution{}).
+			Where("runner_id = ? AND canceled_at IS NOT NULL AND status NOT IN ?", runnerID, finalStatuses).
+			Pluck("id", &ids).
+			Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,356 @@
+package execution
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/bornholm/oplet/internal/store"
+	"github.com/bornholm/oplet/internal/task"
+)
+
+func TestReclaimExpired(t *testing.T) {
+	type testCase struct {
+		name              string
+		lease             *time.Duration
+		claimed           bool
+		started           bool
+		updated           time.Duration
+		canceled          bool
+		requeue           bool
+		status            store.TaskExecutionStatus
+		expectedReclaimed bool
+		expectedStatus    store.TaskExecutionStatus
+		expectedErrorType string
+	}
+
+	expired := -time.Minute
+	valid := time.Minute
+
+	testCases := []testCase{
+		{
+			name:              "expired lease failed",
+			lease:             &expired,
+			claimed:           true,
+			started:           true,
+			status:            store.StatusRunning,
+			expectedReclaimed: true,
+			expectedStatus:    store.StatusFailed,
+			expectedErrorType: string(task.ErrorTypeRunnerLost),
+		},
+		{
+			name:              "expired lease requeued",
+			lease:             &expired,
+			claimed:           true,
+			started:           true,
+			requeue:           true,
+			status:            store.StatusRunning,
+			expectedReclaimed: true,
+			expectedStatus:    store.StatusPending,
+		},
+		{
+			name:              "expired lease of a canceled execution",
+			lease:             &expired,
+			claimed:           true,
+			started:           true,
+			canceled:          true,
+			requeue:           true,
+			status:            store.StatusRunning,
+			expectedReclaimed: true,
+			expectedStatus:    store.StatusKilled,
+		},
+		{
+			name:           "valid lease",
+			lease:          &valid,
+			claimed:        true,
+			started:        true,
+			status:         store.StatusRunning,
+			expectedStatus: store.StatusRunning,
+		},
+		{
+			name:           "finished execution",
+			lease:          &expired,
+			claimed:        true,
+			started:        true,
+			status:         store.StatusSucceeded,
+			expectedStatus: store.StatusSucceeded,
+		},
+		{
+			name:           "pending execution",
+			status:         store.StatusPending,
+			updated:        -time.Hour,
+			expectedStatus: store.StatusPending,
+		},
+		{
+			name:              "started without lease nor update",
+			started:           true,
+			updated:           -time.Hour,
+			status:            store.StatusRunning,
+			expectedReclaimed: true,
+			expectedStatus:    store.StatusFailed,
+			expectedErrorType: string(task.ErrorTypeRunnerLost),
+		},
+		{
+			name:           "started without lease recently updated",
+			started:        true,
+			status:         store.StatusRunning,
+			expectedStatus: store.StatusRunning,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			repo, db := newTestRepository(ctx, t)
+
+			task := &store.Task{ImageRef: "task:1"}
+			if err := db.Create(task).Error; err != nil {
+				t.Fatalf("%+v", errors.WithStack(err))
+			}
+
+			runner := &store.Runner{Name: "runner", Token: "runner-token"}
+			if err := db.Create(runner).Error; err != nil {
+				t.Fatalf("%+v", errors.WithStack(err))
+			}
+
+			now := time.Now()
+
+			execution := &store.TaskExecution{
+				TaskID:      task.ID,
+				Status:      tc.status,
+				RunnerToken: "token",
+				Requeue:     tc.requeue,
+			}
+
+			if tc.claimed {
+				execution.RunnerID = &runner.ID
+			}
+
+			if tc.lease != nil {
+				leaseExpiresAt := now.Add(*tc.lease)
+				execution.LeaseExpiresAt = &leaseExpiresAt
+			}
+
+			if tc.started {
+				execution.StartedAt = &now
+			}
+
+			if tc.canceled {
+				execution.CanceledAt = &now
+			}
+
+			if err := db.Create(execution).Error; err != nil {
+				t.Fatalf("%+v", errors.WithStack(err))
+			}
+
+			if tc.updated != 0 {
+				err := db.Model(execution).UpdateColumn("updated_at", now.Add(tc.updated)).Error
+				if err != nil {
+					t.Fatalf("%+v", errors.WithStack(err))
+				}
+			}
+
+			reclaimed, err := repo.ReclaimExpired(ctx, 2*time.Minute)
+			if err != nil {
+				t.Fatalf("%+v", errors.WithStack(err))
+			}
+
+			if e, g := tc.expectedReclaimed, len(reclaimed) == 1; e != g {
+				t.Fatalf("reclaimed: expected %v, got %d executions", e, len(reclaimed))
+			}
+
+			if tc.expectedReclaimed {
+				if e, g := tc.expectedStatus, reclaimed[0].Status; e != g {
+					t.Errorf("reclaimed[0].Status: expected '%s', got '%s'", e, g)
+				}
+			}
+
+			var stored store.TaskExecution
+			if err := db.First(&stored, execution.ID).Error; err != nil {
+				t.Fatalf("%+v", errors.WithStack(err))
+			}
+
+			if e, g := tc.expectedStatus, stored.Status; e != g {
+				t.Errorf("stored.Status: expected '%s', got '%s'", e, g)
+			}
+
+			if !tc.expectedReclaimed {
+				return
+			}
+
+			if e, g := tc.expectedErrorType, stored.ErrorType; e != g {
+				t.Errorf("stored.ErrorType: expected '%s', got '%s'", e, g)
+			}
+
+			if stored.LeaseExpiresAt != nil {
+				t.Errorf("stored.LeaseExpiresAt: expected nil, got %v", *stored.LeaseExpiresAt)
+			}
+
+			switch tc.expectedStatus {
+			case store.StatusPending:
+				if stored.RunnerID != nil || stored.StartedAt != nil {
+					t.Errorf("expected the requeued execution to be released, got runner %v started at %v", stored.RunnerID, stored.StartedAt)
+				}
+
+				if stored.RunnerToken == execution.RunnerToken {
+					t.Errorf("expected the runner token of the requeued execution to be regenerated")
+				}
+
+			default:
+				if stored.FinishedAt == nil {
+					t.Errorf("stored.FinishedAt: expected a time, got nil")
+				}
+			}
+
+			var logs int64
+			if err := db.Model(&store.TaskExecutionLog{}).Where("execution_id = ? AND source = ?", execution.ID, "system").Count(&logs).Error; err != nil {
+				t.Fatalf("%+v", errors.WithStack(err))
+			}
+
+			if e, g := int64(1), logs; e != g {
+				t.Errorf("system logs: expected %d, got %d", e, g)
+			}
+		})
+	}
+}
+
+func TestRenewRunnerLeases(t *testing.T) {
+	ctx := context.Background()
+
+	repo, db := newTestRepository(ctx, t)
+
+	task := &store.Task{ImageRef: "task:1"}
+	if err := db.Create(task).Error; err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	runners := []*store.Runner{
+		{Name: "runner", Token: "runner-token"},
+		{Name: "other", Token: "other-token"},
+	}
+	if err := db.Create(runners).Error; err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	type execution struct {
+		name     string
+		runner   int
+		status   store.TaskExecutionStatus
+		reported bool
+		renewed  bool
+	}
+
+	executions := []execution{
+		{name: "reported", status: store.StatusRunning, reported: true, renewed: true},
+		{name: "not reported", status: store.StatusRunning},
+		{name: "reported but finished", status: store.StatusSucceeded, reported: true},
+		{name: "reported but held by another runner", runner: 1, status: store.StatusRunning, reported: true},
+	}
+
+	leaseExpiresAt := time.Now().Add(time.Minute).Truncate(time.Second)
+
+	ids := make([]uint, 0, len(executions))
+	reported := make([]uint, 0)
+
+	for i, e := range executions {
+		record := &store.TaskExecution{
+			TaskID:         task.ID,
+			Status:         e.status,
+			RunnerToken:    fmt.Sprintf("token-%d", i),
+			RunnerID:       &runners[e.runner].ID,
+			LeaseExpiresAt: &leaseExpiresAt,
+		}
+
+		if err := db.Create(record).Error; err != nil {
+			t.Fatalf("%+v", errors.WithStack(err))
+		}
+
+		ids = append(ids, record.ID)
+
+		if e.reported {
+			reported = append(reported, record.ID)
+		}
+	}
+
+	renewedAt := leaseExpiresAt.Add(time.Hour)
+
+	if err := repo.RenewRunnerLeases(ctx, runners[0].ID, reported, renewedAt); err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	for i, e := range executions {
+		var stored store.TaskExecution
+		if err := db.First(&stored, ids[i]).Error; err != nil {
+			t.Fatalf("%+v", errors.WithStack(err))
+		}
+
+		expected := leaseExpiresAt
+		if e.renewed {
+			expected = renewedAt
+		}
+
+		if g := *stored.LeaseExpiresAt; !expected.Equal(g) {
+			t.Errorf("execution '%s' lease: expected %v, got %v", e.name, expected, g)
+		}
+	}
+}
+
+func TestHoldsLease(t *testing.T) {
+	runnerID := uint(1)
+	otherRunnerID := uint(2)
+	leaseExpiresAt := time.Now()
+
+	runner := &store.Runner{}
+	runner.ID = runnerID
+
+	type testCase struct {
+		name        string
+		execution   store.TaskExecution
+		runnerToken string
+		expected    bool
+	}
+
+	testCases := []testCase{
+		{
+			name:        "current claim",
+			execution:   store.TaskExecution{RunnerID: &runnerID, LeaseExpiresAt: &leaseExpiresAt, RunnerToken: "token"},
+			runnerToken: "token",
+			expected:    true,
+		},
+		{
+			name:        "previous claim",
+			execution:   store.TaskExecution{RunnerID: &runnerID, LeaseExpiresAt: &leaseExpiresAt, RunnerToken: "token"},
+			runnerToken: "previous-token",
+			expected:    false,
+		},
+		{
+			name:      "missing token",
+			execution: store.TaskExecution{RunnerID: &runnerID, LeaseExpiresAt: &leaseExpiresAt, RunnerToken: "token"},
+			expected:  false,
+		},
+		{
+			name:        "held by another runner",
+			execution:   store.TaskExecution{RunnerID: &otherRunnerID, LeaseExpiresAt: &leaseExpiresAt, RunnerToken: "token"},
+			runnerToken: "token",
+			expected:    false,
+		},
+		{
+			name:        "released",
+			execution:   store.TaskExecution{RunnerToken: "token"},
+			runnerToken: "token",
+			expected:    false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if e, g := tc.expected, HoldsLease(runner, &tc.execution, tc.runnerToken); e != g {
+				t.Errorf("HoldsLease: expected %v, got %v", e, g)
+			}
+		})
+	}
+}
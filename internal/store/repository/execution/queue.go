@@ -6,6 +6,7 @@ import (
 
 	"github.com/bornholm/oplet/internal/crypto"
 	"github.com/bornholm/oplet/internal/store"
+	"github.com/bornholm/oplet/internal/task"
 	"github.com/pkg/errors"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
@@ -13,14 +14,56 @@ import (
 
 const tokenSize int = 32
 
-func (r *Repository) NextTask(ctx context.Context) (*store.TaskExecution, error) {
+// Capacity is the remaining capacity of a runner
+type Capacity struct {
+	CPUs   float64
+	Memory int64
+}
+
+// Fits returns true if the resources requested by the execution fit
+// in the capacity. A nil capacity fits any execution.
+func (c *Capacity) Fits(execution *store.TaskExecution) bool {
+	if c == nil {
+		return true
+	}
+
+	return execution.CPURequest <= c.CPUs && execution.MemoryRequest <= c.Memory
+}
+
+// NextTask claims the oldest pending execution the given runner is able to handle
+// within its remaining capacity, if known, and grants it a lease on the execution
+func (r *Repository) NextTask(ctx context.Context, runner *store.Runner, capacity *Capacity, leaseDuration time.Duration) (*store.TaskExecution, error) {
 	var execution store.TaskExecution
 	err := r.store.WithTx(ctx, func(ctx context.Context, db *gorm.DB) error {
-		err := db.Model(&execution).
+		var candidates []*store.TaskExecution
+
+		err := db.Model(&store.TaskExecution{}).
+			Select("id", "runner_tags", "cpu_request", "memory_request").
+			Where("started_at is null AND status = ?", store.StatusPending).
+			Where("scheduled_at is null OR scheduled_at <= ?", time.Now()).
+			Order("created_at ASC").
+			Find(&candidates).
+			Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		var executionID uint
+		for _, c := range candidates {
+			if CanClaim(runner, c) && capacity.Fits(c) {
+				executionID = c.ID
+				break
+			}
+		}
+
+		if executionID == 0 {
+			return errors.WithStack(gorm.ErrRecordNotFound)
+		}
+
+		err = db.Model(&execution).
 			Preload(clause.Associations).
 			Preload("Task.Configurations").
-			Where("started_at is null").
-			Order("created_at ASC").
+			Where("id = ? AND started_at is null AND status = ?", executionID, store.StatusPending).
 			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
 			First(&execution).
 			Error
@@ -29,8 +72,11 @@ func (r *Repository) NextTask(ctx context.Context) (*store.TaskExecution, error)
 		}
 
 		now := time.Now()
+		leaseExpiresAt := now.Add(leaseDuration)
 
 		execution.StartedAt = &now
+		execution.RunnerID = &runner.ID
+		execution.LeaseExpiresAt = &leaseExpiresAt
 
 		token, err := crypto.RandomToken(tokenSize)
 		if err != nil {
@@ -50,3 +96,67 @@ func (r *Repository) NextTask(ctx context.Context) (*store.TaskExecution, error)
 
 	return &execution, nil
 }
+
+// RunnerTags returns the tags defined by an administrator and
+// reported by the runner itself
+func RunnerTags(runner *store.Runner) []string {
+	return task.ParseTags(runner.Tags + "," + runner.ReportedTags)
+}
+
+// CanClaim returns true if the runner is able to handle the execution
+func CanClaim(runner *store.Runner, execution *store.TaskExecution) bool {
+	return task.MatchTags(task.ParseTags(execution.RunnerTags), RunnerTags(runner))
+}
+
+// MaxCapacity returns the maximum capacity reported by the runner,
+// nil if it did not report it
+func MaxCapacity(runner *store.Runner) *Capacity {
+	if runner.MaxCPUs <= 0 && runner.MaxMemory <= 0 {
+		return nil
+	}
+
+	return &Capacity{CPUs: runner.MaxCPUs, Memory: runner.MaxMemory}
+}
+
+// CanEverClaim returns true if the runner is able to handle the execution
+// once its running executions completed
+func CanEverClaim(runner *store.Runner, execution *store.TaskExecution) bool {
+	return CanClaim(runner, execution) && MaxCapacity(runner).Fits(execution)
+}
+
+// ListPending returns the executions waiting to be claimed by a runner
+func (r *Repository) ListPending(ctx context.Context) ([]*store.TaskExecution, error) {
+	var executions []*store.TaskExecution
+	err := r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		err := db.Preload("Task").Preload("User").
+			Where("started_at is null AND status = ?", store.StatusPending).
+			Order("created_at ASC").
+			Find(&executions).
+			Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return executions, nil
+}
+
+// GetClaimed retrieves an execution already claimed by a runner,
+// without its logs and files
+func (r *Repository) GetClaimed(ctx context.Context, executionID uint) (*store.TaskExecution, error) {
+	var execution store.TaskExecution
+	err := r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		if err := db.Where("id = ? AND started_at IS NOT NULL", executionID).First(&execution).Error; err != nil {
+			return errors.WithStack(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &execution, nil
+}
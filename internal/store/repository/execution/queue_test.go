@@ -0,0 +1,225 @@
+package execution
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+
+	"github.com/bornholm/oplet/internal/store"
+)
+
+func TestCanClaim(t *testing.T) {
+	type testCase struct {
+		name         string
+		runnerTags   string
+		reportedTags string
+		required     string
+		expected     bool
+	}
+
+	testCases := []testCase{
+		{name: "no required tags", runnerTags: "", required: "", expected: true},
+		{name: "no required tags on a tagged runner", runnerTags: "gpu", required: "", expected: true},
+		{name: "required tag defined by an administrator", runnerTags: "gpu, zone=dmz", required: "gpu", expected: true},
+		{name: "required tag reported by the runner", reportedTags: "arch=arm64", required: "arch=arm64", expected: true},
+		{name: "required tags from both sources", runnerTags: "zone=dmz", reportedTags: "arch=arm64", required: "arch=arm64 zone=dmz", expected: true},
+		{name: "required tags case insensitive", runnerTags: "GPU", required: "gpu", expected: true},
+		{name: "excluded without the required tag", runnerTags: "docker", required: "gpu", expected: false},
+		{name: "excluded with a part of the required tags", runnerTags: "gpu", required: "gpu,zone=dmz", expected: false},
+		{name: "excluded without tags", required: "gpu", expected: false},
+		{name: "excluded with another tag value", reportedTags: "arch=amd64", required: "arch=arm64", expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			runner := &store.Runner{Tags: tc.runnerTags, ReportedTags: tc.reportedTags}
+			execution := &store.TaskExecution{RunnerTags: tc.required}
+
+			if e, g := tc.expected, CanClaim(runner, execution); e != g {
+				t.Errorf("CanClaim: expected %v, got %v", e, g)
+			}
+		})
+	}
+}
+
+func TestNextTaskRouting(t *testing.T) {
+	type execution struct {
+		name      string
+		tags      string
+		scheduled time.Duration
+	}
+
+	type testCase struct {
+		name       string
+		runnerTags string
+		executions []execution
+		expected   string
+	}
+
+	testCases := []testCase{
+		{
+			name: "oldest execution first",
+			executions: []execution{
+				{name: "first"},
+				{name: "second"},
+			},
+			expected: "first",
+		},
+		{
+			name: "executions requiring missing tags skipped",
+			executions: []execution{
+				{name: "gpu", tags: "gpu"},
+				{name: "untagged"},
+			},
+			expected: "untagged",
+		},
+		{
+			name:       "execution requiring the runner tags",
+			runnerTags: "gpu,zone=dmz",
+			executions: []execution{
+				{name: "arm", tags: "arch=arm64"},
+				{name: "gpu", tags: "gpu zone=dmz"},
+			},
+			expected: "gpu",
+		},
+		{
+			name:       "no execution matching the runner tags",
+			runnerTags: "docker",
+			executions: []execution{
+				{name: "gpu", tags: "gpu"},
+				{name: "arm", tags: "arch=arm64"},
+			},
+		},
+		{
+			name: "retries not due yet skipped",
+			executions: []execution{
+				{name: "scheduled", scheduled: time.Hour},
+				{name: "due", scheduled: -time.Minute},
+			},
+			expected: "due",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			repo, db := newTestRepository(ctx, t)
+
+			task := &store.Task{ImageRef: "task:1"}
+			if err := db.Create(task).Error; err != nil {
+				t.Fatalf("%+v", errors.WithStack(err))
+			}
+
+			runner := &store.Runner{Name: "runner", Token: "runner-token", Tags: tc.runnerTags}
+			if err := db.Create(runner).Error; err != nil {
+				t.Fatalf("%+v", errors.WithStack(err))
+			}
+
+			names := make(map[uint]string, len(tc.executions))
+			createdAt := time.Now().Add(-time.Hour)
+
+			for i, e := range tc.executions {
+				record := &store.TaskExecution{
+					TaskID:      task.ID,
+					Status:      store.StatusPending,
+					RunnerToken: fmt.Sprintf("token-%d", i),
+					RunnerTags:  e.tags,
+				}
+
+				record.CreatedAt = createdAt.Add(time.Duration(i) * time.Minute)
+
+				if e.scheduled != 0 {
+					scheduledAt := time.Now().Add(e.scheduled)
+					record.ScheduledAt = &scheduledAt
+				}
+
+				if err := db.Create(record).Error; err != nil {
+					t.Fatalf("%+v", errors.WithStack(err))
+				}
+
+				names[record.ID] = e.name
+			}
+
+			claimed, err := repo.NextTask(ctx, runner, nil, time.Minute)
+			if tc.expected == "" {
+				if !errors.Is(err, gorm.ErrRecordNotFound) {
+					t.Errorf("expected no execution, got '%v' (%v)", claimed, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("%+v", errors.WithStack(err))
+			}
+
+			if e, g := tc.expected, names[claimed.ID]; e != g {
+				t.Errorf("claimed execution: expected '%s', got '%s'", e, g)
+			}
+
+			if claimed.RunnerID == nil || *claimed.RunnerID != runner.ID {
+				t.Errorf("claimed.RunnerID: expected %d, got %v", runner.ID, claimed.RunnerID)
+			}
+
+			if claimed.LeaseExpiresAt == nil {
+				t.Errorf("claimed.LeaseExpiresAt: expected a time, got nil")
+			}
+		})
+	}
+}
+
+func TestCapacityFits(t *testing.T) {
+	type testCase struct {
+		name      string
+		capacity  *Capacity
+		execution store.TaskExecution
+		expected  bool
+	}
+
+	testCases := []testCase{
+		{name: "unknown capacity", capacity: nil, execution: store.TaskExecution{CPURequest: 64, MemoryRequest: 1 << 40}, expected: true},
+		{name: "no requests", capacity: &Capacity{}, execution: store.TaskExecution{}, expected: true},
+		{name: "within the capacity", capacity: &Capacity{CPUs: 2, Memory: 1 << 30}, execution: store.TaskExecution{CPURequest: 0.5, MemoryRequest: 512 << 20}, expected: true},
+		{name: "exactly the capacity", capacity: &Capacity{CPUs: 2, Memory: 1 << 30}, execution: store.TaskExecution{CPURequest: 2, MemoryRequest: 1 << 30}, expected: true},
+		{name: "too many cpus", capacity: &Capacity{CPUs: 2, Memory: 1 << 30}, execution: store.TaskExecution{CPURequest: 2.5}, expected: false},
+		{name: "too much memory", capacity: &Capacity{CPUs: 2, Memory: 1 << 30}, execution: store.TaskExecution{MemoryRequest: 1<<30 + 1}, expected: false},
+		{name: "exhausted capacity", capacity: &Capacity{}, execution: store.TaskExecution{CPURequest: 0.1}, expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if e, g := tc.expected, tc.capacity.Fits(&tc.execution); e != g {
+				t.Errorf("Fits: expected %v, got %v", e, g)
+			}
+		})
+	}
+}
+
+func TestCanEverClaim(t *testing.T) {
+	type testCase struct {
+		name      string
+		runner    store.Runner
+		execution store.TaskExecution
+		expected  bool
+	}
+
+	testCases := []testCase{
+		{name: "capacity not reported", runner: store.Runner{}, execution: store.TaskExecution{CPURequest: 64}, expected: true},
+		{name: "within the maximum capacity", runner: store.Runner{MaxCPUs: 4, MaxMemory: 8 << 30}, execution: store.TaskExecution{CPURequest: 4, MemoryRequest: 8 << 30}, expected: true},
+		{name: "more cpus than the maximum", runner: store.Runner{MaxCPUs: 4, MaxMemory: 8 << 30}, execution: store.TaskExecution{CPURequest: 8}, expected: false},
+		{name: "more memory than the maximum", runner: store.Runner{MaxCPUs: 4, MaxMemory: 8 << 30}, execution: store.TaskExecution{MemoryRequest: 16 << 30}, expected: false},
+		{name: "missing required tag", runner: store.Runner{MaxCPUs: 4, MaxMemory: 8 << 30}, execution: store.TaskExecution{RunnerTags: "gpu"}, expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if e, g := tc.expected, CanEverClaim(&tc.runner, &tc.execution); e != g {
+				t.Errorf("CanEverClaim: expected %v, got %v", e, g)
+			}
+		})
+	}
+}
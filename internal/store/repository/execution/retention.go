@@ -0,0 +1,151 @@
+package execution
+
+import (
+	"context"
+	"time"
+
+	"github.com/bornholm/oplet/internal/store"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// ListExpired returns the finished executions of the given task which ended
+// before the given time, oldest first, soft deleted ones included so that
+// they are purged too. Pinned executions are not returned, nor first
+// attempts as long as their retries, sharing their input files, exist.
+func (r *Repository) ListExpired(ctx context.Context, taskID uint, before time.Time, limit int) ([]*store.TaskExecution, error) {
+	var executions []*store.TaskExecution
+	err := r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		retried := db.Unscoped().Model(&store.TaskExecution{}).
+			Select("first_attempt_id").
+			Where("first_attempt_id IS NOT NULL")
+
+		query := db.Unscoped().Preload("User").
+			Where("task_id = ? AND status IN ? AND pinned = ?", taskID, finalStatuses, false).
+			Where("COALESCE(finished_at, created_at) < ?", before).
+			Where("id NOT IN (?)", retried).
+			Order("COALESCE(finished_at, created_at) ASC")
+
+		if limit > 0 {
+			query = query.Limit(limit)
+		}
+
+		if err := query.Find(&executions).Error; err != nil {
+			return errors.WithStack(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return executions, nil
+}
+
+// GetFilesSize returns the total size of the input and output files of each
+// of the given executions
+func (r *Repository) GetFilesSize(ctx context.Context, executionIDs []uint) (map[uint]int64, error) {
+	sizes := make(map[uint]int64, len(executionIDs))
+	if len(executionIDs) == 0 {
+		return sizes, nil
+	}
+
+	err := r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		var rows []struct {
+			ExecutionID uint
+			Size        int64
+		}
+
+		err := db.Model(&store.TaskExecutionFile{}).
+			Select("execution_id, SUM(file_size) AS size").
+			Where("execution_id IN ?", executionIDs).
+			Group("execution_id").
+			Scan(&rows).
+			Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		for _, row := range rows {
+			sizes[row.ExecutionID] = row.Size
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return sizes, nil
+}
+
+// Purge permanently deletes the execution with its logs and the records of
+// its files. The files themselves are left to the caller.
+func (r *Repository) Purge(ctx context.Context, executionID uint) error {
+	return r.store.WithTx(ctx, func(ctx context.Context, db *gorm.DB) error {
+		if err := db.Unscoped().Where("execution_id = ?", executionID).Delete(&store.TaskExecutionLog{}).Error; err != nil {
+			return errors.WithStack(err)
+		}
+
+		if err := db.Unscoped().Where("execution_id = ?", executionID).Delete(&store.TaskExecutionFile{}).Error; err != nil {
+			return errors.WithStack(err)
+		}
+
+		if err := db.Unscoped().Delete(&store.TaskExecution{}, executionID).Error; err != nil {
+			return errors.WithStack(err)
+		}
+
+		return nil
+	})
+}
+
+// ListExisting returns the given execution identifiers which still have
+// a record, soft deleted or not
+func (r *Repository) ListExisting(ctx context.Context, executionIDs []uint) ([]uint, error) {
+	var ids []uint
+	if len(executionIDs) == 0 {
+		return ids, nil
+	}
+
+	err := r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		err := db.Unscoped().Model(&store.TaskExecution{}).
+			Where("id IN ?", executionIDs).
+			Pluck("id", &ids).
+			Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return ids, nil
+}
+
+// SetPinned pins or unpins an execution, pinned executions being kept
+// whatever the retention
+func (r *Repository) SetPinned(ctx context.Context, executionID uint, pinned bool) error {
+	return r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		err := db.Model(&store.TaskExecution{}).
+			Where("id = ?", executionID).
+			UpdateColumn("pinned", pinned).
+			Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return nil
+	})
+}
+
+// CleanupOrphanedLogs permanently deletes the log entries whose execution
+// does not exist anymore
+func (r *Repository) CleanupOrphanedLogs(ctx context.Context) error {
+	return r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		if err := db.Unscoped().Where("execution_id NOT IN (SELECT id FROM task_executions)").Delete(&store.TaskExecutionLog{}).Error; err != nil {
+			return errors.WithStack(err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,222 @@
+package execution
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+
+	"github.com/bornholm/oplet/internal/store"
+)
+
+func TestListExpired(t *testing.T) {
+	type execution struct {
+		name      string
+		status    store.TaskExecutionStatus
+		finished  time.Duration
+		pinned    bool
+		retryOf   string
+		deleted   bool
+		otherTask bool
+	}
+
+	type testCase struct {
+		name       string
+		executions []execution
+		limit      int
+		expected   []string
+	}
+
+	testCases := []testCase{
+		{
+			name: "finished before the expiry",
+			executions: []execution{
+				{name: "old", status: store.StatusSucceeded, finished: -48 * time.Hour},
+				{name: "recent", status: store.StatusFailed, finished: -time.Hour},
+				{name: "running", status: store.StatusRunning, finished: -48 * time.Hour},
+				{name: "other task", status: store.StatusSucceeded, finished: -48 * time.Hour, otherTask: true},
+			},
+			expected: []string{"old"},
+		},
+		{
+			name: "pinned executions are kept",
+			executions: []execution{
+				{name: "pinned", status: store.StatusSucceeded, finished: -48 * time.Hour, pinned: true},
+				{name: "unpinned", status: store.StatusKilled, finished: -48 * time.Hour},
+			},
+			expected: []string{"unpinned"},
+		},
+		{
+			name: "first attempts are kept with their retries",
+			executions: []execution{
+				{name: "first", status: store.StatusFailed, finished: -72 * time.Hour},
+				{name: "retry", status: store.StatusFailed, finished: -48 * time.Hour, retryOf: "first"},
+			},
+			expected: []string{"retry"},
+		},
+		{
+			name: "first attempts of soft deleted retries are kept",
+			executions: []execution{
+				{name: "first", status: store.StatusFailed, finished: -72 * time.Hour},
+				{name: "retry", status: store.StatusFailed, finished: -time.Hour, retryOf: "first", deleted: true},
+			},
+			expected: []string{},
+		},
+		{
+			name: "soft deleted executions are purged too",
+			executions: []execution{
+				{name: "deleted", status: store.StatusSucceeded, finished: -72 * time.Hour, deleted: true},
+				{name: "kept", status: store.StatusSucceeded, finished: -48 * time.Hour},
+			},
+			expected: []string{"deleted", "kept"},
+		},
+		{
+			name: "oldest first within the limit",
+			executions: []execution{
+				{name: "second", status: store.StatusSucceeded, finished: -48 * time.Hour},
+				{name: "first", status: store.StatusTimedOut, finished: -72 * time.Hour},
+				{name: "third", status: store.StatusSucceeded, finished: -36 * time.Hour},
+			},
+			limit:    2,
+			expected: []string{"first", "second"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			repo, db := newTestRepository(ctx, t)
+
+			now := time.Now()
+
+			tasks := []*store.Task{{ImageRef: "task:1"}, {ImageRef: "task:2"}}
+			if err := db.Create(tasks).Error; err != nil {
+				t.Fatalf("%+v", errors.WithStack(err))
+			}
+
+			names := make(map[uint]string)
+			ids := make(map[string]uint)
+
+			for i, e := range tc.executions {
+				finishedAt := now.Add(e.finished)
+
+				execution := &store.TaskExecution{
+					TaskID:      tasks[0].ID,
+					Status:      e.status,
+					RunnerToken: fmt.Sprintf("token-%d", i),
+					Pinned:      e.pinned,
+					FinishedAt:  &finishedAt,
+				}
+
+				if e.otherTask {
+					execution.TaskID = tasks[1].ID
+				}
+
+				if e.retryOf != "" {
+					firstAttemptID := ids[e.retryOf]
+					execution.FirstAttemptID = &firstAttemptID
+				}
+
+				if err := db.Create(execution).Error; err != nil {
+					t.Fatalf("%+v", errors.WithStack(err))
+				}
+
+				if e.deleted {
+					if err := db.Delete(execution).Error; err != nil {
+						t.Fatalf("%+v", errors.WithStack(err))
+					}
+				}
+
+				names[execution.ID] = e.name
+				ids[e.name] = execution.ID
+			}
+
+			executions, err := repo.ListExpired(ctx, tasks[0].ID, now.Add(-24*time.Hour), tc.limit)
+			if err != nil {
+				t.Fatalf("%+v", errors.WithStack(err))
+			}
+
+			expired := make([]string, 0, len(executions))
+			for _, e := range executions {
+				expired = append(expired, names[e.ID])
+			}
+
+			if e, g := tc.expected, expired; !slices.Equal(e, g) {
+				t.Errorf("expired executions: expected %v, got %v", e, g)
+			}
+		})
+	}
+}
+
+func TestPurge(t *testing.T) {
+	ctx := context.Background()
+
+	repo, db := newTestRepository(ctx, t)
+
+	task := &store.Task{ImageRef: "task:1"}
+	if err := db.Create(task).Error; err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	execution := &store.TaskExecution{
+		TaskID:      task.ID,
+		Status:      store.StatusSucceeded,
+		RunnerToken: "token",
+		Logs:        []store.TaskExecutionLog{{Message: "log"}},
+		OutputFiles: []store.TaskExecutionFile{{Filename: "output.txt", IsOutput: true}},
+	}
+
+	if err := db.Create(execution).Error; err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	if err := db.Delete(execution).Error; err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	if err := repo.Purge(ctx, execution.ID); err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	existing, err := repo.ListExisting(ctx, []uint{execution.ID})
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	if e, g := 0, len(existing); e != g {
+		t.Errorf("existing executions: expected %d, got %d", e, g)
+	}
+
+	for _, model := range []any{&store.TaskExecutionLog{}, &store.TaskExecutionFile{}} {
+		var count int64
+		if err := db.Unscoped().Model(model).Where("execution_id = ?", execution.ID).Count(&count).Error; err != nil {
+			t.Fatalf("%+v", errors.WithStack(err))
+		}
+
+		if e, g := int64(0), count; e != g {
+			t.Errorf("%T rows: expected %d, got %d", model, e, g)
+		}
+	}
+}
+
+func newTestRepository(ctx context.Context, t *testing.T) (*Repository, *gorm.DB) {
+	db, err := gorm.Open(sqlite.Open(t.TempDir()+"/db.sqlite"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	st := store.New(db)
+
+	// Run the migrations before the records are created
+	if err := st.Ping(ctx); err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	return NewRepository(st), db
+}
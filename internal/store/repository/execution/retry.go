@@ -0,0 +1,200 @@
+package execution
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/bornholm/oplet/internal/crypto"
+	"github.com/bornholm/oplet/internal/store"
+	"github.com/bornholm/oplet/internal/task"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// retryableErrorTypes are the failures considered as transient
+var retryableErrorTypes = []string{
+	string(task.ErrorTypeImagePullFailed),
+	string(task.ErrorTypeRunnerLost),
+}
+
+// Attempt returns the attempt number of the execution
+func Attempt(execution *store.TaskExecution) int {
+	return max(execution.Attempt, 1)
+}
+
+// ShouldRetry returns true if the retry policy of the failed execution
+// allows a new attempt
+func ShouldRetry(execution *store.TaskExecution) bool {
+	if execution.Status != store.StatusFailed || execution.CanceledAt != nil {
+		return false
+	}
+
+	// Tasks stored before the retries were capped may exceed the maximum
+	if Attempt(execution) > min(execution.Retries, task.MaxRetries) {
+		return false
+	}
+
+	if slices.Contains(retryableErrorTypes, execution.ErrorType) {
+		return true
+	}
+
+	exited := execution.ErrorType == "" && execution.ExitCode != nil && *execution.ExitCode != 0
+
+	return exited && execution.RetryOnExit
+}
+
+// RetryDelay returns the delay before the attempt following the given
+// execution, the backoff being doubled on each attempt up to task.MaxRetryDelay
+func RetryDelay(execution *store.TaskExecution) time.Duration {
+	delay := execution.RetryBackoff
+
+	for range min(Attempt(execution), task.MaxRetries+1) - 1 {
+		if delay >= task.MaxRetryDelay {
+			break
+		}
+
+		delay *= 2
+	}
+
+	return min(delay, task.MaxRetryDelay)
+}
+
+// ScheduleRetry creates the next attempt of the given execution if its retry
+// policy allows it. It returns nil if no attempt was scheduled.
+func (r *Repository) ScheduleRetry(ctx context.Context, executionID uint) (*store.TaskExecution, error) {
+	var next *store.TaskExecution
+	err := r.store.WithTx(ctx, func(ctx context.Context, db *gorm.DB) error {
+		var failed store.TaskExecution
+		if err := db.First(&failed, executionID).Error; err != nil {
+			return errors.WithStack(err)
+		}
+
+		if !ShouldRetry(&failed) {
+			return nil
+		}
+
+		firstAttemptID := failed.ID
+		if failed.FirstAttemptID != nil {
+			firstAttemptID = *failed.FirstAttemptID
+		}
+
+		// Status updates can be reported more than once by runners
+		var retried int64
+		err := db.Model(&store.TaskExecution{}).
+			Where("first_attempt_id = ? AND attempt > ?", firstAttemptID, Attempt(&failed)).
+			Count(&retried).
+			Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if retried > 0 {
+			return nil
+		}
+
+		token, err := crypto.RandomToken(tokenSize)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		scheduledAt := time.Now().Add(RetryDelay(&failed))
+
+		next = &store.TaskExecution{
+			TaskID:              failed.TaskID,
+			UserID:              failed.UserID,
+			Status:              store.StatusPending,
+			RunnerToken:         token,
+			RunnerTags:          failed.RunnerTags,
+			Requeue:             failed.Requeue,
+			Retries:             failed.Retries,
+			RetryBackoff:        failed.RetryBackoff,
+			RetryOnExit:         failed.RetryOnExit,
+			Timeout:             failed.Timeout,
+			Network:             failed.Network,
+			CPURequest:          failed.CPURequest,
+			MemoryRequest:       failed.MemoryRequest,
+			CPULimit:            failed.CPULimit,
+			MemoryLimit:         failed.MemoryLimit,
+			SecurityRelaxations: failed.SecurityRelaxations,
+			Attempt:             Attempt(&failed) + 1,
+			FirstAttemptID:      &firstAttemptID,
+			ScheduledAt:         &scheduledAt,
+			InputParameters:     failed.InputParameters,
+		}
+
+		if err := db.Create(next).Error; err != nil {
+			return errors.WithStack(err)
+		}
+
+		// Attempts share the input files of the failed execution
+		var inputs []*store.TaskExecutionFile
+		if err := db.Where("execution_id = ? AND is_output = ?", failed.ID, false).Find(&inputs).Error; err != nil {
+			return errors.WithStack(err)
+		}
+
+		for _, input := range inputs {
+			file := &store.TaskExecutionFile{
+				ExecutionID: next.ID,
+				Filename:    input.Filename,
+				FilePath:    input.FilePath,
+				FileSize:    input.FileSize,
+				MimeType:    input.MimeType,
+				IsOutput:    false,
+				Checksum:    input.Checksum,
+			}
+
+			if err := db.Create(file).Error; err != nil {
+				return errors.WithStack(err)
+			}
+		}
+
+		now := time.Now()
+
+		log := &store.TaskExecutionLog{
+			ExecutionID: failed.ID,
+			Timestamp:   now.UnixMicro(),
+			Source:      "system",
+			Message:     fmt.Sprintf("Retry scheduled as execution #%d (attempt %d/%d) at %s", next.ID, next.Attempt, failed.Retries+1, scheduledAt.Format(time.DateTime)),
+			Clock:       uint(now.UnixMicro()),
+		}
+
+		if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(log).Error; err != nil {
+			return errors.WithStack(err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return next, nil
+}
+
+// GetAttempts returns all the attempts of the given execution, ordered by attempt number
+func (r *Repository) GetAttempts(ctx context.Context, execution *store.TaskExecution) ([]*store.TaskExecution, error) {
+	firstAttemptID := execution.ID
+	if execution.FirstAttemptID != nil {
+		firstAttemptID = *execution.FirstAttemptID
+	}
+
+	var attempts []*store.TaskExecution
+	err := r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		err := db.Where("id = ? OR first_attempt_id = ?", firstAttemptID, firstAttemptID).
+			Order("attempt ASC, id ASC").
+			Find(&attempts).
+			Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return attempts, nil
+}
@@ -0,0 +1,275 @@
+package execution
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/bornholm/oplet/internal/store"
+	"github.com/bornholm/oplet/internal/task"
+)
+
+func TestShouldRetry(t *testing.T) {
+	exitCode := 1
+	successCode := 0
+	canceledAt := time.Now()
+
+	type testCase struct {
+		name      string
+		execution store.TaskExecution
+		expected  bool
+	}
+
+	testCases := []testCase{
+		{
+			name:      "lost runner",
+			execution: store.TaskExecution{Status: store.StatusFailed, ErrorType: string(task.ErrorTypeRunnerLost), Retries: 1},
+			expected:  true,
+		},
+		{
+			name:      "image pull failure",
+			execution: store.TaskExecution{Status: store.StatusFailed, ErrorType: string(task.ErrorTypeImagePullFailed), Retries: 1},
+			expected:  true,
+		},
+		{
+			name:      "non transient failure",
+			execution: store.TaskExecution{Status: store.StatusFailed, ErrorType: string(task.ErrorTypeNetworkNotAllowed), Retries: 1},
+			expected:  false,
+		},
+		{
+			name:      "no retries",
+			execution: store.TaskExecution{Status: store.StatusFailed, ErrorType: string(task.ErrorTypeRunnerLost)},
+			expected:  false,
+		},
+		{
+			name:      "last attempt",
+			execution: store.TaskExecution{Status: store.StatusFailed, ErrorType: string(task.ErrorTypeRunnerLost), Retries: 2, Attempt: 3},
+			expected:  false,
+		},
+		{
+			name:      "attempt before the last one",
+			execution: store.TaskExecution{Status: store.StatusFailed, ErrorType: string(task.ErrorTypeRunnerLost), Retries: 2, Attempt: 2},
+			expected:  true,
+		},
+		{
+			name:      "retries over the maximum",
+			execution: store.TaskExecution{Status: store.StatusFailed, ErrorType: string(task.ErrorTypeRunnerLost), Retries: task.MaxRetries + 5, Attempt: task.MaxRetries + 1},
+			expected:  false,
+		},
+		{
+			name:      "exit code retried",
+			execution: store.TaskExecution{Status: store.StatusFailed, ExitCode: &exitCode, Retries: 1, RetryOnExit: true},
+			expected:  true,
+		},
+		{
+			name:      "exit code not retried",
+			execution: store.TaskExecution{Status: store.StatusFailed, ExitCode: &exitCode, Retries: 1},
+			expected:  false,
+		},
+		{
+			name:      "zero exit code",
+			execution: store.TaskExecution{Status: store.StatusFailed, ExitCode: &successCode, Retries: 1, RetryOnExit: true},
+			expected:  false,
+		},
+		{
+			name:      "timed out",
+			execution: store.TaskExecution{Status: store.StatusTimedOut, ErrorType: string(task.ErrorTypeRunnerLost), Retries: 1},
+			expected:  false,
+		},
+		{
+			name:      "canceled",
+			execution: store.TaskExecution{Status: store.StatusFailed, ErrorType: string(task.ErrorTypeRunnerLost), Retries: 1, CanceledAt: &canceledAt},
+			expected:  false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if e, g := tc.expected, ShouldRetry(&tc.execution); e != g {
+				t.Errorf("ShouldRetry: expected %v, got %v", e, g)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	type testCase struct {
+		name      string
+		execution store.TaskExecution
+		expected  time.Duration
+	}
+
+	testCases := []testCase{
+		{name: "first attempt", execution: store.TaskExecution{RetryBackoff: time.Minute}, expected: time.Minute},
+		{name: "first attempt numbered", execution: store.TaskExecution{RetryBackoff: time.Minute, Attempt: 1}, expected: time.Minute},
+		{name: "second attempt", execution: store.TaskExecution{RetryBackoff: time.Minute, Attempt: 2}, expected: 2 * time.Minute},
+		{name: "fourth attempt", execution: store.TaskExecution{RetryBackoff: time.Minute, Attempt: 4}, expected: 8 * time.Minute},
+		{name: "capped", execution: store.TaskExecution{RetryBackoff: 20 * time.Minute, Attempt: 3}, expected: task.MaxRetryDelay},
+		{name: "backoff over the maximum", execution: store.TaskExecution{RetryBackoff: 2 * task.MaxRetryDelay}, expected: task.MaxRetryDelay},
+		{name: "doublings bounded by the maximum retries", execution: store.TaskExecution{RetryBackoff: time.Second, Attempt: 1000}, expected: (1 << task.MaxRetries) * time.Second},
+		{name: "no backoff", execution: store.TaskExecution{Attempt: 3}, expected: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if e, g := tc.expected, RetryDelay(&tc.execution); e != g {
+				t.Errorf("RetryDelay: expected %v, got %v", e, g)
+			}
+		})
+	}
+}
+
+func TestScheduleRetry(t *testing.T) {
+	ctx := context.Background()
+
+	repo, db := newTestRepository(ctx, t)
+
+	runnerLost := string(task.ErrorTypeRunnerLost)
+
+	task := &store.Task{ImageRef: "task:1"}
+	if err := db.Create(task).Error; err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	first := &store.TaskExecution{
+		TaskID:          task.ID,
+		Status:          store.StatusFailed,
+		ErrorType:       runnerLost,
+		RunnerToken:     "token",
+		RunnerTags:      "gpu",
+		Retries:         2,
+		RetryBackoff:    time.Minute,
+		InputParameters: `{"pages": 30}`,
+	}
+
+	if err := db.Create(first).Error; err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	files := []*store.TaskExecutionFile{
+		{ExecutionID: first.ID, Filename: "input.pdf", FilePath: "blobs/input", FileSize: 12, Checksum: "input-checksum"},
+		{ExecutionID: first.ID, Filename: "output.pdf", FilePath: "blobs/output", FileSize: 24, IsOutput: true},
+	}
+
+	if err := db.Create(files).Error; err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	second, err := repo.ScheduleRetry(ctx, first.ID)
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	if second == nil {
+		t.Fatalf("expected a retry to be scheduled")
+	}
+
+	if e, g := 2, second.Attempt; e != g {
+		t.Errorf("second.Attempt: expected %d, got %d", e, g)
+	}
+
+	if second.FirstAttemptID == nil || *second.FirstAttemptID != first.ID {
+		t.Errorf("second.FirstAttemptID: expected %d, got %v", first.ID, second.FirstAttemptID)
+	}
+
+	if e, g := store.StatusPending, second.Status; e != g {
+		t.Errorf("second.Status: expected '%s', got '%s'", e, g)
+	}
+
+	if e, g := first.RunnerTags, second.RunnerTags; e != g {
+		t.Errorf("second.RunnerTags: expected '%s', got '%s'", e, g)
+	}
+
+	if e, g := first.InputParameters, second.InputParameters; e != g {
+		t.Errorf("second.InputParameters: expected '%s', got '%s'", e, g)
+	}
+
+	if second.ScheduledAt == nil || second.ScheduledAt.Before(time.Now().Add(30*time.Second)) {
+		t.Errorf("second.ScheduledAt: expected about a minute from now, got %v", second.ScheduledAt)
+	}
+
+	// Only the input files are shared with the retry
+	var copied []*store.TaskExecutionFile
+	if err := db.Where("execution_id = ?", second.ID).Find(&copied).Error; err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	if e, g := 1, len(copied); e != g {
+		t.Fatalf("len(copied): expected %d, got %d", e, g)
+	}
+
+	if e, g := files[0], copied[0]; e.Filename != g.Filename || e.FilePath != g.FilePath || e.FileSize != g.FileSize || e.Checksum != g.Checksum || g.IsOutput {
+		t.Errorf("copied[0]: expected %+v, got %+v", e, g)
+	}
+
+	// A failure reported twice does not schedule another retry
+	replayed, err := repo.ScheduleRetry(ctx, first.ID)
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	if replayed != nil {
+		t.Errorf("expected no retry to be scheduled again, got execution #%d", replayed.ID)
+	}
+
+	// Later attempts are linked to the first one
+	err = db.Model(&store.TaskExecution{}).Where("id = ?", second.ID).Updates(map[string]any{
+		"status":     store.StatusFailed,
+		"error_type": runnerLost,
+	}).Error
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	third, err := repo.ScheduleRetry(ctx, second.ID)
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	if third == nil {
+		t.Fatalf("expected a third attempt to be scheduled")
+	}
+
+	if e, g := 3, third.Attempt; e != g {
+		t.Errorf("third.Attempt: expected %d, got %d", e, g)
+	}
+
+	if third.FirstAttemptID == nil || *third.FirstAttemptID != first.ID {
+		t.Errorf("third.FirstAttemptID: expected %d, got %v", first.ID, third.FirstAttemptID)
+	}
+
+	// The retries are exhausted once the last attempt failed
+	err = db.Model(&store.TaskExecution{}).Where("id = ?", third.ID).Updates(map[string]any{
+		"status":     store.StatusFailed,
+		"error_type": runnerLost,
+	}).Error
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	exhausted, err := repo.ScheduleRetry(ctx, third.ID)
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	if exhausted != nil {
+		t.Errorf("expected no retry once the retries are exhausted, got execution #%d", exhausted.ID)
+	}
+
+	attempts, err := repo.GetAttempts(ctx, third)
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	ids := make([]uint, 0, len(attempts))
+	for _, a := range attempts {
+		ids = append(ids, a.ID)
+	}
+
+	if e, g := []uint{first.ID, second.ID, third.ID}, ids; !slices.Equal(e, g) {
+		t.Errorf("attempts: expected %v, got %v", e, g)
+	}
+}
@@ -0,0 +1,38 @@
+package execution
+
+import (
+	"context"
+
+	"github.com/bornholm/oplet/internal/store"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// TrimFilePathPrefix removes the given prefix from the paths of the files
+// starting with it, turning the legacy filesystem paths into storage keys,
+// and returns the number of updated files
+func (r *Repository) TrimFilePathPrefix(ctx context.Context, prefix string) (int64, error) {
+	if prefix == "" {
+		return 0, nil
+	}
+
+	var updated int64
+	err := r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		// SUBSTR is used instead of LIKE which would interpret the wildcards
+		// of the prefix
+		result := db.Unscoped().Model(&store.TaskExecutionFile{}).
+			Where("SUBSTR(file_path, 1, LENGTH(?)) = ?", prefix, prefix).
+			Update("file_path", gorm.Expr("SUBSTR(file_path, LENGTH(?) + 1)", prefix))
+		if result.Error != nil {
+			return errors.WithStack(result.Error)
+		}
+
+		updated = result.RowsAffected
+		return nil
+	})
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return updated, nil
+}
@@ -0,0 +1,45 @@
+package runner
+
+import (
+	"context"
+
+	"github.com/bornholm/oplet/internal/store"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// AddCommand queues a command for the given runner
+func (r *Repository) AddCommand(ctx context.Context, command *store.RunnerCommand) error {
+	return r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		if err := db.Create(command).Error; err != nil {
+			return errors.WithStack(err)
+		}
+		return nil
+	})
+}
+
+// PopCommands removes and returns the commands queued for the given runner,
+// oldest first
+func (r *Repository) PopCommands(ctx context.Context, runnerID uint) ([]*store.RunnerCommand, error) {
+	var commands []*store.RunnerCommand
+	err := r.store.WithTx(ctx, func(ctx context.Context, db *gorm.DB) error {
+		if err := db.Where("runner_id = ?", runnerID).Order("id ASC").Find(&commands).Error; err != nil {
+			return errors.WithStack(err)
+		}
+
+		if len(commands) == 0 {
+			return nil
+		}
+
+		if err := db.Delete(&commands).Error; err != nil {
+			return errors.WithStack(err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return commands, nil
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/bornholm/oplet/internal/crypto"
 	"github.com/bornholm/oplet/internal/store"
+	"github.com/bornholm/oplet/internal/task"
 	"github.com/pkg/errors"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
@@ -36,6 +37,40 @@ func (r *Repository) UpdateContactAt(ctx context.Context, runnerID uint, contact
 	})
 }
 
+// ReportedState is the state a runner reports on heartbeat
+type ReportedState struct {
+	Slots     int
+	UsedSlots int
+	Tags      []string
+	MaxCPUs   float64
+	MaxMemory int64
+}
+
+func (r *Repository) UpdateReportedState(ctx context.Context, runnerID uint, state ReportedState) error {
+	return r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		err := db.Model(&store.Runner{}).Where("id = ?", runnerID).UpdateColumns(map[string]interface{}{
+			"slots":         state.Slots,
+			"used_slots":    state.UsedSlots,
+			"reported_tags": task.FormatTags(state.Tags),
+			"max_cpus":      state.MaxCPUs,
+			"max_memory":    state.MaxMemory,
+		}).Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return nil
+	})
+}
+
+func (r *Repository) UpdateTags(ctx context.Context, id uint, tags []string) error {
+	return r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		if err := db.Model(&store.Runner{}).Where("id = ?", id).UpdateColumn("tags", task.FormatTags(tags)).Error; err != nil {
+			return errors.WithStack(err)
+		}
+		return nil
+	})
+}
+
 func (r *Repository) Update(ctx context.Context, runner *store.Runner) error {
 	return r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
 		if err := db.Save(runner).Error; err != nil {
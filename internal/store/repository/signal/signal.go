@@ -0,0 +1,74 @@
+package signal
+
+import (
+	"context"
+	"time"
+
+	"github.com/bornholm/oplet/internal/store"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Raise increments the sequence of the given signal and returns its new value
+func (r *Repository) Raise(ctx context.Context, id string) (uint64, error) {
+	var signal store.Signal
+	err := r.store.WithTx(ctx, func(ctx context.Context, db *gorm.DB) error {
+		err := db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "id"}},
+			DoUpdates: clause.Assignments(map[string]any{"sequence": gorm.Expr("signals.sequence + 1"), "updated_at": gorm.Expr("CURRENT_TIMESTAMP")}),
+		}).Create(&store.Signal{ID: id, Sequence: 1}).Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if err := db.First(&signal, "id = ?", id).Error; err != nil {
+			return errors.WithStack(err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return signal.Sequence, nil
+}
+
+// GetSequence returns the current sequence of the given signal, zero if it
+// was never raised
+func (r *Repository) GetSequence(ctx context.Context, id string) (uint64, error) {
+	var signal store.Signal
+	err := r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		if err := db.Where("id = ?", id).Limit(1).Find(&signal).Error; err != nil {
+			return errors.WithStack(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return signal.Sequence, nil
+}
+
+// DeleteStale deletes the signals whose identifier starts with the given
+// prefix and which were not raised since the given time
+func (r *Repository) DeleteStale(ctx context.Context, prefix string, before time.Time) (int64, error) {
+	var deleted int64
+	err := r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		result := db.Where("id LIKE ? AND updated_at < ?", prefix+"%", before).Delete(&store.Signal{})
+		if result.Error != nil {
+			return errors.WithStack(result.Error)
+		}
+
+		deleted = result.RowsAffected
+
+		return nil
+	})
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return deleted, nil
+}
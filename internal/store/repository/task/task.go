@@ -114,6 +114,33 @@ func (r *Repository) UpdateConfiguration(ctx context.Context, taskID uint, value
 	})
 }
 
+// settingsColumns are the columns holding the execution settings
+// overridden by an administrator
+var settingsColumns = []string{
+	"runner_tags",
+	"requeue",
+	"retries",
+	"retry_backoff",
+	"retry_on_exit",
+	"timeout",
+	"retention",
+	"cpu_request",
+	"cpu_limit",
+	"memory_request",
+	"memory_limit",
+	"approved_security",
+}
+
+// UpdateSettings saves the execution settings overrides of the given task
+func (r *Repository) UpdateSettings(ctx context.Context, task *store.Task) error {
+	return r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		if err := db.Model(task).Select(settingsColumns).Updates(task).Error; err != nil {
+			return errors.WithStack(err)
+		}
+		return nil
+	})
+}
+
 // Delete deletes a task by ID
 func (r *Repository) Delete(ctx context.Context, id uint) error {
 	return r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
@@ -0,0 +1,241 @@
+package upload
+
+import (
+	"context"
+	"time"
+
+	"github.com/bornholm/oplet/internal/crypto"
+	"github.com/bornholm/oplet/internal/store"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const idSize int = 16
+
+// ErrConsumed is returned when an upload was deleted or used by another
+// execution in the meantime
+var ErrConsumed = errors.New("upload already consumed")
+
+func (r *Repository) Create(ctx context.Context, upload *store.Upload) error {
+	return r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		id, err := crypto.RandomToken(idSize)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		upload.ID = id
+
+		if err := db.Create(upload).Error; err != nil {
+			return errors.WithStack(err)
+		}
+		return nil
+	})
+}
+
+// GetByIDForUser retrieves a file input upload by ID, ensuring it belongs to
+// the specified user
+func (r *Repository) GetByIDForUser(ctx context.Context, id string, userID uint) (*store.Upload, error) {
+	var upload store.Upload
+	err := r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		if err := db.Where("id = ? AND user_id = ? AND execution_id IS NULL", id, userID).First(&upload).Error; err != nil {
+			return errors.WithStack(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// GetByIDForExecution retrieves an output file upload by ID, ensuring it
+// belongs to the specified execution
+func (r *Repository) GetByIDForExecution(ctx context.Context, id string, executionID uint) (*store.Upload, error) {
+	var upload store.Upload
+	err := r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		if err := db.Where("id = ? AND execution_id = ?", id, executionID).First(&upload).Error; err != nil {
+			return errors.WithStack(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// Advance moves the received offset of the upload forward once a chunk is
+// stored, returning false if another chunk was received in the meantime
+func (r *Repository) Advance(ctx context.Context, id string, from int64, to int64) (bool, error) {
+	var advanced bool
+	err := r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		result := db.Model(&store.Upload{}).
+			Where("id = ? AND received = ? AND completed_at IS NULL", id, from).
+			Update("received", to)
+		if result.Error != nil {
+			return errors.WithStack(result.Error)
+		}
+
+		advanced = result.RowsAffected > 0
+		return nil
+	})
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return advanced, nil
+}
+
+// Reset restarts the upload from its beginning, its received content not
+// matching the expected one. Its chunks must be deleted beforehand with
+// file.Storage.DeleteUpload.
+func (r *Repository) Reset(ctx context.Context, id string) error {
+	return r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		err := db.Model(&store.Upload{}).
+			Where("id = ? AND completed_at IS NULL", id).
+			Update("received", 0).Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return nil
+	})
+}
+
+// Complete records the file holding the content of the upload once its
+// checksum is verified, returning false if the upload was not fully
+// received or already completed
+func (r *Repository) Complete(ctx context.Context, id string, checksum string, filePath string, mimeType string) (bool, error) {
+	var completed bool
+	err := r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		var err error
+		completed, err = complete(db, id, checksum, filePath, mimeType)
+		return errors.WithStack(err)
+	})
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return completed, nil
+}
+
+// CompleteOutput completes the upload of an output file as Complete does and
+// records the file as an output of its execution at once
+func (r *Repository) CompleteOutput(ctx context.Context, upload *store.Upload, checksum string, filePath string, mimeType string) (bool, error) {
+	if upload.ExecutionID == nil {
+		return false, errors.Errorf("upload %s is not an output file upload", upload.ID)
+	}
+
+	var completed bool
+	err := r.store.WithTx(ctx, func(ctx context.Context, db *gorm.DB) error {
+		var err error
+		completed, err = complete(db, upload.ID, checksum, filePath, mimeType)
+		if err != nil || !completed {
+			return errors.WithStack(err)
+		}
+
+		file := &store.TaskExecutionFile{
+			ExecutionID: *upload.ExecutionID,
+			Filename:    upload.Filename,
+			FilePath:    filePath,
+			FileSize:    upload.Size,
+			MimeType:    mimeType,
+			Checksum:    checksum,
+			IsOutput:    true,
+		}
+
+		if err := db.Create(file).Error; err != nil {
+			return errors.WithStack(err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return completed, nil
+}
+
+func complete(db *gorm.DB, id string, checksum string, filePath string, mimeType string) (bool, error) {
+	result := db.Model(&store.Upload{}).
+		Where("id = ? AND received = size AND completed_at IS NULL", id).
+		Updates(map[string]any{
+			"checksum":     checksum,
+			"file_path":    filePath,
+			"mime_type":    mimeType,
+			"completed_at": time.Now(),
+		})
+	if result.Error != nil {
+		return false, errors.WithStack(result.Error)
+	}
+
+	return result.RowsAffected > 0, nil
+}
+
+// Consume records the completed uploads as the input files of the given
+// execution and deletes them, returning ErrConsumed if one of them does not
+// exist anymore
+func (r *Repository) Consume(ctx context.Context, executionID uint, uploads []*store.Upload) error {
+	return r.store.WithTx(ctx, func(ctx context.Context, db *gorm.DB) error {
+		for _, u := range uploads {
+			result := db.Where("id = ? AND completed_at IS NOT NULL", u.ID).Delete(&store.Upload{})
+			if result.Error != nil {
+				return errors.WithStack(result.Error)
+			}
+
+			if result.RowsAffected == 0 {
+				return errors.Wrapf(ErrConsumed, "upload %s", u.ID)
+			}
+
+			// The input file is named after its parameter, so that it is
+			// positioned correctly in /oplet/inputs
+			file := &store.TaskExecutionFile{
+				ExecutionID: executionID,
+				Filename:    u.InputName,
+				FilePath:    u.FilePath,
+				FileSize:    u.Size,
+				MimeType:    u.MimeType,
+				Checksum:    u.Checksum,
+				IsOutput:    false,
+			}
+
+			if err := db.Create(file).Error; err != nil {
+				return errors.WithStack(err)
+			}
+		}
+
+		return nil
+	})
+}
+
+func (r *Repository) Delete(ctx context.Context, id string) error {
+	return r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		if err := db.Delete(&store.Upload{}, "id = ?", id).Error; err != nil {
+			return errors.WithStack(err)
+		}
+		return nil
+	})
+}
+
+// ListExpired returns the uploads which were not updated since the given
+// time, either abandoned or never used by an execution
+func (r *Repository) ListExpired(ctx context.Context, before time.Time, limit int) ([]*store.Upload, error) {
+	var uploads []*store.Upload
+	err := r.store.WithDatabase(ctx, func(ctx context.Context, db *gorm.DB) error {
+		query := db.Where("updated_at < ?", before).Order("updated_at ASC")
+
+		if limit > 0 {
+			query = query.Limit(limit)
+		}
+
+		if err := query.Find(&uploads).Error; err != nil {
+			return errors.WithStack(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return uploads, nil
+}
@@ -12,5 +12,39 @@ type Runner struct {
 	Name  string `gorm:"unique"`
 	Token string `gorm:"unique"`
 
+	// Comma separated tags defined by an administrator
+	Tags string
+
 	ContactedAt *time.Time
+
+	// State reported by the runner on its last heartbeat
+	Slots        int
+	UsedSlots    int
+	ReportedTags string
+
+	// Maximum capacity reported by the runner, zero if unknown
+	MaxCPUs   float64
+	MaxMemory int64
+}
+
+type RunnerCommandType string
+
+const (
+	// RunnerCommandDrain stops the runner from claiming new executions
+	RunnerCommandDrain RunnerCommandType = "drain"
+	// RunnerCommandResume resumes the claiming of executions by a drained runner
+	RunnerCommandResume RunnerCommandType = "resume"
+	// RunnerCommandPull pre-pulls an image on the runner
+	RunnerCommandPull RunnerCommandType = "pull"
+)
+
+// RunnerCommand is a command queued by an administrator, delivered to
+// the runner over its session
+type RunnerCommand struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+
+	RunnerID uint `gorm:"index"`
+	Type     RunnerCommandType
+	ImageRef string
 }
@@ -0,0 +1,13 @@
+package store
+
+import (
+	"time"
+)
+
+// Signal is a counter incremented on each occurrence of an event, to notify
+// the server instances sharing the database
+type Signal struct {
+	ID        string `gorm:"primarykey"`
+	Sequence  uint64
+	UpdatedAt time.Time
+}
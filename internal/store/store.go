@@ -21,6 +21,10 @@ var models = []any{
 	&TaskExecutionFile{},
 	&TaskConfiguration{},
 	&Runner{},
+	&Signal{},
+	&RunnerCommand{},
+	&Blob{},
+	&Upload{},
 }
 
 type Store struct {
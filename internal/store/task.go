@@ -14,6 +14,27 @@ type Task struct {
 	Description    string
 	Configurations []*TaskConfiguration `gorm:"constraint:OnDelete:CASCADE;"`
 
+	// Execution settings overridden by an administrator,
+	// empty values fall back on the task image labels
+	RunnerTags    string
+	Requeue       *bool
+	Retries       *int
+	RetryBackoff  *time.Duration
+	RetryOnExit   *bool
+	Timeout       *time.Duration
+	CPURequest    *float64
+	CPULimit      *float64
+	MemoryRequest *int64
+	MemoryLimit   *int64
+
+	// Duration after which the finished executions of the task are deleted,
+	// zero to keep them, overriding the server retention
+	Retention *time.Duration
+
+	// Comma separated security relaxations approved by an administrator,
+	// applied only if requested by the task image labels
+	ApprovedSecurity string
+
 	Executions []*TaskExecution `gorm:"constraint:OnDelete:CASCADE;"`
 }
 
@@ -31,13 +52,79 @@ type TaskExecution struct {
 	Status       TaskExecutionStatus `gorm:"index"`
 	ExitCode     *int                // Nullable until completion
 	ErrorMessage string              `gorm:"type:text"`
+	ErrorType    string
 
 	RunnerToken string `gorm:"unique"`
 
+	// Comma separated tags a runner must declare to claim the execution
+	RunnerTags string
+
+	// Runner holding the execution and expiration of its lease, renewed
+	// on each contact of the runner
+	Runner         *Runner `gorm:"constraint:OnDelete:SET NULL;"`
+	RunnerID       *uint   `gorm:"index"`
+	LeaseExpiresAt *time.Time
+
+	// Requeue the execution instead of failing it when its runner disappears
+	Requeue bool
+
+	// Retry policy of the execution
+	Retries      int
+	RetryBackoff time.Duration
+	RetryOnExit  bool
+
+	// Maximum duration of the execution, zero for none
+	Timeout time.Duration
+
+	// Resources requested by the execution, used to select a runner
+	// with enough remaining capacity
+	CPURequest    float64
+	MemoryRequest int64
+
+	// Resource limits of the execution container, zero for none
+	CPULimit    float64
+	MemoryLimit int64
+
+	// Network mode of the execution, see the task.Network* constants
+	Network string
+
+	// Limits applied by the runner, once capped at its maximums
+	EffectiveCPULimit    float64
+	EffectiveMemoryLimit int64
+
+	// Comma separated relaxations of the runner security profile
+	// requested by the task and approved by an administrator
+	SecurityRelaxations string
+
+	// Security profile applied by the runner, see task.SecurityProfile
+	SecurityProfile string
+
+	// Attempts of a same execution are linked to the first one,
+	// numbered from 1
+	Attempt        int
+	FirstAttemptID *uint `gorm:"index"`
+
+	// Set when the execution can not be claimed before the given time
+	ScheduledAt *time.Time
+
 	// Timing
 	StartedAt  *time.Time
 	FinishedAt *time.Time
 
+	// Set when a user requested the cancellation of the execution
+	CanceledAt *time.Time
+
+	// Last progress reported by the task, in percent, and its current step
+	Progress          *float64
+	ProgressStep      string
+	ProgressUpdatedAt *time.Time
+
+	// Pinned executions are kept whatever the retention
+	Pinned bool `gorm:"index"`
+
+	// Result written by the task, JSON of task.Result
+	Result string `gorm:"type:text"`
+
 	// Input Parameters (JSON)
 	InputParameters string `gorm:"type:text"` // JSON of form inputs
 
@@ -65,6 +152,7 @@ const (
 	StatusSucceeded         TaskExecutionStatus = "succeeded"
 	StatusFailed            TaskExecutionStatus = "failed"
 	StatusKilled            TaskExecutionStatus = "killed"
+	StatusTimedOut          TaskExecutionStatus = "timed_out"
 )
 
 type TaskExecutionLog struct {
@@ -74,6 +162,7 @@ type TaskExecutionLog struct {
 	Timestamp   int64  `gorm:"index:task_execution_log,unique"`
 	Source      string `gorm:"index:task_execution_log,unique"` // "container", "system"
 	Clock       uint   `gorm:"index:task_execution_log,unique"`
+	Stream      string // "stdout", "stderr" or empty if unknown
 	Message     string
 }
 
@@ -82,10 +171,13 @@ type TaskExecutionFile struct {
 	Execution   *TaskExecution
 	ExecutionID uint `gorm:"index"`
 	Filename    string
-	FilePath    string // Filesystem path
+	FilePath    string // Key of the file in the file storage
 	FileSize    int64
 	MimeType    string
 	IsOutput    bool // true for output files, false for input files
+	// SHA-256 of the content, identifying the blob shared by the files with
+	// the same content, empty for the files stored before deduplication
+	Checksum string `gorm:"index"`
 }
 
 type TaskConfiguration struct {
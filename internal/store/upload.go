@@ -0,0 +1,41 @@
+package store
+
+import (
+	"time"
+)
+
+// Upload is a file sent by chunks so that its transfer can be resumed, either
+// a file input sent before the execution using it is created or an output
+// file sent by the runner of an execution
+type Upload struct {
+	ID        string `gorm:"primarykey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time `gorm:"index"`
+
+	User   *User
+	UserID uint `gorm:"index"`
+
+	Task   *Task
+	TaskID uint
+
+	// Execution producing the output file, nil for the file inputs
+	ExecutionID *uint `gorm:"index"`
+
+	InputName string
+	// Path relative to the outputs directory for the output files
+	Filename string
+	Size     int64
+	// Number of bytes received so far, the offset of the next chunk
+	Received int64
+
+	// Set once every chunk is received and the checksum of the content
+	// verified
+	Checksum    string `gorm:"index"`
+	FilePath    string
+	MimeType    string
+	CompletedAt *time.Time
+}
+
+func (u *Upload) IsCompleted() bool {
+	return u.CompletedAt != nil
+}
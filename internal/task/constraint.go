@@ -0,0 +1,146 @@
+package task
+
+import (
+	"context"
+	"io"
+	"mime"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FileInfoConstraint is implemented by the constraints which can be asserted
+// from the name and the size of a file, before its content is received
+type FileInfoConstraint interface {
+	AssertFileInfo(ctx context.Context, input *Input, filename string, size int64) error
+}
+
+// AssertFileInfo checks the name and the size of a file against the
+// constraints of its input supporting it
+func AssertFileInfo(ctx context.Context, input *Input, filename string, size int64) error {
+	for _, constraint := range input.Constraints {
+		infoConstraint, ok := constraint.(FileInfoConstraint)
+		if !ok {
+			continue
+		}
+
+		if err := infoConstraint.AssertFileInfo(ctx, input, filename, size); err != nil && !errors.Is(err, ErrSkipConstraint) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Accept returns the file types accepted by the input, formatted as the
+// accept attribute of the HTML file inputs, empty if all are accepted
+func Accept(input *Input) string {
+	patterns := make([]string, 0)
+
+	for _, constraint := range input.Constraints {
+		if accept, ok := constraint.(*acceptConstraint); ok {
+			patterns = append(patterns, accept.patterns...)
+		}
+	}
+
+	return strings.Join(patterns, ",")
+}
+
+type maxSizeConstraint struct {
+	max int64
+}
+
+// AssertFile implements Constraint.
+func (c *maxSizeConstraint) AssertFile(ctx context.Context, input *Input, r io.Reader) error {
+	size, err := io.Copy(io.Discard, io.LimitReader(r, c.max+1))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.assertSize(size)
+}
+
+// AssertFileInfo implements FileInfoConstraint.
+func (c *maxSizeConstraint) AssertFileInfo(ctx context.Context, input *Input, filename string, size int64) error {
+	return c.assertSize(size)
+}
+
+// AssertValue implements Constraint.
+func (c *maxSizeConstraint) AssertValue(ctx context.Context, input *Input, value string) error {
+	return errors.WithStack(ErrSkipConstraint)
+}
+
+func (c *maxSizeConstraint) assertSize(size int64) error {
+	if size > c.max {
+		return errors.Errorf("file too large, must not exceed %s", FormatMemory(c.max))
+	}
+
+	return nil
+}
+
+var (
+	_ Constraint         = &maxSizeConstraint{}
+	_ FileInfoConstraint = &maxSizeConstraint{}
+)
+
+// NewMaxSizeConstraint returns a constraint rejecting the files larger than
+// the given number of bytes
+func NewMaxSizeConstraint(max int64) Constraint {
+	return &maxSizeConstraint{max}
+}
+
+type acceptConstraint struct {
+	patterns []string
+}
+
+// AssertFile implements Constraint. The type of the file is checked from
+// its name only.
+func (c *acceptConstraint) AssertFile(ctx context.Context, input *Input, r io.Reader) error {
+	return errors.WithStack(ErrSkipConstraint)
+}
+
+// AssertFileInfo implements FileInfoConstraint.
+func (c *acceptConstraint) AssertFileInfo(ctx context.Context, input *Input, filename string, size int64) error {
+	extension := strings.ToLower(filepath.Ext(filename))
+
+	mimeType, _, _ := strings.Cut(mime.TypeByExtension(extension), ";")
+
+	for _, pattern := range c.patterns {
+		switch {
+		case strings.HasPrefix(pattern, "."):
+			if extension == strings.ToLower(pattern) {
+				return nil
+			}
+
+		case strings.HasSuffix(pattern, "/*"):
+			if mimeType != "" && strings.HasPrefix(mimeType, strings.TrimSuffix(pattern, "*")) {
+				return nil
+			}
+
+		default:
+			if mimeType != "" && strings.EqualFold(mimeType, pattern) {
+				return nil
+			}
+		}
+	}
+
+	return errors.Errorf("file type not accepted, must be one of %s", strings.Join(c.patterns, ", "))
+}
+
+// AssertValue implements Constraint.
+func (c *acceptConstraint) AssertValue(ctx context.Context, input *Input, value string) error {
+	return errors.WithStack(ErrSkipConstraint)
+}
+
+var (
+	_ Constraint         = &acceptConstraint{}
+	_ FileInfoConstraint = &acceptConstraint{}
+)
+
+// NewAcceptConstraint returns a constraint rejecting the files whose type is
+// not matched by any of the given patterns, which are file extensions
+// (.csv), MIME types (text/csv) or MIME type prefixes (image/*)
+func NewAcceptConstraint(patterns []string) Constraint {
+	return &acceptConstraint{patterns}
+}
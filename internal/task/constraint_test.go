@@ -0,0 +1,99 @@
+package task
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestFileConstraints(t *testing.T) {
+	type testCase struct {
+		name        string
+		constraints []Constraint
+		filename    string
+		size        int64
+		expectError bool
+	}
+
+	testCases := []testCase{
+		{
+			name:        "no constraint",
+			filename:    "data.bin",
+			size:        1 << 30,
+			expectError: false,
+		},
+		{
+			name:        "size below the maximum",
+			constraints: []Constraint{NewMaxSizeConstraint(1024)},
+			filename:    "data.bin",
+			size:        1024,
+			expectError: false,
+		},
+		{
+			name:        "size above the maximum",
+			constraints: []Constraint{NewMaxSizeConstraint(1024)},
+			filename:    "data.bin",
+			size:        1025,
+			expectError: true,
+		},
+		{
+			name:        "accepted extension",
+			constraints: []Constraint{NewAcceptConstraint([]string{".csv", ".tsv"})},
+			filename:    "DATA.CSV",
+			expectError: false,
+		},
+		{
+			name:        "rejected extension",
+			constraints: []Constraint{NewAcceptConstraint([]string{".csv"})},
+			filename:    "data.xlsx",
+			expectError: true,
+		},
+		{
+			name:        "accepted MIME type prefix",
+			constraints: []Constraint{NewAcceptConstraint([]string{"image/*"})},
+			filename:    "photo.png",
+			expectError: false,
+		},
+		{
+			name:        "accepted MIME type",
+			constraints: []Constraint{NewAcceptConstraint([]string{"application/json"})},
+			filename:    "data.json",
+			expectError: false,
+		},
+		{
+			name:        "unknown type",
+			constraints: []Constraint{NewAcceptConstraint([]string{"image/*"})},
+			filename:    "photo",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			input := &Input{Name: "file", Type: TypeFile, Constraints: tc.constraints}
+
+			err := AssertFileInfo(context.Background(), input, tc.filename, tc.size)
+
+			if tc.expectError && err == nil {
+				t.Errorf("expected an error, got nil")
+			}
+
+			if !tc.expectError && err != nil {
+				t.Errorf("expected no error, got '%v'", err)
+			}
+		})
+	}
+}
+
+func TestMaxSizeConstraintAssertFile(t *testing.T) {
+	constraint := NewMaxSizeConstraint(4)
+	input := &Input{Name: "file", Type: TypeFile}
+
+	if err := constraint.AssertFile(context.Background(), input, strings.NewReader("1234")); err != nil {
+		t.Errorf("expected no error, got '%v'", err)
+	}
+
+	if err := constraint.AssertFile(context.Background(), input, strings.NewReader("12345")); err == nil {
+		t.Errorf("expected an error, got nil")
+	}
+}
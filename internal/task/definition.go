@@ -3,6 +3,7 @@ package task
 import (
 	"context"
 	"io"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -15,8 +16,34 @@ type Definition struct {
 	ImageRef      string
 	Inputs        []*Input
 	Configuration []*Input
+	// Tags a runner must declare to execute the task
+	RunnerTags []string
+	// Requeue the execution when its runner disappears
+	Requeue bool
+	// Number of times a failed execution is retried, up to MaxRetries
+	Retries int
+	// Delay before the first retry, doubled on each following attempt up
+	// to MaxRetryDelay
+	RetryBackoff time.Duration
+	// Retry executions exiting with a non-zero code
+	RetryOnExit bool
+	// Maximum duration of an execution, zero for none
+	Timeout time.Duration
+	// Resources requested by an execution and limits of its container
+	Resources Resources
+	// Network mode of an execution, see the Network* constants
+	Network string
+	// Relaxations of the runner security profile, applied once approved
+	Security SecurityRelaxations
 }
 
+const (
+	// MaxRetries is the maximum number of times a failed execution is retried
+	MaxRetries = 10
+	// MaxRetryDelay caps the delay before a retry, once doubled on each attempt
+	MaxRetryDelay = time.Hour
+)
+
 type Type string
 
 const (
@@ -2,7 +2,6 @@ package docker
 
 import (
 	"archive/tar"
-	"bufio"
 	"bytes"
 	"context"
 	"crypto/sha256"
@@ -10,6 +9,7 @@ import (
 	"io"
 	"log/slog"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,7 +17,6 @@ import (
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/client"
-	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/pkg/errors"
 	"github.com/rs/xid"
 
@@ -64,13 +63,6 @@ func (e *DockerExecutor) Execute(ctx context.Context, req task.ExecutionRequest)
 
 		onChange(execution)
 
-		// Set timeout if specified
-		if req.Timeout > 0 {
-			var cancel context.CancelFunc
-			ctx, cancel = context.WithTimeout(ctx, req.Timeout)
-			defer cancel()
-		}
-
 		execution.State = task.ExecutionStatePullingImage
 		onChange(execution)
 
@@ -118,6 +110,21 @@ func (e *DockerExecutor) Execute(ctx context.Context, req task.ExecutionRequest)
 			}
 		}()
 
+		// Give the forced user the ownership of the base, inputs and outputs directories
+		if req.Security.User != "" {
+			if err := e.chownDirectories(ctx, containerID, req.Security.User, task.BaseDir, task.InputsDir, task.OutputsDir); err != nil {
+				execution.State = task.ExecutionStateFailed
+				execution.Error = &task.ExecutionError{
+					Type:        task.ErrorTypeFileUploadFailed,
+					Message:     "failed to prepare container directories",
+					ContainerID: containerID,
+					Cause:       errors.WithStack(err),
+				}
+				onChange(execution)
+				return
+			}
+		}
+
 		// Upload files
 		if len(req.Inputs) > 0 {
 			execution.State = task.ExecutionStateUploadingFiles
@@ -143,6 +150,14 @@ func (e *DockerExecutor) Execute(ctx context.Context, req task.ExecutionRequest)
 		execution.State = task.ExecutionStateStartingContainer
 		onChange(execution)
 
+		// Set timeout if specified, counted from the container start
+		runCtx := ctx
+		if req.Timeout > 0 {
+			var cancel context.CancelFunc
+			runCtx, cancel = context.WithTimeout(ctx, req.Timeout)
+			defer cancel()
+		}
+
 		// Start container
 		if err := e.client.ContainerStart(ctx, containerID, container.StartOptions{}); err != nil {
 			execution.State = task.ExecutionStateFailed
@@ -160,15 +175,29 @@ func (e *DockerExecutor) Execute(ctx context.Context, req task.ExecutionRequest)
 		onChange(execution)
 
 		// Wait for container to finish
-		statusCh, errCh := e.client.ContainerWait(ctx, containerID, container.WaitConditionNotRunning)
+		statusCh, errCh := e.client.ContainerWait(runCtx, containerID, container.WaitConditionNotRunning)
 		select {
 
-		case <-ctx.Done():
+		case <-runCtx.Done():
 			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 			defer cancel()
 			if err := e.client.ContainerStop(shutdownCtx, containerID, container.StopOptions{}); err != nil {
 				slog.ErrorContext(shutdownCtx, "could not stop container", slog.String("containerID", containerID))
 			}
+
+			if ctx.Err() == nil && errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+				execution.FinishedAt = time.Now()
+				execution.State = task.ExecutionStateTimedOut
+				execution.Error = &task.ExecutionError{
+					Type:        task.ErrorTypeTimeout,
+					Message:     fmt.Sprintf("execution exceeded its timeout of %s", req.Timeout),
+					ContainerID: containerID,
+					Cause:       errors.WithStack(runCtx.Err()),
+				}
+				onChange(execution)
+				return
+			}
+
 			execution.State = task.ExecutionStateKilled
 			execution.Error = &task.ExecutionError{
 				Type:        task.ErrorTypeDockerDaemonError,
@@ -242,6 +271,11 @@ func (e *DockerExecutor) Execute(ctx context.Context, req task.ExecutionRequest)
 	return nil
 }
 
+// PullImage implements task.ImagePuller.PullImage
+func (e *DockerExecutor) PullImage(ctx context.Context, imageRef string) error {
+	return e.pullImage(ctx, imageRef)
+}
+
 // pullImage pulls the image
 func (e *DockerExecutor) pullImage(ctx context.Context, imageRef string) error {
 	e.logger.Info("pulling image", "image", imageRef)
@@ -300,6 +334,18 @@ func (e *DockerExecutor) createContainer(ctx context.Context, runID string, req
 
 	env = append(env, fmt.Sprintf("OPLET_RUN_ID=%s", runID))
 
+	networkMode := req.Network.Name
+	if networkMode == "" {
+		networkMode = task.NetworkBridge
+	}
+
+	if req.Network.ProxyURL != "" {
+		for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy"} {
+			env = append(env, fmt.Sprintf("%s=%s", name, req.Network.ProxyURL))
+		}
+		env = append(env, "NO_PROXY=localhost,127.0.0.1", "no_proxy=localhost,127.0.0.1")
+	}
+
 	// Build container configuration
 	config := &container.Config{
 		Image: req.ImageRef,
@@ -307,11 +353,19 @@ func (e *DockerExecutor) createContainer(ctx context.Context, runID string, req
 		Labels: map[string]string{
 			"io.oplet.run.id": runID,
 		},
+		User:         req.Security.User,
 		AttachStdout: true,
 		AttachStderr: true,
 	}
 
+	// The base directory is a volume so that the progress file can be written
+	// whatever the security profile
 	containerMounts := []mount.Mount{
+		{
+			Type:   mount.TypeVolume,
+			Source: "oplet-run-" + runID,
+			Target: task.BaseDir,
+		},
 		{
 			Type:   mount.TypeVolume,
 			Source: "oplet-inputs-" + runID,
@@ -342,21 +396,49 @@ func (e *DockerExecutor) createContainer(ctx context.Context, runID string, req
 		req.Constraints.MaxMemory = int64(0.5 * float64(memory.Total))
 	}
 
-	e.logger.Debug("applying constraints", "max_memory", req.Constraints.MaxMemory, "cpus", req.Constraints.CPUs)
+	e.logger.Debug("applying constraints", "max_memory", req.Constraints.MaxMemory, "cpus", req.Constraints.CPUs, "network", networkMode, "security", req.Security.String())
 
 	// Build host configuration
 	hostConfig := &container.HostConfig{
-		NetworkMode:    container.NetworkMode("bridge"),
-		ReadonlyRootfs: false,
+		NetworkMode:    container.NetworkMode(networkMode),
+		ReadonlyRootfs: req.Security.ReadOnlyRootfs,
 		AutoRemove:     false,
 		Mounts:         containerMounts,
 		Binds:          cacheBinds,
+		CapAdd:         req.Security.Capabilities,
 		Resources: container.Resources{
 			NanoCPUs: int64(req.Constraints.CPUs * 1000000000.0),
 			Memory:   req.Constraints.MaxMemory,
 		},
 	}
 
+	if req.Security.ReadOnlyRootfs {
+		hostConfig.Tmpfs = map[string]string{
+			"/tmp": "rw,nosuid,nodev",
+		}
+	}
+
+	if req.Security.DropCapabilities {
+		hostConfig.CapDrop = []string{"ALL"}
+	}
+
+	if req.Security.NoNewPrivileges {
+		hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, "no-new-privileges:true")
+	}
+
+	if req.Security.SeccompProfile != "" {
+		seccompProfile, err := os.ReadFile(req.Security.SeccompProfile)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to read seccomp profile '%s'", req.Security.SeccompProfile)
+		}
+
+		hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, "seccomp="+string(seccompProfile))
+	}
+
+	if req.Security.PidsLimit > 0 {
+		hostConfig.Resources.PidsLimit = &req.Security.PidsLimit
+	}
+
 	// Create container
 	resp, err := e.client.ContainerCreate(ctx, config, hostConfig, nil, nil, fmt.Sprintf("oplet-task-%s", runID))
 	if err != nil {
@@ -368,6 +450,53 @@ func (e *DockerExecutor) createContainer(ctx context.Context, runID string, req
 	return resp.ID, nil
 }
 
+// chownDirectories gives the ownership of the given container directories to
+// the user the container runs as, formatted as uid[:gid]
+func (e *DockerExecutor) chownDirectories(ctx context.Context, containerID string, user string, dirs ...string) error {
+	rawUID, rawGID, found := strings.Cut(user, ":")
+	if !found {
+		rawGID = rawUID
+	}
+
+	uid, err := strconv.Atoi(rawUID)
+	if err != nil {
+		return errors.Wrapf(err, "invalid uid '%s'", rawUID)
+	}
+
+	gid, err := strconv.Atoi(rawGID)
+	if err != nil {
+		return errors.Wrapf(err, "invalid gid '%s'", rawGID)
+	}
+
+	for _, dir := range dirs {
+		var buff bytes.Buffer
+
+		tw := tar.NewWriter(&buff)
+
+		header := &tar.Header{
+			Name:     "./",
+			Typeflag: tar.TypeDir,
+			Mode:     0755,
+			Uid:      uid,
+			Gid:      gid,
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return errors.Wrapf(err, "failed to write TAR header for %s", dir)
+		}
+
+		if err := tw.Close(); err != nil {
+			return errors.WithStack(err)
+		}
+
+		if err := e.client.CopyToContainer(ctx, containerID, dir, &buff, container.CopyToContainerOptions{}); err != nil {
+			return errors.Wrapf(err, "failed to change ownership of %s", dir)
+		}
+	}
+
+	return nil
+}
+
 // uploadFiles uploads files to the container using TAR streams
 func (e *DockerExecutor) uploadFiles(ctx context.Context, containerID string, inputsDir string, files map[string]io.ReadCloser) error {
 	if len(files) == 0 {
@@ -459,10 +588,43 @@ func (e *DockerExecutor) downloadFiles(ctx context.Context, containerID string,
 	return tar.NewReader(reader), close, nil
 }
 
+// ReadProgress implements task.ProgressReader
+func (e *DockerExecutor) ReadProgress(ctx context.Context, containerID string) (string, error) {
+	reader, _, err := e.client.CopyFromContainer(ctx, containerID, task.ProgressFile)
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			return "", nil
+		}
+
+		return "", errors.WithStack(err)
+	}
+
+	defer reader.Close()
+
+	tr := tar.NewReader(reader)
+
+	header, err := tr.Next()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	// Only the end of the file is read, the last line holding the progress
+	if header.Size > task.MaxProgressFileSize {
+		if _, err := io.CopyN(io.Discard, tr, header.Size-task.MaxProgressFileSize); err != nil {
+			return "", errors.WithStack(err)
+		}
+	}
+
+	data, err := io.ReadAll(tr)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return string(data), nil
+}
+
 // GetLogs implements task.Executor.GetLogs
 func (e *DockerExecutor) GetLogs(ctx context.Context, containerID string) (chan task.LogEntry, error) {
-	var clock uint = 0
-
 	options := container.LogsOptions{
 		ShowStdout: true,
 		ShowStderr: true,
@@ -486,57 +648,37 @@ func (e *DockerExecutor) GetLogs(ctx context.Context, containerID string) (chan
 
 	logs := make(chan task.LogEntry)
 
-	r, w := io.Pipe()
-	lineScanner := bufio.NewScanner(r)
-
 	go func() {
+		defer close(logs)
+
 		defer func() {
 			if err := logReader.Close(); err != nil {
 				e.logger.ErrorContext(ctx, "could not close log reader", slogx.Error(errors.WithStack(err)))
 			}
-
-			if err := w.Close(); err != nil {
-				e.logger.ErrorContext(ctx, "could not close log writer", slogx.Error(errors.WithStack(err)))
-			}
 		}()
 
-		if _, err := stdcopy.StdCopy(w, w, logReader); err != nil {
-			e.logger.ErrorContext(ctx, "could not close log reader", slogx.Error(errors.WithStack(err)))
-		}
-	}()
-
-	go func() {
-		defer close(logs)
+		// Both streams are demultiplexed in a single pass, the clock of the
+		// entries following the order of the logs of the container
+		var clock uint = 0
 
-		for lineScanner.Scan() {
-			message := lineScanner.Text()
-			line := message
-			timestamp := time.Now()
-
-			if strings.Contains(message, "T") && strings.Contains(message, "Z") {
-				parts := strings.SplitN(message, " ", 2)
-				if len(parts) == 2 {
-					line = parts[1]
-					ts, err := time.Parse(time.RFC3339, parts[0])
-					if err != nil {
-						e.logger.ErrorContext(ctx, "could not parse log entry timestamp", slog.String("logMessage", message), slogx.Error(errors.WithStack(err)))
-					} else {
-						timestamp = ts
-					}
-				}
-			}
-
-			logs <- task.LogEntry{
-				Timestamp: timestamp,
-				Message:   line,
+		err := readLogLines(logReader, func(line logLine) bool {
+			select {
+			case logs <- task.LogEntry{
+				Timestamp: line.Timestamp,
+				Stream:    line.Stream,
+				Message:   line.Message,
 				Clock:     clock,
+			}:
+			case <-ctx.Done():
+				return false
 			}
 
 			clock++
-		}
 
-		if err := lineScanner.Err(); err != nil {
-			e.logger.ErrorContext(ctx, "could not scan for next log line", slogx.Error(errors.WithStack(err)))
+			return true
+		})
+		if err != nil && ctx.Err() == nil {
+			e.logger.ErrorContext(ctx, "could not read container logs", slogx.Error(errors.WithStack(err)))
 		}
 	}()
 
@@ -600,6 +742,12 @@ func (e *DockerExecutor) remove(ctx context.Context, containerID string) error {
 // Ensure DockerExecutor implements task.Executor interface
 var _ task.Executor = &DockerExecutor{}
 
+// Ensure DockerExecutor implements task.ImagePuller interface
+var _ task.ImagePuller = &DockerExecutor{}
+
+// Ensure DockerExecutor implements task.ProgressReader interface
+var _ task.ProgressReader = &DockerExecutor{}
+
 // generateCacheVolumeName creates a consistent name for the volume.
 // Format: oplet-cache-<sanitized_image_name>-<hash_of_mount_path>
 func generateCacheVolumeName(imageName, mountPath string) string {
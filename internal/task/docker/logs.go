@@ -0,0 +1,133 @@
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/pkg/errors"
+
+	"github.com/bornholm/oplet/internal/task"
+)
+
+const (
+	// logFrameHeaderSize is the size of the header of the frames of the
+	// multiplexed logs, holding their stream and the size of their payload
+	logFrameHeaderSize = 8
+	// maxLogFrameSize is the maximum size of the payload of a frame
+	maxLogFrameSize = 1 << 20
+	// maxLogLineSize is the size after which a line without line break is
+	// split
+	maxLogLineSize = 64 << 10
+)
+
+// logLine is a line of the logs of a container, stripped of its timestamp
+type logLine struct {
+	Stream    task.LogStream
+	Timestamp time.Time
+	Message   string
+}
+
+// readLogLines demultiplexes the stdout and stderr frames of the logs of a
+// container in a single pass, calling fn with each line in the order of the
+// frames, so that the entries are numbered in the same order on each read.
+// It stops once fn returns false.
+func readLogLines(r io.Reader, fn func(line logLine) bool) error {
+	reader := bufio.NewReader(r)
+
+	header := make([]byte, logFrameHeaderSize)
+
+	pending := map[task.LogStream]*bytes.Buffer{
+		task.LogStreamStdout: {},
+		task.LogStreamStderr: {},
+	}
+
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				// Lines without a final line break
+				for _, stream := range []task.LogStream{task.LogStreamStdout, task.LogStreamStderr} {
+					if buf := pending[stream]; buf.Len() > 0 && !fn(parseLogLine(stream, buf.String())) {
+						return nil
+					}
+				}
+
+				return nil
+			}
+
+			return errors.WithStack(err)
+		}
+
+		size := binary.BigEndian.Uint32(header[4:])
+		if size > maxLogFrameSize {
+			return errors.Errorf("log frame of %d bytes exceeds the maximum of %d bytes", size, maxLogFrameSize)
+		}
+
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return errors.WithStack(err)
+		}
+
+		var stream task.LogStream
+
+		switch stdcopy.StdType(header[0]) {
+		case stdcopy.Stdout:
+			stream = task.LogStreamStdout
+		case stdcopy.Stderr:
+			stream = task.LogStreamStderr
+		case stdcopy.Systemerr:
+			return errors.Errorf("error from the docker daemon: %s", payload)
+		default:
+			return errors.Errorf("unexpected log stream %d", header[0])
+		}
+
+		buf := pending[stream]
+		buf.Write(payload)
+
+		for {
+			index := bytes.IndexByte(buf.Bytes(), '\n')
+			if index < 0 {
+				if buf.Len() < maxLogLineSize {
+					break
+				}
+
+				index = buf.Len() - 1
+			}
+
+			message := strings.TrimSuffix(string(buf.Next(index+1)), "\n")
+
+			if !fn(parseLogLine(stream, message)) {
+				return nil
+			}
+		}
+	}
+}
+
+// parseLogLine strips the timestamp prefixed by the docker daemon to the
+// given message, the current time being used if it has none
+func parseLogLine(stream task.LogStream, message string) logLine {
+	line := logLine{
+		Stream:    stream,
+		Timestamp: time.Now(),
+		Message:   message,
+	}
+
+	rawTimestamp, rest, found := strings.Cut(message, " ")
+	if !found {
+		return line
+	}
+
+	timestamp, err := time.Parse(time.RFC3339Nano, rawTimestamp)
+	if err != nil {
+		return line
+	}
+
+	line.Timestamp = timestamp
+	line.Message = rest
+
+	return line
+}
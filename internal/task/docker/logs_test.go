@@ -0,0 +1,115 @@
+package docker
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/pkg/errors"
+
+	"github.com/bornholm/oplet/internal/task"
+)
+
+type logFrame struct {
+	Stream  stdcopy.StdType
+	Payload string
+}
+
+func TestReadLogLines(t *testing.T) {
+	type testCase struct {
+		name          string
+		frames        []logFrame
+		expected      []logLine
+		expectedError bool
+	}
+
+	timestamp := time.Date(2025, 1, 2, 3, 4, 5, 600000000, time.UTC)
+
+	testCases := []testCase{
+		{
+			name: "interleaved streams keep the order of the frames",
+			frames: []logFrame{
+				{Stream: stdcopy.Stdout, Payload: "2025-01-02T03:04:05.6Z first\n"},
+				{Stream: stdcopy.Stderr, Payload: "2025-01-02T03:04:05.6Z second\n"},
+				{Stream: stdcopy.Stdout, Payload: "2025-01-02T03:04:05.6Z third\n"},
+			},
+			expected: []logLine{
+				{Stream: task.LogStreamStdout, Timestamp: timestamp, Message: "first"},
+				{Stream: task.LogStreamStderr, Timestamp: timestamp, Message: "second"},
+				{Stream: task.LogStreamStdout, Timestamp: timestamp, Message: "third"},
+			},
+		},
+		{
+			name: "lines split across frames",
+			frames: []logFrame{
+				{Stream: stdcopy.Stdout, Payload: "2025-01-02T03:04:05.6Z hello "},
+				{Stream: stdcopy.Stderr, Payload: "2025-01-02T03:04:05.6Z error\n"},
+				{Stream: stdcopy.Stdout, Payload: "world\n2025-01-02T03:04:05.6Z unterminated"},
+			},
+			expected: []logLine{
+				{Stream: task.LogStreamStderr, Timestamp: timestamp, Message: "error"},
+				{Stream: task.LogStreamStdout, Timestamp: timestamp, Message: "hello world"},
+				{Stream: task.LogStreamStdout, Timestamp: timestamp, Message: "unterminated"},
+			},
+		},
+		{
+			name: "daemon error",
+			frames: []logFrame{
+				{Stream: stdcopy.Stdout, Payload: "2025-01-02T03:04:05.6Z first\n"},
+				{Stream: stdcopy.Systemerr, Payload: "failure"},
+			},
+			expected: []logLine{
+				{Stream: task.LogStreamStdout, Timestamp: timestamp, Message: "first"},
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+
+			for _, f := range tc.frames {
+				if _, err := stdcopy.NewStdWriter(&buf, f.Stream).Write([]byte(f.Payload)); err != nil {
+					t.Fatalf("%+v", errors.WithStack(err))
+				}
+			}
+
+			lines := make([]logLine, 0)
+
+			err := readLogLines(&buf, func(line logLine) bool {
+				lines = append(lines, line)
+				return true
+			})
+
+			if tc.expectedError && err == nil {
+				t.Errorf("expected an error, got nil")
+			}
+
+			if !tc.expectedError && err != nil {
+				t.Fatalf("%+v", errors.WithStack(err))
+			}
+
+			if e, g := len(tc.expected), len(lines); e != g {
+				t.Fatalf("lines: expected %d, got %d (%v)", e, g, lines)
+			}
+
+			for i, expected := range tc.expected {
+				line := lines[i]
+
+				if e, g := expected.Stream, line.Stream; e != g {
+					t.Errorf("line #%d stream: expected '%s', got '%s'", i, e, g)
+				}
+
+				if e, g := expected.Message, line.Message; e != g {
+					t.Errorf("line #%d message: expected '%s', got '%s'", i, e, g)
+				}
+
+				if e, g := expected.Timestamp, line.Timestamp; !e.Equal(g) {
+					t.Errorf("line #%d timestamp: expected '%s', got '%s'", i, e, g)
+				}
+			}
+		})
+	}
+}
@@ -11,8 +11,10 @@ import (
 )
 
 const (
-	InputsDir  string = "/oplet/inputs"
-	OutputsDir string = "/oplet/outputs"
+	BaseDir      string = "/oplet"
+	InputsDir    string = "/oplet/inputs"
+	OutputsDir   string = "/oplet/outputs"
+	ProgressFile string = "/oplet/progress"
 )
 
 // ExecutionRequest represents a container execution request
@@ -20,9 +22,11 @@ type ExecutionRequest struct {
 	ImageRef    string                   // Docker image reference
 	Environment map[string]string        // Environment variables
 	Inputs      map[string]io.ReadCloser // Files to upload to container
-	Timeout     time.Duration            // Execution timeout (optional)
+	Timeout     time.Duration            // Container execution timeout (optional)
 
 	Constraints Constraints
+	Network     Network
+	Security    SecurityProfile
 
 	OnChange func(Execution)
 }
@@ -30,11 +34,21 @@ type ExecutionRequest struct {
 type Constraints struct {
 	CPUs      float64 // CPU quota
 	MaxMemory int64   // Max memory in bytes
+	MaxFuel   uint64  // Max fuel, consumed by WASM executions on each function call
 }
 
+// LogStream is the output stream a log entry was written to
+type LogStream string
+
+const (
+	LogStreamStdout LogStream = "stdout"
+	LogStreamStderr LogStream = "stderr"
+)
+
 type LogEntry struct {
 	Clock     uint
 	Timestamp time.Time
+	Stream    LogStream // Empty when the executor can not tell the streams apart
 	Message   string
 }
 
@@ -57,6 +71,7 @@ const (
 	ExecutionStateSucceeded
 	ExecutionStateFailed
 	ExecutionStateKilled
+	ExecutionStateTimedOut
 )
 
 // ExecutionResult represents the result of container execution
@@ -84,6 +99,20 @@ type Executor interface {
 	GetLogs(ctx context.Context, containerID string) (chan LogEntry, error)
 }
 
+// ImagePuller is implemented by the executors able to pull an image ahead
+// of the executions using it
+type ImagePuller interface {
+	PullImage(ctx context.Context, imageRef string) error
+}
+
+// ProgressReader is implemented by the executors able to read the progress
+// file written by a running task
+type ProgressReader interface {
+	// ReadProgress returns the content of the progress file, empty if the
+	// task did not write it
+	ReadProgress(ctx context.Context, containerID string) (string, error)
+}
+
 // ContainerInfo provides information about a container
 type ContainerInfo struct {
 	ID       string            // Container ID
@@ -133,6 +162,18 @@ const (
 	ErrorTypeFileUploadFailed   ExecutionErrorType = "file_upload_failed"
 	ErrorTypeFileDownloadFailed ExecutionErrorType = "file_download_failed"
 	ErrorTypeDockerDaemonError  ExecutionErrorType = "docker_daemon_error"
+	ErrorTypeTimeout            ExecutionErrorType = "timeout"
+	ErrorTypeNetworkNotAllowed  ExecutionErrorType = "network_not_allowed"
+	ErrorTypeCommandNotFound    ExecutionErrorType = "command_not_found"
+	ErrorTypeProcessError       ExecutionErrorType = "process_error"
+	ErrorTypeKubernetesError    ExecutionErrorType = "kubernetes_error"
+	ErrorTypeWasmError          ExecutionErrorType = "wasm_error"
+	ErrorTypeFuelExhausted      ExecutionErrorType = "fuel_exhausted"
+	// Reported by the server when the runner holding an execution disappears
+	ErrorTypeRunnerLost ExecutionErrorType = "runner_lost"
+	// Reported by the runner when the output files of an execution could
+	// not be sent to the server
+	ErrorTypeOutputUploadFailed ExecutionErrorType = "output_upload_failed"
 )
 
 // Predefined errors
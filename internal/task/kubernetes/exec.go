@@ -0,0 +1,80 @@
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// execFunc runs a command in a container of a pod, streaming the given
+// reader to its stdin and its stdout to the given writer, both optional
+type execFunc func(ctx context.Context, pod string, container string, command []string, stdin io.Reader, stdout io.Writer) error
+
+// newExecFunc returns an execFunc using the exec API of the pods of the namespace
+func newExecFunc(client kubernetes.Interface, config *rest.Config, namespace string) execFunc {
+	return func(ctx context.Context, pod string, container string, command []string, stdin io.Reader, stdout io.Writer) error {
+		req := client.CoreV1().RESTClient().Post().
+			Resource("pods").
+			Namespace(namespace).
+			Name(pod).
+			SubResource("exec").
+			VersionedParams(&corev1.PodExecOptions{
+				Container: container,
+				Command:   command,
+				Stdin:     stdin != nil,
+				Stdout:    stdout != nil,
+				Stderr:    true,
+			}, scheme.ParameterCodec)
+
+		executor, err := remotecommand.NewSPDYExecutor(config, http.MethodPost, req.URL())
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		var stderr bytes.Buffer
+
+		err = executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+			Stdin:  stdin,
+			Stdout: stdout,
+			Stderr: &limitedWriter{w: &stderr, remaining: maxStderrSize},
+		})
+		if err != nil {
+			return errors.Wrapf(err, "command '%s' failed: %s", strings.Join(command, " "), strings.TrimSpace(stderr.String()))
+		}
+
+		return nil
+	}
+}
+
+// maxStderrSize is the maximum number of bytes of the stderr of the commands
+// kept for their errors
+const maxStderrSize = 4096
+
+// limitedWriter discards the bytes written once its limit is reached
+type limitedWriter struct {
+	w         io.Writer
+	remaining int
+}
+
+// Write implements io.Writer
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if w.remaining > 0 {
+		n := min(len(p), w.remaining)
+		if _, err := w.w.Write(p[:n]); err != nil {
+			return 0, errors.WithStack(err)
+		}
+
+		w.remaining -= n
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,623 @@
+package kubernetes
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/xid"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/bornholm/oplet/internal/slogx"
+	"github.com/bornholm/oplet/internal/task"
+)
+
+// JobExecutor implements task.Executor by running each execution as a
+// Kubernetes job. Input and output files are streamed as TAR archives
+// through the exec API of helper containers sharing volumes with the task.
+//
+// Pids limits and network modes are not enforced.
+type JobExecutor struct {
+	client kubernetes.Interface
+	exec   execFunc
+	opts   *Options
+	logger *slog.Logger
+}
+
+// NewClient creates a Kubernetes client from the given kubeconfig file,
+// or from the in-cluster configuration if empty, along with its configuration
+func NewClient(kubeconfig string) (kubernetes.Interface, *rest.Config, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "could not load kubernetes configuration")
+	}
+
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "could not create kubernetes client")
+	}
+
+	return client, config, nil
+}
+
+func NewExecutor(client kubernetes.Interface, config *rest.Config, logger *slog.Logger, funcs ...OptionFunc) (*JobExecutor, error) {
+	opts, err := NewOptions(funcs...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &JobExecutor{
+		client: client,
+		exec:   newExecFunc(client, config, opts.Namespace),
+		opts:   opts,
+		logger: logger.With("component", "kubernetes-executor"),
+	}, nil
+}
+
+// Execute implements task.Executor.Execute
+func (e *JobExecutor) Execute(ctx context.Context, req task.ExecutionRequest) error {
+	runID := xid.New().String()
+
+	e.logger.Info("starting job execution", "image", req.ImageRef, "namespace", e.opts.Namespace)
+
+	onChange := req.OnChange
+	if onChange == nil {
+		onChange = func(e task.Execution) {}
+	}
+
+	go func() {
+		execution := task.Execution{
+			State: task.ExecutionStateProcessingRequest,
+		}
+
+		onChange(execution)
+
+		execution.State = task.ExecutionStateCreatingContainer
+		onChange(execution)
+
+		// Ensure cleanup
+		defer func() {
+			cleanupCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			if err := e.remove(cleanupCtx, runID); err != nil {
+				e.logger.Warn("failed to cleanup job", "job", jobName(runID), "error", err)
+			}
+		}()
+
+		// Create job
+		job, err := newJob(e.opts, runID, req, len(req.Inputs) > 0)
+		if err != nil {
+			execution.State = task.ExecutionStateFailed
+			execution.Error = &task.ExecutionError{
+				Type:    task.ErrorTypeKubernetesError,
+				Message: "failed to prepare job",
+				Cause:   errors.WithStack(err),
+			}
+			onChange(execution)
+			return
+		}
+
+		if _, err := e.client.BatchV1().Jobs(e.opts.Namespace).Create(ctx, job, metav1.CreateOptions{}); err != nil {
+			execution.State = task.ExecutionStateFailed
+			execution.Error = &task.ExecutionError{
+				Type:    task.ErrorTypeKubernetesError,
+				Message: "failed to create job",
+				Cause:   errors.WithStack(err),
+			}
+			onChange(execution)
+			return
+		}
+
+		execution.ContainerID = job.Name
+		execution.State = task.ExecutionStateContainerCreated
+		onChange(execution)
+
+		// Set timeout if specified, counted from the job creation
+		runCtx := ctx
+		if req.Timeout > 0 {
+			var cancel context.CancelFunc
+			runCtx, cancel = context.WithTimeout(ctx, req.Timeout)
+			defer cancel()
+		}
+
+		// Upload files
+		if len(req.Inputs) > 0 {
+			execution.State = task.ExecutionStateUploadingFiles
+			onChange(execution)
+
+			if err := e.uploadFiles(runCtx, runID, req.Inputs); err != nil {
+				if runCtx.Err() != nil {
+					e.handleWaitError(ctx, runCtx, &execution, job.Name, req, err, onChange)
+					return
+				}
+
+				execution.State = task.ExecutionStateFailed
+				execution.Error = &task.ExecutionError{
+					Type:        task.ErrorTypeFileUploadFailed,
+					Message:     "error uploading files",
+					ContainerID: job.Name,
+					Cause:       errors.WithStack(err),
+				}
+				onChange(execution)
+				return
+			}
+
+			execution.State = task.ExecutionStateFilesUploaded
+			onChange(execution)
+		}
+
+		execution.StartedAt = time.Now()
+		execution.State = task.ExecutionStateStartingContainer
+		onChange(execution)
+
+		// Wait for the task container to start
+		pod, err := e.waitForPod(runCtx, runID, func(pod *corev1.Pod) (bool, error) {
+			status := containerStatus(pod.Status.InitContainerStatuses, containerTask)
+			if status == nil {
+				return podFailed(pod)
+			}
+
+			if status.State.Waiting != nil {
+				switch status.State.Waiting.Reason {
+				case "ErrImagePull", "ImagePullBackOff", "InvalidImageName":
+					return false, &task.ExecutionError{
+						Type:        task.ErrorTypeImagePullFailed,
+						Message:     fmt.Sprintf("failed to pull image %s", req.ImageRef),
+						ContainerID: job.Name,
+						Cause:       errors.New(status.State.Waiting.Message),
+					}
+				}
+			}
+
+			if status.State.Running != nil || status.State.Terminated != nil {
+				return true, nil
+			}
+
+			return podFailed(pod)
+		})
+		if err != nil {
+			e.handleWaitError(ctx, runCtx, &execution, job.Name, req, err, onChange)
+			return
+		}
+
+		execution.State = task.ExecutionStateContainerStarted
+		onChange(execution)
+
+		// Wait for the task container to finish
+		pod, err = e.waitForPod(runCtx, runID, func(pod *corev1.Pod) (bool, error) {
+			status := containerStatus(pod.Status.InitContainerStatuses, containerTask)
+			if status != nil && status.State.Terminated != nil {
+				return true, nil
+			}
+
+			return podFailed(pod)
+		})
+		if err != nil {
+			e.handleWaitError(ctx, runCtx, &execution, job.Name, req, err, onChange)
+			return
+		}
+
+		terminated := containerStatus(pod.Status.InitContainerStatuses, containerTask).State.Terminated
+
+		execution.ExitCode = int(terminated.ExitCode)
+		execution.FinishedAt = time.Now()
+		execution.State = task.ExecutionStateContainerFinished
+		onChange(execution)
+
+		failed := execution.ExitCode != 0
+
+		if !failed {
+			execution.State = task.ExecutionStateDownloadingFiles
+			onChange(execution)
+
+			// Download output files
+			outputFiles, close, err := e.downloadFiles(ctx, runID)
+			if err != nil {
+				execution.State = task.ExecutionStateFailed
+				execution.Error = &task.ExecutionError{
+					Type:        task.ErrorTypeFileDownloadFailed,
+					Message:     "error downloading files",
+					ContainerID: job.Name,
+					Cause:       errors.WithStack(err),
+				}
+				onChange(execution)
+				return
+			}
+
+			execution.Outputs = outputFiles
+			defer close()
+
+			execution.State = task.ExecutionStateFilesDownloaded
+			onChange(execution)
+		}
+
+		e.logger.Info("job execution completed",
+			"job", job.Name,
+			"exit_code", execution.ExitCode,
+			"duration", execution.FinishedAt.Sub(execution.StartedAt))
+
+		if failed {
+			execution.State = task.ExecutionStateFailed
+		} else {
+			execution.State = task.ExecutionStateSucceeded
+		}
+
+		onChange(execution)
+	}()
+
+	return nil
+}
+
+// handleWaitError reports the failure of an execution while waiting for its pod
+func (e *JobExecutor) handleWaitError(ctx context.Context, runCtx context.Context, execution *task.Execution, jobName string, req task.ExecutionRequest, err error, onChange func(task.Execution)) {
+	if runCtx.Err() != nil {
+		if ctx.Err() == nil && errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+			execution.FinishedAt = time.Now()
+			execution.State = task.ExecutionStateTimedOut
+			execution.Error = &task.ExecutionError{
+				Type:        task.ErrorTypeTimeout,
+				Message:     fmt.Sprintf("execution exceeded its timeout of %s", req.Timeout),
+				ContainerID: jobName,
+				Cause:       errors.WithStack(runCtx.Err()),
+			}
+			onChange(*execution)
+			return
+		}
+
+		execution.State = task.ExecutionStateKilled
+		execution.Error = &task.ExecutionError{
+			Type:        task.ErrorTypeKubernetesError,
+			Message:     "job killed",
+			ContainerID: jobName,
+			Cause:       errors.WithStack(runCtx.Err()),
+		}
+		onChange(*execution)
+		return
+	}
+
+	var executionErr *task.ExecutionError
+	if !errors.As(err, &executionErr) {
+		executionErr = &task.ExecutionError{
+			Type:        task.ErrorTypeKubernetesError,
+			Message:     "error waiting for job",
+			ContainerID: jobName,
+			Cause:       errors.WithStack(err),
+		}
+	}
+
+	execution.State = task.ExecutionStateFailed
+	execution.Error = executionErr
+	onChange(*execution)
+}
+
+// uploadFiles extracts the TAR archive of the input files in the inputs
+// volume, through the container waiting for them, then releases it
+func (e *JobExecutor) uploadFiles(ctx context.Context, runID string, files map[string]io.ReadCloser) error {
+	pod, err := e.waitForHelper(ctx, runID, func(pod *corev1.Pod) *corev1.ContainerStatus {
+		return containerStatus(pod.Status.InitContainerStatuses, containerInputs)
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	pr, pw := io.Pipe()
+
+	errChan := make(chan error, 1)
+
+	go func() {
+		err := writeInputsArchive(pw, files)
+		pw.CloseWithError(err)
+		errChan <- err
+	}()
+
+	extractErr := e.exec(ctx, pod.Name, containerInputs, []string{"tar", "-xf", "-", "-C", task.InputsDir}, pr, nil)
+
+	// Unblocks the archive writer if the extraction stopped early
+	pr.Close()
+
+	routineErr := <-errChan
+
+	if routineErr != nil {
+		return errors.Wrap(routineErr, "tar creation failed")
+	}
+
+	if extractErr != nil {
+		return errors.Wrap(extractErr, "tar extraction failed")
+	}
+
+	if err := e.exec(ctx, pod.Name, containerInputs, []string{"touch", markerInputs}, nil, nil); err != nil {
+		return errors.Wrap(err, "could not release inputs container")
+	}
+
+	e.logger.Debug("files uploaded successfully", "pod", pod.Name)
+
+	return nil
+}
+
+// writeInputsArchive writes the TAR archive of the input files, named after
+// their base name, closing them
+func writeInputsArchive(w io.Writer, files map[string]io.ReadCloser) error {
+	tw := tar.NewWriter(w)
+
+	for filename, file := range files {
+		if err := writeArchiveFile(tw, filepath.Base(filename), file); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func writeArchiveFile(tw *tar.Writer, name string, file io.ReadCloser) error {
+	defer file.Close()
+
+	var size int64
+	if f, ok := file.(interface{ Stat() (os.FileInfo, error) }); ok {
+		info, err := f.Stat()
+		if err != nil {
+			return errors.Wrapf(err, "failed to stat file %s", name)
+		}
+		size = info.Size()
+	} else {
+		content, err := io.ReadAll(file)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read file %s", name)
+		}
+		size = int64(len(content))
+		file = io.NopCloser(bytes.NewReader(content))
+	}
+
+	header := &tar.Header{
+		Name: name,
+		Mode: 0644,
+		Size: size,
+	}
+
+	if err := tw.WriteHeader(header); err != nil {
+		return errors.Wrapf(err, "failed to write TAR header for %s", name)
+	}
+
+	if _, err := io.Copy(tw, file); err != nil {
+		return errors.Wrapf(err, "failed to write TAR content for %s", name)
+	}
+
+	return nil
+}
+
+// waitForHelper waits for the helper container returned by the given
+// function to run, failing if it exited
+func (e *JobExecutor) waitForHelper(ctx context.Context, runID string, helper func(pod *corev1.Pod) *corev1.ContainerStatus) (*corev1.Pod, error) {
+	pod, err := e.waitForPod(ctx, runID, func(pod *corev1.Pod) (bool, error) {
+		status := helper(pod)
+		if status != nil {
+			if status.State.Running != nil {
+				return true, nil
+			}
+
+			if status.State.Terminated != nil {
+				return false, errors.Errorf("container %s exited with code %d", status.Name, status.State.Terminated.ExitCode)
+			}
+		}
+
+		return podFailed(pod)
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return pod, nil
+}
+
+// waitForPod polls the pod of an execution until the given condition is met
+func (e *JobExecutor) waitForPod(ctx context.Context, runID string, condition func(pod *corev1.Pod) (bool, error)) (*corev1.Pod, error) {
+	ticker := time.NewTicker(e.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		pod, err := e.findPod(ctx, runID)
+		if err != nil && !errors.Is(err, task.ErrContainerNotFound) {
+			return nil, errors.WithStack(err)
+		}
+
+		if pod != nil {
+			done, err := condition(pod)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+
+			if done {
+				return pod, nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, errors.WithStack(ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// findPod returns the pod created by the job of an execution
+func (e *JobExecutor) findPod(ctx context.Context, runID string) (*corev1.Pod, error) {
+	pods, err := e.client.CoreV1().Pods(e.opts.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labelRunID + "=" + runID,
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if len(pods.Items) == 0 {
+		return nil, errors.WithStack(task.ErrContainerNotFound)
+	}
+
+	return &pods.Items[0], nil
+}
+
+// downloadFiles streams the TAR archive of the outputs directory from the
+// collector container. The returned function must be called once the
+// archive is read, releasing the collector.
+func (e *JobExecutor) downloadFiles(ctx context.Context, runID string) (*tar.Reader, func(), error) {
+	pod, err := e.waitForHelper(ctx, runID, func(pod *corev1.Pod) *corev1.ContainerStatus {
+		return containerStatus(pod.Status.ContainerStatuses, containerCollector)
+	})
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	pr, pw := io.Pipe()
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		command := []string{"tar", "-cf", "-", "-C", filepath.Dir(task.OutputsDir), filepath.Base(task.OutputsDir)}
+
+		// The errors are returned to the reader of the archive
+		pw.CloseWithError(e.exec(ctx, pod.Name, containerCollector, command, nil, pw))
+	}()
+
+	release := func() {
+		pr.Close()
+		<-done
+
+		if err := e.exec(ctx, pod.Name, containerCollector, []string{"touch", markerOutputs}, nil, nil); err != nil {
+			e.logger.WarnContext(ctx, "could not release outputs collector", "pod", pod.Name, slogx.Error(errors.WithStack(err)))
+		}
+	}
+
+	return tar.NewReader(pr), release, nil
+}
+
+// GetLogs implements task.Executor.GetLogs
+func (e *JobExecutor) GetLogs(ctx context.Context, jobName string) (chan task.LogEntry, error) {
+	runID := strings.TrimPrefix(jobName, "oplet-task-")
+
+	pod, err := e.findPod(ctx, runID)
+	if err != nil {
+		return nil, &task.ExecutionError{
+			Type:        task.ErrorTypeKubernetesError,
+			Message:     "failed to get job logs",
+			ContainerID: jobName,
+			Cause:       errors.WithStack(err),
+		}
+	}
+
+	stream, err := e.client.CoreV1().Pods(e.opts.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container:  containerTask,
+		Follow:     true,
+		Timestamps: true,
+	}).Stream(ctx)
+	if err != nil {
+		return nil, &task.ExecutionError{
+			Type:        task.ErrorTypeKubernetesError,
+			Message:     "failed to get job logs",
+			ContainerID: jobName,
+			Cause:       errors.WithStack(err),
+		}
+	}
+
+	logs := make(chan task.LogEntry)
+
+	go func() {
+		defer close(logs)
+
+		defer func() {
+			if err := stream.Close(); err != nil {
+				e.logger.ErrorContext(ctx, "could not close log stream", slogx.Error(errors.WithStack(err)))
+			}
+		}()
+
+		var clock uint = 0
+
+		scanner := bufio.NewScanner(stream)
+		for scanner.Scan() {
+			line := scanner.Text()
+			timestamp := time.Now()
+
+			if rawTimestamp, message, found := strings.Cut(line, " "); found {
+				if ts, err := time.Parse(time.RFC3339Nano, rawTimestamp); err == nil {
+					line = message
+					timestamp = ts
+				}
+			}
+
+			// The pod logs merge both streams, the stream of the
+			// entries is left unknown
+			select {
+			case logs <- task.LogEntry{
+				Timestamp: timestamp,
+				Message:   line,
+				Clock:     clock,
+			}:
+			case <-ctx.Done():
+				return
+			}
+
+			clock++
+		}
+
+		if err := scanner.Err(); err != nil {
+			e.logger.ErrorContext(ctx, "could not scan for next log line", slogx.Error(errors.WithStack(err)))
+		}
+	}()
+
+	return logs, nil
+}
+
+// remove deletes the job of an execution, along with its pod
+func (e *JobExecutor) remove(ctx context.Context, runID string) error {
+	propagation := metav1.DeletePropagationBackground
+
+	err := e.client.BatchV1().Jobs(e.opts.Namespace).Delete(ctx, jobName(runID), metav1.DeleteOptions{
+		PropagationPolicy: &propagation,
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.WithStack(err)
+	}
+
+	e.logger.Debug("job removed", "job", jobName(runID))
+
+	return nil
+}
+
+func containerStatus(statuses []corev1.ContainerStatus, name string) *corev1.ContainerStatus {
+	for i := range statuses {
+		if statuses[i].Name == name {
+			return &statuses[i]
+		}
+	}
+
+	return nil
+}
+
+// podFailed returns an error if the pod failed before the expected condition
+func podFailed(pod *corev1.Pod) (bool, error) {
+	if pod.Status.Phase != corev1.PodFailed {
+		return false, nil
+	}
+
+	return false, errors.Errorf("pod %s failed: %s %s", pod.Name, pod.Status.Reason, pod.Status.Message)
+}
+
+// Ensure JobExecutor implements task.Executor interface
+var _ task.Executor = &JobExecutor{}
@@ -0,0 +1,299 @@
+package kubernetes
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/bornholm/oplet/internal/slogx"
+	"github.com/bornholm/oplet/internal/task"
+	"github.com/bornholm/oplet/internal/task/testsuite"
+)
+
+func TestExecutor(t *testing.T) {
+	logger := slogx.NewTestLogger(t)
+
+	executor, err := NewExecutor(newFakeClient(t), &rest.Config{}, logger, WithPollInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	executor.exec = newFakeExec(t, nil).Exec
+
+	testsuite.RunExecutorTestSuite(t, executor)
+}
+
+func TestExecutorFiles(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	logger := slogx.NewTestLogger(t)
+
+	executor, err := NewExecutor(newFakeClient(t), &rest.Config{}, logger, WithPollInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	fake := newFakeExec(t, map[string]string{
+		"outputs/report.txt": "the report",
+	})
+
+	executor.exec = fake.Exec
+
+	var (
+		wg      sync.WaitGroup
+		outputs = map[string]string{}
+		final   task.Execution
+	)
+
+	wg.Add(1)
+
+	err = executor.Execute(ctx, task.ExecutionRequest{
+		ImageRef: "docker.io/bornholm/oplet-hello-world-task:latest",
+		Inputs: map[string]io.ReadCloser{
+			"data.txt": io.NopCloser(strings.NewReader("the data")),
+		},
+		OnChange: func(e task.Execution) {
+			switch e.State {
+			case task.ExecutionStateFilesDownloaded:
+				for {
+					header, err := e.Outputs.Next()
+					if errors.Is(err, io.EOF) {
+						break
+					}
+
+					if err != nil {
+						t.Errorf("%+v", errors.WithStack(err))
+						return
+					}
+
+					content, err := io.ReadAll(e.Outputs)
+					if err != nil {
+						t.Errorf("%+v", errors.WithStack(err))
+						return
+					}
+
+					outputs[header.Name] = string(content)
+				}
+			case task.ExecutionStateSucceeded, task.ExecutionStateFailed, task.ExecutionStateKilled:
+				final = e
+				wg.Done()
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	wg.Wait()
+
+	if e, g := task.ExecutionStateSucceeded, final.State; e != g {
+		t.Fatalf("final state: expected %d, got %d (%v)", e, g, final.Error)
+	}
+
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	if e, g := "the data", fake.inputs["data.txt"]; e != g {
+		t.Errorf("input file: expected '%s', got '%s'", e, g)
+	}
+
+	if e, g := "the report", outputs["outputs/report.txt"]; e != g {
+		t.Errorf("output file: expected '%s', got '%s'", e, g)
+	}
+
+	for _, marker := range []string{markerInputs, markerOutputs} {
+		if !fake.markers[marker] {
+			t.Errorf("marker %s: expected to be created", marker)
+		}
+	}
+}
+
+// newFakeClient simulates the job controller and the kubelet by creating
+// a pod for each created job, its task being completed and its helper
+// containers running
+func newFakeClient(t *testing.T) *fake.Clientset {
+	client := fake.NewClientset()
+
+	client.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+
+		go func() {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      job.Name + "-pod",
+					Namespace: job.Namespace,
+					Labels:    job.Spec.Template.Labels,
+				},
+				Spec: job.Spec.Template.Spec,
+				Status: corev1.PodStatus{
+					Phase: corev1.PodRunning,
+					InitContainerStatuses: []corev1.ContainerStatus{
+						{
+							Name:  containerInputs,
+							State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+						},
+						{
+							Name:  containerTask,
+							State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0}},
+						},
+					},
+					ContainerStatuses: []corev1.ContainerStatus{
+						{
+							Name:  containerCollector,
+							State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+						},
+					},
+				},
+			}
+
+			if _, err := client.CoreV1().Pods(job.Namespace).Create(context.Background(), pod, metav1.CreateOptions{}); err != nil {
+				t.Errorf("%+v", errors.WithStack(err))
+			}
+		}()
+
+		return false, nil, nil
+	})
+
+	return client
+}
+
+// fakeExec simulates the commands run in the helper containers
+type fakeExec struct {
+	t       *testing.T
+	mutex   sync.Mutex
+	outputs map[string]string
+	inputs  map[string]string
+	markers map[string]bool
+}
+
+func newFakeExec(t *testing.T, outputs map[string]string) *fakeExec {
+	return &fakeExec{
+		t:       t,
+		outputs: outputs,
+		inputs:  map[string]string{},
+		markers: map[string]bool{},
+	}
+}
+
+func (f *fakeExec) Exec(ctx context.Context, pod string, container string, command []string, stdin io.Reader, stdout io.Writer) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	switch {
+	case command[0] == "touch":
+		f.markers[command[1]] = true
+
+	case container == containerInputs && command[0] == "tar":
+		tr := tar.NewReader(stdin)
+		for {
+			header, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			content, err := io.ReadAll(tr)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			f.inputs[header.Name] = string(content)
+		}
+
+	case container == containerCollector && command[0] == "tar":
+		tw := tar.NewWriter(stdout)
+		for name, content := range f.outputs {
+			if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+				return errors.WithStack(err)
+			}
+
+			if _, err := tw.Write([]byte(content)); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+
+		if err := tw.Close(); err != nil {
+			return errors.WithStack(err)
+		}
+
+	default:
+		f.t.Errorf("unexpected command %v in container %s", command, container)
+	}
+
+	return nil
+}
+
+func TestNewJob(t *testing.T) {
+	opts, err := NewOptions(WithNamespace("oplet"))
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	profile, err := task.ParseSecurityProfile(task.HardenedSecurityProfile)
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	job, err := newJob(opts, "run", task.ExecutionRequest{
+		ImageRef: "docker.io/bornholm/oplet-hello-world-task:latest",
+		Constraints: task.Constraints{
+			CPUs:      0.5,
+			MaxMemory: 512 << 20,
+		},
+		Security: profile,
+	}, true)
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	spec := job.Spec.Template.Spec
+
+	if e, g := 2, len(spec.InitContainers); e != g {
+		t.Fatalf("init containers: expected %d, got %d", e, g)
+	}
+
+	if e, g := containerInputs, spec.InitContainers[0].Name; e != g {
+		t.Errorf("first init container: expected %s, got %s", e, g)
+	}
+
+	for _, volume := range spec.Volumes {
+		if volume.Secret != nil {
+			t.Errorf("volume %s: expected no secret", volume.Name)
+		}
+	}
+
+	container := spec.InitContainers[1]
+
+	if e, g := "500m", container.Resources.Limits.Cpu().String(); e != g {
+		t.Errorf("cpu limit: expected %s, got %s", e, g)
+	}
+
+	if e, g := "512Mi", container.Resources.Limits.Memory().String(); e != g {
+		t.Errorf("memory limit: expected %s, got %s", e, g)
+	}
+
+	if !*container.SecurityContext.ReadOnlyRootFilesystem {
+		t.Errorf("read-only rootfs: expected true, got false")
+	}
+
+	if e, g := int64(65534), *container.SecurityContext.RunAsUser; e != g {
+		t.Errorf("run as user: expected %d, got %d", e, g)
+	}
+}
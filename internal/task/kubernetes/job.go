@@ -0,0 +1,255 @@
+package kubernetes
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+
+	"github.com/bornholm/oplet/internal/task"
+	"github.com/pkg/errors"
+)
+
+const (
+	labelRunID = "io.oplet.run.id"
+
+	containerInputs    = "inputs"
+	containerTask      = "task"
+	containerCollector = "outputs"
+
+	volumeInputs  = "oplet-inputs"
+	volumeOutputs = "oplet-outputs"
+	volumeControl = "oplet-control"
+	volumeTmp     = "oplet-tmp"
+
+	// controlDir holds the marker files created by the executor to release
+	// the helper containers
+	controlDir = "/oplet/control"
+	// markerInputs is created once the input files are extracted
+	markerInputs = controlDir + "/inputs-staged"
+	// markerOutputs is created once the output files are collected
+	markerOutputs = controlDir + "/outputs-collected"
+	// helperTimeout is the maximum time the helper containers wait for
+	// their marker file
+	helperTimeout = time.Hour
+)
+
+func jobName(runID string) string {
+	return "oplet-task-" + runID
+}
+
+// newJob creates the job of an execution. Its pod runs the task as an init
+// container, after the one in which the input files are extracted, so that
+// the outputs are collected from the main container once the task succeeded.
+// The files are streamed through the exec API of the helper containers,
+// which wait for the executor to create their marker file.
+func newJob(opts *Options, runID string, req task.ExecutionRequest, withInputs bool) (*batchv1.Job, error) {
+	env := make([]corev1.EnvVar, 0, len(req.Environment))
+	for key, value := range req.Environment {
+		env = append(env, corev1.EnvVar{Name: key, Value: value})
+	}
+
+	slices.SortFunc(env, func(a, b corev1.EnvVar) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	env = append(env, corev1.EnvVar{Name: "OPLET_RUN_ID", Value: runID})
+
+	if req.Network.ProxyURL != "" {
+		for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy"} {
+			env = append(env, corev1.EnvVar{Name: name, Value: req.Network.ProxyURL})
+		}
+		env = append(env,
+			corev1.EnvVar{Name: "NO_PROXY", Value: "localhost,127.0.0.1"},
+			corev1.EnvVar{Name: "no_proxy", Value: "localhost,127.0.0.1"},
+		)
+	}
+
+	taskSecurityContext, podSecurityContext, err := newSecurityContexts(req.Security)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	taskMounts := []corev1.VolumeMount{
+		{Name: volumeInputs, MountPath: task.InputsDir},
+		{Name: volumeOutputs, MountPath: task.OutputsDir},
+	}
+
+	volumes := []corev1.Volume{
+		{Name: volumeInputs, VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+		{Name: volumeOutputs, VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+		{Name: volumeControl, VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{Medium: corev1.StorageMediumMemory}}},
+	}
+
+	if req.Security.ReadOnlyRootfs {
+		taskMounts = append(taskMounts, corev1.VolumeMount{Name: volumeTmp, MountPath: "/tmp"})
+		volumes = append(volumes, corev1.Volume{
+			Name:         volumeTmp,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{Medium: corev1.StorageMediumMemory}},
+		})
+	}
+
+	initContainers := make([]corev1.Container, 0, 2)
+
+	if withInputs {
+		initContainers = append(initContainers, corev1.Container{
+			Name:    containerInputs,
+			Image:   opts.HelperImage,
+			Command: waitForMarkerCommand(markerInputs),
+			VolumeMounts: []corev1.VolumeMount{
+				{Name: volumeInputs, MountPath: task.InputsDir},
+				{Name: volumeControl, MountPath: controlDir},
+			},
+		})
+	}
+
+	taskContainer := corev1.Container{
+		Name:            containerTask,
+		Image:           req.ImageRef,
+		Env:             env,
+		VolumeMounts:    taskMounts,
+		SecurityContext: taskSecurityContext,
+		Resources:       newResourceRequirements(req.Constraints),
+	}
+
+	initContainers = append(initContainers, taskContainer)
+
+	collector := corev1.Container{
+		Name:    containerCollector,
+		Image:   opts.HelperImage,
+		Command: waitForMarkerCommand(markerOutputs),
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: volumeOutputs, MountPath: task.OutputsDir, ReadOnly: true},
+			{Name: volumeControl, MountPath: controlDir},
+		},
+	}
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      jobName(runID),
+			Namespace: opts.Namespace,
+			Labels: map[string]string{
+				labelRunID: runID,
+			},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: ptr.To[int32](0),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						labelRunID: runID,
+					},
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy:                corev1.RestartPolicyNever,
+					AutomountServiceAccountToken: ptr.To(false),
+					SecurityContext:              podSecurityContext,
+					InitContainers:               initContainers,
+					Containers:                   []corev1.Container{collector},
+					Volumes:                      volumes,
+				},
+			},
+		},
+	}
+
+	return job, nil
+}
+
+// waitForMarkerCommand returns the command of a helper container waiting
+// for the given marker file, failing after helperTimeout
+func waitForMarkerCommand(marker string) []string {
+	script := fmt.Sprintf(
+		"i=0; until [ -f %s ]; do if [ $i -ge %d ]; then exit 1; fi; i=$((i+1)); sleep 1; done",
+		marker, int(helperTimeout.Seconds()),
+	)
+
+	return []string{"sh", "-c", script}
+}
+
+// newResourceRequirements maps the constraints of an execution to the limits
+// of its container, requests defaulting to the limits
+func newResourceRequirements(constraints task.Constraints) corev1.ResourceRequirements {
+	limits := corev1.ResourceList{}
+
+	if constraints.CPUs > 0 {
+		limits[corev1.ResourceCPU] = *resource.NewMilliQuantity(int64(constraints.CPUs*1000), resource.DecimalSI)
+	}
+
+	if constraints.MaxMemory > 0 {
+		limits[corev1.ResourceMemory] = *resource.NewQuantity(constraints.MaxMemory, resource.BinarySI)
+	}
+
+	return corev1.ResourceRequirements{
+		Limits: limits,
+	}
+}
+
+// newSecurityContexts maps a security profile to the security contexts of
+// the task container and of its pod. The pids limit is not supported.
+func newSecurityContexts(profile task.SecurityProfile) (*corev1.SecurityContext, *corev1.PodSecurityContext, error) {
+	container := &corev1.SecurityContext{
+		ReadOnlyRootFilesystem: ptr.To(profile.ReadOnlyRootfs),
+	}
+
+	pod := &corev1.PodSecurityContext{
+		SeccompProfile: &corev1.SeccompProfile{
+			Type: corev1.SeccompProfileTypeRuntimeDefault,
+		},
+	}
+
+	if profile.SeccompProfile != "" {
+		// Path relative to the seccomp directory of the kubelet
+		pod.SeccompProfile = &corev1.SeccompProfile{
+			Type:             corev1.SeccompProfileTypeLocalhost,
+			LocalhostProfile: ptr.To(profile.SeccompProfile),
+		}
+	}
+
+	if profile.NoNewPrivileges {
+		container.AllowPrivilegeEscalation = ptr.To(false)
+	}
+
+	capabilities := &corev1.Capabilities{}
+
+	if profile.DropCapabilities {
+		capabilities.Drop = []corev1.Capability{"ALL"}
+	}
+
+	for _, c := range profile.Capabilities {
+		capabilities.Add = append(capabilities.Add, corev1.Capability(c))
+	}
+
+	container.Capabilities = capabilities
+
+	if profile.User != "" {
+		rawUID, rawGID, found := strings.Cut(profile.User, ":")
+		if !found {
+			rawGID = rawUID
+		}
+
+		uid, err := strconv.ParseInt(rawUID, 10, 64)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "invalid uid '%s'", rawUID)
+		}
+
+		gid, err := strconv.ParseInt(rawGID, 10, 64)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "invalid gid '%s'", rawGID)
+		}
+
+		container.RunAsUser = &uid
+		container.RunAsGroup = &gid
+
+		// Gives the group the ownership of the inputs and outputs volumes
+		pod.FSGroup = &gid
+	}
+
+	return container, pod, nil
+}
@@ -0,0 +1,64 @@
+package kubernetes
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type Options struct {
+	// Namespace of the jobs created by the executor
+	Namespace string
+	// Image of the containers receiving the inputs and collecting the outputs,
+	// must provide sh, sleep, touch and tar
+	HelperImage string
+	// Interval between two checks of the pod of an execution
+	PollInterval time.Duration
+}
+
+type OptionFunc func(opts *Options) error
+
+func NewOptions(funcs ...OptionFunc) (*Options, error) {
+	opts := &Options{
+		Namespace:    "default",
+		HelperImage:  "docker.io/library/busybox:1.37",
+		PollInterval: time.Second * 2,
+	}
+
+	for _, fn := range funcs {
+		if err := fn(opts); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	if opts.Namespace == "" {
+		return nil, errors.New("namespace can not be empty")
+	}
+
+	if opts.PollInterval <= 0 {
+		return nil, errors.Errorf("invalid poll interval '%s', must be positive", opts.PollInterval)
+	}
+
+	return opts, nil
+}
+
+func WithNamespace(namespace string) OptionFunc {
+	return func(opts *Options) error {
+		opts.Namespace = namespace
+		return nil
+	}
+}
+
+func WithHelperImage(image string) OptionFunc {
+	return func(opts *Options) error {
+		opts.HelperImage = image
+		return nil
+	}
+}
+
+func WithPollInterval(interval time.Duration) OptionFunc {
+	return func(opts *Options) error {
+		opts.PollInterval = interval
+		return nil
+	}
+}
@@ -3,6 +3,7 @@ package label
 import (
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bornholm/oplet/internal/task"
 	"github.com/pkg/errors"
@@ -26,6 +27,12 @@ func (p *Parser) ParseLabels(labels map[string]string) (*ParsedLabels, error) {
 	// Parse meta labels
 	parsed.Meta = p.parseMetaLabels(labels)
 
+	// Parse resources labels
+	parsed.Resources = p.parseResourcesLabels(labels)
+
+	// Parse security labels
+	parsed.Security = p.parseSecurityLabels(labels)
+
 	// Parse input and config labels
 	if err := p.parseInputLabels(labels, parsed); err != nil {
 		return nil, err
@@ -37,10 +44,36 @@ func (p *Parser) ParseLabels(labels map[string]string) (*ParsedLabels, error) {
 // parseMetaLabels extracts metadata labels
 func (p *Parser) parseMetaLabels(labels map[string]string) MetaLabels {
 	return MetaLabels{
-		Name:        labels[LabelMetaName],
-		Description: labels[LabelMetaDescription],
-		Author:      labels[LabelMetaAuthor],
-		URL:         labels[LabelMetaURL],
+		Name:         labels[LabelMetaName],
+		Description:  labels[LabelMetaDescription],
+		Author:       labels[LabelMetaAuthor],
+		URL:          labels[LabelMetaURL],
+		RunnerTags:   labels[LabelMetaRunnerTags],
+		Requeue:      labels[LabelMetaRequeue],
+		Retries:      labels[LabelMetaRetries],
+		RetryBackoff: labels[LabelMetaRetryBackoff],
+		RetryOnExit:  labels[LabelMetaRetryOnExit],
+		Timeout:      labels[LabelMetaTimeout],
+		Network:      labels[LabelMetaNetwork],
+	}
+}
+
+// parseResourcesLabels extracts resources labels
+func (p *Parser) parseResourcesLabels(labels map[string]string) ResourcesLabels {
+	return ResourcesLabels{
+		CPURequest:    labels[LabelResourcesCPURequest],
+		CPULimit:      labels[LabelResourcesCPULimit],
+		MemoryRequest: labels[LabelResourcesMemoryRequest],
+		MemoryLimit:   labels[LabelResourcesMemoryLimit],
+	}
+}
+
+// parseSecurityLabels extracts security labels
+func (p *Parser) parseSecurityLabels(labels map[string]string) SecurityLabels {
+	return SecurityLabels{
+		WritableRootfs: labels[LabelSecurityWritableRootfs],
+		ImageUser:      labels[LabelSecurityImageUser],
+		Capabilities:   labels[LabelSecurityCapabilities],
 	}
 }
 
@@ -121,6 +154,8 @@ func (p *Parser) buildInputLabels(props map[string]string) (InputLabels, error)
 		Description: props[PropertyDescription],
 		Required:    props[PropertyRequired],
 		Tags:        strings.Split(props[PropertyTags], " "),
+		MaxSize:     props[PropertyMaxSize],
+		Accept:      props[PropertyAccept],
 	}
 
 	// Validate required fields
@@ -160,6 +195,7 @@ func (p *Parser) BuildTaskDefinition(parsed *ParsedLabels, imageRef string) (*ta
 		ImageRef:      imageRef,
 		Inputs:        make([]*task.Input, 0),
 		Configuration: make([]*task.Input, 0),
+		RunnerTags:    task.ParseTags(parsed.Meta.RunnerTags),
 	}
 
 	// Convert inputs
@@ -180,9 +216,164 @@ func (p *Parser) BuildTaskDefinition(parsed *ParsedLabels, imageRef string) (*ta
 		definition.Configuration = append(definition.Configuration, taskInput)
 	}
 
+	if parsed.Meta.Requeue != "" {
+		requeue, err := strconv.ParseBool(parsed.Meta.Requeue)
+		if err != nil {
+			return nil, errors.Wrapf(ErrInvalidLabels, "invalid meta.requeue value '%s', must be 'true' or 'false'", parsed.Meta.Requeue)
+		}
+
+		definition.Requeue = requeue
+	}
+
+	if parsed.Meta.Retries != "" {
+		retries, err := strconv.Atoi(parsed.Meta.Retries)
+		if err != nil || retries < 0 || retries > task.MaxRetries {
+			return nil, errors.Wrapf(ErrInvalidLabels, "invalid meta.retries value '%s', must be a positive integer up to %d", parsed.Meta.Retries, task.MaxRetries)
+		}
+
+		definition.Retries = retries
+	}
+
+	if parsed.Meta.RetryBackoff != "" {
+		backoff, err := time.ParseDuration(parsed.Meta.RetryBackoff)
+		if err != nil || backoff < 0 {
+			return nil, errors.Wrapf(ErrInvalidLabels, "invalid meta.retry-backoff value '%s', must be a positive duration", parsed.Meta.RetryBackoff)
+		}
+
+		definition.RetryBackoff = backoff
+	}
+
+	if parsed.Meta.RetryOnExit != "" {
+		retryOnExit, err := strconv.ParseBool(parsed.Meta.RetryOnExit)
+		if err != nil {
+			return nil, errors.Wrapf(ErrInvalidLabels, "invalid meta.retry-on-exit value '%s', must be 'true' or 'false'", parsed.Meta.RetryOnExit)
+		}
+
+		definition.RetryOnExit = retryOnExit
+	}
+
+	if parsed.Meta.Timeout != "" {
+		timeout, err := time.ParseDuration(parsed.Meta.Timeout)
+		if err != nil || timeout < 0 {
+			return nil, errors.Wrapf(ErrInvalidLabels, "invalid meta.timeout value '%s', must be a positive duration", parsed.Meta.Timeout)
+		}
+
+		definition.Timeout = timeout
+	}
+
+	if parsed.Meta.Network != "" {
+		if !task.ValidNetwork(parsed.Meta.Network) {
+			return nil, errors.Wrapf(ErrInvalidLabels, "invalid meta.network value '%s', must be 'none', 'bridge', 'egress' or a network name", parsed.Meta.Network)
+		}
+
+		definition.Network = parsed.Meta.Network
+	}
+
+	resources, err := p.buildResources(parsed.Resources)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	definition.Resources = resources
+
+	security, err := p.buildSecurityRelaxations(parsed.Security)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	definition.Security = security
+
 	return definition, nil
 }
 
+// buildResources converts resources labels to task.Resources.
+// Requests default to the limits.
+func (p *Parser) buildResources(labels ResourcesLabels) (task.Resources, error) {
+	var resources task.Resources
+
+	if labels.CPULimit != "" {
+		cpus, err := task.ParseCPUs(labels.CPULimit)
+		if err != nil {
+			return resources, errors.Wrapf(ErrInvalidLabels, "invalid resources.cpu-limit value '%s', must be a positive number", labels.CPULimit)
+		}
+
+		resources.CPULimit = cpus
+	}
+
+	resources.CPURequest = resources.CPULimit
+	if labels.CPURequest != "" {
+		cpus, err := task.ParseCPUs(labels.CPURequest)
+		if err != nil {
+			return resources, errors.Wrapf(ErrInvalidLabels, "invalid resources.cpu-request value '%s', must be a positive number", labels.CPURequest)
+		}
+
+		resources.CPURequest = cpus
+	}
+
+	if labels.MemoryLimit != "" {
+		memory, err := task.ParseMemory(labels.MemoryLimit)
+		if err != nil {
+			return resources, errors.Wrapf(ErrInvalidLabels, "invalid resources.memory-limit value '%s', must be a positive quantity, ex: 512Mi", labels.MemoryLimit)
+		}
+
+		resources.MemoryLimit = memory
+	}
+
+	resources.MemoryRequest = resources.MemoryLimit
+	if labels.MemoryRequest != "" {
+		memory, err := task.ParseMemory(labels.MemoryRequest)
+		if err != nil {
+			return resources, errors.Wrapf(ErrInvalidLabels, "invalid resources.memory-request value '%s', must be a positive quantity, ex: 512Mi", labels.MemoryRequest)
+		}
+
+		resources.MemoryRequest = memory
+	}
+
+	if resources.CPULimit > 0 && resources.CPURequest > resources.CPULimit {
+		return resources, errors.Wrap(ErrInvalidLabels, "resources.cpu-request can not be greater than resources.cpu-limit")
+	}
+
+	if resources.MemoryLimit > 0 && resources.MemoryRequest > resources.MemoryLimit {
+		return resources, errors.Wrap(ErrInvalidLabels, "resources.memory-request can not be greater than resources.memory-limit")
+	}
+
+	return resources, nil
+}
+
+// buildSecurityRelaxations converts security labels to task.SecurityRelaxations
+func (p *Parser) buildSecurityRelaxations(labels SecurityLabels) (task.SecurityRelaxations, error) {
+	var relaxations task.SecurityRelaxations
+
+	if labels.WritableRootfs != "" {
+		writableRootfs, err := strconv.ParseBool(labels.WritableRootfs)
+		if err != nil {
+			return relaxations, errors.Wrapf(ErrInvalidLabels, "invalid security.writable-rootfs value '%s', must be 'true' or 'false'", labels.WritableRootfs)
+		}
+
+		relaxations.WritableRootfs = writableRootfs
+	}
+
+	if labels.ImageUser != "" {
+		imageUser, err := strconv.ParseBool(labels.ImageUser)
+		if err != nil {
+			return relaxations, errors.Wrapf(ErrInvalidLabels, "invalid security.image-user value '%s', must be 'true' or 'false'", labels.ImageUser)
+		}
+
+		relaxations.ImageUser = imageUser
+	}
+
+	capabilities, err := task.ParseCapabilities(labels.Capabilities)
+	if err != nil {
+		return relaxations, errors.Wrapf(ErrInvalidLabels, "invalid security.capabilities value '%s', must be a comma separated list of capabilities, ex: NET_BIND_SERVICE", labels.Capabilities)
+	}
+
+	if len(capabilities) > 0 {
+		relaxations.Capabilities = capabilities
+	}
+
+	return relaxations, nil
+}
+
 // convertToTaskInput converts InputLabels to task.TaskInput
 func (p *Parser) convertToTaskInput(name string, inputLabels InputLabels) (*task.Input, error) {
 	// Parse required field
@@ -195,12 +386,42 @@ func (p *Parser) convertToTaskInput(name string, inputLabels InputLabels) (*task
 		}
 	}
 
+	constraints := []task.Constraint{}
+
+	if inputLabels.MaxSize != "" {
+		maxSize, err := task.ParseMemory(inputLabels.MaxSize)
+		if err != nil || maxSize == 0 {
+			return nil, errors.Errorf("invalid max-size value '%s', must be a positive quantity, ex: 10Mi", inputLabels.MaxSize)
+		}
+
+		constraints = append(constraints, task.NewMaxSizeConstraint(maxSize))
+	}
+
+	if inputLabels.Accept != "" {
+		patterns := make([]string, 0)
+		for _, pattern := range strings.Split(inputLabels.Accept, ",") {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				patterns = append(patterns, pattern)
+			}
+		}
+
+		if len(patterns) == 0 {
+			return nil, errors.Errorf("invalid accept value '%s', must be a comma separated list of extensions or MIME types", inputLabels.Accept)
+		}
+
+		constraints = append(constraints, task.NewAcceptConstraint(patterns))
+	}
+
+	if len(constraints) > 0 && task.Type(inputLabels.Type) != task.TypeFile {
+		return nil, errors.New("max-size and accept properties are only supported by file inputs")
+	}
+
 	return &task.Input{
 		Name:        name,
 		Label:       inputLabels.Label,
 		Type:        task.Type(inputLabels.Type),
 		Description: inputLabels.Description,
 		Required:    required,
-		Constraints: []task.Constraint{}, // No constraints for now
+		Constraints: constraints,
 	}, nil
 }
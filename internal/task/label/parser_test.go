@@ -1,7 +1,10 @@
 package label
 
 import (
+	"context"
+	"slices"
 	"testing"
+	"time"
 
 	"github.com/bornholm/oplet/internal/task"
 )
@@ -253,6 +256,303 @@ func TestParser_BuildTaskDefinition(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "runner tags",
+			parsed: &ParsedLabels{
+				Meta: MetaLabels{
+					Name:       "Test Task",
+					RunnerTags: "zone=dmz, arch=arm64 docker",
+				},
+				Inputs: map[string]InputLabels{},
+				Config: map[string]InputLabels{},
+			},
+			imageRef:    "registry.example.com/test:latest",
+			expectError: false,
+			validate: func(t *testing.T, def *task.Definition) {
+				expected := []string{"arch=arm64", "docker", "zone=dmz"}
+				if !slices.Equal(def.RunnerTags, expected) {
+					t.Errorf("runnerTags: expected %v, got %v", expected, def.RunnerTags)
+				}
+			},
+		},
+		{
+			name: "requeue",
+			parsed: &ParsedLabels{
+				Meta: MetaLabels{
+					Name:    "Test Task",
+					Requeue: "true",
+				},
+				Inputs: map[string]InputLabels{},
+				Config: map[string]InputLabels{},
+			},
+			imageRef:    "registry.example.com/test:latest",
+			expectError: false,
+			validate: func(t *testing.T, def *task.Definition) {
+				if !def.Requeue {
+					t.Errorf("requeue: expected true, got %v", def.Requeue)
+				}
+			},
+		},
+		{
+			name: "retry policy",
+			parsed: &ParsedLabels{
+				Meta: MetaLabels{
+					Name:         "Test Task",
+					Retries:      "3",
+					RetryBackoff: "30s",
+					RetryOnExit:  "true",
+				},
+				Inputs: map[string]InputLabels{},
+				Config: map[string]InputLabels{},
+			},
+			imageRef:    "registry.example.com/test:latest",
+			expectError: false,
+			validate: func(t *testing.T, def *task.Definition) {
+				if def.Retries != 3 {
+					t.Errorf("retries: expected 3, got %d", def.Retries)
+				}
+				if def.RetryBackoff != 30*time.Second {
+					t.Errorf("retryBackoff: expected 30s, got %s", def.RetryBackoff)
+				}
+				if !def.RetryOnExit {
+					t.Errorf("retryOnExit: expected true, got %v", def.RetryOnExit)
+				}
+			},
+		},
+		{
+			name: "invalid retries",
+			parsed: &ParsedLabels{
+				Meta: MetaLabels{
+					Name:    "Test Task",
+					Retries: "-1",
+				},
+				Inputs: map[string]InputLabels{},
+				Config: map[string]InputLabels{},
+			},
+			imageRef:    "registry.example.com/test:latest",
+			expectError: true,
+		},
+		{
+			name: "file constraints",
+			parsed: &ParsedLabels{
+				Meta: MetaLabels{
+					Name: "Test Task",
+				},
+				Inputs: map[string]InputLabels{
+					"dataset": {
+						Type:    "file",
+						MaxSize: "1Ki",
+						Accept:  ".csv, text/tab-separated-values",
+					},
+				},
+				Config: map[string]InputLabels{},
+			},
+			imageRef:    "registry.example.com/test:latest",
+			expectError: false,
+			validate: func(t *testing.T, def *task.Definition) {
+				input := def.Inputs[0]
+
+				if e, g := ".csv,text/tab-separated-values", task.Accept(input); e != g {
+					t.Errorf("accept: expected %q, got %q", e, g)
+				}
+
+				if err := task.AssertFileInfo(context.Background(), input, "data.csv", 1024); err != nil {
+					t.Errorf("expected data.csv to be accepted, got %v", err)
+				}
+
+				if err := task.AssertFileInfo(context.Background(), input, "data.csv", 1025); err == nil {
+					t.Errorf("expected a file larger than 1Ki to be rejected")
+				}
+			},
+		},
+		{
+			name: "constraints of a non file input",
+			parsed: &ParsedLabels{
+				Meta: MetaLabels{
+					Name: "Test Task",
+				},
+				Inputs: map[string]InputLabels{
+					"name": {
+						Type:    "text",
+						MaxSize: "1Ki",
+					},
+				},
+				Config: map[string]InputLabels{},
+			},
+			imageRef:    "registry.example.com/test:latest",
+			expectError: true,
+		},
+		{
+			name: "too many retries",
+			parsed: &ParsedLabels{
+				Meta: MetaLabels{
+					Name:    "Test Task",
+					Retries: "1000",
+				},
+				Inputs: map[string]InputLabels{},
+				Config: map[string]InputLabels{},
+			},
+			imageRef:    "registry.example.com/test:latest",
+			expectError: true,
+		},
+		{
+			name: "timeout",
+			parsed: &ParsedLabels{
+				Meta: MetaLabels{
+					Name:    "Test Task",
+					Timeout: "15m",
+				},
+				Inputs: map[string]InputLabels{},
+				Config: map[string]InputLabels{},
+			},
+			imageRef:    "registry.example.com/test:latest",
+			expectError: false,
+			validate: func(t *testing.T, def *task.Definition) {
+				if def.Timeout != 15*time.Minute {
+					t.Errorf("timeout: expected 15m, got %s", def.Timeout)
+				}
+			},
+		},
+		{
+			name: "invalid timeout",
+			parsed: &ParsedLabels{
+				Meta: MetaLabels{
+					Name:    "Test Task",
+					Timeout: "forever",
+				},
+				Inputs: map[string]InputLabels{},
+				Config: map[string]InputLabels{},
+			},
+			imageRef:    "registry.example.com/test:latest",
+			expectError: true,
+		},
+		{
+			name: "network",
+			parsed: &ParsedLabels{
+				Meta: MetaLabels{
+					Name:    "Test Task",
+					Network: "none",
+				},
+				Inputs: map[string]InputLabels{},
+				Config: map[string]InputLabels{},
+			},
+			imageRef:    "registry.example.com/test:latest",
+			expectError: false,
+			validate: func(t *testing.T, def *task.Definition) {
+				if def.Network != task.NetworkNone {
+					t.Errorf("network: expected none, got %s", def.Network)
+				}
+			},
+		},
+		{
+			name: "invalid network",
+			parsed: &ParsedLabels{
+				Meta: MetaLabels{
+					Name:    "Test Task",
+					Network: "host network",
+				},
+				Inputs: map[string]InputLabels{},
+				Config: map[string]InputLabels{},
+			},
+			imageRef:    "registry.example.com/test:latest",
+			expectError: true,
+		},
+		{
+			name: "security",
+			parsed: &ParsedLabels{
+				Meta: MetaLabels{
+					Name: "Test Task",
+				},
+				Security: SecurityLabels{
+					WritableRootfs: "true",
+					Capabilities:   "net_bind_service, CAP_CHOWN",
+				},
+				Inputs: map[string]InputLabels{},
+				Config: map[string]InputLabels{},
+			},
+			imageRef:    "registry.example.com/test:latest",
+			expectError: false,
+			validate: func(t *testing.T, def *task.Definition) {
+				if e, g := "writable-rootfs,cap=CHOWN,cap=NET_BIND_SERVICE", def.Security.String(); e != g {
+					t.Errorf("security: expected %s, got %s", e, g)
+				}
+			},
+		},
+		{
+			name: "invalid security capability",
+			parsed: &ParsedLabels{
+				Meta: MetaLabels{
+					Name: "Test Task",
+				},
+				Security: SecurityLabels{
+					Capabilities: "NET-ADMIN",
+				},
+				Inputs: map[string]InputLabels{},
+				Config: map[string]InputLabels{},
+			},
+			imageRef:    "registry.example.com/test:latest",
+			expectError: true,
+		},
+		{
+			name: "resources",
+			parsed: &ParsedLabels{
+				Meta: MetaLabels{
+					Name: "Test Task",
+				},
+				Resources: ResourcesLabels{
+					CPULimit:    "2",
+					CPURequest:  "0.5",
+					MemoryLimit: "1Gi",
+				},
+				Inputs: map[string]InputLabels{},
+				Config: map[string]InputLabels{},
+			},
+			imageRef:    "registry.example.com/test:latest",
+			expectError: false,
+			validate: func(t *testing.T, def *task.Definition) {
+				if def.Resources.CPULimit != 2 {
+					t.Errorf("cpuLimit: expected 2, got %v", def.Resources.CPULimit)
+				}
+				if def.Resources.CPURequest != 0.5 {
+					t.Errorf("cpuRequest: expected 0.5, got %v", def.Resources.CPURequest)
+				}
+				if def.Resources.MemoryLimit != 1<<30 {
+					t.Errorf("memoryLimit: expected %d, got %d", 1<<30, def.Resources.MemoryLimit)
+				}
+				if def.Resources.MemoryRequest != def.Resources.MemoryLimit {
+					t.Errorf("memoryRequest: expected %d, got %d", def.Resources.MemoryLimit, def.Resources.MemoryRequest)
+				}
+			},
+		},
+		{
+			name: "resources request greater than limit",
+			parsed: &ParsedLabels{
+				Meta: MetaLabels{
+					Name: "Test Task",
+				},
+				Resources: ResourcesLabels{
+					MemoryLimit:   "512M",
+					MemoryRequest: "1G",
+				},
+				Inputs: map[string]InputLabels{},
+				Config: map[string]InputLabels{},
+			},
+			imageRef:    "registry.example.com/test:latest",
+			expectError: true,
+		},
+		{
+			name: "invalid requeue",
+			parsed: &ParsedLabels{
+				Meta: MetaLabels{
+					Name:    "Test Task",
+					Requeue: "maybe",
+				},
+				Inputs: map[string]InputLabels{},
+				Config: map[string]InputLabels{},
+			},
+			imageRef:    "registry.example.com/test:latest",
+			expectError: true,
+		},
 		{
 			name: "missing name",
 			parsed: &ParsedLabels{
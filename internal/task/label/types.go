@@ -7,11 +7,32 @@ const (
 	LabelPrefixInputs = "io.oplet.task.inputs"
 	LabelPrefixConfig = "io.oplet.task.config"
 
+	LabelPrefixResources = "io.oplet.task.resources"
+	LabelPrefixSecurity  = "io.oplet.task.security"
+
 	// Meta label keys
-	LabelMetaName        = "io.oplet.task.meta.name"
-	LabelMetaDescription = "io.oplet.task.meta.description"
-	LabelMetaAuthor      = "io.oplet.task.meta.author"
-	LabelMetaURL         = "io.oplet.task.meta.url"
+	LabelMetaName         = "io.oplet.task.meta.name"
+	LabelMetaDescription  = "io.oplet.task.meta.description"
+	LabelMetaAuthor       = "io.oplet.task.meta.author"
+	LabelMetaURL          = "io.oplet.task.meta.url"
+	LabelMetaRunnerTags   = "io.oplet.task.meta.runner-tags"
+	LabelMetaRequeue      = "io.oplet.task.meta.requeue"
+	LabelMetaRetries      = "io.oplet.task.meta.retries"
+	LabelMetaRetryBackoff = "io.oplet.task.meta.retry-backoff"
+	LabelMetaRetryOnExit  = "io.oplet.task.meta.retry-on-exit"
+	LabelMetaTimeout      = "io.oplet.task.meta.timeout"
+	LabelMetaNetwork      = "io.oplet.task.meta.network"
+
+	// Resources label keys
+	LabelResourcesCPURequest    = "io.oplet.task.resources.cpu-request"
+	LabelResourcesCPULimit      = "io.oplet.task.resources.cpu-limit"
+	LabelResourcesMemoryRequest = "io.oplet.task.resources.memory-request"
+	LabelResourcesMemoryLimit   = "io.oplet.task.resources.memory-limit"
+
+	// Security label keys
+	LabelSecurityWritableRootfs = "io.oplet.task.security.writable-rootfs"
+	LabelSecurityImageUser      = "io.oplet.task.security.image-user"
+	LabelSecurityCapabilities   = "io.oplet.task.security.capabilities"
 
 	// Input/Config property suffixes
 	PropertyLabel       = "label"
@@ -19,21 +40,49 @@ const (
 	PropertyDescription = "description"
 	PropertyRequired    = "required"
 	PropertyTags        = "tags"
+	PropertyMaxSize     = "max-size"
+	PropertyAccept      = "accept"
 )
 
 // ParsedLabels represents the structured labels extracted from an image
 type ParsedLabels struct {
-	Meta   MetaLabels             `json:"meta"`
-	Inputs map[string]InputLabels `json:"inputs"`
-	Config map[string]InputLabels `json:"config"`
+	Meta      MetaLabels             `json:"meta"`
+	Resources ResourcesLabels        `json:"resources"`
+	Security  SecurityLabels         `json:"security"`
+	Inputs    map[string]InputLabels `json:"inputs"`
+	Config    map[string]InputLabels `json:"config"`
 }
 
 // MetaLabels contains metadata about the task
 type MetaLabels struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Author      string `json:"author"`
-	URL         string `json:"url"`
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	Author       string `json:"author"`
+	URL          string `json:"url"`
+	RunnerTags   string `json:"runner_tags"`
+	Requeue      string `json:"requeue"`
+	Retries      string `json:"retries"`
+	RetryBackoff string `json:"retry_backoff"`
+	RetryOnExit  string `json:"retry_on_exit"`
+	Timeout      string `json:"timeout"`
+	Network      string `json:"network"`
+}
+
+// ResourcesLabels contains the resources requested by the task
+// and the limits applied to its container
+type ResourcesLabels struct {
+	CPURequest    string `json:"cpu_request"`
+	CPULimit      string `json:"cpu_limit"`
+	MemoryRequest string `json:"memory_request"`
+	MemoryLimit   string `json:"memory_limit"`
+}
+
+// SecurityLabels contains the relaxations of the runner security
+// profile requested by the task
+type SecurityLabels struct {
+	WritableRootfs string `json:"writable_rootfs"`
+	ImageUser      string `json:"image_user"`
+	Capabilities   string `json:"capabilities"`
 }
 
 // InputLabels contains the properties for a single input or configuration item
@@ -44,4 +93,6 @@ type InputLabels struct {
 	Required     string   `json:"required"`
 	DefaultValue string   `json:"default_value"`
 	Tags         []string `json:"tags"`
+	MaxSize      string   `json:"max_size"`
+	Accept       string   `json:"accept"`
 }
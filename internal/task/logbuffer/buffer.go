@@ -0,0 +1,140 @@
+package logbuffer
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/bornholm/oplet/internal/task"
+	"github.com/pkg/errors"
+)
+
+// Buffer keeps the log entries of an execution running in-process and
+// lets readers follow them from the beginning
+type Buffer struct {
+	mutex   sync.Mutex
+	entries []task.LogEntry
+	closed  bool
+	notify  chan struct{}
+}
+
+func New() *Buffer {
+	return &Buffer{
+		entries: make([]task.LogEntry, 0),
+		notify:  make(chan struct{}),
+	}
+}
+
+// Append adds a new entry written to the given stream to the buffer and
+// wakes up the readers
+func (b *Buffer) Append(stream task.LogStream, message string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return
+	}
+
+	b.entries = append(b.entries, task.LogEntry{
+		Clock:     uint(len(b.entries)),
+		Timestamp: time.Now(),
+		Stream:    stream,
+		Message:   message,
+	})
+
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+// Writer returns a writer appending each line written to the buffer as an
+// entry of the given stream. Writes must be serialized by the caller. The returned function must be called once
+// writes are over, it waits for the remaining lines to be appended and returns
+// the error which interrupted the scan, if any.
+func (b *Buffer) Writer(stream task.LogStream) (io.Writer, func() error) {
+	r, w := io.Pipe()
+
+	done := make(chan error, 1)
+
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			b.Append(stream, scanner.Text())
+		}
+
+		err := scanner.Err()
+
+		// Consume the remaining output so that writers are never blocked
+		if _, copyErr := io.Copy(io.Discard, r); err == nil {
+			err = copyErr
+		}
+
+		done <- errors.WithStack(err)
+	}()
+
+	return w, func() error {
+		w.Close()
+		return <-done
+	}
+}
+
+// Close marks the buffer as complete, readers are closed once
+// they received all the entries
+func (b *Buffer) Close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return
+	}
+
+	b.closed = true
+	close(b.notify)
+}
+
+// Follow returns a channel receiving all the entries of the buffer,
+// closed once the buffer is closed or ctx is done
+func (b *Buffer) Follow(ctx context.Context) chan task.LogEntry {
+	logs := make(chan task.LogEntry)
+
+	go func() {
+		defer close(logs)
+
+		next := 0
+
+		for {
+			b.mutex.Lock()
+			entries := b.entries[next:]
+			closed := b.closed
+			notify := b.notify
+			b.mutex.Unlock()
+
+			for _, e := range entries {
+				select {
+				case logs <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			next += len(entries)
+
+			if closed && len(entries) == 0 {
+				return
+			}
+
+			if len(entries) > 0 {
+				continue
+			}
+
+			select {
+			case <-notify:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return logs
+}
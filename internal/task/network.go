@@ -0,0 +1,40 @@
+package task
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Network modes of an execution. Any other value is the name
+// of a network the container is attached to.
+const (
+	// No network access at all
+	NetworkNone = "none"
+	// Default network of the container runtime, with full outbound access
+	NetworkBridge = "bridge"
+	// Dedicated network whose only way out is a filtering proxy
+	NetworkEgress = "egress"
+)
+
+// Network describes the network the container of an execution is attached to
+type Network struct {
+	// Network mode or name of the network
+	Name string
+	// URL of the proxy filtering outbound traffic, if any
+	ProxyURL string
+}
+
+var networkNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// ValidNetwork returns true if the value is a network mode or a valid network name
+func ValidNetwork(network string) bool {
+	return networkNamePattern.MatchString(network)
+}
+
+// ParseNetworks parses a comma separated list of network modes or names
+func ParseNetworks(raw string) []string {
+	return strings.FieldsFunc(raw, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
@@ -16,4 +16,7 @@ var (
 
 	// ErrUnsupportedImageFormat is returned when the image format is not supported
 	ErrUnsupportedImageFormat = errors.New("unsupported image format")
+
+	// ErrNotWasmArtifact is returned when the image does not hold a WASM module
+	ErrNotWasmArtifact = errors.New("not a wasm artifact")
 )
@@ -35,7 +35,8 @@ func NewProviderWithLogger(logger *slog.Logger) *Provider {
 }
 
 // FetchTaskDefinition implements task.Provider.
-// It fetches an image from an OCI registry and extracts task definition from labels.
+// It fetches an image from an OCI registry and extracts task definition from labels,
+// or from the annotations of WASM artifacts.
 func (p *Provider) FetchTaskDefinition(ctx context.Context, imageRef string) (*task.Definition, error) {
 	p.logger.Info("fetching task definition", "image_ref", imageRef)
 
@@ -45,16 +46,14 @@ func (p *Provider) FetchTaskDefinition(ctx context.Context, imageRef string) (*t
 		return nil, errors.Wrap(ErrInvalidImageRef, "image reference cannot be empty")
 	}
 
-	// Fetch image configuration from registry
-	p.logger.Debug("fetching image config from registry", "image_ref", imageRef)
-	configFile, err := p.registryClient.FetchImageConfig(ctx, imageRef)
+	// Fetch image labels, or artifact annotations, from registry
+	p.logger.Debug("fetching image labels from registry", "image_ref", imageRef)
+	labels, err := p.registryClient.FetchLabels(ctx, imageRef)
 	if err != nil {
-		p.logger.Error("failed to fetch image config", "image_ref", imageRef, "error", err)
-		return nil, errors.Wrapf(err, "failed to fetch image config for '%s'", imageRef)
+		p.logger.Error("failed to fetch image labels", "image_ref", imageRef, "error", err)
+		return nil, errors.Wrapf(err, "failed to fetch image labels for '%s'", imageRef)
 	}
 
-	// Extract labels from image config
-	labels := configFile.Config.Labels
 	if labels == nil {
 		labels = make(map[string]string)
 	}
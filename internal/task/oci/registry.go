@@ -3,6 +3,7 @@ package oci
 import (
 	"context"
 	"log/slog"
+	"maps"
 	"time"
 
 	"github.com/google/go-containerregistry/pkg/name"
@@ -37,6 +38,82 @@ func NewRegistryClientWithLogger(logger *slog.Logger) *RegistryClient {
 func (c *RegistryClient) FetchImageConfig(ctx context.Context, imageRef string) (*v1.ConfigFile, error) {
 	c.logger.Debug("starting image config fetch", "image_ref", imageRef)
 
+	// Create context with timeout
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	img, err := c.fetchImage(ctx, imageRef)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	c.logger.Debug("successfully fetched image, extracting config", "image_ref", imageRef)
+
+	// Get the image configuration
+	configFile, err := img.ConfigFile()
+	if err != nil {
+		c.logger.Error("failed to extract image config", "image_ref", imageRef, "error", err)
+		return nil, errors.Wrap(ErrUnsupportedImageFormat, err.Error())
+	}
+
+	c.logger.Debug("successfully extracted image config",
+		"image_ref", imageRef,
+		"architecture", configFile.Architecture,
+		"os", configFile.OS,
+		"label_count", len(configFile.Config.Labels))
+
+	return configFile, nil
+}
+
+// FetchLabels fetches the labels of an image from the registry. For WASM
+// artifacts, which have no image configuration, the annotations of the
+// manifest and of its config descriptor are used instead.
+func (c *RegistryClient) FetchLabels(ctx context.Context, imageRef string) (map[string]string, error) {
+	c.logger.Debug("starting labels fetch", "image_ref", imageRef)
+
+	// Create context with timeout
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	img, err := c.fetchImage(ctx, imageRef)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	manifest, err := img.Manifest()
+	if err != nil {
+		c.logger.Error("failed to extract image manifest", "image_ref", imageRef, "error", err)
+		return nil, errors.Wrap(ErrUnsupportedImageFormat, err.Error())
+	}
+
+	if isWasmArtifact(manifest) {
+		labels := make(map[string]string, len(manifest.Annotations)+len(manifest.Config.Annotations))
+		maps.Copy(labels, manifest.Annotations)
+		maps.Copy(labels, manifest.Config.Annotations)
+
+		c.logger.Debug("successfully extracted artifact annotations",
+			"image_ref", imageRef,
+			"label_count", len(labels))
+
+		return labels, nil
+	}
+
+	configFile, err := img.ConfigFile()
+	if err != nil {
+		c.logger.Error("failed to extract image config", "image_ref", imageRef, "error", err)
+		return nil, errors.Wrap(ErrUnsupportedImageFormat, err.Error())
+	}
+
+	c.logger.Debug("successfully extracted image labels",
+		"image_ref", imageRef,
+		"label_count", len(configFile.Config.Labels))
+
+	return configFile.Config.Labels, nil
+}
+
+// fetchImage parses the image reference and fetches the image from the registry,
+// its content being lazily fetched with the given context
+func (c *RegistryClient) fetchImage(ctx context.Context, imageRef string) (v1.Image, error) {
 	// Parse the image reference
 	ref, err := name.ParseReference(imageRef)
 	if err != nil {
@@ -50,10 +127,6 @@ func (c *RegistryClient) FetchImageConfig(ctx context.Context, imageRef string)
 		"repository", ref.Context().RepositoryStr(),
 		"tag", ref.Identifier())
 
-	// Create context with timeout
-	ctx, cancel := context.WithTimeout(ctx, c.timeout)
-	defer cancel()
-
 	c.logger.Debug("fetching image from registry",
 		"image_ref", imageRef,
 		"timeout", c.timeout)
@@ -69,22 +142,7 @@ func (c *RegistryClient) FetchImageConfig(ctx context.Context, imageRef string)
 		return nil, errors.Wrap(ErrRegistryUnavailable, err.Error())
 	}
 
-	c.logger.Debug("successfully fetched image, extracting config", "image_ref", imageRef)
-
-	// Get the image configuration
-	configFile, err := img.ConfigFile()
-	if err != nil {
-		c.logger.Error("failed to extract image config", "image_ref", imageRef, "error", err)
-		return nil, errors.Wrap(ErrUnsupportedImageFormat, err.Error())
-	}
-
-	c.logger.Debug("successfully extracted image config",
-		"image_ref", imageRef,
-		"architecture", configFile.Architecture,
-		"os", configFile.OS,
-		"label_count", len(configFile.Config.Labels))
-
-	return configFile, nil
+	return img, nil
 }
 
 // isNotFoundError checks if the error indicates the image was not found
@@ -0,0 +1,109 @@
+package oci
+
+import (
+	"context"
+	"io"
+	"slices"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/pkg/errors"
+)
+
+const (
+	// MediaTypeWasmConfig is the config media type of the WASM artifacts
+	// following the CNCF TAG runtime specification
+	MediaTypeWasmConfig types.MediaType = "application/vnd.wasm.config.v0+json"
+	// MediaTypeWasmLayer is the layer media type of the WASM artifacts
+	// following the CNCF TAG runtime specification
+	MediaTypeWasmLayer types.MediaType = "application/vnd.wasm.content.layer.v1+wasm"
+	// MediaTypeModuleWasmLayer is the layer media type of the WASM artifacts
+	// pushed by wasm-to-oci
+	MediaTypeModuleWasmLayer types.MediaType = "application/vnd.module.wasm.content.layer.v1+wasm"
+	// MediaTypeWasm is the generic media type of WASM modules
+	MediaTypeWasm types.MediaType = "application/wasm"
+)
+
+var wasmLayerMediaTypes = []types.MediaType{
+	MediaTypeWasmLayer,
+	MediaTypeModuleWasmLayer,
+	MediaTypeWasm,
+}
+
+// maxWasmModuleSize is the maximum size of a fetched WASM module
+const maxWasmModuleSize = 256 << 20
+
+// FetchWasmModule fetches the binary of the WASM module held by an artifact
+// from the registry
+func (c *RegistryClient) FetchWasmModule(ctx context.Context, imageRef string) ([]byte, error) {
+	c.logger.Debug("starting wasm module fetch", "image_ref", imageRef)
+
+	// Create context with timeout
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	img, err := c.fetchImage(ctx, imageRef)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	manifest, err := img.Manifest()
+	if err != nil {
+		c.logger.Error("failed to extract image manifest", "image_ref", imageRef, "error", err)
+		return nil, errors.Wrap(ErrUnsupportedImageFormat, err.Error())
+	}
+
+	index := slices.IndexFunc(manifest.Layers, func(d v1.Descriptor) bool {
+		return slices.Contains(wasmLayerMediaTypes, d.MediaType)
+	})
+	if index == -1 {
+		return nil, errors.Wrapf(ErrNotWasmArtifact, "no wasm layer found in '%s'", imageRef)
+	}
+
+	descriptor := manifest.Layers[index]
+
+	if descriptor.Size > maxWasmModuleSize {
+		return nil, errors.Wrapf(ErrUnsupportedImageFormat, "wasm module of %d bytes exceeds the maximum size of %d bytes", descriptor.Size, maxWasmModuleSize)
+	}
+
+	layer, err := img.LayerByDigest(descriptor.Digest)
+	if err != nil {
+		return nil, errors.Wrap(ErrUnsupportedImageFormat, err.Error())
+	}
+
+	// WASM layers are stored without compression
+	blob, err := layer.Compressed()
+	if err != nil {
+		return nil, errors.Wrap(ErrRegistryUnavailable, err.Error())
+	}
+
+	defer blob.Close()
+
+	module, err := io.ReadAll(io.LimitReader(blob, maxWasmModuleSize))
+	if err != nil {
+		return nil, errors.Wrap(ErrRegistryUnavailable, err.Error())
+	}
+
+	c.logger.Debug("successfully fetched wasm module",
+		"image_ref", imageRef,
+		"digest", descriptor.Digest.String(),
+		"size", len(module))
+
+	return module, nil
+}
+
+// isWasmArtifact checks if the manifest describes a WASM artifact rather than
+// a container image
+func isWasmArtifact(manifest *v1.Manifest) bool {
+	if manifest.Config.MediaType == MediaTypeWasmConfig {
+		return true
+	}
+
+	if manifest.Config.MediaType.IsConfig() {
+		return false
+	}
+
+	return slices.ContainsFunc(manifest.Layers, func(d v1.Descriptor) bool {
+		return slices.Contains(wasmLayerMediaTypes, d.MediaType)
+	})
+}
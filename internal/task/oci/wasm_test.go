@@ -0,0 +1,81 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/pkg/errors"
+
+	"github.com/bornholm/oplet/internal/task/label"
+)
+
+func TestRegistryClient_WasmArtifact(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	module := []byte("\x00asm\x01\x00\x00\x00")
+
+	var artifact v1.Image = empty.Image
+	artifact = mutate.MediaType(artifact, "application/vnd.oci.image.manifest.v1+json")
+	artifact = mutate.ConfigMediaType(artifact, MediaTypeWasmConfig)
+
+	artifact, err = mutate.Append(artifact, mutate.Addendum{
+		Layer:     static.NewLayer(module, MediaTypeWasmLayer),
+		MediaType: MediaTypeWasmLayer,
+	})
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	artifact = mutate.Annotations(artifact, map[string]string{
+		label.LabelMetaName: "Hello World",
+	}).(v1.Image)
+
+	imageRef := fmt.Sprintf("%s/oplet/hello-world:latest", serverURL.Host)
+
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	if err := remote.Write(ref, artifact); err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	client := NewRegistryClient()
+	ctx := context.Background()
+
+	labels, err := client.FetchLabels(ctx, imageRef)
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	if e, g := "Hello World", labels[label.LabelMetaName]; e != g {
+		t.Errorf("labels: expected task name '%s', got '%s'", e, g)
+	}
+
+	fetched, err := client.FetchWasmModule(ctx, imageRef)
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	if !bytes.Equal(module, fetched) {
+		t.Errorf("FetchWasmModule: expected module %v, got %v", module, fetched)
+	}
+}
@@ -0,0 +1,380 @@
+package process
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/xid"
+
+	"github.com/bornholm/oplet/internal/slogx"
+	"github.com/bornholm/oplet/internal/task"
+	"github.com/bornholm/oplet/internal/task/logbuffer"
+	"github.com/bornholm/oplet/internal/task/workdir"
+)
+
+// ProcessExecutor implements task.Executor by running tasks as local processes
+// in temporary directories, without container runtime. The image reference of
+// an execution selects the command to run.
+//
+// Resource constraints, networks and security profiles are not enforced.
+type ProcessExecutor struct {
+	logger   *slog.Logger
+	commands map[string][]string
+
+	mutex sync.Mutex
+	runs  map[string]*run
+}
+
+// run holds the log buffer and the working directory of a running execution
+type run struct {
+	logs    *logbuffer.Buffer
+	workDir *workdir.Dir
+}
+
+// NewExecutor creates a new process executor running the given commands,
+// indexed by image reference
+func NewExecutor(logger *slog.Logger, commands map[string][]string) *ProcessExecutor {
+	return &ProcessExecutor{
+		logger:   logger.With("component", "process-executor"),
+		commands: commands,
+		runs:     make(map[string]*run),
+	}
+}
+
+// LoadCommands reads the commands of a process executor from a JSON file
+// mapping image references to commands, ex: {"my/task:latest": ["./run.sh"]}
+func LoadCommands(path string) (map[string][]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var commands map[string][]string
+	if err := json.Unmarshal(data, &commands); err != nil {
+		return nil, errors.Wrapf(err, "could not parse commands file '%s'", path)
+	}
+
+	for imageRef, command := range commands {
+		if len(command) == 0 {
+			return nil, errors.Errorf("empty command for image '%s'", imageRef)
+		}
+	}
+
+	return commands, nil
+}
+
+// Execute implements task.Executor.Execute
+func (e *ProcessExecutor) Execute(ctx context.Context, req task.ExecutionRequest) error {
+	runID := xid.New().String()
+
+	e.logger.Info("starting process execution", "image", req.ImageRef)
+
+	onChange := req.OnChange
+	if onChange == nil {
+		onChange = func(e task.Execution) {}
+	}
+
+	go func() {
+		execution := task.Execution{
+			State: task.ExecutionStateProcessingRequest,
+		}
+
+		onChange(execution)
+
+		execution.State = task.ExecutionStatePullingImage
+		onChange(execution)
+
+		command, exists := e.commands[req.ImageRef]
+		if !exists {
+			execution.State = task.ExecutionStateFailed
+			execution.Error = &task.ExecutionError{
+				Type:    task.ErrorTypeCommandNotFound,
+				Message: fmt.Sprintf("no command declared for image %s", req.ImageRef),
+				Cause:   errors.WithStack(task.ErrImageNotFound),
+			}
+			onChange(execution)
+			return
+		}
+
+		execution.State = task.ExecutionStateImagePulled
+		onChange(execution)
+
+		execution.State = task.ExecutionStateCreatingContainer
+		onChange(execution)
+
+		workDir, err := workdir.New("oplet-task-" + runID + "-")
+		if err != nil {
+			execution.State = task.ExecutionStateFailed
+			execution.Error = &task.ExecutionError{
+				Type:    task.ErrorTypeProcessError,
+				Message: "failed to create working directory",
+				Cause:   errors.WithStack(err),
+			}
+			onChange(execution)
+			return
+		}
+
+		logs := logbuffer.New()
+
+		e.mutex.Lock()
+		e.runs[runID] = &run{logs: logs, workDir: workDir}
+		e.mutex.Unlock()
+
+		// Ensure cleanup
+		defer func() {
+			logs.Close()
+
+			e.mutex.Lock()
+			delete(e.runs, runID)
+			e.mutex.Unlock()
+
+			if err := workDir.Remove(); err != nil {
+				e.logger.Warn("failed to cleanup working directory", "run_id", runID, "work_dir", workDir.Path(), "error", err)
+			}
+		}()
+
+		execution.ContainerID = runID
+		execution.State = task.ExecutionStateContainerCreated
+		onChange(execution)
+
+		// Copy input files
+		if len(req.Inputs) > 0 {
+			execution.State = task.ExecutionStateUploadingFiles
+			onChange(execution)
+
+			if err := workDir.WriteInputs(req.Inputs); err != nil {
+				execution.State = task.ExecutionStateFailed
+				execution.Error = &task.ExecutionError{
+					Type:        task.ErrorTypeFileUploadFailed,
+					Message:     "failed to copy input files",
+					ContainerID: runID,
+					Cause:       errors.WithStack(err),
+				}
+				onChange(execution)
+				return
+			}
+
+			execution.State = task.ExecutionStateFilesUploaded
+			onChange(execution)
+		}
+
+		execution.StartedAt = time.Now()
+		execution.State = task.ExecutionStateStartingContainer
+		onChange(execution)
+
+		// Set timeout if specified, counted from the process start
+		runCtx := ctx
+		if req.Timeout > 0 {
+			var cancel context.CancelFunc
+			runCtx, cancel = context.WithTimeout(ctx, req.Timeout)
+			defer cancel()
+		}
+
+		cmd := e.createCommand(runCtx, runID, workDir, command, req)
+
+		closeOutput := e.captureOutput(cmd, logs)
+
+		if err := cmd.Start(); err != nil {
+			closeOutput()
+
+			execution.State = task.ExecutionStateFailed
+			execution.Error = &task.ExecutionError{
+				Type:        task.ErrorTypeProcessError,
+				Message:     "failed to start process",
+				ContainerID: runID,
+				Cause:       errors.WithStack(err),
+			}
+			onChange(execution)
+			return
+		}
+
+		execution.State = task.ExecutionStateContainerStarted
+		onChange(execution)
+
+		// Wait for the process to finish
+		waitErr := cmd.Wait()
+		closeOutput()
+
+		execution.FinishedAt = time.Now()
+
+		if runCtx.Err() != nil {
+			if ctx.Err() == nil && errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+				execution.State = task.ExecutionStateTimedOut
+				execution.Error = &task.ExecutionError{
+					Type:        task.ErrorTypeTimeout,
+					Message:     fmt.Sprintf("execution exceeded its timeout of %s", req.Timeout),
+					ContainerID: runID,
+					Cause:       errors.WithStack(runCtx.Err()),
+				}
+				onChange(execution)
+				return
+			}
+
+			execution.State = task.ExecutionStateKilled
+			execution.Error = &task.ExecutionError{
+				Type:        task.ErrorTypeProcessError,
+				Message:     "process killed",
+				ContainerID: runID,
+				Cause:       errors.WithStack(runCtx.Err()),
+			}
+			onChange(execution)
+			return
+		}
+
+		var exitErr *exec.ExitError
+		if waitErr != nil && !errors.As(waitErr, &exitErr) {
+			execution.State = task.ExecutionStateFailed
+			execution.Error = &task.ExecutionError{
+				Type:        task.ErrorTypeProcessError,
+				Message:     "error waiting for process",
+				ContainerID: runID,
+				Cause:       errors.WithStack(waitErr),
+			}
+			onChange(execution)
+			return
+		}
+
+		execution.ExitCode = cmd.ProcessState.ExitCode()
+		execution.State = task.ExecutionStateContainerFinished
+		onChange(execution)
+
+		failed := execution.ExitCode != 0
+
+		if !failed {
+			execution.State = task.ExecutionStateDownloadingFiles
+			onChange(execution)
+
+			outputs, close := workDir.ArchiveOutputs()
+			defer func() {
+				if err := close(); err != nil {
+					e.logger.ErrorContext(ctx, "could not close outputs archive", slogx.Error(err))
+				}
+			}()
+
+			execution.Outputs = outputs
+			execution.State = task.ExecutionStateFilesDownloaded
+			onChange(execution)
+		}
+
+		e.logger.Info("process execution completed",
+			"run_id", runID,
+			"exit_code", execution.ExitCode,
+			"duration", execution.FinishedAt.Sub(execution.StartedAt))
+
+		if failed {
+			execution.State = task.ExecutionStateFailed
+		} else {
+			execution.State = task.ExecutionStateSucceeded
+		}
+
+		onChange(execution)
+	}()
+
+	return nil
+}
+
+// createCommand prepares the process of an execution. The process is
+// interrupted when ctx is done, and killed if it does not exit in time.
+func (e *ProcessExecutor) createCommand(ctx context.Context, runID string, workDir *workdir.Dir, command []string, req task.ExecutionRequest) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+
+	cmd.Dir = workDir.Path()
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	cmd.WaitDelay = 5 * time.Second
+
+	cmd.Env = []string{
+		fmt.Sprintf("PATH=%s", os.Getenv("PATH")),
+		fmt.Sprintf("HOME=%s", workDir.Path()),
+		fmt.Sprintf("TMPDIR=%s", os.TempDir()),
+	}
+
+	for key, value := range req.Environment {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, value))
+	}
+
+	cmd.Env = append(cmd.Env,
+		fmt.Sprintf("OPLET_RUN_ID=%s", runID),
+		fmt.Sprintf("OPLET_INPUTS_DIR=%s", workDir.InputsDir()),
+		fmt.Sprintf("OPLET_OUTPUTS_DIR=%s", workDir.OutputsDir()),
+		fmt.Sprintf("OPLET_PROGRESS_FILE=%s", workDir.ProgressFile()),
+	)
+
+	return cmd
+}
+
+// captureOutput appends the lines written by the process on its stdout and stderr
+// to the log buffer. The returned function must be called once the process exited,
+// it waits for the whole output to be consumed.
+func (e *ProcessExecutor) captureOutput(cmd *exec.Cmd, logs *logbuffer.Buffer) func() {
+	stdout, closeStdout := logs.Writer(task.LogStreamStdout)
+	stderr, closeStderr := logs.Writer(task.LogStreamStderr)
+
+	// Each stream is copied by its own goroutine of exec.Cmd
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	return func() {
+		for _, closeWriter := range []func() error{closeStdout, closeStderr} {
+			if err := closeWriter(); err != nil {
+				e.logger.Error("could not scan for next log line", slogx.Error(err))
+			}
+		}
+	}
+}
+
+// GetLogs implements task.Executor.GetLogs
+func (e *ProcessExecutor) GetLogs(ctx context.Context, runID string) (chan task.LogEntry, error) {
+	e.mutex.Lock()
+	run, exists := e.runs[runID]
+	e.mutex.Unlock()
+
+	if !exists {
+		return nil, &task.ExecutionError{
+			Type:        task.ErrorTypeProcessError,
+			Message:     "failed to get process logs",
+			ContainerID: runID,
+			Cause:       errors.WithStack(task.ErrContainerNotFound),
+		}
+	}
+
+	return run.logs.Follow(ctx), nil
+}
+
+// ReadProgress implements task.ProgressReader
+func (e *ProcessExecutor) ReadProgress(ctx context.Context, runID string) (string, error) {
+	e.mutex.Lock()
+	run, exists := e.runs[runID]
+	e.mutex.Unlock()
+
+	if !exists {
+		return "", &task.ExecutionError{
+			Type:        task.ErrorTypeProcessError,
+			Message:     "failed to read process progress",
+			ContainerID: runID,
+			Cause:       errors.WithStack(task.ErrContainerNotFound),
+		}
+	}
+
+	progress, err := run.workDir.ReadProgress()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return progress, nil
+}
+
+// Ensure ProcessExecutor implements task.Executor interface
+var _ task.Executor = &ProcessExecutor{}
+
+// Ensure ProcessExecutor implements task.ProgressReader interface
+var _ task.ProgressReader = &ProcessExecutor{}
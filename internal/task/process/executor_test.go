@@ -0,0 +1,20 @@
+package process
+
+import (
+	"testing"
+
+	"github.com/bornholm/oplet/internal/slogx"
+	"github.com/bornholm/oplet/internal/task/testsuite"
+)
+
+func TestExecutor(t *testing.T) {
+	logger := slogx.NewTestLogger(t)
+
+	executor := NewExecutor(logger, map[string][]string{
+		"docker.io/bornholm/oplet-hello-world-task:latest": {
+			"sh", "-c", `echo "text_env=${text_env}"; ls -la inputs; echo "hello world" | tee outputs/hello-world.txt`,
+		},
+	})
+
+	testsuite.RunExecutorTestSuite(t, executor)
+}
@@ -0,0 +1,70 @@
+package task
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ProgressLinePrefix prefixes the log lines a task writes to report its
+// progress, ex: "::oplet::progress 42 Processing page 12/30"
+const ProgressLinePrefix = "::oplet::progress "
+
+// MaxProgressFileSize is the size of the end of the progress files read by
+// the executors, the last line holding the progress
+const MaxProgressFileSize = 4096
+
+// maxProgressStepLength is the maximum length of the step of a progress
+const maxProgressStepLength = 256
+
+// Progress is the progress reported by a running task
+type Progress struct {
+	Percent float64 // Between 0 and 100
+	Step    string  // Description of the current step (optional)
+}
+
+// ParseProgressLine parses a log line reporting the progress of a task.
+// It returns false if the line does not report a valid progress.
+func ParseProgressLine(line string) (Progress, bool) {
+	raw, found := strings.CutPrefix(line, ProgressLinePrefix)
+	if !found {
+		return Progress{}, false
+	}
+
+	progress, err := ParseProgress(raw)
+	if err != nil {
+		return Progress{}, false
+	}
+
+	return progress, true
+}
+
+// ParseProgress parses a progress made of a percentage optionally followed
+// by the current step, ex: "42 Processing page 12/30". If the content has
+// several lines, the last non-empty one is parsed, so that tasks can append
+// to their progress file.
+func ParseProgress(raw string) (Progress, error) {
+	lines := strings.Split(strings.TrimSpace(raw), "\n")
+	raw = strings.TrimSpace(lines[len(lines)-1])
+
+	rawPercent, step, _ := strings.Cut(raw, " ")
+
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(rawPercent, "%"), 64)
+	if err != nil || math.IsNaN(percent) {
+		return Progress{}, errors.Errorf("invalid progress percentage '%s'", rawPercent)
+	}
+
+	percent = min(max(percent, 0), 100)
+
+	step = strings.TrimSpace(step)
+	if len(step) > maxProgressStepLength {
+		step = strings.ToValidUTF8(step[:maxProgressStepLength], "")
+	}
+
+	return Progress{
+		Percent: percent,
+		Step:    step,
+	}, nil
+}
@@ -0,0 +1,91 @@
+package task
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestParseProgress(t *testing.T) {
+	type testCase struct {
+		raw           string
+		expected      Progress
+		expectedError bool
+	}
+
+	testCases := []testCase{
+		{raw: "42", expected: Progress{Percent: 42}},
+		{raw: "42.5 Processing page 12/30", expected: Progress{Percent: 42.5, Step: "Processing page 12/30"}},
+		{raw: "42% Processing", expected: Progress{Percent: 42, Step: "Processing"}},
+		{raw: "  10   Starting  \n", expected: Progress{Percent: 10, Step: "Starting"}},
+		{raw: "10 Starting\n20 Running\n\n", expected: Progress{Percent: 20, Step: "Running"}},
+		{raw: "10 Starting\r\n30 Running\r\n", expected: Progress{Percent: 30, Step: "Running"}},
+		{raw: "-5 Rewinding", expected: Progress{Percent: 0, Step: "Rewinding"}},
+		{raw: "150 Overflowing", expected: Progress{Percent: 100, Step: "Overflowing"}},
+		{raw: "Inf", expected: Progress{Percent: 100}},
+		{raw: "50 " + strings.Repeat("a", 300), expected: Progress{Percent: 50, Step: strings.Repeat("a", maxProgressStepLength)}},
+		{raw: "50 " + strings.Repeat("a", maxProgressStepLength-1) + "é", expected: Progress{Percent: 50, Step: strings.Repeat("a", maxProgressStepLength-1)}},
+		{raw: "", expectedError: true},
+		{raw: "NaN", expectedError: true},
+		{raw: "half done", expectedError: true},
+		{raw: "42\nhalf done", expectedError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.raw, func(t *testing.T) {
+			progress, err := ParseProgress(tc.raw)
+
+			if tc.expectedError {
+				if err == nil {
+					t.Errorf("expected an error, got nil")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("%+v", errors.WithStack(err))
+			}
+
+			if e, g := tc.expected, progress; e != g {
+				t.Errorf("ParseProgress(%q): expected %+v, got %+v", tc.raw, e, g)
+			}
+		})
+	}
+}
+
+func TestParseProgressLine(t *testing.T) {
+	type testCase struct {
+		line          string
+		expected      Progress
+		expectedFound bool
+	}
+
+	testCases := []testCase{
+		{line: "::oplet::progress 42 Processing page 12/30", expected: Progress{Percent: 42, Step: "Processing page 12/30"}, expectedFound: true},
+		{line: "::oplet::progress 100", expected: Progress{Percent: 100}, expectedFound: true},
+		{line: "::oplet::progress invalid", expectedFound: false},
+		{line: "::oplet::progress", expectedFound: false},
+		{line: "Processing page 12/30", expectedFound: false},
+		{line: " ::oplet::progress 42", expectedFound: false},
+		{line: "::oplet::progress 10\n::oplet::progress 20", expectedFound: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.line, func(t *testing.T) {
+			progress, found := ParseProgressLine(tc.line)
+
+			if e, g := tc.expectedFound, found; e != g {
+				t.Fatalf("ParseProgressLine(%q): expected found %v, got %v", tc.line, e, g)
+			}
+
+			if !found {
+				return
+			}
+
+			if e, g := tc.expected, progress; e != g {
+				t.Errorf("ParseProgressLine(%q): expected %+v, got %+v", tc.line, e, g)
+			}
+		})
+	}
+}
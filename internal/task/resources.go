@@ -0,0 +1,97 @@
+package task
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Resources describes the resources requested by an execution, used to
+// select a runner with enough remaining capacity, and the limits applied
+// to its container. Zero values mean none.
+type Resources struct {
+	// Number of CPUs
+	CPURequest float64
+	CPULimit   float64
+	// Memory in bytes
+	MemoryRequest int64
+	MemoryLimit   int64
+}
+
+var memoryUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"Ki", 1 << 10},
+	{"Mi", 1 << 20},
+	{"Gi", 1 << 30},
+	{"Ti", 1 << 40},
+	{"K", 1000},
+	{"M", 1000 * 1000},
+	{"G", 1000 * 1000 * 1000},
+	{"T", 1000 * 1000 * 1000 * 1000},
+}
+
+// ParseMemory parses a quantity of memory expressed in bytes or with
+// a decimal or binary suffix, ex: 1073741824, 512M, 2Gi
+func ParseMemory(raw string) (int64, error) {
+	raw = strings.TrimSpace(raw)
+
+	multiplier := int64(1)
+	for _, u := range memoryUnits {
+		if strings.HasSuffix(raw, u.suffix) {
+			raw = strings.TrimSuffix(raw, u.suffix)
+			multiplier = u.multiplier
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	if value < 0 {
+		return 0, errors.Errorf("invalid memory quantity '%s', must be positive", raw)
+	}
+
+	if float64(multiplier)*value > float64(math.MaxInt64) {
+		return 0, errors.Errorf("invalid memory quantity '%s', too large", raw)
+	}
+
+	return int64(value * float64(multiplier)), nil
+}
+
+// FormatMemory formats a quantity of memory with the largest binary
+// suffix allowing an exact representation
+func FormatMemory(bytes int64) string {
+	for i := 3; i >= 0; i-- {
+		u := memoryUnits[i]
+		if bytes >= u.multiplier && bytes%u.multiplier == 0 {
+			return strconv.FormatInt(bytes/u.multiplier, 10) + u.suffix
+		}
+	}
+
+	return strconv.FormatInt(bytes, 10)
+}
+
+// ParseCPUs parses a positive number of CPUs, ex: 0.5, 2
+func ParseCPUs(raw string) (float64, error) {
+	value, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	if value < 0 {
+		return 0, errors.Errorf("invalid number of cpus '%s', must be positive", raw)
+	}
+
+	return value, nil
+}
+
+// FormatCPUs formats a number of CPUs
+func FormatCPUs(cpus float64) string {
+	return strconv.FormatFloat(cpus, 'f', -1, 64)
+}
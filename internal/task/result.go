@@ -0,0 +1,183 @@
+package task
+
+import (
+	"bytes"
+	"encoding/json"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// ResultDir is the directory of the outputs holding the result of the task,
+	// its files not being uploaded as output files
+	ResultDir = ".oplet"
+	// ResultManifestFile is the JSON manifest of the result, in the result directory
+	ResultManifestFile = "result.json"
+	// ResultSummaryFile is the Markdown summary of the result, in the result
+	// directory, used when the manifest does not hold one
+	ResultSummaryFile = "summary.md"
+	// MaxResultSize is the maximum size of each result file
+	MaxResultSize = 1 << 20
+)
+
+const (
+	maxResultMetrics   = 100
+	maxResultTables    = 10
+	maxResultTableRows = 1000
+	maxResultLinks     = 100
+)
+
+// Result is a structured summary of what happened during an execution,
+// written by the task and rendered on the execution page. It is collected
+// with the output files, only from the executions which succeeded.
+type Result struct {
+	// Markdown summary of the execution
+	Summary string         `json:"summary,omitempty"`
+	Metrics []ResultMetric `json:"metrics,omitempty"`
+	Tables  []ResultTable  `json:"tables,omitempty"`
+	Links   []ResultLink   `json:"links,omitempty"`
+}
+
+type ResultMetric struct {
+	Label string      `json:"label"`
+	Value ResultValue `json:"value"`
+	Unit  string      `json:"unit,omitempty"`
+}
+
+type ResultTable struct {
+	Title   string          `json:"title,omitempty"`
+	Columns []string        `json:"columns"`
+	Rows    [][]ResultValue `json:"rows"`
+}
+
+type ResultLink struct {
+	Label string `json:"label"`
+	// Path of the output file, relative to the outputs directory
+	File string `json:"file"`
+}
+
+// ResultValue is a value of the result, written by the task as a JSON string,
+// number or boolean
+type ResultValue string
+
+// UnmarshalJSON implements json.Unmarshaler
+func (v *ResultValue) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return errors.WithStack(err)
+	}
+
+	switch value := value.(type) {
+	case nil:
+		*v = ""
+	case string:
+		*v = ResultValue(value)
+	case json.Number:
+		*v = ResultValue(value.String())
+	case bool:
+		*v = ResultValue(strconv.FormatBool(value))
+	default:
+		return errors.Errorf("result values must be strings, numbers or booleans, got '%s'", data)
+	}
+
+	return nil
+}
+
+// IsEmpty returns true if the result holds nothing to render
+func (r *Result) IsEmpty() bool {
+	return r.Summary == "" && len(r.Metrics) == 0 && len(r.Tables) == 0 && len(r.Links) == 0
+}
+
+// Validate checks that the result can be stored and rendered
+func (r *Result) Validate() error {
+	if len(r.Summary) > MaxResultSize {
+		return errors.Errorf("result summary can not exceed %d bytes", MaxResultSize)
+	}
+
+	if !utf8.ValidString(r.Summary) {
+		return errors.New("result summary must be valid UTF-8")
+	}
+
+	if len(r.Metrics) > maxResultMetrics {
+		return errors.Errorf("result can not hold more than %d metrics", maxResultMetrics)
+	}
+
+	for i, metric := range r.Metrics {
+		if metric.Label == "" {
+			return errors.Errorf("result metric #%d has no label", i)
+		}
+	}
+
+	if len(r.Tables) > maxResultTables {
+		return errors.Errorf("result can not hold more than %d tables", maxResultTables)
+	}
+
+	for i, table := range r.Tables {
+		if len(table.Columns) == 0 {
+			return errors.Errorf("result table #%d has no columns", i)
+		}
+
+		if len(table.Rows) > maxResultTableRows {
+			return errors.Errorf("result table #%d can not hold more than %d rows", i, maxResultTableRows)
+		}
+
+		for j, row := range table.Rows {
+			if len(row) > len(table.Columns) {
+				return errors.Errorf("row #%d of result table #%d has more cells than columns", j, i)
+			}
+		}
+	}
+
+	if len(r.Links) > maxResultLinks {
+		return errors.Errorf("result can not hold more than %d links", maxResultLinks)
+	}
+
+	for i, link := range r.Links {
+		if !IsValidOutputPath(link.File) {
+			return errors.Errorf("result link #%d does not target a file of the outputs directory", i)
+		}
+	}
+
+	return nil
+}
+
+// IsValidOutputPath returns true if the given path is a clean relative path,
+// not escaping the outputs directory
+func IsValidOutputPath(file string) bool {
+	if file == "" || path.IsAbs(file) || strings.Contains(file, "\\") {
+		return false
+	}
+
+	cleaned := path.Clean(file)
+
+	return cleaned == file && cleaned != "." && cleaned != ".." && !strings.HasPrefix(cleaned, "../")
+}
+
+// ParseResult parses the result written by a task from the content of its
+// manifest and of its summary file, either of which can be empty
+func ParseResult(manifest []byte, summary []byte) (*Result, error) {
+	result := &Result{}
+
+	if len(bytes.TrimSpace(manifest)) > 0 {
+		if err := json.Unmarshal(manifest, result); err != nil {
+			return nil, errors.Wrapf(err, "could not parse %s", ResultManifestFile)
+		}
+	}
+
+	if result.Summary == "" {
+		result.Summary = string(summary)
+	}
+
+	if err := result.Validate(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return result, nil
+}
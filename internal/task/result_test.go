@@ -0,0 +1,233 @@
+package task
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestParseResult(t *testing.T) {
+	type testCase struct {
+		name            string
+		manifest        string
+		summary         string
+		expectedSummary string
+		expectedMetrics []ResultMetric
+		expectError     bool
+	}
+
+	testCases := []testCase{
+		{
+			name:            "no result",
+			expectedSummary: "",
+		},
+		{
+			name:            "summary file only",
+			summary:         "Processed **30** pages",
+			expectedSummary: "Processed **30** pages",
+		},
+		{
+			name:            "manifest summary prevails",
+			manifest:        `{"summary": "From the manifest"}`,
+			summary:         "From the file",
+			expectedSummary: "From the manifest",
+		},
+		{
+			name:            "summary file used when the manifest has none",
+			manifest:        `{"metrics": [{"label": "Pages", "value": 30}]}`,
+			summary:         "From the file",
+			expectedSummary: "From the file",
+			expectedMetrics: []ResultMetric{{Label: "Pages", Value: "30"}},
+		},
+		{
+			name:     "metric values",
+			manifest: `{"metrics": [{"label": "Duration", "value": 12.5, "unit": "s"}, {"label": "Done", "value": true}, {"label": "Status", "value": "ok"}, {"label": "Empty", "value": null}]}`,
+			expectedMetrics: []ResultMetric{
+				{Label: "Duration", Value: "12.5", Unit: "s"},
+				{Label: "Done", Value: "true"},
+				{Label: "Status", Value: "ok"},
+				{Label: "Empty", Value: ""},
+			},
+		},
+		{
+			name:        "invalid JSON",
+			manifest:    `{"summary": `,
+			expectError: true,
+		},
+		{
+			name:        "object value",
+			manifest:    `{"metrics": [{"label": "Nested", "value": {"a": 1}}]}`,
+			expectError: true,
+		},
+		{
+			name:        "metric without label",
+			manifest:    `{"metrics": [{"value": 1}]}`,
+			expectError: true,
+		},
+		{
+			name:        "link escaping the outputs directory",
+			manifest:    `{"links": [{"label": "Secrets", "file": "../../etc/passwd"}]}`,
+			expectError: true,
+		},
+		{
+			name:        "summary with invalid UTF-8",
+			summary:     "\xff\xfe",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := ParseResult([]byte(tc.manifest), []byte(tc.summary))
+
+			if tc.expectError {
+				if err == nil {
+					t.Errorf("expected an error, got nil")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("%+v", errors.WithStack(err))
+			}
+
+			if e, g := tc.expectedSummary, result.Summary; e != g {
+				t.Errorf("result.Summary: expected '%s', got '%s'", e, g)
+			}
+
+			if e, g := len(tc.expectedMetrics), len(result.Metrics); e != g {
+				t.Fatalf("len(result.Metrics): expected %d, got %d", e, g)
+			}
+
+			for i, expected := range tc.expectedMetrics {
+				if e, g := expected, result.Metrics[i]; e != g {
+					t.Errorf("result.Metrics[%d]: expected %+v, got %+v", i, e, g)
+				}
+			}
+		})
+	}
+}
+
+func TestResultValidate(t *testing.T) {
+	type testCase struct {
+		name        string
+		result      Result
+		expectError bool
+	}
+
+	rows := func(count int) [][]ResultValue {
+		rows := make([][]ResultValue, count)
+		for i := range rows {
+			rows[i] = []ResultValue{ResultValue(fmt.Sprint(i))}
+		}
+		return rows
+	}
+
+	testCases := []testCase{
+		{
+			name:        "empty result",
+			result:      Result{},
+			expectError: false,
+		},
+		{
+			name: "complete result",
+			result: Result{
+				Summary: "Done",
+				Metrics: []ResultMetric{{Label: "Pages", Value: "30"}},
+				Tables:  []ResultTable{{Columns: []string{"Page"}, Rows: rows(maxResultTableRows)}},
+				Links:   []ResultLink{{Label: "Report", File: "reports/report.pdf"}},
+			},
+			expectError: false,
+		},
+		{
+			name:        "summary too large",
+			result:      Result{Summary: strings.Repeat("a", MaxResultSize+1)},
+			expectError: true,
+		},
+		{
+			name:        "too many metrics",
+			result:      Result{Metrics: make([]ResultMetric, maxResultMetrics+1)},
+			expectError: true,
+		},
+		{
+			name:        "too many tables",
+			result:      Result{Tables: make([]ResultTable, maxResultTables+1)},
+			expectError: true,
+		},
+		{
+			name:        "table without columns",
+			result:      Result{Tables: []ResultTable{{Rows: rows(1)}}},
+			expectError: true,
+		},
+		{
+			name:        "too many rows",
+			result:      Result{Tables: []ResultTable{{Columns: []string{"Page"}, Rows: rows(maxResultTableRows + 1)}}},
+			expectError: true,
+		},
+		{
+			name:        "row with more cells than columns",
+			result:      Result{Tables: []ResultTable{{Columns: []string{"Page"}, Rows: [][]ResultValue{{"1", "2"}}}}},
+			expectError: true,
+		},
+		{
+			name:        "too many links",
+			result:      Result{Links: make([]ResultLink, maxResultLinks+1)},
+			expectError: true,
+		},
+		{
+			name:        "absolute link",
+			result:      Result{Links: []ResultLink{{Label: "Report", File: "/oplet/outputs/report.pdf"}}},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.result.Validate()
+
+			if tc.expectError && err == nil {
+				t.Errorf("expected an error, got nil")
+			}
+
+			if !tc.expectError && err != nil {
+				t.Errorf("unexpected error: %+v", err)
+			}
+		})
+	}
+}
+
+func TestIsValidOutputPath(t *testing.T) {
+	type testCase struct {
+		path     string
+		expected bool
+	}
+
+	testCases := []testCase{
+		{path: "report.pdf", expected: true},
+		{path: "reports/2024/summary.pdf", expected: true},
+		{path: ".oplet/result.json", expected: true},
+		{path: "..report.pdf", expected: true},
+		{path: "", expected: false},
+		{path: ".", expected: false},
+		{path: "..", expected: false},
+		{path: "../report.pdf", expected: false},
+		{path: "reports/../../report.pdf", expected: false},
+		{path: "reports/../report.pdf", expected: false},
+		{path: "./report.pdf", expected: false},
+		{path: "reports//report.pdf", expected: false},
+		{path: "reports/", expected: false},
+		{path: "/etc/passwd", expected: false},
+		{path: "reports\\report.pdf", expected: false},
+		{path: "..\\report.pdf", expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			if e, g := tc.expected, IsValidOutputPath(tc.path); e != g {
+				t.Errorf("IsValidOutputPath(%q): expected %v, got %v", tc.path, e, g)
+			}
+		})
+	}
+}
@@ -0,0 +1,289 @@
+package task
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// HardenedSecurityProfile is the profile selected by the hardened preset:
+// read-only root filesystem, no capabilities, no privilege escalation, an
+// unprivileged user and a limited number of processes. Runners apply no
+// profile unless configured to.
+const HardenedSecurityProfile = "read-only-rootfs,drop-capabilities,no-new-privileges,user=65534:65534,pids-limit=256"
+
+// Keys of the security profiles and relaxations
+const (
+	securityReadOnlyRootfs   = "read-only-rootfs"
+	securityDropCapabilities = "drop-capabilities"
+	securityNoNewPrivileges  = "no-new-privileges"
+	securityUser             = "user"
+	securityPidsLimit        = "pids-limit"
+	securitySeccomp          = "seccomp"
+	securityCapability       = "cap"
+	securityWritableRootfs   = "writable-rootfs"
+	securityImageUser        = "image-user"
+	securityHardened         = "hardened"
+)
+
+// SecurityProfile describes the isolation applied to the container of an execution
+type SecurityProfile struct {
+	// Mount the root filesystem read-only, with a tmpfs on /tmp
+	ReadOnlyRootfs bool
+	// Drop all the capabilities, except the added ones
+	DropCapabilities bool
+	Capabilities     []string
+	// Prevent the processes from gaining new privileges
+	NoNewPrivileges bool
+	// User and group the container runs as, ex: 65534:65534. Empty
+	// for the user of the image
+	User string
+	// Maximum number of processes, zero for none
+	PidsLimit int64
+	// Path of a custom seccomp profile, empty for the default one
+	SeccompProfile string
+}
+
+// SecurityRelaxations describes the parts of the security profile a task
+// asks to relax. They are applied only once approved by an administrator.
+type SecurityRelaxations struct {
+	// Mount the root filesystem read-write
+	WritableRootfs bool
+	// Run as the user of the image instead of the one forced by the runner
+	ImageUser bool
+	// Capabilities added back to the container
+	Capabilities []string
+}
+
+var (
+	capabilityPattern = regexp.MustCompile(`^[A-Z][A-Z_]*$`)
+	userPattern       = regexp.MustCompile(`^[0-9]+(:[0-9]+)?$`)
+)
+
+// ParseCapability normalizes a capability name, ex: net_bind_service, CAP_CHOWN
+func ParseCapability(raw string) (string, error) {
+	capability := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(raw)), "CAP_")
+
+	if !capabilityPattern.MatchString(capability) {
+		return "", errors.Errorf("invalid capability '%s'", raw)
+	}
+
+	return capability, nil
+}
+
+// ParseCapabilities parses a comma separated list of capabilities
+func ParseCapabilities(raw string) ([]string, error) {
+	capabilities := make([]string, 0)
+
+	for _, field := range strings.Split(raw, ",") {
+		if strings.TrimSpace(field) == "" {
+			continue
+		}
+
+		capability, err := ParseCapability(field)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		capabilities = append(capabilities, capability)
+	}
+
+	slices.Sort(capabilities)
+
+	return slices.Compact(capabilities), nil
+}
+
+// ParseSecurityProfile parses a comma separated security profile,
+// ex: read-only-rootfs,drop-capabilities,cap=NET_BIND_SERVICE,user=1000:1000.
+// The hardened option expands to HardenedSecurityProfile, the options
+// following it overriding its own, ex: hardened,pids-limit=1024
+func ParseSecurityProfile(raw string) (SecurityProfile, error) {
+	var profile SecurityProfile
+
+	fields := make([]string, 0)
+	for _, field := range strings.Split(raw, ",") {
+		if strings.TrimSpace(field) == securityHardened {
+			fields = append(fields, strings.Split(HardenedSecurityProfile, ",")...)
+			continue
+		}
+
+		fields = append(fields, field)
+	}
+
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		key, value, _ := strings.Cut(field, "=")
+
+		switch key {
+		case securityReadOnlyRootfs:
+			profile.ReadOnlyRootfs = true
+		case securityDropCapabilities:
+			profile.DropCapabilities = true
+		case securityNoNewPrivileges:
+			profile.NoNewPrivileges = true
+		case securityCapability:
+			capability, err := ParseCapability(value)
+			if err != nil {
+				return profile, errors.WithStack(err)
+			}
+
+			profile.Capabilities = append(profile.Capabilities, capability)
+		case securityUser:
+			if !userPattern.MatchString(value) {
+				return profile, errors.Errorf("invalid user '%s', must be a numeric uid[:gid]", value)
+			}
+
+			profile.User = value
+		case securityPidsLimit:
+			limit, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || limit < 0 {
+				return profile, errors.Errorf("invalid pids limit '%s', must be a positive integer", value)
+			}
+
+			profile.PidsLimit = limit
+		case securitySeccomp:
+			if value == "" {
+				return profile, errors.New("seccomp profile path can not be empty")
+			}
+
+			profile.SeccompProfile = value
+		default:
+			return profile, errors.Errorf("unknown security profile option '%s'", key)
+		}
+	}
+
+	slices.Sort(profile.Capabilities)
+	profile.Capabilities = slices.Compact(profile.Capabilities)
+
+	return profile, nil
+}
+
+// String formats the profile as parsed by ParseSecurityProfile
+func (p SecurityProfile) String() string {
+	fields := make([]string, 0)
+
+	if p.ReadOnlyRootfs {
+		fields = append(fields, securityReadOnlyRootfs)
+	}
+
+	if p.DropCapabilities {
+		fields = append(fields, securityDropCapabilities)
+	}
+
+	for _, capability := range p.Capabilities {
+		fields = append(fields, securityCapability+"="+capability)
+	}
+
+	if p.NoNewPrivileges {
+		fields = append(fields, securityNoNewPrivileges)
+	}
+
+	if p.User != "" {
+		fields = append(fields, securityUser+"="+p.User)
+	}
+
+	if p.PidsLimit > 0 {
+		fields = append(fields, securityPidsLimit+"="+strconv.FormatInt(p.PidsLimit, 10))
+	}
+
+	if p.SeccompProfile != "" {
+		fields = append(fields, securitySeccomp+"="+p.SeccompProfile)
+	}
+
+	return strings.Join(fields, ",")
+}
+
+// Relax returns a copy of the profile with the given relaxations applied
+func (p SecurityProfile) Relax(relaxations SecurityRelaxations) SecurityProfile {
+	if relaxations.WritableRootfs {
+		p.ReadOnlyRootfs = false
+	}
+
+	if relaxations.ImageUser {
+		p.User = ""
+	}
+
+	capabilities := append(slices.Clone(p.Capabilities), relaxations.Capabilities...)
+	slices.Sort(capabilities)
+	p.Capabilities = slices.Compact(capabilities)
+
+	return p
+}
+
+// ParseSecurityRelaxations parses a comma separated list of relaxations,
+// ex: writable-rootfs,image-user,cap=NET_BIND_SERVICE
+func ParseSecurityRelaxations(raw string) (SecurityRelaxations, error) {
+	var relaxations SecurityRelaxations
+
+	for _, field := range strings.Split(raw, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		key, value, _ := strings.Cut(field, "=")
+
+		switch key {
+		case securityWritableRootfs:
+			relaxations.WritableRootfs = true
+		case securityImageUser:
+			relaxations.ImageUser = true
+		case securityCapability:
+			capability, err := ParseCapability(value)
+			if err != nil {
+				return relaxations, errors.WithStack(err)
+			}
+
+			relaxations.Capabilities = append(relaxations.Capabilities, capability)
+		default:
+			return relaxations, errors.Errorf("unknown security relaxation '%s'", key)
+		}
+	}
+
+	slices.Sort(relaxations.Capabilities)
+	relaxations.Capabilities = slices.Compact(relaxations.Capabilities)
+
+	return relaxations, nil
+}
+
+// String formats the relaxations as parsed by ParseSecurityRelaxations
+func (r SecurityRelaxations) String() string {
+	fields := make([]string, 0)
+
+	if r.WritableRootfs {
+		fields = append(fields, securityWritableRootfs)
+	}
+
+	if r.ImageUser {
+		fields = append(fields, securityImageUser)
+	}
+
+	for _, capability := range r.Capabilities {
+		fields = append(fields, securityCapability+"="+capability)
+	}
+
+	return strings.Join(fields, ",")
+}
+
+// Intersect returns the relaxations present in both r and other
+func (r SecurityRelaxations) Intersect(other SecurityRelaxations) SecurityRelaxations {
+	intersection := SecurityRelaxations{
+		WritableRootfs: r.WritableRootfs && other.WritableRootfs,
+		ImageUser:      r.ImageUser && other.ImageUser,
+	}
+
+	for _, capability := range r.Capabilities {
+		if slices.Contains(other.Capabilities, capability) {
+			intersection.Capabilities = append(intersection.Capabilities, capability)
+		}
+	}
+
+	return intersection
+}
@@ -0,0 +1,294 @@
+package task
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseSecurityProfile(t *testing.T) {
+	type testCase struct {
+		name        string
+		raw         string
+		expected    SecurityProfile
+		expectError bool
+	}
+
+	testCases := []testCase{
+		{
+			name:     "empty profile",
+			raw:      "",
+			expected: SecurityProfile{},
+		},
+		{
+			name: "all the options",
+			raw:  "read-only-rootfs, drop-capabilities,no-new-privileges,cap=net_bind_service,cap=CAP_CHOWN,user=1000:1000,pids-limit=64,seccomp=/etc/seccomp.json",
+			expected: SecurityProfile{
+				ReadOnlyRootfs:   true,
+				DropCapabilities: true,
+				Capabilities:     []string{"CHOWN", "NET_BIND_SERVICE"},
+				NoNewPrivileges:  true,
+				User:             "1000:1000",
+				PidsLimit:        64,
+				SeccompProfile:   "/etc/seccomp.json",
+			},
+		},
+		{
+			name: "hardened preset",
+			raw:  "hardened",
+			expected: SecurityProfile{
+				ReadOnlyRootfs:   true,
+				DropCapabilities: true,
+				NoNewPrivileges:  true,
+				User:             "65534:65534",
+				PidsLimit:        256,
+			},
+		},
+		{
+			name: "hardened preset overridden",
+			raw:  "hardened,pids-limit=1024,cap=NET_RAW",
+			expected: SecurityProfile{
+				ReadOnlyRootfs:   true,
+				DropCapabilities: true,
+				Capabilities:     []string{"NET_RAW"},
+				NoNewPrivileges:  true,
+				User:             "65534:65534",
+				PidsLimit:        1024,
+			},
+		},
+		{
+			name:     "duplicated capabilities",
+			raw:      "cap=CHOWN,cap=chown",
+			expected: SecurityProfile{Capabilities: []string{"CHOWN"}},
+		},
+		{
+			name:        "unknown option",
+			raw:         "privileged",
+			expectError: true,
+		},
+		{
+			name:        "invalid capability",
+			raw:         "cap=net-admin",
+			expectError: true,
+		},
+		{
+			name:        "user name",
+			raw:         "user=nobody",
+			expectError: true,
+		},
+		{
+			name:        "negative pids limit",
+			raw:         "pids-limit=-1",
+			expectError: true,
+		},
+		{
+			name:        "empty seccomp profile",
+			raw:         "seccomp=",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			profile, err := ParseSecurityProfile(tc.raw)
+
+			if tc.expectError {
+				if err == nil {
+					t.Errorf("expected an error, got nil")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("expected no error, got '%v'", err)
+			}
+
+			if e, g := tc.expected.String(), profile.String(); e != g {
+				t.Errorf("profile: expected '%s', got '%s'", e, g)
+			}
+
+			// The formatted profile is parsed back to the same profile
+			reparsed, err := ParseSecurityProfile(profile.String())
+			if err != nil {
+				t.Fatalf("expected no error, got '%v'", err)
+			}
+
+			if e, g := profile.String(), reparsed.String(); e != g {
+				t.Errorf("reparsed profile: expected '%s', got '%s'", e, g)
+			}
+		})
+	}
+}
+
+func TestSecurityProfileRelax(t *testing.T) {
+	profile := SecurityProfile{
+		ReadOnlyRootfs:   true,
+		DropCapabilities: true,
+		Capabilities:     []string{"NET_RAW"},
+		NoNewPrivileges:  true,
+		User:             "65534:65534",
+		PidsLimit:        256,
+	}
+
+	type testCase struct {
+		name        string
+		relaxations SecurityRelaxations
+		expected    SecurityProfile
+	}
+
+	testCases := []testCase{
+		{
+			name:     "no relaxation",
+			expected: profile,
+		},
+		{
+			name:        "writable root filesystem",
+			relaxations: SecurityRelaxations{WritableRootfs: true},
+			expected: SecurityProfile{
+				DropCapabilities: true,
+				Capabilities:     []string{"NET_RAW"},
+				NoNewPrivileges:  true,
+				User:             "65534:65534",
+				PidsLimit:        256,
+			},
+		},
+		{
+			name:        "user of the image",
+			relaxations: SecurityRelaxations{ImageUser: true},
+			expected: SecurityProfile{
+				ReadOnlyRootfs:   true,
+				DropCapabilities: true,
+				Capabilities:     []string{"NET_RAW"},
+				NoNewPrivileges:  true,
+				PidsLimit:        256,
+			},
+		},
+		{
+			name:        "capabilities added back",
+			relaxations: SecurityRelaxations{Capabilities: []string{"NET_RAW", "CHOWN"}},
+			expected: SecurityProfile{
+				ReadOnlyRootfs:   true,
+				DropCapabilities: true,
+				Capabilities:     []string{"CHOWN", "NET_RAW"},
+				NoNewPrivileges:  true,
+				User:             "65534:65534",
+				PidsLimit:        256,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			relaxed := profile.Relax(tc.relaxations)
+
+			if e, g := tc.expected.String(), relaxed.String(); e != g {
+				t.Errorf("relaxed profile: expected '%s', got '%s'", e, g)
+			}
+		})
+	}
+
+	if e, g := []string{"NET_RAW"}, profile.Capabilities; !slices.Equal(e, g) {
+		t.Errorf("expected the relaxed profile to be a copy, got capabilities %v", g)
+	}
+}
+
+func TestParseSecurityRelaxations(t *testing.T) {
+	type testCase struct {
+		name        string
+		raw         string
+		expected    SecurityRelaxations
+		expectError bool
+	}
+
+	testCases := []testCase{
+		{
+			name:     "no relaxation",
+			raw:      "",
+			expected: SecurityRelaxations{},
+		},
+		{
+			name: "all the relaxations",
+			raw:  "writable-rootfs, image-user,cap=net_bind_service,cap=CAP_CHOWN,cap=CHOWN",
+			expected: SecurityRelaxations{
+				WritableRootfs: true,
+				ImageUser:      true,
+				Capabilities:   []string{"CHOWN", "NET_BIND_SERVICE"},
+			},
+		},
+		{
+			name:        "profile option",
+			raw:         "read-only-rootfs",
+			expectError: true,
+		},
+		{
+			name:        "invalid capability",
+			raw:         "cap=",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			relaxations, err := ParseSecurityRelaxations(tc.raw)
+
+			if tc.expectError {
+				if err == nil {
+					t.Errorf("expected an error, got nil")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("expected no error, got '%v'", err)
+			}
+
+			if e, g := tc.expected.String(), relaxations.String(); e != g {
+				t.Errorf("relaxations: expected '%s', got '%s'", e, g)
+			}
+		})
+	}
+}
+
+func TestSecurityRelaxationsIntersect(t *testing.T) {
+	type testCase struct {
+		name      string
+		requested SecurityRelaxations
+		approved  SecurityRelaxations
+		expected  SecurityRelaxations
+	}
+
+	testCases := []testCase{
+		{
+			name:      "nothing approved",
+			requested: SecurityRelaxations{WritableRootfs: true, ImageUser: true, Capabilities: []string{"CHOWN"}},
+			expected:  SecurityRelaxations{},
+		},
+		{
+			name:      "everything approved",
+			requested: SecurityRelaxations{WritableRootfs: true, ImageUser: true, Capabilities: []string{"CHOWN"}},
+			approved:  SecurityRelaxations{WritableRootfs: true, ImageUser: true, Capabilities: []string{"CHOWN"}},
+			expected:  SecurityRelaxations{WritableRootfs: true, ImageUser: true, Capabilities: []string{"CHOWN"}},
+		},
+		{
+			name:      "part of the capabilities approved",
+			requested: SecurityRelaxations{Capabilities: []string{"CHOWN", "NET_RAW"}},
+			approved:  SecurityRelaxations{Capabilities: []string{"NET_ADMIN", "NET_RAW"}},
+			expected:  SecurityRelaxations{Capabilities: []string{"NET_RAW"}},
+		},
+		{
+			name:      "approval not requested",
+			requested: SecurityRelaxations{ImageUser: true},
+			approved:  SecurityRelaxations{WritableRootfs: true, ImageUser: true},
+			expected:  SecurityRelaxations{ImageUser: true},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			intersection := tc.requested.Intersect(tc.approved)
+
+			if e, g := tc.expected.String(), intersection.String(); e != g {
+				t.Errorf("intersection: expected '%s', got '%s'", e, g)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package task
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// ParseTags parses a list of tags separated by commas or spaces,
+// ex: "arch=arm64, zone=dmz docker".
+// The returned tags are sorted and deduplicated.
+func ParseTags(raw string) []string {
+	fields := strings.FieldsFunc(raw, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+
+	tags := make([]string, 0, len(fields))
+	for _, f := range fields {
+		tags = append(tags, strings.ToLower(f))
+	}
+
+	slices.Sort(tags)
+
+	return slices.Compact(tags)
+}
+
+// FormatTags formats a list of tags as a comma separated string
+func FormatTags(tags []string) string {
+	return strings.Join(tags, ",")
+}
+
+// MatchTags returns true if all the required tags are in the available ones
+func MatchTags(required []string, available []string) bool {
+	for _, t := range required {
+		if !slices.Contains(available, t) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -60,7 +60,7 @@ func testCaptureLogs(t *testing.T, executor task.Executor) error {
 
 				go func() {
 					for e := range logs {
-						t.Logf("[container] #%d %s", e.Clock, e.Message)
+						t.Logf("[container] [%s] #%d %s", e.Stream, e.Clock, e.Message)
 					}
 				}()
 			case task.ExecutionStateKilled:
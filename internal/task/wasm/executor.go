@@ -0,0 +1,457 @@
+package wasm
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/xid"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/experimental"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+
+	"github.com/bornholm/oplet/internal/slogx"
+	"github.com/bornholm/oplet/internal/task"
+	"github.com/bornholm/oplet/internal/task/logbuffer"
+	"github.com/bornholm/oplet/internal/task/oci"
+	"github.com/bornholm/oplet/internal/task/workdir"
+)
+
+const (
+	pageSize = 64 << 10
+	maxPages = 1 << 16
+)
+
+// WasmExecutor implements task.Executor by running WASI modules, pulled from
+// OCI registries, with an embedded runtime. The inputs and outputs directories
+// are mounted in the module as preopened directories.
+//
+// The memory and fuel constraints are enforced, the CPU constraint, networks
+// and security profiles are not applicable to the sandboxed modules.
+type WasmExecutor struct {
+	logger  *slog.Logger
+	fetcher ModuleFetcher
+	cache   wazero.CompilationCache
+
+	mutex sync.Mutex
+	runs  map[string]*run
+}
+
+// run holds the log buffer and the working directory of a running execution
+type run struct {
+	logs    *logbuffer.Buffer
+	workDir *workdir.Dir
+}
+
+// NewExecutor creates a new WASM executor
+func NewExecutor(logger *slog.Logger, funcs ...OptionFunc) (*WasmExecutor, error) {
+	opts, err := NewOptions(funcs...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	fetcher := opts.Fetcher
+	if fetcher == nil {
+		fetcher = oci.NewRegistryClientWithLogger(logger)
+	}
+
+	cache := wazero.NewCompilationCache()
+	if opts.CacheDir != "" {
+		cache, err = wazero.NewCompilationCacheWithDir(opts.CacheDir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not create compilation cache in '%s'", opts.CacheDir)
+		}
+	}
+
+	return &WasmExecutor{
+		logger:  logger.With("component", "wasm-executor"),
+		fetcher: fetcher,
+		cache:   cache,
+		runs:    make(map[string]*run),
+	}, nil
+}
+
+// Execute implements task.Executor.Execute
+func (e *WasmExecutor) Execute(ctx context.Context, req task.ExecutionRequest) error {
+	runID := xid.New().String()
+
+	e.logger.Info("starting wasm execution", "image", req.ImageRef)
+
+	onChange := req.OnChange
+	if onChange == nil {
+		onChange = func(e task.Execution) {}
+	}
+
+	go func() {
+		execution := task.Execution{
+			State: task.ExecutionStateProcessingRequest,
+		}
+
+		onChange(execution)
+
+		// Pull module
+		execution.State = task.ExecutionStatePullingImage
+		onChange(execution)
+
+		binary, err := e.fetcher.FetchWasmModule(ctx, req.ImageRef)
+		if err != nil {
+			execution.State = task.ExecutionStateFailed
+			execution.Error = &task.ExecutionError{
+				Type:    task.ErrorTypeImagePullFailed,
+				Message: fmt.Sprintf("failed to pull module %s", req.ImageRef),
+				Cause:   errors.WithStack(err),
+			}
+			onChange(execution)
+			return
+		}
+
+		execution.State = task.ExecutionStateImagePulled
+		onChange(execution)
+
+		// Compile module
+		execution.State = task.ExecutionStateCreatingContainer
+		onChange(execution)
+
+		// Canceled with errFuelExhausted once the module exhausted its fuel
+		fuelCtx, cancelFuel := context.WithCancelCause(ctx)
+		defer cancelFuel(nil)
+
+		runtime := wazero.NewRuntimeWithConfig(ctx, e.createRuntimeConfig(req.Constraints))
+		defer func() {
+			if err := runtime.Close(context.WithoutCancel(ctx)); err != nil {
+				e.logger.Warn("failed to close wasm runtime", "run_id", runID, "error", err)
+			}
+		}()
+
+		var fuel *fuelListener
+
+		compileCtx := ctx
+		if req.Constraints.MaxFuel > 0 {
+			fuel = newFuelListener(req.Constraints.MaxFuel, cancelFuel)
+			compileCtx = experimental.WithFunctionListenerFactory(ctx, fuel)
+		}
+
+		if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+			execution.State = task.ExecutionStateFailed
+			execution.Error = &task.ExecutionError{
+				Type:    task.ErrorTypeWasmError,
+				Message: "failed to instantiate wasi",
+				Cause:   errors.WithStack(err),
+			}
+			onChange(execution)
+			return
+		}
+
+		compiled, err := runtime.CompileModule(compileCtx, binary)
+		if err != nil {
+			execution.State = task.ExecutionStateFailed
+			execution.Error = &task.ExecutionError{
+				Type:    task.ErrorTypeWasmError,
+				Message: "failed to compile module",
+				Cause:   errors.WithStack(err),
+			}
+			onChange(execution)
+			return
+		}
+
+		workDir, err := workdir.New("oplet-wasm-" + runID + "-")
+		if err != nil {
+			execution.State = task.ExecutionStateFailed
+			execution.Error = &task.ExecutionError{
+				Type:    task.ErrorTypeWasmError,
+				Message: "failed to create working directory",
+				Cause:   errors.WithStack(err),
+			}
+			onChange(execution)
+			return
+		}
+
+		logs := logbuffer.New()
+
+		e.mutex.Lock()
+		e.runs[runID] = &run{logs: logs, workDir: workDir}
+		e.mutex.Unlock()
+
+		// Ensure cleanup
+		defer func() {
+			logs.Close()
+
+			e.mutex.Lock()
+			delete(e.runs, runID)
+			e.mutex.Unlock()
+
+			if err := workDir.Remove(); err != nil {
+				e.logger.Warn("failed to cleanup working directory", "run_id", runID, "work_dir", workDir.Path(), "error", err)
+			}
+		}()
+
+		execution.ContainerID = runID
+		execution.State = task.ExecutionStateContainerCreated
+		onChange(execution)
+
+		// Copy input files
+		if len(req.Inputs) > 0 {
+			execution.State = task.ExecutionStateUploadingFiles
+			onChange(execution)
+
+			if err := workDir.WriteInputs(req.Inputs); err != nil {
+				execution.State = task.ExecutionStateFailed
+				execution.Error = &task.ExecutionError{
+					Type:        task.ErrorTypeFileUploadFailed,
+					Message:     "failed to copy input files",
+					ContainerID: runID,
+					Cause:       errors.WithStack(err),
+				}
+				onChange(execution)
+				return
+			}
+
+			execution.State = task.ExecutionStateFilesUploaded
+			onChange(execution)
+		}
+
+		stdout, closeStdout := logs.Writer(task.LogStreamStdout)
+		stderr, closeStderr := logs.Writer(task.LogStreamStderr)
+
+		moduleConfig := e.createModuleConfig(runID, workDir, req).
+			WithStdout(stdout).
+			WithStderr(stderr)
+
+		execution.StartedAt = time.Now()
+		execution.State = task.ExecutionStateStartingContainer
+		onChange(execution)
+
+		// Set timeout if specified, counted from the module start
+		runCtx := fuelCtx
+		if req.Timeout > 0 {
+			var cancel context.CancelFunc
+			runCtx, cancel = context.WithTimeout(fuelCtx, req.Timeout)
+			defer cancel()
+		}
+
+		execution.State = task.ExecutionStateContainerStarted
+		onChange(execution)
+
+		// Run the module, its start function being called on instantiation
+		_, runErr := runtime.InstantiateModule(runCtx, compiled, moduleConfig)
+
+		for _, closeOutput := range []func() error{closeStdout, closeStderr} {
+			if err := closeOutput(); err != nil {
+				e.logger.Error("could not scan for next log line", slogx.Error(err))
+			}
+		}
+
+		execution.FinishedAt = time.Now()
+
+		if runCtx.Err() != nil {
+			switch {
+			case errors.Is(context.Cause(runCtx), errFuelExhausted):
+				execution.State = task.ExecutionStateFailed
+				execution.Error = &task.ExecutionError{
+					Type:        task.ErrorTypeFuelExhausted,
+					Message:     fmt.Sprintf("execution exhausted its fuel of %d", req.Constraints.MaxFuel),
+					ContainerID: runID,
+					Cause:       errors.WithStack(errFuelExhausted),
+				}
+
+			case ctx.Err() == nil && errors.Is(runCtx.Err(), context.DeadlineExceeded):
+				execution.State = task.ExecutionStateTimedOut
+				execution.Error = &task.ExecutionError{
+					Type:        task.ErrorTypeTimeout,
+					Message:     fmt.Sprintf("execution exceeded its timeout of %s", req.Timeout),
+					ContainerID: runID,
+					Cause:       errors.WithStack(runCtx.Err()),
+				}
+
+			default:
+				execution.State = task.ExecutionStateKilled
+				execution.Error = &task.ExecutionError{
+					Type:        task.ErrorTypeWasmError,
+					Message:     "module killed",
+					ContainerID: runID,
+					Cause:       errors.WithStack(runCtx.Err()),
+				}
+			}
+
+			onChange(execution)
+			return
+		}
+
+		var exitErr *sys.ExitError
+		if runErr != nil && !errors.As(runErr, &exitErr) {
+			execution.State = task.ExecutionStateFailed
+			execution.Error = &task.ExecutionError{
+				Type:        task.ErrorTypeWasmError,
+				Message:     "module trapped",
+				ContainerID: runID,
+				Cause:       errors.WithStack(runErr),
+			}
+			onChange(execution)
+			return
+		}
+
+		if exitErr != nil {
+			execution.ExitCode = int(exitErr.ExitCode())
+		}
+
+		execution.State = task.ExecutionStateContainerFinished
+		onChange(execution)
+
+		failed := execution.ExitCode != 0
+
+		if !failed {
+			execution.State = task.ExecutionStateDownloadingFiles
+			onChange(execution)
+
+			outputs, close := workDir.ArchiveOutputs()
+			defer func() {
+				if err := close(); err != nil {
+					e.logger.ErrorContext(ctx, "could not close outputs archive", slogx.Error(err))
+				}
+			}()
+
+			execution.Outputs = outputs
+			execution.State = task.ExecutionStateFilesDownloaded
+			onChange(execution)
+		}
+
+		attrs := []any{
+			"run_id", runID,
+			"exit_code", execution.ExitCode,
+			"duration", execution.FinishedAt.Sub(execution.StartedAt),
+		}
+
+		if fuel != nil {
+			attrs = append(attrs, "fuel", fuel.Consumed())
+		}
+
+		e.logger.Info("wasm execution completed", attrs...)
+
+		if failed {
+			execution.State = task.ExecutionStateFailed
+		} else {
+			execution.State = task.ExecutionStateSucceeded
+		}
+
+		onChange(execution)
+	}()
+
+	return nil
+}
+
+// createRuntimeConfig prepares the runtime of an execution, its memory
+// being limited by the constraints
+func (e *WasmExecutor) createRuntimeConfig(constraints task.Constraints) wazero.RuntimeConfig {
+	config := wazero.NewRuntimeConfig().
+		WithCompilationCache(e.cache).
+		WithCloseOnContextDone(true)
+
+	if constraints.MaxMemory > 0 {
+		pages := min(max(constraints.MaxMemory/pageSize, 1), maxPages)
+		config = config.WithMemoryLimitPages(uint32(pages))
+	}
+
+	return config
+}
+
+// createModuleConfig prepares the module of an execution, with its
+// environment and the preopened base directory holding the inputs and
+// outputs directories and the progress file
+func (e *WasmExecutor) createModuleConfig(runID string, workDir *workdir.Dir, req task.ExecutionRequest) wazero.ModuleConfig {
+	fsConfig := wazero.NewFSConfig().
+		WithDirMount(workDir.Path(), task.BaseDir)
+
+	config := wazero.NewModuleConfig().
+		WithName(runID).
+		WithArgs(req.ImageRef).
+		WithFSConfig(fsConfig).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithSysNanosleep().
+		WithRandSource(rand.Reader)
+
+	for key, value := range req.Environment {
+		config = config.WithEnv(key, value)
+	}
+
+	return config.WithEnv("OPLET_RUN_ID", runID)
+}
+
+// PullImage implements task.ImagePuller.PullImage by fetching and compiling
+// the module, the compiled module being kept by the compilation cache
+func (e *WasmExecutor) PullImage(ctx context.Context, imageRef string) error {
+	binary, err := e.fetcher.FetchWasmModule(ctx, imageRef)
+	if err != nil {
+		return errors.Wrapf(err, "failed to pull module %s", imageRef)
+	}
+
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCompilationCache(e.cache))
+	defer func() {
+		if err := runtime.Close(context.WithoutCancel(ctx)); err != nil {
+			e.logger.Warn("failed to close wasm runtime", "error", err)
+		}
+	}()
+
+	if _, err := runtime.CompileModule(ctx, binary); err != nil {
+		return errors.Wrapf(err, "failed to compile module %s", imageRef)
+	}
+
+	e.logger.Info("module pulled successfully", "image", imageRef)
+
+	return nil
+}
+
+// GetLogs implements task.Executor.GetLogs
+func (e *WasmExecutor) GetLogs(ctx context.Context, runID string) (chan task.LogEntry, error) {
+	e.mutex.Lock()
+	run, exists := e.runs[runID]
+	e.mutex.Unlock()
+
+	if !exists {
+		return nil, &task.ExecutionError{
+			Type:        task.ErrorTypeWasmError,
+			Message:     "failed to get module logs",
+			ContainerID: runID,
+			Cause:       errors.WithStack(task.ErrContainerNotFound),
+		}
+	}
+
+	return run.logs.Follow(ctx), nil
+}
+
+// ReadProgress implements task.ProgressReader
+func (e *WasmExecutor) ReadProgress(ctx context.Context, runID string) (string, error) {
+	e.mutex.Lock()
+	run, exists := e.runs[runID]
+	e.mutex.Unlock()
+
+	if !exists {
+		return "", &task.ExecutionError{
+			Type:        task.ErrorTypeWasmError,
+			Message:     "failed to read module progress",
+			ContainerID: runID,
+			Cause:       errors.WithStack(task.ErrContainerNotFound),
+		}
+	}
+
+	progress, err := run.workDir.ReadProgress()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return progress, nil
+}
+
+// Ensure WasmExecutor implements task.Executor interface
+var _ task.Executor = &WasmExecutor{}
+
+// Ensure WasmExecutor implements task.ProgressReader interface
+var _ task.ProgressReader = &WasmExecutor{}
+
+// Ensure WasmExecutor implements task.ImagePuller interface
+var _ task.ImagePuller = &WasmExecutor{}
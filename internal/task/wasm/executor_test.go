@@ -0,0 +1,95 @@
+package wasm
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/bornholm/oplet/internal/slogx"
+	"github.com/bornholm/oplet/internal/task"
+	"github.com/bornholm/oplet/internal/task/testsuite"
+)
+
+func TestExecutor(t *testing.T) {
+	logger := slogx.NewTestLogger(t)
+
+	executor, err := NewExecutor(logger, WithFetcher(buildTestModule(t)))
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	testsuite.RunExecutorTestSuite(t, executor)
+}
+
+func TestExecutorFuel(t *testing.T) {
+	logger := slogx.NewTestLogger(t)
+
+	executor, err := NewExecutor(logger, WithFetcher(buildTestModule(t)))
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	done := make(chan task.Execution, 1)
+
+	err = executor.Execute(ctx, task.ExecutionRequest{
+		ImageRef:    "hello.wasm",
+		Constraints: task.Constraints{MaxFuel: 1000},
+		OnChange: func(e task.Execution) {
+			switch e.State {
+			case task.ExecutionStateSucceeded, task.ExecutionStateFailed, task.ExecutionStateKilled, task.ExecutionStateTimedOut:
+				done <- e
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	execution := <-done
+
+	var executionErr *task.ExecutionError
+	if !errors.As(execution.Error, &executionErr) || executionErr.Type != task.ErrorTypeFuelExhausted {
+		t.Errorf("execution: expected error of type '%s', got '%v'", task.ErrorTypeFuelExhausted, execution.Error)
+	}
+}
+
+type staticFetcher []byte
+
+// FetchWasmModule implements ModuleFetcher
+func (f staticFetcher) FetchWasmModule(ctx context.Context, imageRef string) ([]byte, error) {
+	return f, nil
+}
+
+// buildTestModule compiles the WASI test task with the go toolchain
+func buildTestModule(t *testing.T) staticFetcher {
+	t.Helper()
+
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go toolchain not available")
+	}
+
+	path := filepath.Join(t.TempDir(), "hello.wasm")
+
+	cmd := exec.Command(goBin, "build", "-o", path, "./testdata/hello")
+	cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("could not build test module: %s\n%s", err, output)
+	}
+
+	module, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%+v", errors.WithStack(err))
+	}
+
+	return staticFetcher(module)
+}
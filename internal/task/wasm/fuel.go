@@ -0,0 +1,56 @@
+package wasm
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/experimental"
+)
+
+var errFuelExhausted = errors.New("fuel exhausted")
+
+// fuelListener consumes a unit of fuel on each function call of a module and
+// cancels its execution once the fuel is exhausted
+type fuelListener struct {
+	max      uint64
+	consumed atomic.Uint64
+	cancel   context.CancelCauseFunc
+}
+
+func newFuelListener(max uint64, cancel context.CancelCauseFunc) *fuelListener {
+	return &fuelListener{
+		max:    max,
+		cancel: cancel,
+	}
+}
+
+// NewFunctionListener implements experimental.FunctionListenerFactory
+func (l *fuelListener) NewFunctionListener(api.FunctionDefinition) experimental.FunctionListener {
+	return l
+}
+
+// Before implements experimental.FunctionListener
+func (l *fuelListener) Before(context.Context, api.Module, api.FunctionDefinition, []uint64, experimental.StackIterator) {
+	if l.consumed.Add(1) > l.max {
+		// The module is closed on its next context check
+		l.cancel(errFuelExhausted)
+	}
+}
+
+// After implements experimental.FunctionListener
+func (l *fuelListener) After(context.Context, api.Module, api.FunctionDefinition, []uint64) {}
+
+// Abort implements experimental.FunctionListener
+func (l *fuelListener) Abort(context.Context, api.Module, api.FunctionDefinition, error) {}
+
+// Consumed returns the fuel consumed so far
+func (l *fuelListener) Consumed() uint64 {
+	return l.consumed.Load()
+}
+
+var (
+	_ experimental.FunctionListenerFactory = &fuelListener{}
+	_ experimental.FunctionListener        = &fuelListener{}
+)
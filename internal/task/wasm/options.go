@@ -0,0 +1,48 @@
+package wasm
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// ModuleFetcher retrieves the binary of the WASM module referenced by an execution
+type ModuleFetcher interface {
+	FetchWasmModule(ctx context.Context, imageRef string) ([]byte, error)
+}
+
+type Options struct {
+	// Fetcher of the WASM modules, the OCI registries if nil
+	Fetcher ModuleFetcher
+	// Directory keeping the compiled modules across restarts,
+	// in memory only if empty
+	CacheDir string
+}
+
+type OptionFunc func(opts *Options) error
+
+func NewOptions(funcs ...OptionFunc) (*Options, error) {
+	opts := &Options{}
+
+	for _, fn := range funcs {
+		if err := fn(opts); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	return opts, nil
+}
+
+func WithFetcher(fetcher ModuleFetcher) OptionFunc {
+	return func(opts *Options) error {
+		opts.Fetcher = fetcher
+		return nil
+	}
+}
+
+func WithCacheDir(dir string) OptionFunc {
+	return func(opts *Options) error {
+		opts.CacheDir = dir
+		return nil
+	}
+}
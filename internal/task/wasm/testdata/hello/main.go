@@ -0,0 +1,29 @@
+// Command hello is a WASI task used by the tests of the wasm executor
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+func main() {
+	fmt.Printf("text_env=%s\n", os.Getenv("text_env"))
+
+	entries, err := os.ReadDir("/oplet/inputs")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	for _, e := range entries {
+		fmt.Println(e.Name())
+	}
+
+	if err := os.WriteFile(filepath.Join("/oplet/outputs", "hello-world.txt"), []byte("hello world\n"), 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Println("hello world")
+}
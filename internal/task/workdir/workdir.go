@@ -0,0 +1,215 @@
+package workdir
+
+import (
+	"archive/tar"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/bornholm/oplet/internal/task"
+	"github.com/pkg/errors"
+)
+
+const (
+	inputsDirName    = "inputs"
+	outputsDirName   = "outputs"
+	progressFileName = "progress"
+)
+
+// Dir is the temporary working directory of an execution running on the
+// host, holding its inputs and outputs directories and its progress file
+type Dir struct {
+	path string
+}
+
+// New creates a new working directory in the temporary directory of the host,
+// its name starting with the given prefix
+func New(prefix string) (*Dir, error) {
+	path, err := os.MkdirTemp("", prefix)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	dir := &Dir{path: path}
+
+	for _, name := range []string{inputsDirName, outputsDirName} {
+		if err := os.Mkdir(filepath.Join(path, name), 0o755); err != nil {
+			// Best effort cleanup, the creation error prevails
+			_ = dir.Remove()
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	return dir, nil
+}
+
+// Path returns the path of the working directory
+func (d *Dir) Path() string {
+	return d.path
+}
+
+// InputsDir returns the path of the inputs directory
+func (d *Dir) InputsDir() string {
+	return filepath.Join(d.path, inputsDirName)
+}
+
+// OutputsDir returns the path of the outputs directory
+func (d *Dir) OutputsDir() string {
+	return filepath.Join(d.path, outputsDirName)
+}
+
+// ProgressFile returns the path of the progress file
+func (d *Dir) ProgressFile() string {
+	return filepath.Join(d.path, progressFileName)
+}
+
+// ReadProgress returns the end of the progress file, empty if the task
+// did not write it
+func (d *Dir) ReadProgress() (string, error) {
+	f, err := os.Open(d.ProgressFile())
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", nil
+		}
+
+		return "", errors.WithStack(err)
+	}
+
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	if offset := info.Size() - task.MaxProgressFileSize; offset > 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return "", errors.WithStack(err)
+		}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(f, task.MaxProgressFileSize))
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return string(data), nil
+}
+
+// Remove deletes the working directory and its content
+func (d *Dir) Remove() error {
+	return errors.WithStack(os.RemoveAll(d.path))
+}
+
+// WriteInputs writes the given files in the inputs directory
+func (d *Dir) WriteInputs(files map[string]io.ReadCloser) error {
+	for filename, file := range files {
+		path := filepath.Join(d.InputsDir(), filepath.Base(filename))
+
+		if err := writeFile(path, file); err != nil {
+			return errors.Wrapf(err, "failed to write input file %s", filename)
+		}
+	}
+
+	return nil
+}
+
+func writeFile(path string, r io.ReadCloser) error {
+	defer r.Close()
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// ArchiveOutputs streams the content of the outputs directory as a TAR archive,
+// with entries prefixed by the directory name as the docker executor does.
+// The returned function closes the archive.
+func (d *Dir) ArchiveOutputs() (*tar.Reader, func() error) {
+	outputsDir := d.OutputsDir()
+
+	pr, pw := io.Pipe()
+
+	go func() {
+		tw := tar.NewWriter(pw)
+
+		err := filepath.WalkDir(outputsDir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			rel, err := filepath.Rel(d.path, path)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			info, err := entry.Info()
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			if !info.Mode().IsRegular() && !info.IsDir() {
+				return nil
+			}
+
+			header, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			header.Name = filepath.ToSlash(rel)
+
+			if info.IsDir() {
+				return errors.WithStack(tw.WriteHeader(header))
+			}
+
+			f, err := os.Open(path)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			defer f.Close()
+
+			// The file could have been replaced by a link since it was listed
+			opened, err := f.Stat()
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			if !os.SameFile(info, opened) {
+				return nil
+			}
+
+			if err := tw.WriteHeader(header); err != nil {
+				return errors.WithStack(err)
+			}
+
+			if _, err := io.Copy(tw, f); err != nil {
+				return errors.WithStack(err)
+			}
+
+			return nil
+		})
+		if err == nil {
+			err = tw.Close()
+		}
+
+		pw.CloseWithError(err)
+	}()
+
+	close := func() error {
+		return errors.WithStack(pr.Close())
+	}
+
+	return tar.NewReader(pr), close
+}